@@ -22,6 +22,11 @@ type CommandData struct {
 	Usage  string
 	Params []ParamData
 	Cmd    CommandFunc
+
+	// Flags declares --name=value arguments of command. Parsed flags are
+	// passed to CmdFlags function, or removed from Cmd function parameters
+	Flags    []FlagData
+	CmdFlags CommandFlagsFunc
 }
 type ParamData struct {
 	Name  string
@@ -65,11 +70,9 @@ func (c Command) String() (usage string) {
 		if i > 0 {
 			usage += NEW_LINE
 		}
-		usage += INDENT + c.commands[i].Name
-		for j := range c.commands[i].Params {
-			usage += " " + c.commands[i].Params[j].Name
-		}
+		usage += INDENT + c.commands[i].usage()
 		usage += DASH + c.commands[i].Usage
+		usage += c.commands[i].flagsUsage(INDENT+INDENT, DASH)
 		for j := range c.commands[i].Params {
 			usage += NEW_LINE +
 				INDENT + INDENT + c.commands[i].Params[j].Name +
@@ -126,7 +129,10 @@ func (c Command) Get(name string) (commandData *CommandData, ok bool) {
 // Exec executes command
 func (c Command) Exec(cmd []byte) (result []byte, err error) {
 
-	params := strings.Fields(string(cmd))
+	params, err := Split(string(cmd))
+	if err != nil {
+		return
+	}
 
 	// Check name set
 	if len(params) == 0 {
@@ -144,11 +150,24 @@ func (c Command) Exec(cmd []byte) (result []byte, err error) {
 	}
 
 	// Check command function defined
-	if commandData.Cmd == nil {
+	if commandData.Cmd == nil && commandData.CmdFlags == nil {
 		err = fmt.Errorf("command %s is not defined", name)
 		return
 	}
 
+	// Parse flags
+	if len(commandData.Flags) > 0 || commandData.CmdFlags != nil {
+		var flags Flags
+		flags, params, err = commandData.parseFlags(params)
+		if err != nil {
+			return
+		}
+		if commandData.CmdFlags != nil {
+			result, err = commandData.CmdFlags(flags, params...)
+			return
+		}
+	}
+
 	// Execute command
 	result, err = commandData.Cmd(params...)
 	return
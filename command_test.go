@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -99,3 +100,91 @@ func TestCommand(t *testing.T) {
 		return
 	}
 }
+
+func TestFlags(t *testing.T) {
+
+	com := New()
+	com.Add(&CommandData{
+		Name:  "list",
+		Usage: "list items",
+		Flags: []FlagData{
+			{Name: "filter", Usage: "items filter", Required: true},
+			{Name: "limit", Usage: "max items", Type: FlagInt, Default: "10"},
+			{Name: "all", Usage: "show hidden items", Type: FlagBool},
+		},
+		Params: []ParamData{{"<folder>", "items folder"}},
+		CmdFlags: func(flags Flags, params ...string) ([]byte, error) {
+			return []byte(fmt.Sprintf("%s %d %v %v", flags.String("filter"),
+				flags.Int("limit"), flags.Bool("all"), params)), nil
+		},
+	})
+
+	for cmd, want := range map[string]string{
+		"list --filter=a docs":                   "a 10 false [docs]",
+		"list docs --filter=b --limit=5 --all":   "b 5 true [docs]",
+		"list --filter=c --all=false -- --limit": "c 10 false [--limit]",
+	} {
+		res, err := com.Exec([]byte(cmd))
+		if err != nil || string(res) != want {
+			t.Errorf("%s: got %s, %v", cmd, res, err)
+		}
+	}
+	for _, cmd := range []string{
+		"list docs", "list --filter=a --limit=x", "list --filter=a --wrong",
+		"list --filter",
+	} {
+		if _, err := com.Exec([]byte(cmd)); err == nil {
+			t.Errorf("%s: should return error", cmd)
+		}
+	}
+
+	usage := com.String()
+	for _, s := range []string{
+		"list --filter=<string> [--limit=<int>] [--all] <folder> - list items",
+		"--limit=<int> - max items (default 10)",
+		"--filter=<string> - items filter (required)",
+	} {
+		if !strings.Contains(usage, s) {
+			t.Errorf("usage should contain %q:\n%s", s, usage)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+
+	for line, want := range map[string][]string{
+		"":                            nil,
+		"  get  a\tb ":                {"get", "a", "b"},
+		`say "hello world" x`:         {"say", "hello world", "x"},
+		`say 'it''s' "a\"b" '\n'`:     {"say", "its", `a"b`, `\n`},
+		`say "a\nb" c\ d`:             {"say", `a\nb`, "c d"},
+		`say "" ''`:                   {"say", "", ""},
+		`say pre"fix 1"'post fix'end`: {"say", "prefix 1post fixend"},
+		`say "\\"`:                    {"say", `\`},
+		"say привет 'мир ок'":         {"say", "привет", "мир ок"},
+	} {
+		args, err := Split(line)
+		if err != nil || fmt.Sprintf("%q", args) != fmt.Sprintf("%q", want) {
+			t.Errorf("%s: got %q, %v", line, args, err)
+		}
+	}
+
+	for _, line := range []string{`say "hello`, `say 'hello`, `say hello\`} {
+		if _, err := Split(line); err == nil {
+			t.Errorf("%s: should return error", line)
+		}
+	}
+
+	// Parameter with spaces
+	com := New()
+	com.Add(&CommandData{
+		Name: "echo",
+		Cmd: func(params ...string) ([]byte, error) {
+			return []byte(strings.Join(params, "|")), nil
+		},
+	})
+	if res, err := com.Exec([]byte(`echo "hello world" 'a b'`)); err != nil ||
+		string(res) != "hello world|a b" {
+		t.Errorf("wrong echo result: %s, %v", res, err)
+	}
+}
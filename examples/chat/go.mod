@@ -0,0 +1,5 @@
+module github.com/kirill-scherba/command/examples/chat
+
+go 1.23.2
+
+require github.com/kirill-scherba/command/v2 v2.0.2
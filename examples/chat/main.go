@@ -0,0 +1,120 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This sample application demonstrates chat rooms with presence built on the
+// Command package websocket server, subscriptions and authentication hooks.
+//
+// Start the server and open http://localhost:8085/?token=alice-token and
+// http://localhost:8085/?token=bob-token in two browser tabs.
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/kirill-scherba/command/v2"
+	"github.com/kirill-scherba/command/v2/server"
+)
+
+const (
+	appVersion = "0.0.1"
+	appAddr    = ":8085"
+)
+
+//go:embed dist
+var embedFS embed.FS
+
+// Application parameters type.
+type Parameters struct {
+	addr  string // HTTP address
+	users string // Users tokens list: user:token,...
+}
+
+// Application parameters object.
+var params Parameters
+
+func main() {
+
+	// Parse parameters
+	flag.StringVar(&params.addr, "addr", appAddr, "http server local address")
+	flag.StringVar(&params.users, "users", "alice:alice-token,bob:bob-token",
+		"comma separated list of user:token pairs")
+	flag.Parse()
+
+	// Users by tokens
+	users, err := parseUsers(params.users)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Create command, subscription and rooms objects
+	c := command.New()
+	s := command.NewSubscription()
+	rooms := command.NewRooms(s)
+
+	// Add subscribe and rooms commands
+	s.AddCommands(c, command.WS)
+	rooms.AddCommands(c, command.WS)
+
+	// Create server with authentication hooks
+	srv := server.New(c, s, server.Options{
+		Addr:   params.addr,
+		Prefix: "/api/v1",
+		WSPath: "/ws",
+		Hooks: server.Hooks{
+			// Reject websocket connections without valid token
+			OnConnect: func(w http.ResponseWriter, r *http.Request) error {
+				if r.URL.Path != "/ws" {
+					return nil
+				}
+				if _, ok := users[r.URL.Query().Get("token")]; !ok {
+					return fmt.Errorf("wrong token")
+				}
+				return nil
+			},
+			// Set user of websocket connection to commands requests
+			OnMessage: func(r *http.Request, name string,
+				req command.RequestInterfaceV2) error {
+
+				req.SetUser(users[r.URL.Query().Get("token")])
+				return nil
+			},
+			// Leave rooms when connection is closed
+			OnDisconnect: func(r *http.Request, ch *command.WSChannel) {
+				rooms.LeaveAll(ch)
+			},
+		},
+	})
+
+	// Chat web page
+	dist, err := fs.Sub(embedFS, "dist")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	srv.Handle("/", http.FileServerFS(dist))
+
+	// Application Logo
+	fmt.Printf("Command package chat example application ver. %s\n", appVersion)
+	log.Printf("start listening for HTTP requests on %s", params.addr)
+	log.Fatalln(srv.ListenAndServe())
+}
+
+// parseUsers parses comma separated list of user:token pairs and returns
+// users by tokens.
+func parseUsers(list string) (map[string]string, error) {
+	users := make(map[string]string)
+	for _, pair := range strings.Split(list, ",") {
+		user, token, ok := strings.Cut(pair, ":")
+		if !ok || user == "" || token == "" {
+			return nil, fmt.Errorf("wrong user:token pair '%s'", pair)
+		}
+		users[token] = user
+	}
+	return users, nil
+}
@@ -10,6 +10,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/kirill-scherba/command/v2"
 )
@@ -24,8 +25,9 @@ const (
 
 // Application parameters type.
 type Parameters struct {
-	addr string // HTTP address
-	port string // HTTP port
+	addr     string // HTTP address
+	port     string // HTTP port
+	markdown bool   // Print Markdown reference of commands and exit
 }
 
 // Application parameters object.
@@ -33,32 +35,42 @@ var params Parameters
 
 func main() {
 
-	// Application Logo
-	fmt.Printf("Command package example server application ver. %s\n", appVersion)
-
 	// Get HTTP port from environment variable
 	// params.port = os.Getenv("PORT")
 	if params.port == "" {
 		params.port = appPort
 	}
-	fmt.Println("HTTP port:", params.port)
 
 	// Parse parameters
 	flag.StringVar(&params.addr, "addr", ":"+params.port, "http server local address")
+	flag.BoolVar(&params.markdown, "markdown", false, "print Markdown reference of commands and exit")
 	flag.Parse()
 
 	// Create command object
 	c := command.New()
 
+	// Create subscription object
+	s := command.NewSubscription()
+
 	// Add commands
-	commands(c)
+	commands(c, s)
+
+	// Print Markdown reference of commands
+	if params.markdown {
+		os.Stdout.Write(c.Markdown())
+		return
+	}
+
+	// Application Logo
+	fmt.Printf("Command package example server application ver. %s\n", appVersion)
+	fmt.Println("HTTP port:", params.port)
 
 	// Start HTTP server
-	serve(c)
+	serve(c, s)
 }
 
 // Server commands
-func commands(c *command.Commands) {
+func commands(c *command.Commands, s *command.Subscription) {
 
 	// Add 'hello' commands
 	c.Add("hello", "say hello", command.HTTP|command.WS, "{name}", "", "", "",
@@ -89,4 +101,7 @@ func commands(c *command.Commands) {
 
 	// Add commands list
 	c.AddCommandsList(command.HTTP)
+
+	// Add subscribe and unsubscribe commands
+	s.AddCommands(c, command.WS)
 }
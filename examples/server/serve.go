@@ -15,23 +15,10 @@ import (
 	"github.com/kirill-scherba/command/v2"
 )
 
-// HttpRequest contains gorilla mux variables and HTTP request.
-type HttpRequest struct {
-	*http.Request
-	Vars map[string]string
-}
-
-func (r *HttpRequest) GetVars() map[string]string {
-	return r.Vars
-}
-
-func (r *HttpRequest) GetData() []byte {
-	return nil
-}
-
-func serve(c *command.Commands) {
+func serve(c *command.Commands, s *command.Subscription) {
 	// Create a mux for routing incoming requests
-	m := mux.NewRouter()
+	// Match escaped path so that parameters may contain slashes
+	m := mux.NewRouter().UseEncodedPath()
 
 	// Commands HTTP handlers
 	c.HabdleCommands(command.HTTP, func(name, params string) {
@@ -47,7 +34,11 @@ func serve(c *command.Commands) {
 		m.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
 
 			// Handlers request contains gorilla mux variables and HTTP request
-			request := &HttpRequest{r, mux.Vars(r)}
+			vars := mux.Vars(r)
+			for k, v := range vars {
+				vars[k] = command.UnescapeParam(v)
+			}
+			request := command.NewHTTPRequest(r, vars)
 
 			// Set CORS headers
 			w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -66,7 +57,7 @@ func serve(c *command.Commands) {
 	})
 
 	// WebSocket handler
-	serveWs(m, c)
+	serveWs(m, c, s)
 
 	// Local file system files
 	frontendFS := http.FileServer(http.FS(getFrontendDistFs()))
@@ -15,28 +15,15 @@ import (
 	"github.com/kirill-scherba/command/v2"
 )
 
-// WsRequest contains gorilla websocket connection and variables map.
-type WsRequest struct {
-	*websocket.Conn
-	Vars map[string]string
-}
-
-func (r *WsRequest) GetVars() map[string]string {
-	return r.Vars
-}
-
-func (r *WsRequest) GetData() []byte {
-	return nil
-}
-
 // ServeWs handles and processes HTTP websocket commands.
 type ServeWs struct {
 	c    *command.Commands
-	conn *websocket.Conn
+	s    *command.Subscription
+	conn *command.WSChannel
 }
 
 // serveWs start a HTTP websocket handler.
-func serveWs(m *mux.Router, c *command.Commands) {
+func serveWs(m *mux.Router, c *command.Commands, s *command.Subscription) {
 	m.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 
 		// Upgrade HTTP connection to WebSocket
@@ -48,7 +35,7 @@ func serveWs(m *mux.Router, c *command.Commands) {
 		}
 
 		// Handle WebSocket connection
-		go (&ServeWs{c, conn}).handleConnection(conn)
+		go (&ServeWs{c, s, command.NewWSChannel(conn)}).handleConnection(conn)
 	})
 }
 
@@ -57,6 +44,7 @@ func serveWs(m *mux.Router, c *command.Commands) {
 // It takes a pointer to a websocket.Conn as a parameter.
 func (s *ServeWs) handleConnection(conn *websocket.Conn) {
 	defer conn.Close()
+	defer s.s.Disconnect(s.conn)
 
 	for {
 		// Read message from client
@@ -80,7 +68,9 @@ func (s *ServeWs) processMessage(conn *websocket.Conn, message []byte) {
 
 	// Execute command
 	log.Println("executing command:", name, vars)
-	res, err := s.c.Exec(name, command.WS, &WsRequest{Conn: conn, Vars: vars})
+	request := command.NewWSRequest(s.conn, vars, nil)
+	request.RemoteAddr = conn.RemoteAddr().String()
+	res, err := s.c.Exec(name, command.WS, request)
 	if err != nil {
 		log.Println("failed to execute command:", err)
 		res = []byte(err.Error())
@@ -0,0 +1,119 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This sample application demonstrates command forwarding between HTTP
+// gateway and backend peer connected with custom transport.
+//
+// The peer is connected with plain TCP transport registered by
+// command.RegisterProcessIn. The gateway forwards HTTP requests to the peer
+// in command.Envelope which carries request deadline, call info and user
+// identity, and the peer executes them with its own transport processIn.
+// Any other transport registered the same way forwards commands alike.
+//
+// Start the application and open http://localhost:8086/api/v1/hello/world.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/kirill-scherba/command/v2"
+	"github.com/kirill-scherba/command/v2/server"
+)
+
+const (
+	appVersion = "0.0.1"
+	appAddr    = ":8086"
+	peerAddr   = "localhost:8087"
+)
+
+// Application parameters type.
+type Parameters struct {
+	addr string // Gateway HTTP address
+	peer string // Peer TCP address
+}
+
+// Application parameters object.
+var params Parameters
+
+func main() {
+
+	// Parse parameters
+	flag.StringVar(&params.addr, "addr", appAddr, "gateway http server local address")
+	flag.StringVar(&params.peer, "peer", peerAddr, "peer tcp server address")
+	flag.Parse()
+
+	// Register peer transport
+	tcp, err := command.RegisterProcessIn("TCP")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Start backend peer
+	ln, err := net.Listen("tcp", params.peer)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	go servePeer(ln, peerCommands(tcp), tcp)
+
+	// Connect gateway to peer
+	p, err := dialPeer(params.peer)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Start HTTP gateway
+	c := gatewayCommands(p)
+	srv := server.New(c, nil, server.Options{Addr: params.addr, Prefix: "/api/v1"})
+
+	fmt.Printf("Command package custom transport example application ver. %s\n", appVersion)
+	log.Fatalln(srv.ListenAndServe())
+}
+
+// peerCommands returns commands of backend peer processed in peer transport.
+func peerCommands(processIn command.ProcessIn) *command.Commands {
+	c := command.New()
+
+	c.Add("hello", "say hello", processIn, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+
+			req, err := c.Request(data)
+			if err != nil {
+				return nil, err
+			}
+			info, _ := command.CallInfoFrom(req.GetContext())
+			return []byte(fmt.Sprintf("Hello %s from %s peer! Request ID: %s",
+				req.GetVars()["name"], processIn, info.RequestID)), nil
+		},
+	)
+
+	return c
+}
+
+// gatewayCommands returns HTTP gateway commands which forward requests to
+// backend peer.
+func gatewayCommands(p *peer) *command.Commands {
+	c := command.New()
+
+	c.Add("hello", "say hello from backend peer", command.HTTP, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+
+			req, err := c.Request(data)
+			if err != nil {
+				return nil, err
+			}
+			e := command.NewEnvelope(cmd.Cmd, req)
+			if e.RequestID == "" {
+				e.RequestID = req.GetHeader("X-Request-ID")
+			}
+			return p.forward(e)
+		},
+	)
+
+	return c
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Backend peer connection forwarding commands in envelopes.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// peerResponse is a peer answer to forwarded command.
+type peerResponse struct {
+	Data []byte `json:"data,omitempty"`
+	Err  string `json:"error,omitempty"`
+}
+
+// peer is gateway connection to backend peer. Commands are forwarded one by
+// one as json envelopes.
+type peer struct {
+	enc *json.Encoder
+	dec *json.Decoder
+	sync.Mutex
+}
+
+// dialPeer connects gateway to backend peer.
+func dialPeer(addr string) (*peer, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &peer{enc: json.NewEncoder(conn), dec: json.NewDecoder(conn)}, nil
+}
+
+// forward sends command envelope to peer and returns its answer.
+func (p *peer) forward(e command.Envelope) ([]byte, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if err := p.enc.Encode(e); err != nil {
+		return nil, err
+	}
+	var res peerResponse
+	if err := p.dec.Decode(&res); err != nil {
+		return nil, err
+	}
+	if res.Err != "" {
+		return nil, errors.New(res.Err)
+	}
+	return res.Data, nil
+}
+
+// servePeer accepts gateway connections and executes forwarded commands
+// with peer transport processIn.
+func servePeer(ln net.Listener, c *command.Commands, processIn command.ProcessIn) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Println("peer accept error:", err)
+			return
+		}
+		go func() {
+			defer conn.Close()
+			enc, dec := json.NewEncoder(conn), json.NewDecoder(conn)
+			for {
+				var e command.Envelope
+				if err := dec.Decode(&e); err != nil {
+					return
+				}
+				var res peerResponse
+				res.Data, err = c.ExecEnvelope(context.Background(), e, processIn)
+				if err != nil {
+					res.Err = err.Error()
+				}
+				if err = enc.Encode(res); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
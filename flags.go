@@ -0,0 +1,183 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Flag-style command arguments.
+
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FlagType is type of flag value
+type FlagType int
+
+// Flag value types
+const (
+	FlagString FlagType = iota
+	FlagInt
+	FlagBool
+)
+
+// String returns flag type name
+func (t FlagType) String() string {
+	switch t {
+	case FlagInt:
+		return "int"
+	case FlagBool:
+		return "bool"
+	}
+	return "string"
+}
+
+// FlagData describes command flag used as --name=value argument
+type FlagData struct {
+	Name     string
+	Usage    string
+	Type     FlagType
+	Default  string
+	Required bool
+}
+
+// Flags contains parsed command flags values: string, int or bool depend on
+// flag type
+type Flags map[string]any
+
+// String returns string flag value
+func (f Flags) String(name string) string {
+	v, _ := f[name].(string)
+	return v
+}
+
+// Int returns int flag value
+func (f Flags) Int(name string) int {
+	v, _ := f[name].(int)
+	return v
+}
+
+// Bool returns bool flag value
+func (f Flags) Bool(name string) bool {
+	v, _ := f[name].(bool)
+	return v
+}
+
+// CommandFlagsFunc is command function which receives parsed flags
+type CommandFlagsFunc func(flags Flags, params ...string) ([]byte, error)
+
+// parseFlags parses --name=value and --name (for bool flags) arguments of
+// command with flags declarations. It returns flags values and remaining
+// positional parameters. The "--" argument stops flags parsing.
+func (cmd *CommandData) parseFlags(args []string) (flags Flags, params []string,
+	err error) {
+
+	decl := make(map[string]*FlagData, len(cmd.Flags))
+	for i := range cmd.Flags {
+		decl[cmd.Flags[i].Name] = &cmd.Flags[i]
+	}
+
+	// Parse arguments
+	values := make(map[string]string)
+	for i, arg := range args {
+		if arg == "--" {
+			params = append(params, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "--") {
+			params = append(params, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(arg[2:], "=")
+		flag, ok := decl[name]
+		if !ok {
+			err = fmt.Errorf("unknown flag --%s\nusage: %s", name, cmd.usage())
+			return
+		}
+		if !hasValue {
+			if flag.Type != FlagBool {
+				err = fmt.Errorf("flag --%s needs value\nusage: %s", name,
+					cmd.usage())
+				return
+			}
+			value = "true"
+		}
+		values[name] = value
+	}
+
+	// Convert values
+	flags = make(Flags, len(cmd.Flags))
+	for _, flag := range cmd.Flags {
+		value, ok := values[flag.Name]
+		if !ok {
+			if flag.Required {
+				err = fmt.Errorf("flag --%s is required\nusage: %s", flag.Name,
+					cmd.usage())
+				return
+			}
+			value = flag.Default
+		}
+		if flags[flag.Name], err = flag.convert(value); err != nil {
+			err = fmt.Errorf("wrong flag --%s value: %s\nusage: %s", flag.Name,
+				err, cmd.usage())
+			return
+		}
+	}
+
+	return
+}
+
+// convert converts flag value string to flag type
+func (flag *FlagData) convert(value string) (any, error) {
+	switch flag.Type {
+	case FlagInt:
+		if value == "" {
+			return 0, nil
+		}
+		return strconv.Atoi(value)
+	case FlagBool:
+		if value == "" {
+			return false, nil
+		}
+		return strconv.ParseBool(value)
+	}
+	return value, nil
+}
+
+// usage returns one line command usage with flags and parameters
+func (cmd *CommandData) usage() (usage string) {
+	usage = cmd.Name
+	for _, flag := range cmd.Flags {
+		f := "--" + flag.Name
+		if flag.Type != FlagBool {
+			f += "=<" + flag.Type.String() + ">"
+		}
+		if !flag.Required {
+			f = "[" + f + "]"
+		}
+		usage += " " + f
+	}
+	for _, param := range cmd.Params {
+		usage += " " + param.Name
+	}
+	return
+}
+
+// flagsUsage returns flags usage lines
+func (cmd *CommandData) flagsUsage(indent, dash string) (usage string) {
+	for _, flag := range cmd.Flags {
+		usage += "\n" + indent + "--" + flag.Name
+		if flag.Type != FlagBool {
+			usage += "=<" + flag.Type.String() + ">"
+		}
+		usage += dash + flag.Usage
+		switch {
+		case flag.Required:
+			usage += " (required)"
+		case flag.Default != "":
+			usage += " (default " + flag.Default + ")"
+		}
+	}
+	return
+}
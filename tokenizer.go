@@ -0,0 +1,76 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Shell-style command line tokenizer.
+
+package command
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Split splits command line to arguments like shell does. Arguments are
+// separated by white spaces. Single quotes keep all characters literally,
+// double quotes keep characters except backslash escapes of '"' and '\',
+// and backslash outside quotes escapes any next character. Empty quotes
+// produce empty argument.
+func Split(line string) (args []string, err error) {
+	var (
+		arg     strings.Builder
+		inArg   bool // Argument started
+		quote   rune // Current quote character or 0
+		escaped bool // Previous character is backslash
+	)
+
+	for _, ch := range line {
+		switch {
+		case escaped:
+			if quote == '"' && ch != '"' && ch != '\\' {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(ch)
+			escaped = false
+
+		case ch == '\\' && quote != '\'':
+			escaped, inArg = true, true
+
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(ch)
+			}
+
+		case ch == '\'' || ch == '"':
+			quote, inArg = ch, true
+
+		case unicode.IsSpace(ch):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+
+		default:
+			arg.WriteRune(ch)
+			inArg = true
+		}
+	}
+
+	if escaped {
+		err = fmt.Errorf("unfinished escape at end of line")
+		return
+	}
+	if quote != 0 {
+		err = fmt.Errorf("unclosed quote %c", quote)
+		return
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package amqp is an AMQP, like RabbitMQ, consumer adapter of the Command
+// processing package registry.
+//
+// The Consumer executes command requests consumed from AMQP queue: the
+// message routing key is command name, string headers are command
+// variables and the message body is command data. The command answer is
+// published to the message reply-to queue with the message correlation ID,
+// so batch systems drive commands asynchronously.
+//
+// The package does not depend on AMQP client library. Applications convert
+// client deliveries to Delivery and implement Publisher, for example with
+// github.com/rabbitmq/amqp091-go:
+//
+//	deliveries := make(chan amqp.Delivery)
+//	go func() {
+//		for d := range msgs {
+//			deliveries <- amqp.Delivery{RoutingKey: d.RoutingKey,
+//				Headers: d.Headers, Body: d.Body, ReplyTo: d.ReplyTo,
+//				CorrelationID: d.CorrelationId,
+//				Ack: func() error { return d.Ack(false) },
+//				Nack: func(requeue bool) error { return d.Nack(false, requeue) }}
+//		}
+//		close(deliveries)
+//	}()
+//	consumer.Consume(ctx, deliveries)
+package amqp
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// ErrorHeader is a header of answer publishing with command execution error.
+const ErrorHeader = "error"
+
+// Delivery is a message consumed from AMQP queue.
+type Delivery struct {
+	RoutingKey    string         // Command name
+	Headers       map[string]any // String headers are command variables
+	Body          []byte         // Command data
+	ReplyTo       string         // Answer queue, answer is not sent if empty
+	CorrelationID string         // Correlation ID of answer
+
+	Ack  func() error             // Acknowledges delivery, may be nil
+	Nack func(requeue bool) error // Rejects delivery, may be nil
+}
+
+// Publishing is an answer published to reply-to queue.
+type Publishing struct {
+	CorrelationID string         // Request correlation ID
+	Headers       map[string]any // ErrorHeader is set on execution error
+	Body          []byte         // Command answer
+}
+
+// Publisher publishes answers to AMQP queues.
+type Publisher interface {
+	// Publish publishes msg to exchange with routing key. The answers are
+	// published to default exchange "" with reply-to queue key.
+	Publish(ctx context.Context, exchange, key string, msg Publishing) error
+}
+
+// Consumer executes commands consumed from AMQP queue.
+type Consumer struct {
+	c         *command.Commands
+	publisher Publisher
+	processIn command.ProcessIn
+
+	// Workers is number of deliveries executed concurrently, 1 by default.
+	Workers int
+}
+
+// New creates AMQP consumer of commands registry. Commands are executed with
+// processIn input processing type, like the one registered by
+// command.RegisterProcessIn("AMQP"). The publisher is used to send answers
+// and may be nil if answers are not needed.
+func New(c *command.Commands, publisher Publisher,
+	processIn command.ProcessIn) *Consumer {
+	return &Consumer{c: c, publisher: publisher, processIn: processIn}
+}
+
+// Consume executes deliveries until deliveries channel is closed or ctx is
+// done. Delivery is acknowledged after its answer is published, and rejected
+// with requeue if the answer publishing fails, so it is redelivered. It
+// returns ctx error if ctx is done.
+func (cons *Consumer) Consume(ctx context.Context, deliveries <-chan Delivery) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	sem := make(chan struct{}, max(cons.Workers, 1))
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				return nil
+			}
+			sem <- struct{}{}
+			wg.Add(1)
+			command.Go("amqp delivery", func() {
+				defer func() { <-sem; wg.Done() }()
+				cons.handle(ctx, d)
+			})
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// handle executes delivery command and publishes its answer.
+func (cons *Consumer) handle(ctx context.Context, d Delivery) {
+	vars := make(map[string]string)
+	for k, v := range d.Headers {
+		if s, ok := v.(string); ok {
+			vars[k] = s
+		}
+	}
+	data, err := cons.c.Exec(d.RoutingKey, cons.processIn,
+		&command.DefaultRequest{Vars: vars, Data: d.Body, Ctx: ctx})
+
+	if d.ReplyTo != "" && cons.publisher != nil {
+		msg := Publishing{CorrelationID: d.CorrelationID, Body: data}
+		if err != nil {
+			msg.Headers = map[string]any{ErrorHeader: err.Error()}
+		}
+		if err = cons.publisher.Publish(ctx, "", d.ReplyTo, msg); err != nil {
+			if d.Nack != nil {
+				d.Nack(true)
+			}
+			return
+		}
+	}
+	if d.Ack != nil {
+		d.Ack()
+	}
+}
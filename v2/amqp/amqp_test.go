@@ -0,0 +1,88 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// testPublisher stores published messages.
+type testPublisher struct {
+	msgs map[string]Publishing
+	fail bool
+	sync.Mutex
+}
+
+func (p *testPublisher) Publish(ctx context.Context, exchange, key string,
+	msg Publishing) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.fail {
+		return fmt.Errorf("publish failed")
+	}
+	p.msgs[msg.CorrelationID] = msg
+	return nil
+}
+
+func TestConsumer(t *testing.T) {
+
+	c := command.New()
+	c.Add("hello", "", command.All, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte("Hello " + vars["name"] + "!"), nil
+		},
+	)
+
+	p := &testPublisher{msgs: make(map[string]Publishing)}
+	cons := New(c, p, command.All)
+	cons.Workers = 2
+
+	var acks, nacks sync.WaitGroup
+	deliveries := make(chan Delivery, 3)
+	send := func(key, id string, requeue bool) {
+		acks.Add(1)
+		d := Delivery{RoutingKey: key, Headers: map[string]any{"name": id},
+			ReplyTo: "answers", CorrelationID: id,
+			Ack: func() error { acks.Done(); return nil },
+			Nack: func(r bool) error {
+				if r != requeue {
+					t.Errorf("wrong requeue: %v", r)
+				}
+				nacks.Done()
+				acks.Done()
+				return nil
+			},
+		}
+		deliveries <- d
+	}
+	send("hello", "1", false)
+	send("hello", "2", false)
+	send("nope", "3", false)
+	close(deliveries)
+
+	if err := cons.Consume(context.Background(), deliveries); err != nil {
+		t.Fatal(err)
+	}
+	acks.Wait()
+
+	if msg := p.msgs["1"]; string(msg.Body) != "Hello 1!" || msg.Headers != nil {
+		t.Errorf("wrong answer: %v", msg)
+	}
+	if msg := p.msgs["3"]; msg.Headers[ErrorHeader] == nil {
+		t.Errorf("wrong error answer: %v", msg)
+	}
+
+	// Delivery is requeued if answer is not published
+	p.fail = true
+	deliveries = make(chan Delivery, 1)
+	nacks.Add(1)
+	send("hello", "4", true)
+	close(deliveries)
+	cons.Consume(context.Background(), deliveries)
+	nacks.Wait()
+}
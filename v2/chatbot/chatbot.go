@@ -0,0 +1,225 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package chatbot is a chat bots adapter of the Command processing package
+// registry for chatops.
+//
+// The Bot exposes commands processed in its ProcessIn type, and optionally
+// tagged with its Tag, as bot commands. Message "/hello John" executes the
+// "hello" command with its first parameter set to "John", quoted arguments,
+// like "/weather \"New York\"", contain spaces and the last parameter gets
+// the rest of arguments. Command names are converted to bot command names,
+// like "debug-info" to "debug_info". The "/help" and "/start" commands
+// answer list of commands with their descriptions.
+//
+// TelegramHandler serves Telegram bot webhook updates and SlackHandler
+// serves Slack slash commands, other platforms call Bot.Reply.
+package chatbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// MaxReplySize is a maximum size of reply text, longer replies are
+// truncated.
+const MaxReplySize = 4000
+
+// Bot executes commands of chat messages.
+type Bot struct {
+	c         *command.Commands
+	processIn command.ProcessIn
+
+	// Tag selects commands with the tag, all commands processed in
+	// processIn are bot commands if it is empty.
+	Tag string
+}
+
+// BotCommand is a bot command description, like Telegram setMyCommands
+// BotCommand.
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// New creates chat bot of commands registry. Commands processed in
+// processIn, like the one registered by command.RegisterProcessIn("Bot"),
+// are bot commands.
+func New(c *command.Commands, processIn command.ProcessIn) *Bot {
+	return &Bot{c: c, processIn: processIn}
+}
+
+// BotName returns bot command name of command name.
+func BotName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return unicode.ToLower(r)
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// Commands returns bot commands sorted by name.
+func (b *Bot) Commands() (list []BotCommand) {
+	for name, cmd := range b.c.IterSorted() {
+		if !b.exposed(cmd) {
+			continue
+		}
+		descr := cmd.Descr
+		if descr == "" {
+			descr = name
+		}
+		list = append(list, BotCommand{BotName(name), descr})
+	}
+	return
+}
+
+// exposed returns true if command is bot command.
+func (b *Bot) exposed(cmd *command.CommandData) bool {
+	if cmd.ProcessIn&b.processIn == 0 || cmd.Sub != nil {
+		return false
+	}
+	if b.Tag == "" {
+		return true
+	}
+	for _, tag := range cmd.Tags {
+		if tag == b.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// command returns bot command by bot command name.
+func (b *Bot) command(name string) (*command.CommandData, bool) {
+	for n, cmd := range b.c.IterSorted() {
+		if BotName(n) == name && b.exposed(cmd) {
+			return cmd, true
+		}
+	}
+	return nil, false
+}
+
+// Reply executes command of message text from user and returns formatted
+// reply. It returns false if the text is not a bot command.
+func (b *Bot) Reply(ctx context.Context, user, text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return "", false
+	}
+	args := SplitArgs(text[1:])
+	if len(args) == 0 {
+		return "", false
+	}
+	// Telegram group commands are addressed to bot, like "/help@my_bot"
+	name, _, _ := strings.Cut(args[0], "@")
+	args = args[1:]
+
+	if name == "help" || name == "start" {
+		return b.help(), true
+	}
+	cmd, ok := b.command(strings.ToLower(name))
+	if !ok {
+		return fmt.Sprintf("Unknown command /%s, see /help", name), true
+	}
+
+	// Map arguments to parameters, the last parameter gets the rest
+	params := cmd.ParamsSlice()
+	vars := make(map[string]string, len(params))
+	for i, p := range params {
+		switch {
+		case i >= len(args):
+		case i == len(params)-1:
+			vars[p] = strings.Join(args[i:], " ")
+		default:
+			vars[p] = args[i]
+		}
+	}
+	if len(params) == 0 && len(args) > 0 {
+		return fmt.Sprintf("Command /%s has no arguments", name), true
+	}
+
+	data, err := b.c.Exec(cmd.Cmd, b.processIn, &command.DefaultRequest{
+		Vars: vars, User: user, Ctx: ctx})
+	if err != nil {
+		return "Error: " + err.Error(), true
+	}
+	return Format(data), true
+}
+
+// help returns list of bot commands with descriptions and parameters.
+func (b *Bot) help() string {
+	var sb strings.Builder
+	sb.WriteString("Commands:\n")
+	for name, cmd := range b.c.IterSorted() {
+		if !b.exposed(cmd) {
+			continue
+		}
+		sb.WriteString("/" + BotName(name))
+		for _, p := range cmd.ParamsSlice() {
+			sb.WriteString(" <" + p + ">")
+		}
+		if cmd.Descr != "" {
+			sb.WriteString(" - " + cmd.Descr)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// Format returns reply text of command answer: json answers are indented
+// and, like multiline answers, enclosed in code block.
+func Format(data []byte) string {
+	var buf bytes.Buffer
+	if json.Valid(data) && json.Indent(&buf, data, "", "  ") == nil &&
+		len(data) > 0 && (data[0] == '{' || data[0] == '[') {
+		data = buf.Bytes()
+	}
+	text := strings.TrimSpace(string(data))
+	if len(text) > MaxReplySize {
+		text = strings.ToValidUTF8(text[:MaxReplySize], "") + "..."
+	}
+	switch {
+	case text == "":
+		return "Done"
+	case strings.Contains(text, "\n"):
+		return "```\n" + text + "\n```"
+	}
+	return text
+}
+
+// SplitArgs splits command line to arguments separated by spaces. Double
+// quoted arguments may contain spaces.
+func SplitArgs(line string) (args []string) {
+	var arg strings.Builder
+	var quoted, inArg bool
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted, inArg = !quoted, true
+		case unicode.IsSpace(r) && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return
+}
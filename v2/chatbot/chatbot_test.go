@@ -0,0 +1,148 @@
+package chatbot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// newTestBot creates bot with test commands.
+func newTestBot() *Bot {
+	c := command.New()
+	c.Add("hello", "Say hello", command.All, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte("Hello " + vars["name"] + "!"), nil
+		},
+	)
+	c.Add("move", "Move item", command.All, "{item}/{to}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte(`{"item":"` + vars["item"] + `","to":"` + vars["to"] + `"}`), nil
+		},
+	)
+	c.Add("disk-usage", "Disk usage", command.All, "", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			return []byte("42%"), nil
+		},
+	)
+	c.Add("internal", "Internal", command.WS, "", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			return nil, nil
+		},
+	)
+	return New(c, command.HTTP)
+}
+
+func TestBot(t *testing.T) {
+
+	b := newTestBot()
+	ctx := context.Background()
+
+	for text, want := range map[string]string{
+		"/hello John":              "Hello John!",
+		"/hello@ops_bot John Doe":  "Hello John Doe!",
+		`/move "old box" New York`: "```\n{\n  \"item\": \"old box\",\n  \"to\": \"New York\"\n}\n```",
+		"/disk_usage":              "42%",
+		"/disk_usage now":          "Command /disk_usage has no arguments",
+		"/internal":                "Unknown command /internal, see /help",
+		"/help":                    "Commands:\n/disk_usage - Disk usage\n/hello <name> - Say hello\n/move <item> <to> - Move item\n",
+	} {
+		reply, ok := b.Reply(ctx, "admin", text)
+		if !ok || reply != want {
+			t.Errorf("wrong reply of %q: %q", text, reply)
+		}
+	}
+	if _, ok := b.Reply(ctx, "admin", "hello"); ok {
+		t.Error("not command text replied")
+	}
+
+	// Tag selects commands
+	b.Tag = "ops"
+	if list := b.Commands(); len(list) != 0 {
+		t.Errorf("wrong tagged commands: %v", list)
+	}
+	b.Tag = ""
+	want := []BotCommand{{"disk_usage", "Disk usage"}, {"hello", "Say hello"},
+		{"move", "Move item"}}
+	if list := b.Commands(); !slices.Equal(list, want) {
+		t.Errorf("wrong commands: %v", list)
+	}
+
+	if args := SplitArgs(` a "b c"  "" d`); !slices.Equal(args,
+		[]string{"a", "b c", "", "d"}) {
+		t.Errorf("wrong args: %q", args)
+	}
+}
+
+func TestPlatforms(t *testing.T) {
+
+	b := newTestBot()
+
+	// Telegram webhook
+	h := TelegramHandler(b, "secret")
+	req := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(
+		`{"message":{"chat":{"id":7},"from":{"username":"kate"},"text":"/hello Kate"}}`))
+	req.Header.Set(TelegramSecretHeader, "secret")
+	w := httptest.NewRecorder()
+	h(w, req)
+	var res map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	if res["method"] != "sendMessage" || res["chat_id"] != 7.0 ||
+		res["text"] != "Hello Kate!" {
+		t.Fatalf("wrong telegram reply: %v", res)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(`{}`))
+	w = httptest.NewRecorder()
+	h(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong telegram secret status: %d", w.Code)
+	}
+
+	// Slack slash command
+	h = SlackHandler(b, "signing")
+	body := url.Values{"text": {"hello John"}, "user_name": {"john"}}.Encode()
+	slack := func(signature string) *httptest.ResponseRecorder {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		if signature == "" {
+			mac := hmac.New(sha256.New, []byte("signing"))
+			mac.Write([]byte("v0:" + timestamp + ":" + body))
+			signature = "v0=" + hex.EncodeToString(mac.Sum(nil))
+		}
+		req := httptest.NewRequest(http.MethodPost, "/slack", strings.NewReader(body))
+		req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+		req.Header.Set("X-Slack-Signature", signature)
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+	w = slack("")
+	var sres map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &sres); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	if sres["text"] != "Hello John!" || sres["response_type"] != "in_channel" {
+		t.Fatalf("wrong slack reply: %v", sres)
+	}
+	if w = slack("v0=wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong slack signature status: %d", w.Code)
+	}
+}
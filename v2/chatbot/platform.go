@@ -0,0 +1,125 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Chat platforms webhooks.
+
+package chatbot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxBodySize is a maximum size of webhook request body.
+const MaxBodySize = 1 << 20
+
+// TelegramSecretHeader is a Telegram webhook secret token header.
+const TelegramSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// SlackMaxAge is a maximum age of Slack request timestamp.
+const SlackMaxAge = 5 * time.Minute
+
+// telegramUpdate is a Telegram bot update with message.
+type telegramUpdate struct {
+	Message *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		From struct {
+			Username string `json:"username"`
+		} `json:"from"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+// TelegramHandler returns Telegram bot webhook handler. The reply is sent
+// with sendMessage method in webhook response. The secret is a secret token
+// set by setWebhook and is not checked if empty.
+func TelegramHandler(b *Bot, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if secret != "" && subtle.ConstantTimeCompare(
+			[]byte(r.Header.Get(TelegramSecretHeader)), []byte(secret)) != 1 {
+			http.Error(w, "wrong secret token", http.StatusUnauthorized)
+			return
+		}
+		var update telegramUpdate
+		err := json.NewDecoder(io.LimitReader(r.Body, MaxBodySize)).Decode(&update)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if update.Message == nil {
+			return
+		}
+
+		reply, ok := b.Reply(r.Context(), update.Message.From.Username,
+			update.Message.Text)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"method":  "sendMessage",
+			"chat_id": update.Message.Chat.ID,
+			"text":    reply,
+		})
+	}
+}
+
+// SlackHandler returns Slack slash command handler. Slash command text is
+// bot command with arguments, like "/ops hello John" executes bot command
+// "/hello John". The reply is visible in channel. Requests are verified by
+// signingSecret of Slack app if it is not empty.
+func SlackHandler(b *Bot, signingSecret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if signingSecret != "" && !slackVerify(r.Header, body, signingSecret) {
+			http.Error(w, "wrong signature", http.StatusUnauthorized)
+			return
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reply, ok := b.Reply(r.Context(), form.Get("user_name"),
+			"/"+strings.TrimPrefix(strings.TrimSpace(form.Get("text")), "/"))
+		if !ok {
+			reply = b.help()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"response_type": "in_channel",
+			"text":          reply,
+		})
+	}
+}
+
+// slackVerify verifies Slack request signature.
+func slackVerify(header http.Header, body []byte, secret string) bool {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)).Abs() > SlackMaxAge {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature")))
+}
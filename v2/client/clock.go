@@ -0,0 +1,57 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package client contains client side helpers of the Command processing
+// package.
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// TimeExchange executes time command on server by any transport and returns
+// its answer.
+type TimeExchange func() ([]byte, error)
+
+// ClockOffset estimates offset of server clock relative to local clock like
+// NTP does: it executes time command samples times and uses the sample with
+// minimal round trip time assuming symmetric network delay. Server time is
+// local time plus offset. It returns the offset and round trip time of the
+// used sample.
+func ClockOffset(exchange TimeExchange, samples int) (offset, rtt time.Duration,
+	err error) {
+
+	if samples <= 0 {
+		samples = 1
+	}
+
+	rtt = -1
+	for i := 0; i < samples; i++ {
+		t0 := time.Now()
+		data, err := exchange()
+		t1 := time.Now()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var server command.ServerTime
+		if err = json.Unmarshal(data, &server); err != nil {
+			return 0, 0, fmt.Errorf("wrong server time: %w", err)
+		}
+
+		sampleRTT := t1.Sub(t0)
+		if rtt >= 0 && sampleRTT >= rtt {
+			continue
+		}
+		rtt = sampleRTT
+		local := t0.Add(sampleRTT / 2)
+		offset = time.Unix(0, server.Wall).Sub(local)
+	}
+
+	return
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+func TestClockOffset(t *testing.T) {
+
+	c := command.New()
+	c.AddTimeCommand(command.WS)
+
+	// Server clock is 5 seconds ahead
+	const skew = 5 * time.Second
+	exchange := func() ([]byte, error) {
+		data, err := c.Exec("time", command.WS, nil)
+		if err != nil {
+			return nil, err
+		}
+		var st command.ServerTime
+		json.Unmarshal(data, &st)
+		st.Wall += int64(skew)
+		return json.Marshal(st)
+	}
+
+	offset, rtt, err := ClockOffset(exchange, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := offset - skew; d < -10*time.Millisecond || d > 10*time.Millisecond {
+		t.Errorf("wrong offset %v, rtt %v", offset, rtt)
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package coap is a CoAP (RFC 7252) server adapter of the Command processing
+// package registry for constrained IoT devices.
+//
+// Request Uri-Path options are command name and parameters, like
+// coap://host/hello/John, and request payload is command data. The command
+// answer is returned with 2.05 Content code, errors with 4.xx codes and
+// error text payload. GET request with Observe option 0 (RFC 7641)
+// subscribes the client to command with the command Subscription engine:
+// the client receives the command answer and then subscription pushes as
+// non-confirmable notifications. Observe option 1 or Reset message
+// answering notification cancels the observation.
+//
+// Only UDP transport without DTLS and without block-wise transfers is
+// supported, so answers should fit into one datagram.
+package coap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// MaxMessageSize is a maximum size of received CoAP datagram.
+const MaxMessageSize = 1152
+
+// Server executes commands received in CoAP requests.
+type Server struct {
+	c         *command.Commands
+	s         *command.Subscription
+	processIn command.ProcessIn
+	conn      net.PacketConn
+
+	observers map[string]*observer // Observers by address and token
+	mid       uint16               // Last message ID
+	sync.Mutex
+}
+
+// New creates CoAP server of commands registry. Commands are executed with
+// processIn input processing type, like the one registered by
+// command.RegisterProcessIn("CoAP"). The subscription s is used by observe
+// requests and may be nil, observe requests are executed as usual requests
+// then.
+func New(c *command.Commands, s *command.Subscription,
+	processIn command.ProcessIn) *Server {
+	return &Server{c: c, s: s, processIn: processIn,
+		observers: make(map[string]*observer)}
+}
+
+// Serve serves CoAP requests received from conn until conn is closed or ctx
+// is done. Observations are canceled when it returns.
+func (srv *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	srv.Lock()
+	srv.conn = conn
+	srv.Unlock()
+	defer srv.cancelAll()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	buf := make([]byte, MaxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		var msg Message
+		if msg.Unmarshal(buf[:n]) != nil {
+			continue
+		}
+		srv.handle(ctx, addr, &msg)
+	}
+}
+
+// handle processes received message.
+func (srv *Server) handle(ctx context.Context, addr net.Addr, msg *Message) {
+	switch {
+	case msg.Type == Reset:
+		srv.reset(addr, msg.ID)
+		return
+	case msg.Type == Acknowledgement || msg.Code == Empty:
+		return
+	case msg.Code > DELETE:
+		return
+	}
+
+	// Answer type: piggybacked acknowledgement or non-confirmable message
+	answer := &Message{Type: NonConfirmable, Code: Content, Token: msg.Token}
+	if msg.Type == Confirmable {
+		answer.Type, answer.ID = Acknowledgement, msg.ID
+	} else {
+		answer.ID = srv.nextID()
+	}
+
+	// Command line from Uri-Path options
+	var segments []string
+	for _, v := range msg.Option(OptionURIPath) {
+		segments = append(segments, command.EscapeParam(string(v)))
+	}
+	name, vars := srv.c.ParseCommand([]byte(strings.Join(segments, "/")))
+	if _, ok := srv.c.Get(name); !ok {
+		answer.Code, answer.Payload = NotFound, []byte("command not found")
+		srv.send(addr, answer)
+		return
+	}
+	request := &command.DefaultRequest{Vars: vars, Data: msg.Payload,
+		RemoteAddr: addr.String(), Ctx: ctx}
+
+	// Observe registration and deregistration
+	observe := msg.Option(OptionObserve)
+	key := addr.String() + "/" + string(msg.Token)
+	if len(observe) > 0 && msg.Code == GET && srv.s != nil {
+		if optionUint(observe[0]) == 0 {
+			obs := srv.observe(key, addr, msg.Token, name, request)
+			answer.Options = append(answer.Options,
+				Option{OptionObserve, uintOption(obs.next())})
+		} else {
+			srv.cancel(key)
+		}
+	}
+
+	data, err := srv.c.Exec(name, srv.processIn, request)
+	if err != nil {
+		answer.Code, answer.Payload = BadRequest, []byte(err.Error())
+		if errors.Is(err, command.ErrForbidden) {
+			answer.Code = Forbidden
+		}
+	} else {
+		answer.Payload = data
+	}
+	srv.send(addr, answer)
+}
+
+// send sends message to address.
+func (srv *Server) send(addr net.Addr, msg *Message) error {
+	b, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	srv.Lock()
+	conn := srv.conn
+	srv.Unlock()
+	_, err = conn.WriteTo(b, addr)
+	return err
+}
+
+// nextID returns new message ID.
+func (srv *Server) nextID() uint16 {
+	srv.Lock()
+	defer srv.Unlock()
+	srv.mid++
+	return srv.mid
+}
+
+// observer is a CoAP observation of command. It is a subscription
+// connection channel which sends pushes as notifications.
+type observer struct {
+	srv     *Server
+	key     string
+	addr    net.Addr
+	token   []byte
+	command string
+	seq     uint32 // Observe sequence number
+	mid     uint16 // Last notification message ID
+	sync.Mutex
+}
+
+// Send sends subscription push as non-confirmable notification.
+func (obs *observer) Send(data []byte) error {
+	msg := &Message{Type: NonConfirmable, Code: Content,
+		ID: obs.srv.nextID(), Token: obs.token, Payload: data}
+	msg.Options = []Option{{OptionObserve, uintOption(obs.next())}}
+	obs.Lock()
+	obs.mid = msg.ID
+	obs.Unlock()
+	return obs.srv.send(obs.addr, msg)
+}
+
+// next returns next observe sequence number.
+func (obs *observer) next() uint32 {
+	obs.Lock()
+	defer obs.Unlock()
+	obs.seq = (obs.seq + 1) & 0xffffff
+	return obs.seq
+}
+
+// observe subscribes observer of address and token to command.
+func (srv *Server) observe(key string, addr net.Addr, token []byte,
+	name string, request *command.DefaultRequest) *observer {
+
+	srv.cancel(key)
+	obs := &observer{srv: srv, key: key, addr: addr, token: token,
+		command: name}
+	request.Channel = obs
+	srv.Lock()
+	srv.observers[key] = obs
+	srv.Unlock()
+
+	srv.s.SubscribeCmd(obs, name, command.WrapSubscriptionHandler(
+		func(name string) ([]byte, error) {
+			return srv.c.Exec(name, srv.processIn, request)
+		},
+	))
+	return obs
+}
+
+// cancel cancels observation by key.
+func (srv *Server) cancel(key string) {
+	srv.Lock()
+	obs, ok := srv.observers[key]
+	delete(srv.observers, key)
+	srv.Unlock()
+	if ok {
+		srv.s.Disconnect(obs)
+	}
+}
+
+// reset cancels observation which notification with message ID is answered
+// by Reset message.
+func (srv *Server) reset(addr net.Addr, mid uint16) {
+	srv.Lock()
+	var key string
+	for k, obs := range srv.observers {
+		obs.Lock()
+		if obs.mid == mid && obs.addr.String() == addr.String() {
+			key = k
+		}
+		obs.Unlock()
+	}
+	srv.Unlock()
+	if key != "" {
+		srv.cancel(key)
+	}
+}
+
+// cancelAll cancels all observations.
+func (srv *Server) cancelAll() {
+	srv.Lock()
+	keys := make([]string, 0, len(srv.observers))
+	for key := range srv.observers {
+		keys = append(keys, key)
+	}
+	srv.Unlock()
+	for _, key := range keys {
+		srv.cancel(key)
+	}
+}
+
+// Observers returns number of active observations.
+func (srv *Server) Observers() int {
+	srv.Lock()
+	defer srv.Unlock()
+	return len(srv.observers)
+}
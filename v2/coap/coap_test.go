@@ -0,0 +1,141 @@
+package coap
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+func TestMessage(t *testing.T) {
+	msg := Message{Type: Confirmable, Code: GET, ID: 0x1234, Token: []byte{1, 2},
+		Options: []Option{
+			{OptionURIPath, []byte("hello")},
+			{OptionObserve, nil},
+			{OptionURIPath, []byte("a very long path segment value")},
+			{300, []byte{1}},
+		},
+		Payload: []byte("data"),
+	}
+	b, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Message
+	if err := got.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	// Options are sorted by number
+	msg.Options[0], msg.Options[1] = msg.Options[1], msg.Options[0]
+	msg.Options[0].Value = []byte{}
+	if !reflect.DeepEqual(got, msg) {
+		t.Errorf("wrong decoded message:\n%v\n%v", got, msg)
+	}
+	if err := got.Unmarshal([]byte{0x40}); err == nil {
+		t.Error("malformed message is decoded")
+	}
+}
+
+func TestServer(t *testing.T) {
+
+	c := command.New()
+	c.Add("hello", "", command.All, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte("Hello " + vars["name"] + "!"), nil
+		},
+	)
+	s := command.NewSubscription()
+	srv := New(c, s, command.All)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- srv.Serve(ctx, conn) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	request := func(msg *Message) {
+		b, _ := msg.Marshal()
+		if _, err := client.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive := func() *Message {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		b := make([]byte, MaxMessageSize)
+		n, err := client.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg Message
+		if err := msg.Unmarshal(b[:n]); err != nil {
+			t.Fatal(err)
+		}
+		return &msg
+	}
+	path := func(segments ...string) (options []Option) {
+		for _, s := range segments {
+			options = append(options, Option{OptionURIPath, []byte(s)})
+		}
+		return
+	}
+
+	// Confirmable request with piggybacked answer
+	request(&Message{Type: Confirmable, Code: GET, ID: 1, Token: []byte("t1"),
+		Options: path("hello", "a/b")})
+	answer := receive()
+	if answer.Type != Acknowledgement || answer.ID != 1 || answer.Code != Content ||
+		string(answer.Token) != "t1" || string(answer.Payload) != "Hello a/b!" {
+		t.Errorf("wrong answer: %v", answer)
+	}
+
+	request(&Message{Type: NonConfirmable, Code: GET, ID: 2, Options: path("nope")})
+	if answer = receive(); answer.Code != NotFound || answer.Type != NonConfirmable {
+		t.Errorf("wrong not found answer: %v", answer)
+	}
+
+	// Observe command
+	request(&Message{Type: Confirmable, Code: GET, ID: 3, Token: []byte("ob"),
+		Options: append(path("hello", "Obs"), Option{OptionObserve, nil})})
+	answer = receive()
+	if string(answer.Payload) != "Hello Obs!" || len(answer.Option(OptionObserve)) != 1 {
+		t.Errorf("wrong observe answer: %v", answer)
+	}
+	if s.ConnectionsCount("hello") != 1 {
+		t.Fatal("observer is not subscribed")
+	}
+	s.Broadcast("hello", []byte("news"))
+	notification := receive()
+	var push command.SubscriptionMessage
+	if err := json.Unmarshal(notification.Payload, &push); err != nil ||
+		string(notification.Token) != "ob" || string(push.Data) != "news" ||
+		optionUint(notification.Option(OptionObserve)[0]) != 2 {
+		t.Errorf("wrong notification: %v, %v", notification, err)
+	}
+
+	// Reset cancels observation
+	request(&Message{Type: Reset, ID: notification.ID})
+	for i := 0; srv.Observers() > 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if srv.Observers() != 0 || s.ConnectionsCount("hello") != 0 {
+		t.Error("observation is not canceled")
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// CoAP messages encoding.
+
+package coap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// ErrMessage is an error returned when CoAP message is malformed.
+var ErrMessage = fmt.Errorf("malformed coap message")
+
+// Message types.
+const (
+	Confirmable     uint8 = 0
+	NonConfirmable  uint8 = 1
+	Acknowledgement uint8 = 2
+	Reset           uint8 = 3
+)
+
+// Message codes, class and detail like 2.05.
+const (
+	Empty      uint8 = 0x00
+	GET        uint8 = 0x01
+	POST       uint8 = 0x02
+	PUT        uint8 = 0x03
+	DELETE     uint8 = 0x04
+	Content    uint8 = 0x45 // 2.05
+	BadRequest uint8 = 0x80 // 4.00
+	Forbidden  uint8 = 0x83 // 4.03
+	NotFound   uint8 = 0x84 // 4.04
+)
+
+// Options numbers.
+const (
+	OptionObserve uint16 = 6
+	OptionURIPath uint16 = 11
+)
+
+// Option is a CoAP message option.
+type Option struct {
+	Number uint16
+	Value  []byte
+}
+
+// Message is a CoAP message.
+type Message struct {
+	Type    uint8
+	Code    uint8
+	ID      uint16
+	Token   []byte
+	Options []Option
+	Payload []byte
+}
+
+// Option returns values of option number.
+func (m *Message) Option(number uint16) (values [][]byte) {
+	for _, o := range m.Options {
+		if o.Number == number {
+			values = append(values, o.Value)
+		}
+	}
+	return
+}
+
+// Marshal encodes CoAP message.
+func (m *Message) Marshal() ([]byte, error) {
+	if len(m.Token) > 8 {
+		return nil, fmt.Errorf("token length %d: %w", len(m.Token), ErrMessage)
+	}
+	b := []byte{1<<6 | m.Type<<4 | uint8(len(m.Token)), m.Code, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], m.ID)
+	b = append(b, m.Token...)
+
+	options := slices.Clone(m.Options)
+	slices.SortStableFunc(options, func(a, b Option) int {
+		return int(a.Number) - int(b.Number)
+	})
+	var prev uint16
+	for _, o := range options {
+		delta, dext := nibble(int(o.Number - prev))
+		length, lext := nibble(len(o.Value))
+		b = append(b, delta<<4|length)
+		b = append(b, dext...)
+		b = append(b, lext...)
+		b = append(b, o.Value...)
+		prev = o.Number
+	}
+
+	if len(m.Payload) > 0 {
+		b = append(b, 0xff)
+		b = append(b, m.Payload...)
+	}
+	return b, nil
+}
+
+// nibble returns option delta or length nibble and its extended bytes.
+func nibble(v int) (uint8, []byte) {
+	switch {
+	case v < 13:
+		return uint8(v), nil
+	case v < 269:
+		return 13, []byte{uint8(v - 13)}
+	}
+	return 14, binary.BigEndian.AppendUint16(nil, uint16(v-269))
+}
+
+// Unmarshal decodes CoAP message.
+func (m *Message) Unmarshal(b []byte) error {
+	if len(b) < 4 || b[0]>>6 != 1 {
+		return ErrMessage
+	}
+	m.Type, m.Code = b[0]>>4&0x3, b[1]
+	m.ID = binary.BigEndian.Uint16(b[2:])
+	tkl := int(b[0] & 0xf)
+	if tkl > 8 || len(b) < 4+tkl {
+		return ErrMessage
+	}
+	m.Token = slices.Clone(b[4 : 4+tkl])
+	b = b[4+tkl:]
+
+	m.Options, m.Payload = nil, nil
+	var number int
+	for len(b) > 0 {
+		if b[0] == 0xff {
+			if len(b) == 1 {
+				return ErrMessage
+			}
+			m.Payload = slices.Clone(b[1:])
+			return nil
+		}
+		delta, length := int(b[0]>>4), int(b[0]&0xf)
+		b = b[1:]
+		var err error
+		if delta, b, err = extended(delta, b); err != nil {
+			return err
+		}
+		if length, b, err = extended(length, b); err != nil {
+			return err
+		}
+		if len(b) < length {
+			return ErrMessage
+		}
+		number += delta
+		m.Options = append(m.Options, Option{uint16(number),
+			slices.Clone(b[:length])})
+		b = b[length:]
+	}
+	return nil
+}
+
+// extended decodes option delta or length nibble with extended bytes.
+func extended(v int, b []byte) (int, []byte, error) {
+	switch v {
+	case 13:
+		if len(b) < 1 {
+			return 0, nil, ErrMessage
+		}
+		return int(b[0]) + 13, b[1:], nil
+	case 14:
+		if len(b) < 2 {
+			return 0, nil, ErrMessage
+		}
+		return int(binary.BigEndian.Uint16(b)) + 269, b[2:], nil
+	case 15:
+		return 0, nil, ErrMessage
+	}
+	return v, b, nil
+}
+
+// uintOption encodes uint option value with minimal length.
+func uintOption(v uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, v)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+// optionUint decodes uint option value.
+func optionUint(b []byte) (v uint32) {
+	for _, x := range b {
+		v = v<<8 | uint32(x)
+	}
+	return
+}
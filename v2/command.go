@@ -8,8 +8,13 @@ package command
 import (
 	"bytes"
 	"fmt"
+	"iter"
+	"log"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ErrIncorrectInputData is an error returned when the input data provided is
@@ -19,7 +24,29 @@ var ErrIncorrectInputData = fmt.Errorf("inclorrect input data")
 // Commands is a struct that contains a map of command data and a read-write
 // mutex for synchronizing access to the map.
 type Commands struct {
-	m map[string]*CommandData
+	m               map[string]*CommandData
+	validator       NameValidator
+	caseInsensitive bool
+	subscription    *Subscription
+	flags           FeatureFlagProvider
+	shadowReport    ShadowReporter
+	shadowRuns      atomic.Int32
+	metrics         commandMetrics
+	quotaStore      QuotaStore
+	quotaKey        QuotaKeyFunc
+	costs           *costLimiter
+	services        *services
+	cache           CacheStore
+	serializers     map[ProcessIn]Serializer
+	timeouts        Timeouts
+	slowHandler     SlowHandler
+	responseLimit   ResponseLimit
+	crashes         *crashes
+	frozen          atomic.Pointer[frozenCommands]
+	versions        map[string][]versioned
+	transforms      []ResponseTransform
+	errorRedaction  *ErrorRedaction
+	rolesFunc       RolesFunc
 	*sync.RWMutex
 }
 
@@ -36,6 +63,52 @@ type CommandData struct {
 	Request   string         // Request example
 	Response  string         // Response example
 	Handler   CommandHandler // Command handler
+
+	// Handlers are command handler variants of transports, see SetHandler.
+	Handlers map[ProcessIn]CommandHandler
+
+	Version        string   // Command version
+	Tags           []string // Command tags
+	RequestSchema  string   // Request json schema
+	ResponseSchema string   // Response json schema, see RolesKeyword
+	Flag           string   // Feature flag, command name if empty
+	Cost           int      // Execution cost, see SetCostBudget
+	MaxConcurrency int      // Maximum executions in flight, unlimited if 0
+	Deprecated     bool     // Command is deprecated
+	ReplacedBy     string   // Replacement of deprecated command
+
+	// Route is HTTP route of command overriding its name and parameters,
+	// like "commands/{name}". It should contain command parameters.
+	Route string
+
+	// CacheTTL is lifetime of cached successful results, results are not
+	// cached if 0. Results are cached by command request variables and
+	// data, see SetCacheStore.
+	CacheTTL time.Duration
+
+	// Timeouts are command execution thresholds overriding default
+	// thresholds, see SetTimeouts.
+	Timeouts *Timeouts
+
+	// ResponseLimit is a result size limit overriding default limit, see
+	// SetResponseLimit.
+	ResponseLimit *ResponseLimit
+
+	// ErrorRedaction is an errors redaction policy overriding default
+	// policy, see SetErrorRedaction.
+	ErrorRedaction *ErrorRedaction
+
+	Shadow CommandHandler // Shadow handler, see SetShadow
+	SLO    *SLO           // Service level objective, see SetSLOHandler
+	Quota  *Quota         // Execution quota, see SetQuotaStore
+	Sub    *Commands      // Sub-commands, see AddGroup
+
+	Complete   Completer  // Parameters completion, see Complete
+	Serializer Serializer // Typed results serializer, see SetSerializer
+
+	// Transforms are result transformations applied before global
+	// transformations, see AddTransform.
+	Transforms []ResponseTransform
 }
 
 // ParamsSlice returns a slice of parameters from the CommandData struct.
@@ -151,33 +224,189 @@ func (c *Commands) Data(indata any) ([]byte, error) {
 //
 // Returns:
 // - *Commands: The Commands object itself.
+//
+// Add silently overwrites a command with the same name. Use AddStrict to get
+// an error instead, or Replace to override a command intentionally. Command
+// with name rejected by name validator, see SetNameValidator, is not added
+// and the error is logged.
 func (c *Commands) Add(command, descr string, processIn ProcessIn, params,
 	returnDescr, request, response string, handler CommandHandler) *Commands {
 	c.Lock()
-	c.m[command] = &CommandData{
-		command, processIn, params, returnDescr, descr, request, response, handler,
+	if err := c.checkName(command); err != nil {
+		c.Unlock()
+		log.Printf("command '%s' is not added: %s", command, err)
+		return c
+	}
+	key := c.key(command)
+	c.m[key] = &CommandData{
+		Cmd: command, ProcessIn: processIn, Params: params, Return: returnDescr,
+		Descr: descr, Request: request, Response: response, Handler: handler,
 	}
+	delete(c.versions, key)
+	c.refreeze()
 	c.Unlock()
 	return c
 }
 
-// Get returns CommandData from commands map by name.
+// AddStrict adds command to commands map like the Add method does, but
+// validates the command and returns an error if the command name or parameters
+// are not valid or if a command with the same name already exists.
+func (c *Commands) AddStrict(command, descr string, processIn ProcessIn, params,
+	returnDescr, request, response string, handler CommandHandler) error {
+	return c.AddBatch([]CommandSpec{{
+		Cmd: command, ProcessIn: processIn, Params: params, Return: returnDescr,
+		Descr: descr, Request: request, Response: response, Handler: handler,
+	}})
+}
+
+// Replace replaces existing command in commands map. It is used to override
+// a command intentionally and returns ErrCommandNotFound if the command does
+// not exist or an error if the command parameters are not valid.
+func (c *Commands) Replace(command, descr string, processIn ProcessIn, params,
+	returnDescr, request, response string, handler CommandHandler) error {
+
+	if err := c.CheckName(command); err != nil {
+		return err
+	}
+	if err := checkParams(params); err != nil {
+		return fmt.Errorf("command '%s': %w", command, err)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	key := c.key(command)
+	if _, ok := c.m[key]; !ok {
+		return fmt.Errorf("command '%s': %w", command, ErrCommandNotFound)
+	}
+	c.m[key] = &CommandData{
+		Cmd: command, ProcessIn: processIn, Params: params, Return: returnDescr,
+		Descr: descr, Request: request, Response: response, Handler: handler,
+	}
+	delete(c.versions, key)
+	c.refreeze()
+
+	return nil
+}
+
+// ReplaceHandler atomically replaces handler of existing command keeping its
+// other fields. The optional update functions may change copy of the
+// command data, like its description or examples, before it is stored.
+// Handler variants set by SetHandler are removed, so the new handler serves
+// all transports of the command; update functions may set new variants.
+// Executions in flight finish with previous handler and new executions use
+// the new one, there is no moment when the command is missing. The highest
+// version of command with versions is changed, see AddVersion. Sub-commands
+// are addressed by "parent/child" path. It returns ErrCommandNotFound if the
+// command does not exist.
+func (c *Commands) ReplaceHandler(name string, handler CommandHandler,
+	update ...func(cmd *CommandData)) error {
+
+	if parent, child, isSub := strings.Cut(name, "/"); isSub {
+		if cmd, ok := c.Get(parent); ok && cmd.Sub != nil {
+			return cmd.Sub.ReplaceHandler(child, handler, update...)
+		}
+		return fmt.Errorf("command '%s': %w", name, ErrCommandNotFound)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	key := c.key(name)
+	cmd, ok := c.m[key]
+	if !ok {
+		return fmt.Errorf("command '%s': %w", name, ErrCommandNotFound)
+	}
+
+	// Copy command, so executed commands are not changed
+	changed := *cmd
+	changed.Handler = handler
+	changed.Handlers = nil
+	for _, f := range update {
+		f(&changed)
+	}
+	c.m[key] = &changed
+	c.replaceVersion(key, cmd, &changed)
+	c.refreeze()
+
+	return nil
+}
+
+// Get returns CommandData from commands map by name. Sub-commands are got
+// by "parent/child" path.
 func (c *Commands) Get(name string) (cmd *CommandData, ok bool) {
-	c.RLock()
-	cmd, ok = c.m[name]
-	c.RUnlock()
+	name, child, isSub := strings.Cut(name, "/")
+
+	cmd, ok = c.get(name)
+
+	if isSub && ok {
+		if cmd.Sub == nil {
+			return nil, false
+		}
+		return cmd.Sub.Get(child)
+	}
 	return
 }
 
-// Del removes command from commands map.
+// Del removes command with all its versions from commands map. Sub-commands
+// are removed by "parent/child" path. Executions in flight are not waited, see DelDrain.
 func (c *Commands) Del(name string) {
+	name, child, isSub := strings.Cut(name, "/")
+	if isSub {
+		if cmd, ok := c.Get(name); ok && cmd.Sub != nil {
+			cmd.Sub.Del(child)
+		}
+		return
+	}
+
 	c.Lock()
-	delete(c.m, name)
+	key := c.key(name)
+	delete(c.m, key)
+	delete(c.versions, key)
+	c.refreeze()
 	c.Unlock()
 }
 
+// SetCaseInsensitive sets case-insensitive command names matching mode. When
+// it is on, commands are found by name in any letters case, like v1 Command
+// does. Commands keep their names as added. Commands which names differ only
+// by letters case are merged when the mode is turned on, the last one wins.
+func (c *Commands) SetCaseInsensitive(caseInsensitive bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.caseInsensitive = caseInsensitive
+
+	// Rebuild commands map with new keys
+	m := make(map[string]*CommandData, len(c.m))
+	for _, cmd := range c.m {
+		m[c.key(cmd.Cmd)] = cmd
+	}
+	c.m = m
+	versions := make(map[string][]versioned, len(c.versions))
+	for _, list := range c.versions {
+		key := c.key(list[0].cmd.Cmd)
+		versions[key] = append(versions[key], list...)
+	}
+	for _, list := range versions {
+		slices.SortFunc(list, func(a, b versioned) int { return a.v.compare(b.v) })
+	}
+	c.versions = versions
+	c.refreeze()
+}
+
+// key returns commands map key for the command name. It should be called
+// under the Commands lock.
+func (c *Commands) key(name string) string {
+	if c.caseInsensitive {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
 // Exec executes command from commands map. It returns the result of the command
-// execution or an error if the command is not found.
+// execution or an error if the command is not found. Command version may be
+// requested by "v2:name" prefix or AcceptVersionHeader, see AddVersion.
 //
 // Parameters:
 // - command: The name of the command to execute.
@@ -190,18 +419,81 @@ func (c *Commands) Del(name string) {
 func (c *Commands) Exec(command string, processIn ProcessIn, data any) (
 	[]byte, error) {
 
-	// Get the command from the commands map by name.
-	cmd, ok := c.Get(command)
+	// Get the command from the commands map by name and requested version.
+	cmd, ok := c.resolve(command, data)
 
-	// If the command is found and has a handler, execute the handler.
-	if ok && cmd.Handler != nil {
-		return cmd.Handler(cmd, processIn, data)
+	// If the command is found and has a handler, execute the handler,
+	// redact its error and result fields and transform its result.
+	if ok && cmd.HasHandler(processIn) {
+		res, err := c.execCommand(cmd, command, processIn, data)
+		if err == nil {
+			err = c.checkStream(cmd, data)
+		}
+		res, err = c.redactByRoles(cmd, data, res, c.redactError(cmd, err))
+		return c.transform(cmd, data, res, err)
 	}
 
 	// If the command is not found, return an error.
 	return nil, fmt.Errorf("command '%s' not found", command)
 }
 
+// execCommand executes found command handler with flags, cost, quota,
+// cache, timeouts and statistics.
+func (c *Commands) execCommand(cmd *CommandData, command string,
+	processIn ProcessIn, data any) ([]byte, error) {
+
+	// Check command feature flag
+	if !c.featureEnabled(cmd, data) {
+		return nil, fmt.Errorf("command '%s': %w", command, ErrFeatureDisabled)
+	}
+
+	// Check connection cost budget
+	if err := c.checkCost(cmd, data); err != nil {
+		return nil, err
+	}
+
+	// Check user quota
+	if err := c.checkQuota(cmd, data); err != nil {
+		return nil, err
+	}
+
+	// Get cached result
+	path := c.path(command)
+	var key string
+	if cmd.CacheTTL > 0 {
+		var ok bool
+		if key, ok = resultCacheKey(cmd, path, data); ok {
+			if res, ok := c.cached(cmd, key); ok {
+				return res, nil
+			}
+		}
+	}
+
+	// Execute command and count its statistics by command path
+	c.injectServices(data)
+	c.injectValues(data)
+	if err := c.acquire(cmd, path); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	res, err := c.execTimeout(cmd, path, processIn, data)
+	res, err = c.limitResponse(cmd, res, err)
+	latency := time.Since(start)
+	c.record(cmd, path, latency, err)
+
+	// Execute shadow handler
+	if cmd.Shadow != nil {
+		c.shadow(cmd, processIn, data, ShadowResult{Command: cmd.Cmd,
+			Result: res, Err: err, Latency: latency})
+	}
+
+	// Cache result, streamed response is not cached
+	if key != "" && err == nil && streamed(data) == nil {
+		c.setCached(cmd, key, res)
+	}
+	return res, err
+}
+
 // ForEach calls the given function for each added command.
 //
 // The function passed as an argument should accept two parameters:
@@ -220,8 +512,38 @@ func (c *Commands) ForEach(f func(command string, cmd *CommandData)) {
 	defer c.RUnlock()
 
 	// Iterate over the commands map and call the given function for each command
-	for command, cmd := range c.m {
-		f(command, cmd)
+	for _, cmd := range c.m {
+		f(cmd.Cmd, cmd)
+	}
+}
+
+// IterSorted returns iterator over commands sorted by name. It iterates over
+// snapshot of commands, so commands may be changed during iteration. Listings
+// and HabdleCommands use it to produce deterministic output and routes
+// registration order.
+//
+// Example usage:
+//
+//	for name, cmd := range commands.IterSorted() {
+//	    fmt.Println(name, cmd.Descr)
+//	}
+func (c *Commands) IterSorted() iter.Seq2[string, *CommandData] {
+	c.RLock()
+	list := make([]*CommandData, 0, len(c.m))
+	for _, cmd := range c.m {
+		list = append(list, cmd)
+	}
+	c.RUnlock()
+	slices.SortFunc(list, func(a, b *CommandData) int {
+		return strings.Compare(a.Cmd, b.Cmd)
+	})
+
+	return func(yield func(string, *CommandData) bool) {
+		for _, cmd := range list {
+			if !yield(cmd.Cmd, cmd) {
+				return
+			}
+		}
 	}
 }
 
@@ -234,19 +556,26 @@ func (c *Commands) ForEach(f func(command string, cmd *CommandData)) {
 //   - command: a string that represents the name of the command.
 //   - params: a string that represents the parameters of the command.
 //
-// The function iterates over the commands map using the ForEach method and
-// checks if the command's ProcessIn field has any bitwise AND operation with
+// The function iterates over the commands sorted by name using the IterSorted
+// method and checks if the command's ProcessIn field has any bitwise AND operation with
 // the processIn parameter and if the command's Handler field is not nil. If both
 // conditions are true, the h function is called with the command's name,
 // parameters, and handler.
 func (c *Commands) HabdleCommands(processIn ProcessIn,
 	h func(command, params string)) {
 
-	c.ForEach(func(command string, cmd *CommandData) {
-		if cmd.ProcessIn&processIn != 0 && cmd.Handler != nil {
+	for command, cmd := range c.IterSorted() {
+		if cmd.ProcessIn&processIn != 0 && cmd.HasHandler(processIn) {
 			h(command, cmd.Params)
 		}
-	})
+
+		// Sub-commands
+		if cmd.Sub != nil {
+			cmd.Sub.HabdleCommands(processIn, func(child, params string) {
+				h(command+"/"+child, params)
+			})
+		}
+	}
 }
 
 // ParseCommand parses the command data.
@@ -264,7 +593,8 @@ func (c *Commands) HabdleCommands(processIn ProcessIn,
 // where the key is the parameter name and the value is the parameter value.
 //
 // ***A value with slashes is processed successfully only in the last parameter
-// in 'data'.
+// in 'data'. Percent-encoded values are decoded by UnescapeParam, so values
+// escaped by EscapeParam or CommandLine may contain slashes in any parameter.
 func (c *Commands) ParseCommand(data []byte) (name string, vars map[string]string) {
 
 	// Initialize a map to store the command variables
@@ -273,8 +603,16 @@ func (c *Commands) ParseCommand(data []byte) (name string, vars map[string]strin
 	// Split the command data by '/' character
 	v := bytes.SplitN(data, []byte("/"), 2)
 
-	// Set the command name as the first part of the split data
+	// Set the command name as the first part of the split data, the name may
+	// have version prefix, like "v2:name"
 	name = string(v[0])
+	version, base := splitVersion(name)
+
+	// If the command name is not valid, return empty name and empty variables
+	if c.CheckName(base) != nil {
+		name = ""
+		return
+	}
 
 	// If there is no second part, return empty name and empty variables
 	if len(v) < 2 {
@@ -285,12 +623,22 @@ func (c *Commands) ParseCommand(data []byte) (name string, vars map[string]strin
 	cmdParams := v[1]
 
 	// Get the command data for the command name from the Commands struct
-	cmd, ok := c.Get(name)
+	cmd, ok := c.GetVersion(base, version)
 	if !ok {
 		// If the command is not found, return empty name and empty variables
 		return
 	}
 
+	// Route sub-command recursively, the parent command is returned if
+	// sub-command is not found
+	if cmd.Sub != nil {
+		child, childVars := cmd.Sub.ParseCommand(cmdParams)
+		if _, ok := cmd.Sub.Get(child); ok {
+			return name + "/" + child, childVars
+		}
+		return
+	}
+
 	// Get the command parameters as a slice
 	params := cmd.ParamsSlice()
 
@@ -300,7 +648,7 @@ func (c *Commands) ParseCommand(data []byte) (name string, vars map[string]strin
 		// Split the command parameters by '/' character and create a map of
 		// variables
 		for i, v := range bytes.SplitN(cmdParams, []byte("/"), len(params)) {
-			vars[params[i]] = string(v)
+			vars[params[i]] = UnescapeParam(string(v))
 		}
 	}
 
@@ -0,0 +1,108 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// About command module of Command processing golang package.
+
+package command
+
+import (
+	"encoding/json"
+	"runtime"
+	"runtime/debug"
+)
+
+// ModulePath is a path of this module.
+const ModulePath = "github.com/kirill-scherba/command/v2"
+
+// About is a build info and features report returned by about command.
+type About struct {
+	Module     string         `json:"module"`     // Module path
+	Version    string         `json:"version"`    // Module version
+	GoVersion  string         `json:"go_version"` // Go version
+	Subsystems []string       `json:"subsystems"` // Enabled subsystems
+	Transports []string       `json:"transports"` // Transports of registered commands
+	Commands   map[string]int `json:"commands"`   // Number of commands per transport
+	Total      int            `json:"total"`      // Number of registered commands
+}
+
+// About returns build info and features report of commands: module and Go
+// versions, enabled subsystems, transports used by registered commands and
+// number of commands per transport.
+func (c *Commands) About() About {
+	a := About{Module: ModulePath, Version: "unknown",
+		GoVersion: runtime.Version(), Commands: make(map[string]int)}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == ModulePath {
+			a.Version = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == ModulePath {
+				a.Version = dep.Version
+			}
+		}
+	}
+
+	// Enabled subsystems
+	c.RLock()
+	for _, s := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"metrics", true},
+		{"cache", c.cache != nil},
+		{"quota", c.quotaStore != nil},
+		{"cost", c.costs != nil},
+		{"flags", c.flags != nil},
+		{"services", c.services != nil},
+		{"subscription", c.subscription != nil},
+		{"timeouts", c.timeouts != Timeouts{}},
+		{"response limit", c.responseLimit.Size > 0},
+		{"crash reports", c.crashes != nil},
+		{"transforms", len(c.transforms) > 0},
+		{"error redaction", c.errorRedaction != nil},
+	} {
+		if s.enabled {
+			a.Subsystems = append(a.Subsystems, s.name)
+		}
+	}
+	c.RUnlock()
+	c.metrics.Lock()
+	if c.metrics.handler != nil {
+		a.Subsystems = append(a.Subsystems, "slo")
+	}
+	c.metrics.Unlock()
+
+	// Commands per transport
+	var used ProcessIn
+	c.ForEach(func(command string, cmd *CommandData) {
+		a.Total++
+		used |= cmd.ProcessIn
+		for _, pi := range ProcessIns() {
+			if cmd.ProcessIn&pi != 0 {
+				a.Commands[pi.String()]++
+			}
+		}
+	})
+	for _, pi := range ProcessIns() {
+		if used&pi != 0 {
+			a.Transports = append(a.Transports, pi.String())
+		}
+	}
+	return a
+}
+
+// AddAboutCommand adds about command to commands map. The about command
+// returns build info and features report in json format, see About.
+func (c *Commands) AddAboutCommand(processIn ProcessIn) {
+	c.Add("about", "Get build info and features report.", processIn, "",
+		"json build info and features report", "about",
+		`{"module":"github.com/kirill-scherba/command/v2","version":"v2.1.0",`+
+			`"go_version":"go1.23.2","subsystems":["metrics","cache"],`+
+			`"transports":["http","websocket"],`+
+			`"commands":{"http":10,"websocket":12},"total":14}`,
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			return json.Marshal(c.About())
+		},
+	)
+}
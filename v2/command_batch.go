@@ -0,0 +1,102 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Batch registration module of Command processing golang package.
+
+package command
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrCommandExists is an error returned when a command with the same name
+	// is already registered.
+	ErrCommandExists = fmt.Errorf("command already exists")
+
+	// ErrCommandNotFound is an error returned when a command is not found.
+	ErrCommandNotFound = fmt.Errorf("command not found")
+
+	// ErrInvalidName is an error returned when a command name is not valid.
+	ErrInvalidName = fmt.Errorf("invalid command name")
+
+	// ErrInvalidParams is an error returned when a command parameters string
+	// has wrong placeholders format.
+	ErrInvalidParams = fmt.Errorf("invalid command parameters")
+)
+
+// CommandSpec describes a command added with the AddBatch method. It has the
+// same fields as CommandData.
+type CommandSpec CommandData
+
+// AddBatch validates all command specs and adds them to commands map at once.
+//
+// Every spec is checked before the commands map is changed: the command name
+// syntax, the parameters placeholders format ("{name1}/{name2}") and
+// duplicates both inside the batch and among already added commands. If any
+// check fails, AddBatch returns an error and no command from the batch is
+// added, so the commands map is never left half-configured.
+func (c *Commands) AddBatch(specs []CommandSpec) error {
+
+	c.Lock()
+	defer c.Unlock()
+
+	// Validate specs and check duplicates inside the batch
+	names := make(map[string]struct{}, len(specs))
+	for i := range specs {
+		spec := &specs[i]
+		if err := c.checkName(spec.Cmd); err != nil {
+			return fmt.Errorf("command #%d: %w", i, err)
+		}
+		if err := checkParams(spec.Params); err != nil {
+			return fmt.Errorf("command '%s': %w", spec.Cmd, err)
+		}
+		key := c.key(spec.Cmd)
+		if _, ok := names[key]; ok {
+			return fmt.Errorf("command '%s' duplicated in batch: %w", spec.Cmd,
+				ErrCommandExists)
+		}
+		if _, ok := c.m[key]; ok {
+			return fmt.Errorf("command '%s': %w", spec.Cmd, ErrCommandExists)
+		}
+		names[key] = struct{}{}
+	}
+
+	// Add all commands
+	for i := range specs {
+		cmd := CommandData(specs[i])
+		c.m[c.key(cmd.Cmd)] = &cmd
+	}
+	c.refreeze()
+
+	return nil
+}
+
+// checkParams checks command parameters placeholders format. The params
+// should be empty or contain "/" separated unique placeholders like
+// "{name1}/{name2}".
+func checkParams(params string) error {
+	if params == "" {
+		return nil
+	}
+
+	names := make(map[string]struct{})
+	for _, param := range strings.Split(params, "/") {
+		if len(param) < 3 || param[0] != '{' || param[len(param)-1] != '}' {
+			return fmt.Errorf("wrong placeholder '%s': %w", param, ErrInvalidParams)
+		}
+		name := param[1 : len(param)-1]
+		if strings.ContainsAny(name, "{}") {
+			return fmt.Errorf("wrong placeholder '%s': %w", param, ErrInvalidParams)
+		}
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("duplicate placeholder '%s': %w", param,
+				ErrInvalidParams)
+		}
+		names[name] = struct{}{}
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Response cache module of Command processing golang package.
+
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+)
+
+// CacheStore stores cached command results, like Redis GET and SET with EX,
+// so cluster nodes share cached results. MemoryCache is used by default.
+type CacheStore interface {
+	// Get returns cached data by key and true if it is found.
+	Get(key string) ([]byte, bool, error)
+
+	// Set stores data by key for ttl.
+	Set(key string, data []byte, ttl time.Duration) error
+}
+
+// SetCacheStore sets store of cached results of commands with
+// CommandData.CacheTTL. The in-memory MemoryCache is used if store is nil.
+func (c *Commands) SetCacheStore(store CacheStore) {
+	c.Lock()
+	c.cache = store
+	c.Unlock()
+}
+
+// cacheStore returns commands cache store and creates default in-memory
+// store if it is not set.
+func (c *Commands) cacheStore() CacheStore {
+	c.RLock()
+	store := c.cache
+	c.RUnlock()
+	if store != nil {
+		return store
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.cache == nil {
+		c.cache = NewMemoryCache()
+	}
+	return c.cache
+}
+
+// resultCacheKey returns cache key of command request input data. The key depends
+// on command full path, like "user/create", version, request variables and
+// data, so command results cached this way should not depend on request
+// user.
+func resultCacheKey(cmd *CommandData, path string, data any) (string, bool) {
+	r, ok := data.(RequestInterface)
+	if !ok {
+		return "", false
+	}
+	if cmd.Version != "" {
+		path += "@" + cmd.Version
+	}
+	sum := sha256.Sum256(SigningData(path, r.GetVars(), r.GetData()))
+	return "cache/" + path + "/" + hex.EncodeToString(sum[:]), true
+}
+
+// cached returns cached result of command request. Cache store errors are
+// logged and treated as cache misses.
+func (c *Commands) cached(cmd *CommandData, key string) ([]byte, bool) {
+	data, ok, err := c.cacheStore().Get(key)
+	if err != nil {
+		log.Printf("command '%s' cache get error: %s", cmd.Cmd, err)
+		return nil, false
+	}
+	return data, ok
+}
+
+// setCached stores successful command result in cache.
+func (c *Commands) setCached(cmd *CommandData, key string, data []byte) {
+	if err := c.cacheStore().Set(key, data, cmd.CacheTTL); err != nil {
+		log.Printf("command '%s' cache set error: %s", cmd.Cmd, err)
+	}
+}
+
+// MemoryCache is in-memory CacheStore.
+type MemoryCache struct {
+	m     map[string]cacheItem
+	sweep time.Time
+	*sync.Mutex
+}
+
+// cacheItem is a MemoryCache item.
+type cacheItem struct {
+	data   []byte
+	expire time.Time
+}
+
+// NewMemoryCache creates new in-memory CacheStore.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{m: make(map[string]cacheItem), Mutex: new(sync.Mutex)}
+}
+
+// Get returns cached data by key and true if it is found and not expired.
+func (s *MemoryCache) Get(key string) ([]byte, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	item, ok := s.m[key]
+	if !ok || !time.Now().Before(item.expire) {
+		return nil, false, nil
+	}
+	return item.data, true, nil
+}
+
+// Set stores data by key for ttl. Expired items are removed once a minute.
+func (s *MemoryCache) Set(key string, data []byte, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.sweep) > time.Minute {
+		s.sweep = now
+		for k, item := range s.m {
+			if !now.Before(item.expire) {
+				delete(s.m, k)
+			}
+		}
+	}
+	s.m[key] = cacheItem{data: data, expire: now.Add(ttl)}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Completion module of Command processing golang package.
+
+package command
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Completer is a function that returns completions of command parameter
+// with index param (starting from 0) which value starts with prefix.
+type Completer func(param int, prefix string) []string
+
+// Complete returns completions of the last segment of command line like
+// "hello/Jo". The line without "/" is completed with command names, sub-command
+// names of groups are completed after "parent/", and parameters are completed
+// by command Complete function. Command names of groups are returned with
+// trailing "/". Completions are sorted.
+func (c *Commands) Complete(line string) (completions []string) {
+	name, rest, hasParams := strings.Cut(line, "/")
+
+	// Complete command names
+	if !hasParams {
+		c.ForEach(func(command string, cmd *CommandData) {
+			if !strings.HasPrefix(c.key(command), c.key(name)) {
+				return
+			}
+			if cmd.Sub != nil {
+				command += "/"
+			}
+			completions = append(completions, command)
+		})
+		sort.Strings(completions)
+		return
+	}
+
+	cmd, ok := c.Get(name)
+	if !ok {
+		return nil
+	}
+
+	// Complete sub-commands
+	if cmd.Sub != nil {
+		return cmd.Sub.Complete(rest)
+	}
+
+	// Complete parameters
+	if cmd.Complete == nil {
+		return nil
+	}
+	params := strings.Split(rest, "/")
+	param := len(params) - 1
+	if param >= len(cmd.ParamsSlice()) {
+		return nil
+	}
+	completions = cmd.Complete(param, params[param])
+	sort.Strings(completions)
+	return
+}
+
+// AddCompleteCommand adds complete command to commands map. The complete
+// command returns json array of completions of command line, see the
+// Complete method. It lets web terminals offer autocomplete.
+func (c *Commands) AddCompleteCommand(processIn ProcessIn) {
+	c.Add("complete", "Get command line completions.", processIn, "{line}",
+		"json array of completions", "complete/hello/Jo", `["John","Jonathan"]`,
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			vars, err := c.Vars(indata)
+			if err != nil {
+				return nil, err
+			}
+			completions := c.Complete(vars["line"])
+			if completions == nil {
+				completions = []string{}
+			}
+			return json.Marshal(completions)
+		},
+	)
+}
@@ -0,0 +1,245 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Pipeline conditions module of Command processing golang package.
+
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrCondition is an error returned when pipeline step condition has wrong
+// syntax.
+var ErrCondition = fmt.Errorf("wrong condition")
+
+// stepResult is a result of executed pipeline step.
+type stepResult struct {
+	data     []byte
+	err      error
+	executed bool
+}
+
+// condition is a compiled pipeline step condition.
+type condition func(results []stepResult) bool
+
+// parseCondition compiles pipeline step condition expression.
+//
+// The expression compares results of previous steps:
+//
+//	ok                        previous step succeeded
+//	failed                    previous step failed
+//	result == "done"          previous step result equals to value
+//	result.user.role != admin json field of previous step result
+//	step0.ok                  step with index 0 succeeded
+//	!step1.failed             negation
+//	ok && result.n == 1       and, or (|| has lower priority)
+//
+// Values may be quoted with double quotes. The expression has no side
+// effects and is evaluated in linear time.
+func parseCondition(expr string) (condition, error) {
+	tokens, err := conditionTokens(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s': %w", p.tokens[p.pos], ErrCondition)
+	}
+	return cond, nil
+}
+
+// conditionTokens splits condition expression to tokens: operators,
+// operands and quoted values. Quoted values keep their quotes.
+func conditionTokens(expr string) (tokens []string, err error) {
+	for i := 0; i < len(expr); {
+		switch ch := expr[i]; {
+		case ch == ' ' || ch == '\t':
+			i++
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"),
+			strings.HasPrefix(expr[i:], "=="), strings.HasPrefix(expr[i:], "!="):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case ch == '!':
+			tokens = append(tokens, "!")
+			i++
+		case ch == '"':
+			end := strings.IndexByte(expr[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed quote: %w", ErrCondition)
+			}
+			tokens = append(tokens, expr[i:i+end+2])
+			i += end + 2
+		default:
+			start := i
+			for i < len(expr) && !strings.ContainsRune(" \t&|=!\"", rune(expr[i])) {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("unexpected '%c': %w", ch, ErrCondition)
+			}
+			tokens = append(tokens, expr[start:i])
+		}
+	}
+	return
+}
+
+// conditionParser is recursive descent parser of condition expression.
+type conditionParser struct {
+	tokens []string
+	pos    int
+}
+
+// next returns next token or empty string.
+func (p *conditionParser) next() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// or parses "and || and ..." expression.
+func (p *conditionParser) or() (condition, error) {
+	left, err := p.and()
+	for err == nil && p.next() == "||" {
+		p.pos++
+		var right condition
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(r []stepResult) bool { return l(r) || right(r) }
+		}
+	}
+	return left, err
+}
+
+// and parses "unary && unary ..." expression.
+func (p *conditionParser) and() (condition, error) {
+	left, err := p.unary()
+	for err == nil && p.next() == "&&" {
+		p.pos++
+		var right condition
+		if right, err = p.unary(); err == nil {
+			l := left
+			left = func(r []stepResult) bool { return l(r) && right(r) }
+		}
+	}
+	return left, err
+}
+
+// unary parses "!unary" or comparison expression.
+func (p *conditionParser) unary() (condition, error) {
+	if p.next() == "!" {
+		p.pos++
+		cond, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(r []stepResult) bool { return !cond(r) }, nil
+	}
+	return p.comparison()
+}
+
+// comparison parses "operand" or "operand ==|!= value" expression.
+func (p *conditionParser) comparison() (condition, error) {
+	operand := p.next()
+	if operand == "" || strings.ContainsAny(operand[:1], "&|=!\"") {
+		return nil, fmt.Errorf("operand expected: %w", ErrCondition)
+	}
+	p.pos++
+
+	// Step index prefix
+	step := -1
+	if rest, ok := strings.CutPrefix(operand, "step"); ok {
+		index, field, _ := strings.Cut(rest, ".")
+		n, err := strconv.Atoi(index)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("wrong step '%s': %w", operand, ErrCondition)
+		}
+		step, operand = n, field
+	}
+	get := func(r []stepResult) (stepResult, bool) {
+		i := step
+		if i < 0 {
+			i = len(r) - 1
+		}
+		if i < 0 || i >= len(r) {
+			return stepResult{}, false
+		}
+		return r[i], r[i].executed
+	}
+
+	// Status operands
+	switch operand {
+	case "ok", "failed":
+		failed := operand == "failed"
+		return func(r []stepResult) bool {
+			res, ok := get(r)
+			return ok && (res.err != nil) == failed
+		}, nil
+	}
+
+	// Result comparison
+	path, ok := strings.CutPrefix(operand, "result")
+	if !ok || (path != "" && path[0] != '.') {
+		return nil, fmt.Errorf("unknown operand '%s': %w", operand, ErrCondition)
+	}
+	op := p.next()
+	if op != "==" && op != "!=" {
+		return nil, fmt.Errorf("== or != expected after '%s': %w", operand,
+			ErrCondition)
+	}
+	p.pos++
+	value := p.next()
+	if value == "" || value == "&&" || value == "||" {
+		return nil, fmt.Errorf("value expected: %w", ErrCondition)
+	}
+	p.pos++
+	value = strings.Trim(value, `"`)
+
+	var fields []string
+	if path != "" {
+		fields = strings.Split(path[1:], ".")
+	}
+	return func(r []stepResult) bool {
+		res, ok := get(r)
+		if !ok || res.err != nil {
+			return false
+		}
+		v, found := resultField(res.data, fields)
+		return (found && v == value) == (op == "==")
+	}, nil
+}
+
+// resultField returns string form of json field of data by path, or data
+// itself if path is empty.
+func resultField(data []byte, path []string) (string, bool) {
+	if len(path) == 0 {
+		return string(data), true
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var item any
+	if err := dec.Decode(&item); err != nil {
+		return "", false
+	}
+	for _, name := range path {
+		obj, ok := item.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if item, ok = obj[name]; !ok {
+			return "", false
+		}
+	}
+	return fmt.Sprint(item), true
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Cost-based throttling module of Command processing golang package.
+
+package command
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCostBudget is an error returned by Exec when connection cost budget of
+// the current time window is exhausted.
+var ErrCostBudget = fmt.Errorf("cost budget exhausted")
+
+// RateStore stores rate limiting counters shared by cluster nodes, like
+// Redis INCRBY with EXPIREAT or memcached incr. MemoryQuotaStore implements
+// it for tests and single process servers.
+type RateStore interface {
+	// IncrBy adds n to counter by key and returns its new value. The counter
+	// may be removed after expire time.
+	IncrBy(key string, n int64, expire time.Time) (int64, error)
+}
+
+// costLimiter limits sum of executed commands costs per connection in fixed
+// time window.
+type costLimiter struct {
+	budget int
+	window time.Duration
+	store  RateStore    // Shared counters store, in-process if nil
+	key    QuotaKeyFunc // Shared counters key
+	m      map[any]*costWindow
+	sweep  time.Time
+	*sync.Mutex
+}
+
+// costWindow is a connection cost spent in time window.
+type costWindow struct {
+	start time.Time
+	spent int
+}
+
+// SetCostBudget sets cost budget per connection per time window checked by
+// Exec. Every command costs CommandData.Cost or 1 if Cost is 0, so a few heavy
+// exports may spend the same budget as many cheap reads. Connection is the
+// request connection channel or client IP address if the request has no
+// channel, see SetCostStore for budgets shared by cluster nodes. Costs are
+// not limited if budget is 0.
+func (c *Commands) SetCostBudget(budget int, window time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	if budget <= 0 {
+		c.costs = nil
+		return
+	}
+	c.costs = &costLimiter{budget: budget, window: window,
+		m: make(map[any]*costWindow), Mutex: new(sync.Mutex)}
+}
+
+// SetCostStore sets shared store of cost budgets, so the budget set by
+// SetCostBudget applies across cluster of command servers. Shared budgets are
+// counted per key returned by key function, DefaultQuotaKey if nil, in fixed
+// time windows aligned to window duration. Costs are counted per process
+// connection if store is nil. It should be called after SetCostBudget.
+func (c *Commands) SetCostStore(store RateStore, key QuotaKeyFunc) {
+	if key == nil {
+		key = DefaultQuotaKey
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.costs != nil {
+		c.costs.store, c.costs.key = store, key
+	}
+}
+
+// checkCost spends command cost from connection budget of request input data
+// and returns ErrCostBudget if the budget is exhausted.
+func (c *Commands) checkCost(cmd *CommandData, data any) error {
+	c.RLock()
+	costs := c.costs
+	c.RUnlock()
+	if costs == nil {
+		return nil
+	}
+
+	r, ok := data.(RequestInterface)
+	if !ok {
+		return nil
+	}
+	req := WrapRequest(r)
+	cost := max(cmd.Cost, 1)
+
+	// Shared budget
+	if costs.store != nil {
+		ok, err := costs.spendShared(costs.key(req), cost)
+		if err != nil {
+			return fmt.Errorf("command '%s' cost: %w", cmd.Cmd, err)
+		}
+		if !ok {
+			return fmt.Errorf("command '%s' cost %d: %w", cmd.Cmd, cost,
+				ErrCostBudget)
+		}
+		return nil
+	}
+
+	// Connection budget
+	var key any
+	if ch := req.GetConnectionChannel(); ch != nil {
+		key = ch
+	} else {
+		addr := req.GetRemoteAddr()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		key = addr
+	}
+
+	if !costs.spend(key, cost) {
+		return fmt.Errorf("command '%s' cost %d: %w", cmd.Cmd, cost, ErrCostBudget)
+	}
+	return nil
+}
+
+// spendShared spends cost from shared budget of key. It returns false and
+// spends nothing if the budget has not enough cost left.
+func (l *costLimiter) spendShared(key string, cost int) (bool, error) {
+	start := time.Now().Truncate(l.window)
+	key = "cost/" + key + "/" + strconv.FormatInt(start.Unix(), 10)
+	expire := start.Add(l.window)
+
+	n, err := l.store.IncrBy(key, int64(cost), expire)
+	if err != nil {
+		return false, err
+	}
+	if n <= int64(l.budget) {
+		return true, nil
+	}
+
+	// Return cost to budget
+	_, err = l.store.IncrBy(key, -int64(cost), expire)
+	return false, err
+}
+
+// spend spends cost from connection budget. It returns false and spends
+// nothing if the budget has not enough cost left.
+func (l *costLimiter) spend(key any, cost int) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	// Remove expired windows
+	now := time.Now()
+	if now.Sub(l.sweep) > l.window {
+		l.sweep = now
+		for k, w := range l.m {
+			if now.Sub(w.start) >= l.window {
+				delete(l.m, k)
+			}
+		}
+	}
+
+	w, ok := l.m[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &costWindow{start: now}
+		l.m[key] = w
+	}
+	if w.spent+cost > l.budget {
+		return false
+	}
+	w.spent += cost
+	return true
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Crash reports module of Command processing golang package.
+
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrPanic is an error returned by Exec when command handler panics and
+// crash reports are enabled, see SetCrashReports.
+var ErrPanic = fmt.Errorf("command handler panic")
+
+// CrashReport is a report of recovered command handler panic.
+type CrashReport struct {
+	Command   string    `json:"command"`    // Command name
+	ProcessIn ProcessIn `json:"process_in"` // Input processing type
+	Panic     string    `json:"panic"`      // Panic value
+	Stack     string    `json:"stack"`      // Goroutine stack
+	VarsHash  string    `json:"vars_hash"`  // Hash of request variables
+	Time      time.Time `json:"time"`       // Panic time
+}
+
+// PanicHandler is a function called when command handler panic is
+// recovered. It may be used for alerting.
+type PanicHandler func(r CrashReport)
+
+// crashes is a bounded store of crash reports.
+type crashes struct {
+	reports []CrashReport
+	size    int
+	onPanic PanicHandler
+	*sync.Mutex
+}
+
+// SetCrashReports enables recovering of command handlers panics. Recovered
+// panic is returned by Exec as ErrPanic, its crash report is passed to
+// onPanic handler, if it is not nil, and stored in memory. Only last size
+// reports are stored, see CrashReports. Panics are not recovered if size is
+// 0 and onPanic is nil.
+func (c *Commands) SetCrashReports(size int, onPanic PanicHandler) {
+	c.Lock()
+	defer c.Unlock()
+	if size <= 0 && onPanic == nil {
+		c.crashes = nil
+		return
+	}
+	c.crashes = &crashes{size: max(size, 0), onPanic: onPanic,
+		Mutex: new(sync.Mutex)}
+}
+
+// CrashReports returns stored crash reports, oldest first.
+func (c *Commands) CrashReports() []CrashReport {
+	c.RLock()
+	s := c.crashes
+	c.RUnlock()
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	return slices.Clone(s.reports)
+}
+
+// AddCrashCommand adds crashes command to commands map. The crashes command
+// returns stored crash reports in json format. It is an admin command and
+// should be protected by access control.
+func (c *Commands) AddCrashCommand(processIn ProcessIn) {
+	c.Add("crashes", "Get crash reports.", processIn, "",
+		"json list of crash reports", "crashes",
+		`[{"command":"user","process_in":1,"panic":"runtime error: ...",`+
+			`"stack":"goroutine 1 ...","vars_hash":"5e8f1c2a9b7d3e40",`+
+			`"time":"2024-06-10T12:00:00Z"}]`,
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			reports := c.CrashReports()
+			if reports == nil {
+				reports = []CrashReport{}
+			}
+			return json.Marshal(reports)
+		},
+	)
+}
+
+// recovered returns handler which recovers panic of command handler h if
+// crash reports are enabled.
+func (c *Commands) recovered(h CommandHandler) CommandHandler {
+	c.RLock()
+	s := c.crashes
+	c.RUnlock()
+	if s == nil {
+		return h
+	}
+
+	return func(cmd *CommandData, processIn ProcessIn, data any) (
+		res []byte, err error) {
+
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			r := CrashReport{Command: cmd.Cmd, ProcessIn: processIn,
+				Panic: fmt.Sprint(p), Stack: string(debug.Stack()),
+				Time: time.Now()}
+			vars, _ := c.Vars(data)
+			r.VarsHash = varsHash(vars)
+			s.add(r)
+			res, err = nil, fmt.Errorf("command '%s': %v: %w", cmd.Cmd, p,
+				ErrPanic)
+		}()
+		return h(cmd, processIn, data)
+	}
+}
+
+// add stores crash report and calls panic handler.
+func (s *crashes) add(r CrashReport) {
+	if s.size > 0 {
+		s.Lock()
+		if len(s.reports) >= s.size {
+			s.reports = slices.Delete(s.reports, 0, len(s.reports)-s.size+1)
+		}
+		s.reports = append(s.reports, r)
+		s.Unlock()
+	}
+	if s.onPanic != nil {
+		s.onPanic(r)
+	}
+}
+
+// varsHash returns short hash of request variables. Variables values are
+// not stored in crash reports as they may contain personal data.
+func varsHash(vars map[string]string) string {
+	h := sha256.New()
+	for _, k := range slices.Sorted(maps.Keys(vars)) {
+		fmt.Fprintf(h, "%s=%s\n", k, vars[k])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
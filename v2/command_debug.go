@@ -0,0 +1,124 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Debug commands module of Command processing golang package.
+
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"runtime/pprof"
+	"strconv"
+	"time"
+)
+
+// ErrForbidden is an error returned by protected commands when request is
+// not authorized.
+var ErrForbidden = fmt.Errorf("forbidden")
+
+// MaxCPUProfile is a maximum duration of cpu profile of debug/cpu command.
+const MaxCPUProfile = time.Minute
+
+// DebugAuthorizer is a function which authorizes debug commands request. The
+// request is rejected if it returns an error.
+type DebugAuthorizer func(req RequestInterfaceV2) error
+
+// AddDebugCommands adds debug group of profiling commands to commands map:
+// debug/heap, debug/goroutine and debug/cpu/{seconds} commands return
+// pprof profiles, which may be analyzed by "go tool pprof", and debug/vars
+// command returns expvar variables in json format. Every command request is
+// authorized by authorize function, all requests are rejected with
+// ErrForbidden if it is nil.
+func (c *Commands) AddDebugCommands(processIn ProcessIn, authorize DebugAuthorizer) {
+
+	// protected returns handler h which is executed for authorized requests.
+	protected := func(h CommandHandler) CommandHandler {
+		return func(cmd *CommandData, processIn ProcessIn, indata any) (
+			[]byte, error) {
+
+			if authorize == nil {
+				return nil, ErrForbidden
+			}
+			req, err := c.Request(indata)
+			if err != nil {
+				return nil, err
+			}
+			if err = authorize(req); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrForbidden, err)
+			}
+			return h(cmd, processIn, indata)
+		}
+	}
+
+	// profile returns handler of named pprof profile.
+	profile := func(name string) CommandHandler {
+		return protected(func(cmd *CommandData, processIn ProcessIn,
+			indata any) ([]byte, error) {
+
+			var buf bytes.Buffer
+			if err := pprof.Lookup(name).WriteTo(&buf, 0); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		})
+	}
+
+	debug := c.AddGroup("debug", "Profiling and runtime variables commands.",
+		processIn)
+
+	debug.Add("heap", "Get heap profile.", processIn, "", "pprof heap profile",
+		"debug/heap", "binary pprof data", profile("heap"))
+
+	debug.Add("goroutine", "Get goroutines profile.", processIn, "",
+		"pprof goroutine profile", "debug/goroutine", "binary pprof data",
+		profile("goroutine"))
+
+	debug.Add("cpu", "Get cpu profile.", processIn, "{seconds}",
+		"pprof cpu profile", "debug/cpu/10", "binary pprof data",
+		protected(func(cmd *CommandData, processIn ProcessIn, indata any) (
+			[]byte, error) {
+
+			req, err := c.Request(indata)
+			if err != nil {
+				return nil, err
+			}
+			seconds, err := strconv.Atoi(req.GetVars()["seconds"])
+			if err != nil || seconds <= 0 {
+				return nil, fmt.Errorf("wrong seconds parameter: %w",
+					ErrIncorrectInputData)
+			}
+			duration := min(time.Duration(seconds)*time.Second, MaxCPUProfile)
+
+			var buf bytes.Buffer
+			if err = pprof.StartCPUProfile(&buf); err != nil {
+				return nil, err
+			}
+			timer := time.NewTimer(duration)
+			select {
+			case <-timer.C:
+			case <-req.GetContext().Done():
+				timer.Stop()
+			}
+			pprof.StopCPUProfile()
+			return buf.Bytes(), nil
+		}),
+	)
+
+	debug.Add("vars", "Get expvar variables.", processIn, "",
+		"json object of expvar variables", "debug/vars",
+		`{"cmdline":["server"],"memstats":{"Alloc":1024}}`,
+		protected(func(cmd *CommandData, processIn ProcessIn, indata any) (
+			[]byte, error) {
+
+			vars := make(map[string]json.RawMessage)
+			expvar.Do(func(kv expvar.KeyValue) {
+				vars[kv.Key] = json.RawMessage(kv.Value.String())
+			})
+			return json.Marshal(vars)
+		}),
+	)
+}
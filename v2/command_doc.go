@@ -0,0 +1,73 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command documentation page of Command processing golang package.
+
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+)
+
+// commandDocTemplate is command documentation page template.
+var commandDocTemplate = template.Must(template.New("doc").Parse(`
+	<!DOCTYPE html>
+	<html lang="en">
+	<body>
+	<div><a href="../commands">Commands api</a></div>
+	<h1>{{.Cmd}}{{if .Version}} <span class="version">v{{.Version}}</span>{{end}}</h1>
+	<div class="descr">{{.Descr}}</div>
+	{{if .Tags}}<div class="tags">tags: {{range .Tags}}<span class="tag">{{.}}</span> {{end}}</div>{{end}}
+	<div class="params">processing in: {{.ProcessIn}}</div>
+	<div class="params">path: {{.Cmd}}{{if .Params}}/{{.Params}}{{end}}</div>
+
+	{{if .ParamsSlice}}
+	<h2>Parameters</h2>
+	<table>
+		<tr><th>#</th><th>name</th></tr>
+		{{range $i, $p := .ParamsSlice}}<tr><td>{{$i}}</td><td>{{$p}}</td></tr>
+		{{end}}
+	</table>
+	{{end}}
+
+	{{if .Return}}<h2>Return</h2><div>{{.Return}}</div>{{end}}
+	{{if .Request}}<h2>Request example</h2><pre>{{.Request}}</pre>{{end}}
+	{{if .Response}}<h2>Response example</h2><pre>{{.Response}}</pre>{{end}}
+	{{if .RequestSchema}}<h2>Request schema</h2><pre>{{.RequestSchema}}</pre>{{end}}
+	{{if .ResponseSchema}}<h2>Response schema</h2><pre>{{.ResponseSchema}}</pre>{{end}}
+
+	<style>
+	.version, .tag, .descr {
+		font-size: small;
+	}
+	table {
+		border-collapse: collapse;
+	}
+	th, td {
+		border: 1px solid #ccc;
+		padding: 2px 8px;
+		text-align: left;
+	}
+	</style>
+	</body>
+	</html>`))
+
+// commandDocHandler returns documentation page of command in html format.
+// The page is served at "commands/{name}" route and links to commands list
+// relatively.
+func (a *Commands) commandDocHandler(name string) ([]byte, error) {
+	cmd, ok := a.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("command '%s': %w", name, ErrCommandNotFound)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := commandDocTemplate.Execute(buf, cmd); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Commands draining module of Command processing golang package.
+
+package command
+
+import (
+	"context"
+	"fmt"
+)
+
+// DelDrain removes command from commands map like Del and waits for its
+// executions in flight to finish. New executions of the command are
+// rejected with ErrCommandNotFound from the call, so when DelDrain returns
+// nil the command handler is not executed anymore and may be released or
+// replaced. It returns ctx error if ctx is done before the command is
+// drained, the command is removed anyway.
+func (c *Commands) DelDrain(ctx context.Context, name string) error {
+	if _, ok := c.Get(name); !ok {
+		return fmt.Errorf("command '%s': %w", name, ErrCommandNotFound)
+	}
+
+	m := &c.metrics
+	m.Lock()
+	cnt := m.counters(c.path(name))
+	cnt.draining = true
+	var drained chan struct{}
+	if cnt.inFlight > 0 {
+		drained = make(chan struct{})
+		cnt.drained = drained
+	}
+	m.Unlock()
+
+	defer func() {
+		m.Lock()
+		cnt.draining, cnt.drained = false, nil
+		m.Unlock()
+	}()
+
+	c.Del(name)
+	if drained == nil {
+		return nil
+	}
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
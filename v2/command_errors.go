@@ -0,0 +1,134 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Errors redaction module of Command processing golang package.
+
+package command
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+)
+
+// DefaultErrorMessage is a default client-facing message of redacted errors.
+const DefaultErrorMessage = "internal error"
+
+// safeErrors are package errors which are passed to clients as is.
+var safeErrors = []error{
+	ErrIncorrectInputData, ErrCommandNotFound, ErrInvalidParams, ErrCondition,
+	ErrCostBudget, ErrForbidden, ErrFeatureDisabled, ErrResponseTooLarge,
+	ErrQuotaExceeded, ErrConcurrencyLimit, ErrTimeout, ErrInvalidVersion,
+	ErrSignature, ErrNoUser,
+}
+
+// ErrorRedaction is an errors redaction policy which converts internal
+// errors of command handlers, like stack traces and SQL errors, to safe
+// client-facing errors with incident ID. The original error is logged
+// server-side with the incident ID.
+type ErrorRedaction struct {
+	// Message is a client-facing message of redacted errors,
+	// DefaultErrorMessage if empty.
+	Message string
+
+	// Map returns client-facing message of error, the error is redacted with
+	// Message if it returns false. It may be nil.
+	Map func(cmd *CommandData, err error) (message string, ok bool)
+
+	// Log logs redacted error with incident ID, log.Printf is used if nil.
+	Log func(incident string, cmd *CommandData, err error)
+}
+
+// RedactedError is a client-facing error of redacted internal error. It
+// unwraps to internal error, so errors.Is works on server side, while its
+// message has no internal error details.
+type RedactedError struct {
+	Message  string // Client-facing message
+	Incident string // Incident ID logged with internal error
+	err      error
+}
+
+func (e *RedactedError) Error() string {
+	return e.Message + " (incident " + e.Incident + ")"
+}
+
+func (e *RedactedError) Unwrap() error { return e.err }
+
+// publicError is an error marked safe for clients by PublicError.
+type publicError struct{ error }
+
+func (e publicError) Unwrap() error { return e.error }
+
+// PublicError marks handler error as safe for clients, so it is not redacted.
+func PublicError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return publicError{err}
+}
+
+// SetErrorRedaction sets default errors redaction policy applied by Exec to
+// errors of command handlers, errors are not redacted if policy is nil.
+// Package errors, like ErrQuotaExceeded, and errors marked by PublicError
+// are not redacted. CommandData ErrorRedaction overrides default policy.
+func (c *Commands) SetErrorRedaction(policy *ErrorRedaction) {
+	c.Lock()
+	defer c.Unlock()
+	c.errorRedaction = policy
+}
+
+// redactError applies errors redaction policy to command error.
+func (c *Commands) redactError(cmd *CommandData, err error) error {
+	if err == nil {
+		return nil
+	}
+	policy := cmd.ErrorRedaction
+	if policy == nil {
+		c.RLock()
+		policy = c.errorRedaction
+		c.RUnlock()
+	}
+	if policy == nil || isSafeError(err) {
+		return err
+	}
+
+	e := &RedactedError{Message: policy.Message, Incident: incidentID(), err: err}
+	if e.Message == "" {
+		e.Message = DefaultErrorMessage
+	}
+	if policy.Map != nil {
+		if message, ok := policy.Map(cmd, err); ok {
+			e.Message = message
+		}
+	}
+	if policy.Log != nil {
+		policy.Log(e.Incident, cmd, err)
+	} else {
+		log.Printf("command '%s' error, incident %s: %v", cmd.Cmd, e.Incident, err)
+	}
+	return e
+}
+
+// isSafeError returns true if err is package error or marked by
+// PublicError.
+func isSafeError(err error) bool {
+	var public publicError
+	if errors.As(err, &public) {
+		return true
+	}
+	for _, safe := range safeErrors {
+		if errors.Is(err, safe) {
+			return true
+		}
+	}
+	return false
+}
+
+// incidentID returns new random incident ID.
+func incidentID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
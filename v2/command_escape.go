@@ -0,0 +1,46 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command parameters escaping module of Command processing golang package.
+
+package command
+
+import (
+	"net/url"
+	"strings"
+)
+
+// EscapeParam escapes command parameter value, so values containing '/',
+// spaces, '%' or unicode characters may be sent in command line and HTTP
+// path. The '+' character is not changed as it is literal in paths.
+func EscapeParam(value string) string {
+	return url.PathEscape(value)
+}
+
+// UnescapeParam decodes percent-encoded command parameter value. The '+'
+// character is not decoded to space. The value is returned as is if it is
+// not correctly encoded, so not escaped values sent by older clients are
+// accepted.
+func UnescapeParam(value string) string {
+	if !strings.Contains(value, "%") {
+		return value
+	}
+	v, err := url.PathUnescape(value)
+	if err != nil {
+		return value
+	}
+	return v
+}
+
+// CommandLine returns command line of command name with escaped parameters
+// values, like "hello/John%20Doe", parsed by ParseCommand.
+func CommandLine(name string, params ...string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, p := range params {
+		b.WriteByte('/')
+		b.WriteString(EscapeParam(p))
+	}
+	return b.String()
+}
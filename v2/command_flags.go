@@ -0,0 +1,60 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Feature flags module of Command processing golang package.
+
+package command
+
+import "fmt"
+
+// ErrFeatureDisabled is an error returned by Exec when command feature flag
+// is disabled for request.
+var ErrFeatureDisabled = fmt.Errorf("feature disabled")
+
+// FeatureFlagProvider checks feature flags. It is consulted by Exec before
+// command handler is called, so commands may be dark-launched per user or
+// tenant without code changes in handlers.
+type FeatureFlagProvider interface {
+	// Enabled returns true if feature flag is enabled for request. The
+	// request is nil if command input data is not a request.
+	Enabled(flag string, req RequestInterfaceV2) bool
+}
+
+// FeatureFlagFunc is a function adapter of FeatureFlagProvider.
+type FeatureFlagFunc func(flag string, req RequestInterfaceV2) bool
+
+// Enabled calls f(flag, req).
+func (f FeatureFlagFunc) Enabled(flag string, req RequestInterfaceV2) bool {
+	return f(flag, req)
+}
+
+// SetFeatureFlags sets feature flags provider consulted by Exec. The flag of
+// command is CommandData.Flag or the command name if Flag is empty. Feature
+// flags are not checked if provider is nil.
+func (c *Commands) SetFeatureFlags(provider FeatureFlagProvider) {
+	c.Lock()
+	c.flags = provider
+	c.Unlock()
+}
+
+// featureEnabled returns true if command feature flag is enabled for request
+// input data or flags provider is not set.
+func (c *Commands) featureEnabled(cmd *CommandData, data any) bool {
+	c.RLock()
+	flags := c.flags
+	c.RUnlock()
+
+	if flags == nil {
+		return true
+	}
+	flag := cmd.Flag
+	if flag == "" {
+		flag = cmd.Cmd
+	}
+	var req RequestInterfaceV2
+	if r, ok := data.(RequestInterface); ok {
+		req = WrapRequest(r)
+	}
+	return flags.Enabled(flag, req)
+}
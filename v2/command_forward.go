@@ -0,0 +1,98 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Forwarded commands envelope module of Command processing golang package.
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CallInfo contains request attributes propagated when command is forwarded
+// from gateway to backend node.
+type CallInfo struct {
+	RequestID   string `json:"request_id,omitempty"`  // Request ID
+	Priority    int    `json:"priority,omitempty"`    // Request priority
+	TraceParent string `json:"traceparent,omitempty"` // W3C trace context
+}
+
+// callInfoKey is a context key of CallInfo.
+type callInfoKey struct{}
+
+// WithCallInfo returns copy of ctx with call info.
+func WithCallInfo(ctx context.Context, info CallInfo) context.Context {
+	return context.WithValue(ctx, callInfoKey{}, info)
+}
+
+// CallInfoFrom returns call info of ctx.
+func CallInfoFrom(ctx context.Context) (info CallInfo, ok bool) {
+	info, ok = ctx.Value(callInfoKey{}).(CallInfo)
+	return
+}
+
+// Envelope is a standard envelope of command forwarded from gateway to
+// backend node. It carries request deadline, call info and user identity, so
+// backend executes the command as if it was called locally. Backend should
+// trust identity only of authenticated gateways, for example verified with
+// Verifier.
+type Envelope struct {
+	Command  string            `json:"command"`            // Command name
+	Vars     map[string]string `json:"vars,omitempty"`     // Request variables
+	Data     []byte            `json:"data,omitempty"`     // Request data
+	Deadline time.Time         `json:"deadline"`           // Request deadline
+	Identity string            `json:"identity,omitempty"` // Request user
+	CallInfo
+}
+
+// NewEnvelope creates envelope of command request forwarded to backend. The
+// request user is used as identity if it is a string or fmt.Stringer.
+func NewEnvelope(command string, req RequestInterfaceV2) Envelope {
+	e := Envelope{Command: command, Vars: req.GetVars(), Data: req.GetData()}
+	ctx := req.GetContext()
+	e.Deadline, _ = ctx.Deadline()
+	e.CallInfo, _ = CallInfoFrom(ctx)
+	switch user := req.GetUser().(type) {
+	case string:
+		e.Identity = user
+	case fmt.Stringer:
+		e.Identity = user.String()
+	}
+	return e
+}
+
+// Request returns request of envelope with context derived from parent. The
+// context has envelope deadline and call info, the request user is envelope
+// identity. The returned cancel function should be called when the request
+// is executed.
+func (e Envelope) Request(parent context.Context) (*DefaultRequest,
+	context.CancelFunc) {
+
+	ctx, cancel := parent, context.CancelFunc(func() {})
+	if !e.Deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(parent, e.Deadline)
+	}
+	ctx = WithCallInfo(ctx, e.CallInfo)
+
+	req := &DefaultRequest{Vars: e.Vars, Data: e.Data, Ctx: ctx}
+	if e.Identity != "" {
+		req.User = e.Identity
+	}
+	return req, cancel
+}
+
+// ExecEnvelope executes command forwarded in envelope. It returns the context
+// error without executing the command if the envelope deadline is exceeded.
+func (c *Commands) ExecEnvelope(ctx context.Context, e Envelope,
+	processIn ProcessIn) ([]byte, error) {
+
+	req, cancel := e.Request(ctx)
+	defer cancel()
+	if err := req.Ctx.Err(); err != nil {
+		return nil, fmt.Errorf("command '%s': %w", e.Command, err)
+	}
+	return c.Exec(e.Command, processIn, req)
+}
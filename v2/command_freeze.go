@@ -0,0 +1,84 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Frozen commands registry module of Command processing golang package.
+
+package command
+
+import (
+	"maps"
+	"strings"
+)
+
+// frozenCommands is a read-only snapshot of commands map.
+type frozenCommands struct {
+	m               map[string]*CommandData
+	caseInsensitive bool
+}
+
+// Freeze makes read-only snapshot of commands map used by Get and Exec
+// without locking, which lowers commands lookup latency of busy transports.
+// Sub-commands groups are frozen too. Commands added, replaced or removed
+// after Freeze rebuild the snapshot, so every change copies the commands
+// map and production servers usually call Freeze once after all commands
+// are added.
+func (c *Commands) Freeze() {
+	c.Lock()
+	f := &frozenCommands{m: maps.Clone(c.m), caseInsensitive: c.caseInsensitive}
+	c.frozen.Store(f)
+	c.Unlock()
+
+	for _, cmd := range f.m {
+		if cmd.Sub != nil {
+			cmd.Sub.Freeze()
+		}
+	}
+}
+
+// Unfreeze drops read-only snapshot of commands map made by Freeze, so Get
+// and Exec use the commands map without snapshot copies on its changes.
+func (c *Commands) Unfreeze() {
+	c.Lock()
+	f := c.frozen.Swap(nil)
+	c.Unlock()
+	if f == nil {
+		return
+	}
+	for _, cmd := range f.m {
+		if cmd.Sub != nil {
+			cmd.Sub.Unfreeze()
+		}
+	}
+}
+
+// Frozen returns true if commands map is frozen, see Freeze.
+func (c *Commands) Frozen() bool {
+	return c.frozen.Load() != nil
+}
+
+// refreeze rebuilds read-only snapshot of frozen commands map after its
+// change. It should be called under the Commands lock.
+func (c *Commands) refreeze() {
+	if c.frozen.Load() == nil {
+		return
+	}
+	c.frozen.Store(&frozenCommands{m: maps.Clone(c.m),
+		caseInsensitive: c.caseInsensitive})
+}
+
+// get returns command from frozen snapshot or from commands map.
+func (c *Commands) get(name string) (cmd *CommandData, ok bool) {
+	if f := c.frozen.Load(); f != nil {
+		if f.caseInsensitive {
+			name = strings.ToLower(name)
+		}
+		cmd, ok = f.m[name]
+		return
+	}
+
+	c.RLock()
+	cmd, ok = c.m[c.key(name)]
+	c.RUnlock()
+	return
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Sub-commands module of Command processing golang package.
+
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// AddGroup adds parent command which groups sub-commands and returns
+// Commands object of its sub-commands. Sub-commands are added to the
+// returned object and addressed by "parent/child" path, like "user/create",
+// in Get, Exec, ParseCommand and HabdleCommands. Groups may be nested. The
+// parent command executed without sub-command returns help listing its
+// sub-commands.
+func (c *Commands) AddGroup(name, descr string, processIn ProcessIn) *Commands {
+	sub := New()
+	c.RLock()
+	sub.validator, sub.caseInsensitive = c.validator, c.caseInsensitive
+	c.RUnlock()
+
+	c.Lock()
+	key := c.key(name)
+	c.m[key] = &CommandData{
+		Cmd: name, ProcessIn: processIn, Descr: descr,
+		Return: "list of sub-commands", Sub: sub,
+		Handler: func(cmd *CommandData, processIn ProcessIn, data any) (
+			[]byte, error) {
+			return sub.help(cmd.Cmd), nil
+		},
+	}
+	delete(c.versions, key)
+	c.refreeze()
+	c.Unlock()
+
+	// Group of frozen commands is frozen too
+	if c.Frozen() {
+		sub.Freeze()
+	}
+
+	return sub
+}
+
+// help returns text help of group sub-commands.
+func (c *Commands) help(parent string) []byte {
+	var lines []string
+	for command, cmd := range c.IterSorted() {
+		line := parent + "/" + command
+		if cmd.Params != "" {
+			line += "/" + cmd.Params
+		}
+		if cmd.Sub != nil {
+			line += "/..."
+		}
+		if cmd.Descr != "" {
+			line += " - " + cmd.Descr
+		}
+		lines = append(lines, line)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s sub-commands:\n", parent)
+	for _, line := range lines {
+		buf.WriteString("  " + line + "\n")
+	}
+	return buf.Bytes()
+}
+
+// path returns full path of command requested by name with optional version
+// prefix, like "user/create", made of names of found commands as they were
+// added. Commands statistics and limits are kept by path, so sub-commands
+// don't share them with top-level commands of the same name.
+func (c *Commands) path(name string) string {
+	_, name = splitVersion(name)
+	name, child, isSub := strings.Cut(name, "/")
+	cmd, ok := c.get(name)
+	switch {
+	case !ok:
+		return name
+	case !isSub || cmd.Sub == nil:
+		return cmd.Cmd
+	}
+	return cmd.Cmd + "/" + cmd.Sub.path(child)
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Response size limit module of Command processing golang package.
+
+package command
+
+import "fmt"
+
+// ErrResponseTooLarge is an error returned by Exec when command result
+// exceeds response size limit, see SetResponseLimit.
+var ErrResponseTooLarge = fmt.Errorf("response too large")
+
+// ResponseLimit is a command result size limit.
+type ResponseLimit struct {
+	Size     int  // Maximum result size in bytes, unlimited if 0
+	Truncate bool // Truncate result instead of returning ErrResponseTooLarge
+}
+
+// SetResponseLimit sets default result size limit of commands. The
+// CommandData ResponseLimit field overrides it for the command.
+func (c *Commands) SetResponseLimit(limit ResponseLimit) {
+	c.Lock()
+	defer c.Unlock()
+	c.responseLimit = limit
+}
+
+// limitResponse applies command result size limit to result.
+func (c *Commands) limitResponse(cmd *CommandData, res []byte, err error) (
+	[]byte, error) {
+
+	c.RLock()
+	limit := c.responseLimit
+	c.RUnlock()
+	if cmd.ResponseLimit != nil {
+		limit = *cmd.ResponseLimit
+	}
+	if err != nil || limit.Size <= 0 || len(res) <= limit.Size {
+		return res, err
+	}
+	if limit.Truncate {
+		return res[:limit.Size:limit.Size], nil
+	}
+	return nil, fmt.Errorf("command '%s' result %d bytes, limit %d: %w",
+		cmd.Cmd, len(res), limit.Size, ErrResponseTooLarge)
+}
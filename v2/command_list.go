@@ -10,12 +10,18 @@ import (
 	"bytes"
 	"encoding/json"
 	"html/template"
-	"sort"
+	"log"
+	"maps"
+	"slices"
+	"strings"
 )
 
 // AddCommandsList adds commands list command. The commands list command return
 // list of all commands of this server in text format. The input function f
-// should convert indata to map[string]string.
+// should convert indata to map[string]string. Commands processed in HTTP
+// also get html list of commands and commdoc command which serves
+// documentation page of command at "commands/{name}" HTTP route. Pages links
+// are relative, so they work under any server prefix.
 func (a *Commands) AddCommandsList(processIn ProcessIn, setFieldsets ...bool) {
 
 	// Check setFieldset
@@ -33,7 +39,8 @@ func (a *Commands) AddCommandsList(processIn ProcessIn, setFieldsets ...bool) {
 		if err != nil {
 			return nil, err
 		}
-		return a.commandsHttpHandler(setFieldset, vars)
+		vars = filterQueryVars(indata, vars)
+		return a.commandsHttpHandler(setFieldset, vars, command.ParamsSlice())
 	}
 
 	// handlerJson converts input data to map[string]string and use it in
@@ -50,22 +57,45 @@ func (a *Commands) AddCommandsList(processIn ProcessIn, setFieldsets ...bool) {
 		returnDesc := "HTML list of commands"
 		a.Add("commands", "Get html list of commands.", processIn,
 			"", returnDesc, "", "", handler)
+		err := a.AddBatch([]CommandSpec{{
+			Cmd: "commdoc", ProcessIn: processIn, Params: "{name}",
+			Route: "commands/{name}", Descr: "Get html documentation page of command.",
+			Return: "HTML command documentation", Request: "commdoc/commands",
+			Handler: func(command *CommandData, processIn ProcessIn, indata any) (
+				[]byte, error) {
+
+				vars, err := a.Vars(indata)
+				if err != nil {
+					return nil, err
+				}
+				return a.commandDocHandler(vars["name"])
+			},
+		}})
+		if err != nil {
+			log.Printf("commands list documentation command add error: %s", err)
+		}
 		if setFieldset {
+			var params []string
+			for _, pi := range filterPathProcessIns {
+				params = append(params, "{"+processInKey(pi)+"}")
+			}
 			a.Add("commfilt", "Get html list of commands with filter.", processIn,
-				"{http}/{webrtc}/{tru}/{ws}", returnDesc, "", "", handler)
+				strings.Join(params, "/"), returnDesc, "", "", handler)
 		}
 	}
 }
 
 // Page item struct
 type commandsListItem struct {
-	Command   string `json:"command"`
-	Params    string `json:"params"`
-	Return    string `json:"return"`
-	ProcessIn string `json:"processIn"`
-	Descr     string `json:"descr"`
-	Request   string `json:"request"`
-	Response  string `json:"response"`
+	Command   string   `json:"command"`
+	Params    string   `json:"params"`
+	Return    string   `json:"return"`
+	ProcessIn string   `json:"processIn"`
+	Descr     string   `json:"descr"`
+	Request   string   `json:"request"`
+	Response  string   `json:"response"`
+	Version   string   `json:"version,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
 }
 
 // commandsJsonHandler returns array of commands in json format.
@@ -74,22 +104,20 @@ func (a *Commands) commandsJsonHandler() ([]byte, error) {
 	var list []commandsListItem
 
 	// Get list of commands
-	a.ForEach(func(command string, cmd *CommandData) {
+	for command, cmd := range a.IterSorted() {
 		list = append(list, commandsListItem{
 			command, cmd.Params, cmd.Return, cmd.ProcessIn.String(), cmd.Descr,
-			cmd.Request, cmd.Response,
+			cmd.Request, cmd.Response, cmd.Version, cmd.Tags,
 		})
-
-	})
-	sort.Slice(list, func(i, j int) bool {
-		return list[i].Command < list[j].Command
-	})
+	}
 
 	return json.Marshal(list)
 }
 
-// commandsHttpHandler returns list of commands in html format.
-func (a *Commands) commandsHttpHandler(setFieldset bool, vars map[string]string) ([]byte, error) {
+// commandsHttpHandler returns list of commands in html format. The params
+// are parameters of page command, links are relative to page path.
+func (a *Commands) commandsHttpHandler(setFieldset bool, vars map[string]string,
+	params []string) ([]byte, error) {
 
 	var fieldset string
 
@@ -98,19 +126,10 @@ func (a *Commands) commandsHttpHandler(setFieldset bool, vars map[string]string)
 			`
 	<fieldset>
 	<legend>Choose processing in commands:</legend>
-	<div>
-		<input type="checkbox" id="http" name="http" onclick="onClickHandler()" checked />
-		<label for="scales">Http</label>
-	
-		<input type="checkbox" id="webrtc" name="webrtc" onclick="onClickHandler()" checked />
-		<label for="horns">Webrtc</label>
-	
-		<input type="checkbox" id="tru" name="tru" onclick="onClickHandler()" checked />
-		<label for="horns">Tru</label>
-
-		<input type="checkbox" id="websocket" name="websocket" onclick="onClickHandler()" checked />
-		<label for="horns">Websocket</label>
-	</div>
+	<div>{{range .Filter}}
+		<input type="checkbox" id="{{.Key}}" name="{{.Key}}" onclick="onClickHandler()"{{if .Checked}} checked{{end}} />
+		<label for="{{.Key}}">{{.Name}}</label>
+	{{end}}</div>
 	</fieldset>
 	<br/>
 	`
@@ -127,31 +146,12 @@ func (a *Commands) commandsHttpHandler(setFieldset bool, vars map[string]string)
 	</div>
 	<br/>
 
-	<script>
-	function setValues() {
-		const params = new URLSearchParams(document.location.search);
-
-		const http = "{{.Filter.ProcessIn.Http}}";
-		document.getElementById("http").checked = http=="true";
-
-		const webrtc = "{{.Filter.ProcessIn.Webrtc}}";
-		document.getElementById("webrtc").checked = webrtc=="true";
-
-		const tru = "{{.Filter.ProcessIn.Tru}}";
-		document.getElementById("tru").checked = tru=="true";
-
-		const ws = "{{.Filter.ProcessIn.Websocket}}";
-		document.getElementById("websocket").checked = ws=="true";
-	}
-	setValues();
-	</script>
-
 	<div class="list">
 	{{range .List}}
-		<div class="command">{{.Command}}</div>
+		<div class="command"><a href="{{$.Base}}commands/{{.Command}}">{{.Command}}</a></div>
 		<div class="descr">{{.Descr}}</div>{{if .Params}}
 		<div class="params">params: {{.Params}}</div>{{end}}
-		<div class="params">return: {{.Return}}</div>{{end}}
+		<div class="params">return: {{.Return}}</div>
 		<div class="params">processing in: {{.ProcessIn}}</div>
 		<br/>
 	{{end}}
@@ -159,16 +159,19 @@ func (a *Commands) commandsHttpHandler(setFieldset bool, vars map[string]string)
 
 	<script>
 	function onClickHandler() {
-		var chkHttp = document.getElementById("http").checked;
-		var chkWebrtc = document.getElementById("webrtc").checked;
-		var chkTru = document.getElementById("tru").checked;
-		var chkWs = document.getElementById("websocket").checked;
-
-		if (chkHttp && chkWebrtc && chkTru && chkWs) {
-			window.location = '/commands';
-			return;
+		const keys = [{{range .Filter}}[{{.Key}}, {{.Query}}],{{end}}];
+		let all = true, path = '', query = [];
+		for (const [key, inQuery] of keys) {
+			const checked = document.getElementById(key).checked;
+			all = all && checked;
+			if (!inQuery) {
+				path += '/' + checked;
+			} else if (!checked) {
+				query.push(key + '=false');
+			}
 		}
-		window.location = '/commfilt/'+chkHttp+'/'+chkWebrtc+'/'+chkTru+'/'+chkWs;
+		window.location = {{.Base}} + (all ? 'commands' : 'commfilt' + path +
+			(query.length ? '?' + query.join('&') : ''));
 	}
 	</script>
 
@@ -186,45 +189,47 @@ func (a *Commands) commandsHttpHandler(setFieldset bool, vars map[string]string)
 	</body>
 	</html>`
 
+	// Filter item struct
+	type FilterItem struct {
+		Key     string // Filter parameter name
+		Name    string // Processing type name
+		Checked bool
+		Query   bool // Filter parameter is query parameter
+	}
+
 	// Page struct
 	type Page struct {
 		List   []commandsListItem
-		Filter struct {
-			ProcessIn struct {
-				Http      bool
-				Webrtc    bool
-				Tru       bool
-				Websocket bool
-			}
-		}
+		Filter []FilterItem
+		Base   string // Relative path of commands prefix
 	}
 
 	// Template page data
-	var page Page
+	page := Page{Base: strings.Repeat("../", len(params))}
 
 	// Parse parameters
-	page.Filter.ProcessIn.Http = vars["http"] != "false"
-	page.Filter.ProcessIn.Webrtc = vars["webrtc"] != "false"
-	page.Filter.ProcessIn.Tru = vars["tru"] != "false"
-	page.Filter.ProcessIn.Websocket = vars["websocket"] != "false"
+	var filter ProcessIn
+	for _, pi := range filterProcessIns() {
+		key := processInKey(pi)
+		checked := vars[key] != "false"
+		if checked {
+			filter |= pi
+		}
+		page.Filter = append(page.Filter, FilterItem{key, pi.Name(), checked,
+			!slices.Contains(filterPathProcessIns, pi)})
+	}
 
 	// Get list of commands depending on filter
-	a.ForEach(func(command string, cmd *CommandData) {
+	for command, cmd := range a.IterSorted() {
 		// Check processing filter
-		if page.Filter.ProcessIn.Http && cmd.ProcessIn&HTTP != 0 ||
-			page.Filter.ProcessIn.Webrtc && cmd.ProcessIn&WebRTC != 0 ||
-			page.Filter.ProcessIn.Tru && cmd.ProcessIn&TRU != 0 ||
-			page.Filter.ProcessIn.Websocket && cmd.ProcessIn&WS != 0 {
+		if cmd.ProcessIn&filter != 0 {
 
 			page.List = append(page.List, commandsListItem{
 				command, cmd.Params, cmd.Return, cmd.ProcessIn.String(), cmd.Descr,
-				cmd.Request, cmd.Response,
+				cmd.Request, cmd.Response, cmd.Version, cmd.Tags,
 			})
 		}
-	})
-	sort.Slice(page.List, func(i, j int) bool {
-		return page.List[i].Command < page.List[j].Command
-	})
+	}
 
 	// Execute template
 	buf := new(bytes.Buffer)
@@ -233,3 +238,50 @@ func (a *Commands) commandsHttpHandler(setFieldset bool, vars map[string]string)
 
 	return buf.Bytes(), nil
 }
+
+// filterPathProcessIns are ProcessIns of commfilt command path parameters
+// {http}/{webrtc}/{tru}/{ws}. Other registered types are filtered by query
+// parameters, like "commfilt/true/true/true/true?teonet=false", so existing
+// links keep working.
+var filterPathProcessIns = []ProcessIn{HTTP, WebRTC, TRU, WS}
+
+// filterProcessIns returns ProcessIns of commands list filter: ProcessIns of
+// commfilt path parameters followed by other registered types.
+func filterProcessIns() []ProcessIn {
+	list := slices.Clone(filterPathProcessIns)
+	for _, pi := range ProcessIns() {
+		if !slices.Contains(list, pi) {
+			list = append(list, pi)
+		}
+	}
+	return list
+}
+
+// filterQueryVars returns commands list filter variables with query
+// parameters of HTTP request indata, see filterPathProcessIns.
+func filterQueryVars(indata any, vars map[string]string) map[string]string {
+	r, ok := indata.(*HTTPRequest)
+	if !ok || r.Request == nil {
+		return vars
+	}
+	query := r.Request.URL.Query()
+	filter := maps.Clone(vars)
+	if filter == nil {
+		filter = make(map[string]string)
+	}
+	for _, pi := range filterProcessIns()[len(filterPathProcessIns):] {
+		key := processInKey(pi)
+		if query.Has(key) {
+			filter[key] = query.Get(key)
+		}
+	}
+	return filter
+}
+
+// processInKey returns commands list filter parameter name of ProcessIn.
+func processInKey(pi ProcessIn) string {
+	if pi == WS {
+		return "ws"
+	}
+	return strings.ToLower(pi.Name())
+}
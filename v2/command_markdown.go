@@ -0,0 +1,92 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Markdown documentation of Command processing golang package.
+
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Markdown returns Markdown reference of all commands sorted by name. It may
+// be committed into project docs or served by docs site.
+func (a *Commands) Markdown() []byte {
+
+	// Get sorted list of commands
+	var list []*CommandData
+	for _, cmd := range a.IterSorted() {
+		list = append(list, cmd)
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "# Commands api\n\nNumber of commands: %d\n\n", len(list))
+
+	// Table of contents
+	buf.WriteString("| Command | Description | Processing in |\n")
+	buf.WriteString("| ------- | ----------- | ------------- |\n")
+	for _, cmd := range list {
+		fmt.Fprintf(buf, "| [%s](#%s) | %s | %s |\n", cmd.Cmd,
+			markdownAnchor(cmd.Cmd), markdownCell(cmd.Descr), cmd.ProcessIn)
+	}
+
+	// Commands
+	for _, cmd := range list {
+		fmt.Fprintf(buf, "\n## %s\n\n", cmd.Cmd)
+		if cmd.Descr != "" {
+			fmt.Fprintf(buf, "%s\n\n", cmd.Descr)
+		}
+		if cmd.Version != "" {
+			fmt.Fprintf(buf, "- Version: %s\n", cmd.Version)
+		}
+		if versions := a.Versions(cmd.Cmd); len(versions) > 1 {
+			fmt.Fprintf(buf, "- Versions: %s\n", strings.Join(versions, ", "))
+		}
+		if len(cmd.Tags) > 0 {
+			fmt.Fprintf(buf, "- Tags: %s\n", strings.Join(cmd.Tags, ", "))
+		}
+		fmt.Fprintf(buf, "- Processing in: %s\n", cmd.ProcessIn)
+		path := cmd.Cmd
+		if cmd.Params != "" {
+			path += "/" + cmd.Params
+		}
+		fmt.Fprintf(buf, "- Path: `%s`\n", path)
+		if cmd.Return != "" {
+			fmt.Fprintf(buf, "- Return: %s\n", cmd.Return)
+		}
+
+		if params := cmd.ParamsSlice(); len(params) > 0 {
+			buf.WriteString("\n### Parameters\n\n")
+			for _, param := range params {
+				fmt.Fprintf(buf, "- `%s`\n", param)
+			}
+		}
+
+		for _, block := range []struct{ title, text, lang string }{
+			{"Request example", cmd.Request, ""},
+			{"Response example", cmd.Response, ""},
+			{"Request schema", cmd.RequestSchema, "json"},
+			{"Response schema", cmd.ResponseSchema, "json"},
+		} {
+			if block.text != "" {
+				fmt.Fprintf(buf, "\n### %s\n\n```%s\n%s\n```\n", block.title,
+					block.lang, block.text)
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// markdownAnchor returns Markdown heading anchor of command name.
+func markdownAnchor(name string) string {
+	return strings.NewReplacer(".", "", "/", "").Replace(strings.ToLower(name))
+}
+
+// markdownCell escapes text for Markdown table cell.
+func markdownCell(text string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(text)
+}
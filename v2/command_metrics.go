@@ -0,0 +1,234 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Prometheus metrics module of Command processing golang package.
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetricsContentType is a content type of Prometheus text exposition format.
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// ErrMetricsPush is an error returned when metrics receiver rejects pushed
+// metrics.
+var ErrMetricsPush = fmt.Errorf("metrics push rejected")
+
+// metric is a commands statistics metric.
+type metric struct {
+	name, typ, help string
+	value           func(s CommandStats) float64
+}
+
+// metrics are commands statistics metrics.
+var metrics = []metric{
+	{"command_calls_total", "counter", "Executed command calls.",
+		func(s CommandStats) float64 { return float64(s.Calls) }},
+	{"command_errors_total", "counter", "Failed command calls.",
+		func(s CommandStats) float64 { return float64(s.Errors) }},
+	{"command_latency_avg_seconds", "gauge", "Average command latency.",
+		func(s CommandStats) float64 { return s.AvgLatency.Seconds() }},
+	{"command_latency_max_seconds", "gauge", "Maximum command latency.",
+		func(s CommandStats) float64 { return s.MaxLatency.Seconds() }},
+	{"command_in_flight", "gauge", "Command executions in flight.",
+		func(s CommandStats) float64 { return float64(s.InFlight) }},
+}
+
+// WritePrometheus writes commands execution statistics to w in Prometheus
+// text exposition format, it is the scrape endpoint handler body:
+//
+//	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+//	    w.Header().Set("Content-Type", command.MetricsContentType)
+//	    c.WritePrometheus(w)
+//	})
+func (c *Commands) WritePrometheus(w io.Writer) error {
+	stats := c.Stats()
+	commands := slices.Sorted(maps.Keys(stats))
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help,
+			m.name, m.typ)
+		for _, command := range commands {
+			fmt.Fprintf(&buf, "%s{command=\"%s\"} %s\n", m.name,
+				escapeLabel(command), strconv.FormatFloat(m.value(stats[command]), 'g', -1, 64))
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// MetricsPush contains options of PushMetrics.
+type MetricsPush struct {
+	// URL is metrics receiver URL, like Pushgateway
+	// "http://pushgateway:9091/metrics/job/commands/instance/host1" or
+	// remote-write "http://prometheus:9090/api/v1/write".
+	URL string
+
+	// RemoteWrite turns on Prometheus remote-write protocol, metrics are
+	// pushed in Pushgateway text format with PUT method otherwise.
+	RemoteWrite bool
+
+	// Labels are added to remote-write series, like "job" and "instance".
+	// Pushgateway takes them from URL.
+	Labels map[string]string
+
+	Interval time.Duration // Push interval, 15 seconds by default
+	Header   http.Header   // Request headers, like Authorization
+	Client   *http.Client  // HTTP client, http.DefaultClient by default
+}
+
+// PushMetrics pushes commands execution statistics to Pushgateway or
+// remote-write receiver every push interval until ctx is done, for servers
+// which can not be scraped. Push errors are logged, the last push is made
+// when ctx is done. It returns ctx error.
+func (c *Commands) PushMetrics(ctx context.Context, push MetricsPush) error {
+	if push.Interval <= 0 {
+		push.Interval = 15 * time.Second
+	}
+	ticker := time.NewTicker(push.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			pctx, cancel := context.WithTimeout(context.WithoutCancel(ctx),
+				push.Interval)
+			defer cancel()
+			if err := c.pushMetrics(pctx, push); err != nil {
+				log.Println("push metrics:", err)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			if err := c.pushMetrics(ctx, push); err != nil {
+				log.Println("push metrics:", err)
+			}
+		}
+	}
+}
+
+// pushMetrics pushes commands execution statistics once.
+func (c *Commands) pushMetrics(ctx context.Context, push MetricsPush) error {
+	var body bytes.Buffer
+	method, header := http.MethodPut, http.Header{}
+	if push.RemoteWrite {
+		method = http.MethodPost
+		header.Set("Content-Type", "application/x-protobuf")
+		header.Set("Content-Encoding", "snappy")
+		header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		body.Write(snappyEncode(c.writeRequest(push.Labels, time.Now())))
+	} else {
+		header.Set("Content-Type", MetricsContentType)
+		if err := c.WritePrometheus(&body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, push.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	for k, v := range push.Header {
+		req.Header[k] = v
+	}
+	client := push.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%w: %s", ErrMetricsPush, res.Status)
+	}
+	return nil
+}
+
+// writeRequest returns remote-write protobuf WriteRequest of commands
+// statistics at time now.
+func (c *Commands) writeRequest(labels map[string]string, now time.Time) []byte {
+	stats := c.Stats()
+	commands := slices.Sorted(maps.Keys(stats))
+
+	var req []byte
+	for _, m := range metrics {
+		for _, command := range commands {
+			series := map[string]string{"__name__": m.name, "command": command}
+			for k, v := range labels {
+				if _, ok := series[k]; !ok {
+					series[k] = v
+				}
+			}
+
+			// TimeSeries: labels sorted by name and sample
+			var ts []byte
+			for _, name := range slices.Sorted(maps.Keys(series)) {
+				var label []byte
+				label = protoBytes(label, 1, []byte(name))
+				label = protoBytes(label, 2, []byte(series[name]))
+				ts = protoBytes(ts, 1, label)
+			}
+			var sample []byte
+			sample = binary.AppendUvarint(sample, 1<<3|1)
+			sample = binary.LittleEndian.AppendUint64(sample,
+				math.Float64bits(m.value(stats[command])))
+			sample = binary.AppendUvarint(sample, 2<<3)
+			sample = binary.AppendUvarint(sample, uint64(now.UnixMilli()))
+			ts = protoBytes(ts, 2, sample)
+
+			req = protoBytes(req, 1, ts)
+		}
+	}
+	return req
+}
+
+// protoBytes appends protobuf length-delimited field to b.
+func protoBytes(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// snappyEncode returns snappy block format encoding of src. Data is encoded
+// with literals only, remote-write payloads are small.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(nil, uint64(len(src)))
+	for len(src) > 0 {
+		n := min(len(src), 1<<16)
+		switch {
+		case n <= 60:
+			dst = append(dst, byte(n-1)<<2)
+		case n <= 1<<8:
+			dst = append(dst, 60<<2, byte(n-1))
+		default:
+			dst = append(dst, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
+
+// escapeLabel escapes Prometheus label value.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
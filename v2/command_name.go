@@ -0,0 +1,80 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command name validation module of Command processing golang package.
+
+package command
+
+import "fmt"
+
+// MaxNameLength is the maximum length of a command name accepted by the
+// default name validator.
+const MaxNameLength = 64
+
+// NameValidator is a function that checks command name and returns an error if
+// the name is not valid.
+type NameValidator func(name string) error
+
+// ValidateName is the default command name validator.
+//
+// A valid command name:
+//   - is not empty and not longer than MaxNameLength bytes;
+//   - does not start with a slash;
+//   - starts with an ASCII letter or digit;
+//   - contains only ASCII letters, digits, '_', '-' and '.' characters.
+//
+// Such names are safe to use in routing paths and file names.
+func ValidateName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("empty name: %w", ErrInvalidName)
+	case len(name) > MaxNameLength:
+		return fmt.Errorf("'%s' is longer than %d: %w", name, MaxNameLength,
+			ErrInvalidName)
+	case name[0] == '/':
+		return fmt.Errorf("'%s' starts with slash: %w", name, ErrInvalidName)
+	case !isAlnum(name[0]):
+		return fmt.Errorf("'%s' should start with letter or digit: %w", name,
+			ErrInvalidName)
+	}
+
+	for i := 1; i < len(name); i++ {
+		if ch := name[i]; !isAlnum(ch) && ch != '_' && ch != '-' && ch != '.' {
+			return fmt.Errorf("'%s' contains wrong character %q: %w", name, ch,
+				ErrInvalidName)
+		}
+	}
+
+	return nil
+}
+
+// isAlnum returns true if ch is an ASCII letter or digit.
+func isAlnum(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+}
+
+// SetNameValidator sets command name validator used by this Commands object.
+// If validator is nil the default ValidateName validator is used.
+func (c *Commands) SetNameValidator(validator NameValidator) {
+	c.Lock()
+	c.validator = validator
+	c.Unlock()
+}
+
+// CheckName checks command name with the Commands object name validator. It is
+// used by Add, AddStrict, AddBatch, Replace and ParseCommand methods.
+func (c *Commands) CheckName(name string) error {
+	c.RLock()
+	defer c.RUnlock()
+	return c.checkName(name)
+}
+
+// checkName checks command name with the Commands object name validator. It
+// should be called under the Commands lock.
+func (c *Commands) checkName(name string) error {
+	if c.validator == nil {
+		return ValidateName(name)
+	}
+	return c.validator(name)
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Pipelines module of Command processing golang package.
+
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ErrPipelineLimit is an error returned when pipeline step result exceeds
+// intermediate size limit.
+var ErrPipelineLimit = fmt.Errorf("pipeline result exceeds size limit")
+
+// MaxPipelineSize is the default maximum size of pipeline step result.
+const MaxPipelineSize = 1 << 20
+
+// PipelineStep is a command executed in pipeline.
+type PipelineStep struct {
+	Command string            `json:"command"`        // Command name
+	Vars    map[string]string `json:"vars,omitempty"` // Request variables
+
+	// If is a condition expression of step execution, the step is skipped
+	// if the condition is false. A step with condition is executed after a
+	// failed step, so it may handle the failure. See parseCondition for the
+	// expression syntax.
+	If string `json:"if,omitempty"`
+}
+
+// ParsePipeline parses pipeline spec. The spec is json array of
+// PipelineStep or text of commands separated by "|", like
+// "users/active | count", which are parsed by ParseCommand.
+func (c *Commands) ParsePipeline(spec []byte) (steps []PipelineStep, err error) {
+	spec = bytes.TrimSpace(spec)
+
+	// Json pipeline spec
+	if len(spec) > 0 && spec[0] == '[' {
+		if err = json.Unmarshal(spec, &steps); err != nil {
+			return nil, fmt.Errorf("wrong pipeline spec: %w", err)
+		}
+		return
+	}
+
+	// Text pipeline spec
+	for _, part := range strings.Split(string(spec), "|") {
+		name, vars := c.ParseCommand([]byte(strings.TrimSpace(part)))
+		if name == "" {
+			return nil, fmt.Errorf("wrong pipeline command '%s': %w",
+				strings.TrimSpace(part), ErrInvalidName)
+		}
+		steps = append(steps, PipelineStep{Command: name, Vars: vars})
+	}
+	return
+}
+
+// Pipeline executes pipeline steps one by one: the result of a step is the
+// data of the next step request, the first step gets data of request r.
+// Other request fields, like user and connection channel, are passed to
+// every step. Steps with false If condition are skipped. Pipeline stops on
+// failed step unless the next step has condition, or when step result
+// exceeds maxSize bytes, MaxPipelineSize if maxSize is 0. It returns the
+// result of the last executed step.
+func (c *Commands) Pipeline(steps []PipelineStep, processIn ProcessIn,
+	r RequestInterfaceV2, maxSize int) (data []byte, err error) {
+
+	if maxSize <= 0 {
+		maxSize = MaxPipelineSize
+	}
+	if r != nil {
+		data = r.GetData()
+	}
+
+	// Compile conditions
+	conditions := make([]condition, len(steps))
+	for i, step := range steps {
+		if step.If == "" {
+			continue
+		}
+		if conditions[i], err = parseCondition(step.If); err != nil {
+			return nil, fmt.Errorf("pipeline step %d '%s': %w", i, step.Command,
+				err)
+		}
+	}
+
+	results := make([]stepResult, len(steps))
+	var stepErr error
+	for i, step := range steps {
+
+		// Check condition
+		if conditions[i] != nil {
+			if !conditions[i](results[:i]) {
+				continue
+			}
+		} else if stepErr != nil {
+			return nil, stepErr
+		}
+		stepErr = nil
+
+		req := &DefaultRequest{Vars: step.Vars, Data: data}
+		if r != nil {
+			req.RemoteAddr, req.User, req.Ctx = r.GetRemoteAddr(), r.GetUser(),
+				r.GetContext()
+			req.Channel = r.GetConnectionChannel()
+			req.PeerCertificates = r.GetPeerCertificates()
+		}
+		res, err := c.Exec(step.Command, processIn, req)
+		if err == nil && len(res) > maxSize {
+			return nil, fmt.Errorf("pipeline step %d '%s': %w", i, step.Command,
+				ErrPipelineLimit)
+		}
+		results[i] = stepResult{data: res, err: err, executed: true}
+		if err != nil {
+			stepErr = fmt.Errorf("pipeline step %d '%s': %w", i, step.Command, err)
+			continue
+		}
+		data = res
+	}
+
+	return data, stepErr
+}
+
+// AddPipelineCommand adds pipeline command to commands map. The pipeline
+// command gets pipeline spec in request data, see ParsePipeline, executes
+// it server-side and returns result of the last step, so clients don't need
+// round trips for composite operations.
+func (c *Commands) AddPipelineCommand(processIn ProcessIn) {
+	c.Add("pipeline", "Execute commands pipeline.", processIn, "",
+		"result of the last pipeline command", "users/active | count", "10",
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			req, err := c.Request(indata)
+			if err != nil {
+				return nil, err
+			}
+			steps, err := c.ParsePipeline(req.GetData())
+			if err != nil {
+				return nil, err
+			}
+			return c.Pipeline(steps, processIn, &pipelineRequest{req}, 0)
+		},
+	)
+}
+
+// pipelineRequest is pipeline command request which data is pipeline spec,
+// so the first step gets empty data.
+type pipelineRequest struct {
+	RequestInterfaceV2
+}
+
+// GetData returns empty data.
+func (r *pipelineRequest) GetData() []byte {
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Commands printing module of Command processing golang package.
+
+package command
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+)
+
+// PrintSort is a commands sort order of Fprint.
+type PrintSort int
+
+// Fprint sort orders.
+const (
+	SortByName    PrintSort = iota // Command name
+	SortByCalls                    // Most called first
+	SortByErrors                   // Most failed first
+	SortByLatency                  // Slowest average latency first
+)
+
+// PrintOptions contains Fprint options.
+type PrintOptions struct {
+	ProcessIn ProcessIn // Print commands processed in processIn, all if 0
+	Stats     bool      // Print execution statistics columns
+	Sort      PrintSort // Sort order, by name by default
+}
+
+// printRow is a command row of Fprint table.
+type printRow struct {
+	cmd   *CommandData
+	stats CommandStats
+}
+
+// Fprint writes table of commands with name, parameters, processing types
+// and description columns, and execution statistics columns if opts.Stats
+// is set, to w. The commands are copied under the lock and written without
+// it, so w may be slow, like log or network writer.
+func (c *Commands) Fprint(w io.Writer, opts PrintOptions) error {
+	var rows []printRow
+	for _, cmd := range c.IterSorted() {
+		if opts.ProcessIn == 0 || cmd.ProcessIn&opts.ProcessIn != 0 {
+			rows = append(rows, printRow{cmd: cmd})
+		}
+	}
+	if opts.Stats || opts.Sort != SortByName {
+		stats := c.Stats()
+		for i := range rows {
+			rows[i].stats = stats[rows[i].cmd.Cmd]
+		}
+	}
+
+	slices.SortStableFunc(rows, func(a, b printRow) int {
+		switch opts.Sort {
+		case SortByCalls:
+			return cmp.Compare(b.stats.Calls, a.stats.Calls)
+		case SortByErrors:
+			return cmp.Compare(b.stats.Errors, a.stats.Errors)
+		case SortByLatency:
+			return cmp.Compare(b.stats.AvgLatency, a.stats.AvgLatency)
+		}
+		return 0
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "NAME\tPARAMS\tPROCESS IN\tDESCRIPTION"
+	if opts.Stats {
+		header += "\tCALLS\tERRORS\tAVG LATENCY\tMAX LATENCY"
+	}
+	fmt.Fprintln(tw, header)
+	for _, row := range rows {
+		cmd := row.cmd
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s", cmd.Cmd, cmd.Params, cmd.ProcessIn,
+			cmd.Descr)
+		if opts.Stats {
+			s := row.stats
+			fmt.Fprintf(tw, "\t%d\t%d\t%s\t%s", s.Calls, s.Errors, s.AvgLatency,
+				s.MaxLatency)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,207 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Execution quotas module of Command processing golang package.
+
+package command
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is an error returned by Exec when user execution quota of
+// command is exceeded.
+var ErrQuotaExceeded = fmt.Errorf("quota exceeded")
+
+// Quota response headers set by HTTP server.
+const (
+	QuotaLimitHeader     = "X-Quota-Limit"
+	QuotaRemainingHeader = "X-Quota-Remaining"
+	QuotaResetHeader     = "X-Quota-Reset"
+)
+
+// APIKeyHeader is a request header with API key used as quota key of
+// anonymous requests by default.
+const APIKeyHeader = "X-API-Key"
+
+// QuotaPeriod is a quota period.
+type QuotaPeriod int
+
+// Quota periods. Periods start at UTC midnight and at the first day of UTC
+// month.
+const (
+	QuotaDaily QuotaPeriod = iota
+	QuotaMonthly
+)
+
+// Quota is a command execution quota per user or API key.
+type Quota struct {
+	Limit  int64       // Maximum number of executions in period
+	Period QuotaPeriod // Quota period
+}
+
+// reset returns start and end of quota period which contains t.
+func (q *Quota) reset(t time.Time) (start, end time.Time) {
+	t = t.UTC()
+	switch q.Period {
+	case QuotaMonthly:
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		end = start.AddDate(0, 1, 0)
+	default:
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		end = start.AddDate(0, 0, 1)
+	}
+	return
+}
+
+// QuotaUsage is a quota usage of request, it is passed to requests which
+// implement QuotaUsageSetter.
+type QuotaUsage struct {
+	Limit     int64     `json:"limit"`     // Quota limit
+	Remaining int64     `json:"remaining"` // Remaining executions in period
+	Reset     time.Time `json:"reset"`     // End of quota period
+}
+
+// QuotaUsageSetter is implemented by requests which receive quota usage of
+// executed command, like HTTPRequest, to return it to client.
+type QuotaUsageSetter interface {
+	SetQuotaUsage(usage QuotaUsage)
+}
+
+// QuotaStore stores quota counters. Shared store, like redis, should be used
+// when commands are served by several processes.
+type QuotaStore interface {
+	// Incr increments counter by key and returns its new value. The counter
+	// may be removed after expire time.
+	Incr(key string, expire time.Time) (int64, error)
+}
+
+// QuotaKeyFunc returns quota key of request, usually user or API key. The
+// request is nil if command input data is not a request.
+type QuotaKeyFunc func(req RequestInterfaceV2) string
+
+// SetQuotaStore sets quota counters store and quota key function used by
+// Exec to check CommandData.Quota. The DefaultQuotaKey is used if key is nil.
+// Quotas are not checked if store is nil.
+func (c *Commands) SetQuotaStore(store QuotaStore, key QuotaKeyFunc) {
+	if key == nil {
+		key = DefaultQuotaKey
+	}
+	c.Lock()
+	c.quotaStore, c.quotaKey = store, key
+	c.Unlock()
+}
+
+// DefaultQuotaKey returns request user, API key header or client IP address.
+func DefaultQuotaKey(req RequestInterfaceV2) string {
+	if req == nil {
+		return ""
+	}
+	if user := req.GetUser(); user != nil {
+		return "user:" + fmt.Sprint(user)
+	}
+	if key := req.GetHeader(APIKeyHeader); key != "" {
+		return "key:" + key
+	}
+	addr := req.GetRemoteAddr()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return "addr:" + addr
+}
+
+// checkQuota increments command quota counter of request input data and
+// returns ErrQuotaExceeded if the quota is exceeded.
+func (c *Commands) checkQuota(cmd *CommandData, data any) error {
+	if cmd.Quota == nil {
+		return nil
+	}
+	c.RLock()
+	store, keyFunc := c.quotaStore, c.quotaKey
+	c.RUnlock()
+	if store == nil {
+		return nil
+	}
+
+	var req RequestInterfaceV2
+	if r, ok := data.(RequestInterface); ok {
+		req = WrapRequest(r)
+	}
+	start, reset := cmd.Quota.reset(time.Now())
+	key := cmd.Cmd + "/" + keyFunc(req) + "/" + strconv.FormatInt(start.Unix(), 10)
+	n, err := store.Incr(key, reset)
+	if err != nil {
+		return fmt.Errorf("command '%s' quota: %w", cmd.Cmd, err)
+	}
+
+	// Report quota usage
+	usage := QuotaUsage{Limit: cmd.Quota.Limit, Remaining: cmd.Quota.Limit - n,
+		Reset: reset}
+	usage.Remaining = max(usage.Remaining, 0)
+	if s, ok := data.(QuotaUsageSetter); ok {
+		s.SetQuotaUsage(usage)
+	}
+
+	if n > cmd.Quota.Limit {
+		return fmt.Errorf("command '%s': %w, reset at %s", cmd.Cmd,
+			ErrQuotaExceeded, reset.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// MemoryQuotaStore is in-memory QuotaStore and RateStore.
+type MemoryQuotaStore struct {
+	m     map[string]*memoryQuotaCounter
+	sweep time.Time
+	*sync.Mutex
+}
+
+// memoryQuotaCounter is a counter of MemoryQuotaStore.
+type memoryQuotaCounter struct {
+	n      int64
+	expire time.Time
+}
+
+// NewMemoryQuotaStore creates new in-memory QuotaStore and RateStore.
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{m: make(map[string]*memoryQuotaCounter),
+		Mutex: new(sync.Mutex)}
+}
+
+// Incr increments counter by key and returns its new value. Expired
+// counters are removed once a minute.
+func (s *MemoryQuotaStore) Incr(key string, expire time.Time) (int64, error) {
+	return s.IncrBy(key, 1, expire)
+}
+
+// IncrBy adds n to counter by key and returns its new value. It implements
+// RateStore.
+func (s *MemoryQuotaStore) IncrBy(key string, n int64, expire time.Time) (
+	int64, error) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.sweep) > time.Minute {
+		s.sweep = now
+		for k, counter := range s.m {
+			if !now.Before(counter.expire) {
+				delete(s.m, k)
+			}
+		}
+	}
+
+	counter, ok := s.m[key]
+	if !ok || !now.Before(counter.expire) {
+		counter = &memoryQuotaCounter{expire: expire}
+		s.m[key] = counter
+	}
+	counter.n += n
+	return counter.n, nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Response fields redaction by role module of Command processing golang
+// package.
+
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// RolesKeyword is a response json schema keyword of property required
+// roles. The property is returned only to users having any of listed roles:
+//
+//	{"type":"object","properties":{
+//	    "name":{"type":"string"},
+//	    "salary":{"type":"number","x-roles":["admin","hr"]}
+//	}}
+const RolesKeyword = "x-roles"
+
+// ErrRolesRedaction is an error returned by Exec instead of command result
+// which can't be redacted by roles: response schema with roles annotations
+// is not valid or uses unsupported keywords, like "$ref" or "oneOf", or the
+// result is not a single json value.
+var ErrRolesRedaction = fmt.Errorf("response can't be redacted by roles")
+
+// rolesUnsupported are json schema keywords of subschemas which are not
+// walked by roles redaction.
+var rolesUnsupported = []string{"$ref", "allOf", "anyOf", "oneOf", "not", "if",
+	"then", "else", "additionalProperties", "patternProperties", "prefixItems",
+	"dependentSchemas", "unevaluatedProperties", "unevaluatedItems"}
+
+// RoleHolder is implemented by request users which have roles.
+type RoleHolder interface {
+	Roles() []string
+}
+
+// RolesFunc returns roles of request user of input data.
+type RolesFunc func(data any) []string
+
+// SetRolesFunc sets function which returns roles of request user used by
+// response fields redaction, see RolesKeyword. By default roles are taken
+// from request user implementing RoleHolder.
+func (c *Commands) SetRolesFunc(f RolesFunc) {
+	c.Lock()
+	defer c.Unlock()
+	c.rolesFunc = f
+}
+
+// roles returns roles of request user of input data.
+func (c *Commands) roles(data any) []string {
+	c.RLock()
+	f := c.rolesFunc
+	c.RUnlock()
+	if f != nil {
+		return f(data)
+	}
+	req, err := c.Request(data)
+	if err != nil {
+		return nil
+	}
+	if user, ok := req.GetUser().(RoleHolder); ok {
+		return user.Roles()
+	}
+	return nil
+}
+
+// rolesSchema is a parsed response schema with roles annotations.
+type rolesSchema struct {
+	schema   map[string]any
+	hasRoles bool  // Schema has roles annotations
+	err      error // Schema with roles annotations can't be used
+}
+
+// rolesSchemas are parsed response schemas by schema text.
+var rolesSchemas sync.Map
+
+// parseRolesSchema returns parsed response schema.
+func parseRolesSchema(text string) *rolesSchema {
+	if v, ok := rolesSchemas.Load(text); ok {
+		return v.(*rolesSchema)
+	}
+	s := &rolesSchema{
+		hasRoles: bytes.Contains([]byte(text), []byte(`"`+RolesKeyword+`"`)),
+	}
+	if err := json.Unmarshal([]byte(text), &s.schema); err != nil {
+		s.err = fmt.Errorf("invalid json schema: %s: %w", err, ErrRolesRedaction)
+	} else {
+		s.err = checkRolesSchema(s.schema)
+	}
+	if !s.hasRoles {
+		s.err = nil
+	}
+	rolesSchemas.Store(text, s)
+	return s
+}
+
+// checkRolesSchema returns error if schema has subschemas which are not
+// walked by roles redaction, see rolesUnsupported.
+func checkRolesSchema(schema map[string]any) error {
+	for _, keyword := range rolesUnsupported {
+		if v, ok := schema[keyword]; ok {
+			if _, isBool := v.(bool); !isBool {
+				return fmt.Errorf("unsupported keyword '%s': %w", keyword,
+					ErrRolesRedaction)
+			}
+		}
+	}
+	props, _ := schema["properties"].(map[string]any)
+	for _, p := range props {
+		if prop, ok := p.(map[string]any); ok {
+			if err := checkRolesSchema(prop); err != nil {
+				return err
+			}
+		}
+	}
+	if items, ok := schema["items"].(map[string]any); ok {
+		return checkRolesSchema(items)
+	}
+	return nil
+}
+
+// redactByRoles removes json result fields which roles annotated in command
+// response schema are not roles of request user. It returns
+// ErrRolesRedaction if the result can't be redacted.
+func (c *Commands) redactByRoles(cmd *CommandData, data any, res []byte,
+	err error) ([]byte, error) {
+
+	if err != nil || cmd.ResponseSchema == "" || len(res) == 0 {
+		return res, err
+	}
+	s := parseRolesSchema(cmd.ResponseSchema)
+	if !s.hasRoles {
+		return res, nil
+	}
+	if s.err != nil {
+		return nil, fmt.Errorf("command '%s' response schema: %w", cmd.Cmd, s.err)
+	}
+
+	// Result should be a single json value
+	dec := json.NewDecoder(bytes.NewReader(res))
+	dec.UseNumber()
+	var v any
+	err = dec.Decode(&v)
+	if err == nil {
+		if _, extra := dec.Token(); extra != io.EOF {
+			err = fmt.Errorf("extra data after json value")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("command '%s' result: %s: %w", cmd.Cmd, err,
+			ErrRolesRedaction)
+	}
+
+	if !redactRoles(s.schema, v, c.roles(data)) {
+		return res, nil
+	}
+	return json.Marshal(v)
+}
+
+// redactRoles removes fields of value v not allowed to roles by schema and
+// returns true if any field was removed.
+func redactRoles(schema map[string]any, v any, roles []string) (removed bool) {
+	switch x := v.(type) {
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		for name, p := range props {
+			prop, _ := p.(map[string]any)
+			value, ok := x[name]
+			if prop == nil || !ok {
+				continue
+			}
+			if !allowed(prop, roles) {
+				delete(x, name)
+				removed = true
+				continue
+			}
+			removed = redactRoles(prop, value, roles) || removed
+		}
+	case []any:
+		items, _ := schema["items"].(map[string]any)
+		if items == nil {
+			return
+		}
+		for _, item := range x {
+			removed = redactRoles(items, item, roles) || removed
+		}
+	}
+	return
+}
+
+// allowed returns true if schema property has no roles annotation or roles
+// have any of annotated roles.
+func allowed(prop map[string]any, roles []string) bool {
+	required, ok := prop[RolesKeyword].([]any)
+	if !ok {
+		return true
+	}
+	for _, role := range required {
+		if s, ok := role.(string); ok && slices.Contains(roles, s) {
+			return true
+		}
+	}
+	return false
+}
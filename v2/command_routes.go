@@ -0,0 +1,100 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Routes conflicts module of Command processing golang package.
+
+package command
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrRouteConflict is an error returned by CheckRoutes when commands routes
+// conflict.
+var ErrRouteConflict = fmt.Errorf("route conflict")
+
+// RouteConflict is a conflict of two commands routes generated by
+// HabdleCommands. Route is command path and parameters, like "user/{id}".
+// Routes conflict when the same request path matches both of them, for
+// example when command added by Add has name with slash, like "user/me", or
+// duplicates group sub-command.
+type RouteConflict struct {
+	Route string // Route
+	Other string // Conflicting route registered before Route
+
+	// Shadow is true if parameter of one route matches literal segment of
+	// other route, like "user/{id}" and "user/me", and false if routes are
+	// the same after parameters expansion, like "user/{id}" and
+	// "user/{name}".
+	Shadow bool
+}
+
+// String returns description of routes conflict.
+func (rc RouteConflict) String() string {
+	if rc.Shadow {
+		return fmt.Sprintf("route '%s' shadows '%s'", rc.Route, rc.Other)
+	}
+	return fmt.Sprintf("route '%s' duplicates '%s'", rc.Route, rc.Other)
+}
+
+// RouteConflicts returns conflicts of routes generated by HabdleCommands for
+// commands processed in processIn. Transport routers silently pick one of
+// conflicting routes or fail on registration, so servers may check routes
+// before registration.
+func (c *Commands) RouteConflicts(processIn ProcessIn) (conflicts []RouteConflict) {
+	var routes [][]string
+	c.HabdleCommands(processIn, func(command, params string) {
+		route := command
+		if params != "" {
+			route += "/" + params
+		}
+		segments := strings.Split(route, "/")
+		for _, other := range routes {
+			if shadow, ok := c.routesConflict(segments, other); ok {
+				conflicts = append(conflicts, RouteConflict{route,
+					strings.Join(other, "/"), shadow})
+			}
+		}
+		routes = append(routes, segments)
+	})
+	return
+}
+
+// CheckRoutes returns ErrRouteConflict joined with descriptions of routes
+// conflicts of commands processed in processIn, see RouteConflicts. It
+// returns nil if routes have no conflicts.
+func (c *Commands) CheckRoutes(processIn ProcessIn) error {
+	var errs []error
+	for _, rc := range c.RouteConflicts(processIn) {
+		errs = append(errs, fmt.Errorf("%s: %w", rc, ErrRouteConflict))
+	}
+	return errors.Join(errs...)
+}
+
+// routesConflict returns true in ok if routes segments a and b match the
+// same path, and true in shadow if parameter segment of one route matches
+// literal segment of the other.
+func (c *Commands) routesConflict(a, b []string) (shadow, ok bool) {
+	if len(a) != len(b) {
+		return
+	}
+	for i := range a {
+		pa, pb := isRouteParam(a[i]), isRouteParam(b[i])
+		switch {
+		case pa && pb:
+		case pa || pb:
+			shadow = true
+		case c.key(a[i]) != c.key(b[i]):
+			return false, false
+		}
+	}
+	return shadow, true
+}
+
+// isRouteParam returns true if route segment is parameter placeholder.
+func isRouteParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
@@ -0,0 +1,192 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Scheduled commands module of Command processing golang package.
+
+package command
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// LeaderLock is a cluster lease lock used to elect node which executes
+// scheduled command, like Redis SET NX PX with owner check on renew.
+type LeaderLock interface {
+	// Acquire acquires or renews lease of name by node for ttl. It returns
+	// true if node holds the lease.
+	Acquire(name, node string, ttl time.Duration) (bool, error)
+
+	// Release releases lease of name held by node.
+	Release(name, node string) error
+}
+
+// Scheduler executes commands periodically. In clustered deployments
+// scheduled command is executed on one node which holds the command lease,
+// see SetLeaderLock. If the leader dies its lease expires and other node
+// takes over.
+type Scheduler struct {
+	c         *Commands
+	processIn ProcessIn
+	lock      LeaderLock
+	node      string
+	jobs      []*scheduledJob
+	*sync.Mutex
+}
+
+// scheduledJob is a periodically executed command.
+type scheduledJob struct {
+	command  string
+	interval time.Duration
+}
+
+// NewScheduler creates new scheduler of commands c executed with processIn.
+func NewScheduler(c *Commands, processIn ProcessIn) *Scheduler {
+	return &Scheduler{c: c, processIn: processIn, Mutex: new(sync.Mutex)}
+}
+
+// SetLeaderLock sets cluster lease lock and unique name of this node. The
+// command lease lifetime is two command intervals, so other node takes over
+// scheduled command during two intervals after leader death. Scheduled
+// commands are executed on every node if lock is nil.
+func (s *Scheduler) SetLeaderLock(lock LeaderLock, node string) {
+	s.Lock()
+	s.lock, s.node = lock, node
+	s.Unlock()
+}
+
+// Every schedules command execution every interval. It should be called
+// before Start.
+func (s *Scheduler) Every(command string, interval time.Duration) error {
+	if _, ok := s.c.Get(command); !ok {
+		return fmt.Errorf("command '%s': %w", command, ErrCommandNotFound)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("command '%s': wrong interval %s", command, interval)
+	}
+	s.Lock()
+	s.jobs = append(s.jobs, &scheduledJob{command, interval})
+	s.Unlock()
+	return nil
+}
+
+// Start starts scheduled commands. It returns function which stops the
+// scheduler and releases leases held by this node.
+func (s *Scheduler) Start() (stop func()) {
+	s.Lock()
+	jobs := s.jobs
+	s.Unlock()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		Go(GoScheduler, func() {
+			defer wg.Done()
+			ticker := time.NewTicker(job.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					s.run(job)
+				case <-done:
+					s.release(job)
+					return
+				}
+			}
+		})
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
+// leaseName returns lease name of scheduled job.
+func (job *scheduledJob) leaseName() string {
+	return "schedule/" + job.command
+}
+
+// run executes scheduled command if this node is the command leader.
+func (s *Scheduler) run(job *scheduledJob) {
+	s.Lock()
+	lock, node := s.lock, s.node
+	s.Unlock()
+
+	if lock != nil {
+		leader, err := lock.Acquire(job.leaseName(), node, 2*job.interval)
+		if err != nil {
+			log.Printf("scheduled command '%s' lease error: %s", job.command, err)
+			return
+		}
+		if !leader {
+			return
+		}
+	}
+
+	if _, err := s.c.Exec(job.command, s.processIn, &DefaultRequest{}); err != nil {
+		log.Printf("scheduled command '%s' error: %s", job.command, err)
+	}
+}
+
+// release releases scheduled job lease.
+func (s *Scheduler) release(job *scheduledJob) {
+	s.Lock()
+	lock, node := s.lock, s.node
+	s.Unlock()
+
+	if lock != nil {
+		lock.Release(job.leaseName(), node)
+	}
+}
+
+// MemoryLeaderLock is in-process LeaderLock used in tests and single process
+// clusters.
+type MemoryLeaderLock struct {
+	m map[string]memoryLease
+	*sync.Mutex
+}
+
+// memoryLease is MemoryLeaderLock lease.
+type memoryLease struct {
+	node   string
+	expire time.Time
+}
+
+// NewMemoryLeaderLock creates new in-process LeaderLock.
+func NewMemoryLeaderLock() *MemoryLeaderLock {
+	return &MemoryLeaderLock{m: make(map[string]memoryLease),
+		Mutex: new(sync.Mutex)}
+}
+
+// Acquire acquires or renews lease of name by node for ttl.
+func (l *MemoryLeaderLock) Acquire(name, node string, ttl time.Duration) (
+	bool, error) {
+
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	if lease, ok := l.m[name]; ok && lease.node != node && now.Before(lease.expire) {
+		return false, nil
+	}
+	l.m[name] = memoryLease{node: node, expire: now.Add(ttl)}
+	return true, nil
+}
+
+// Release releases lease of name held by node.
+func (l *MemoryLeaderLock) Release(name, node string) error {
+	l.Lock()
+	defer l.Unlock()
+	if lease, ok := l.m[name]; ok && lease.node == node {
+		delete(l.m, name)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Serializers module of Command processing golang package.
+
+package command
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+)
+
+// ErrSerialize is an error returned by RawSerializer when value can't be
+// serialized raw.
+var ErrSerialize = fmt.Errorf("can't serialize value")
+
+// Serializer marshals typed command results and unmarshals requests, like
+// JSON or MessagePack.
+type Serializer interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONSerializer is json Serializer. It is used by default.
+type JSONSerializer struct{}
+
+// Marshal returns json encoding of v.
+func (JSONSerializer) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal parses json encoded data to v.
+func (JSONSerializer) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// RawSerializer passes []byte and string values as is and uses binary
+// marshaling of encoding.BinaryMarshaler values, like for TRU transport.
+type RawSerializer struct{}
+
+// Marshal returns raw bytes of v.
+func (RawSerializer) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case encoding.BinaryMarshaler:
+		return v.MarshalBinary()
+	}
+	return nil, fmt.Errorf("%T: %w", v, ErrSerialize)
+}
+
+// Unmarshal copies data to *[]byte or *string v or uses binary unmarshaling
+// of encoding.BinaryUnmarshaler v.
+func (RawSerializer) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append((*v)[:0], data...)
+		return nil
+	case *string:
+		*v = string(data)
+		return nil
+	case encoding.BinaryUnmarshaler:
+		return v.UnmarshalBinary(data)
+	}
+	return fmt.Errorf("%T: %w", v, ErrSerialize)
+}
+
+// SetSerializer sets default serializer of transports processIn, like
+// MessagePack for WebRTC and RawSerializer for TRU. CommandData.Serializer
+// overrides it. The JSONSerializer is used if serializer is not set.
+func (c *Commands) SetSerializer(processIn ProcessIn, s Serializer) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.serializers == nil {
+		c.serializers = make(map[ProcessIn]Serializer)
+	}
+	for pi := ProcessIn(1); pi != 0; pi <<= 1 {
+		if processIn&pi != 0 {
+			c.serializers[pi] = s
+		}
+	}
+}
+
+// Serializer returns serializer of command executed with processIn: the
+// command serializer, transport serializer or JSONSerializer.
+func (c *Commands) Serializer(cmd *CommandData, processIn ProcessIn) Serializer {
+	if cmd != nil && cmd.Serializer != nil {
+		return cmd.Serializer
+	}
+	c.RLock()
+	s := c.serializers[processIn]
+	c.RUnlock()
+	if s != nil {
+		return s
+	}
+	return JSONSerializer{}
+}
+
+// Typed returns command handler of typed handler h. The result of h is
+// marshaled by command Serializer of the transport the command is executed
+// with:
+//
+//	c.Add("user", "Get user.", command.HTTP|command.WebRTC, "{id}", "", "", "",
+//		command.Typed(c, func(cmd *command.CommandData,
+//			processIn command.ProcessIn, data any) (*User, error) {
+//			...
+//		}),
+//	)
+func Typed[T any](c *Commands, h func(cmd *CommandData, processIn ProcessIn,
+	data any) (T, error)) CommandHandler {
+
+	return func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		res, err := h(cmd, processIn, data)
+		if err != nil {
+			return nil, err
+		}
+		return c.Serializer(cmd, processIn).Marshal(res)
+	}
+}
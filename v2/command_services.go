@@ -0,0 +1,111 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Services container module of Command processing golang package.
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrServiceNotFound is an error returned by Service when service of
+// requested type is not provided.
+var ErrServiceNotFound = fmt.Errorf("service not found")
+
+// services is a container of services provided to command handlers.
+type services struct {
+	m map[reflect.Type]any
+	*sync.RWMutex
+}
+
+// servicesKey is a context key of services container.
+type servicesKey struct{}
+
+// contextSetter is implemented by requests which context may be replaced,
+// like DefaultRequest and HTTPRequest.
+type contextSetter interface {
+	SetContext(ctx context.Context)
+}
+
+// Provide registers service of type T, like database pool or cache, in
+// commands services container. The service replaces previously provided
+// service of the same type. Use interface type parameter to provide service
+// by interface:
+//
+//	command.Provide[Storage](c, postgresStorage)
+func Provide[T any](c *Commands, service T) {
+	c.Lock()
+	if c.services == nil {
+		c.services = &services{m: make(map[reflect.Type]any),
+			RWMutex: new(sync.RWMutex)}
+	}
+	s := c.services
+	c.Unlock()
+
+	s.Lock()
+	s.m[reflect.TypeFor[T]()] = service
+	s.Unlock()
+}
+
+// Service returns service of type T from request context. Exec adds commands
+// services to context of requests which implement SetContext method, so
+// handlers get services without package-level globals:
+//
+//	req, _ := c.Request(data)
+//	db, err := command.Service[*sql.DB](req.GetContext())
+func Service[T any](ctx context.Context) (service T, err error) {
+	s, _ := ctx.Value(servicesKey{}).(*services)
+	if s == nil {
+		err = fmt.Errorf("%s: %w", reflect.TypeFor[T](), ErrServiceNotFound)
+		return
+	}
+
+	s.RLock()
+	v, ok := s.m[reflect.TypeFor[T]()]
+	s.RUnlock()
+	if !ok {
+		err = fmt.Errorf("%s: %w", reflect.TypeFor[T](), ErrServiceNotFound)
+		return
+	}
+	service, _ = v.(T) // v is nil if nil interface service was provided
+	return
+}
+
+// WithServices returns copy of ctx with commands services. It may be used to
+// get services outside of command handlers.
+func (c *Commands) WithServices(ctx context.Context) context.Context {
+	c.RLock()
+	s := c.services
+	c.RUnlock()
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, servicesKey{}, s)
+}
+
+// injectServices adds commands services to context of request input data.
+func (c *Commands) injectServices(data any) {
+	r, ok := data.(interface {
+		contextSetter
+		GetContext() context.Context
+	})
+	if !ok {
+		return
+	}
+	c.RLock()
+	s := c.services
+	c.RUnlock()
+	if s == nil {
+		return
+	}
+	ctx := r.GetContext()
+	if ctx.Value(servicesKey{}) == s {
+		return
+	}
+	r.SetContext(context.WithValue(ctx, servicesKey{}, s))
+}
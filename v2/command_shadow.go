@@ -0,0 +1,175 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Shadow execution module of Command processing golang package.
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MaxShadowRuns is a maximum number of shadow handlers executed at the same
+// time by Commands object. Shadow runs of requests are dropped when it is
+// reached, so slow shadow does not pile up goroutines.
+const MaxShadowRuns = 64
+
+// ShadowResult contains results of command primary and shadow handlers
+// executed with the same request.
+type ShadowResult struct {
+	Command       string        // Command name
+	Result        []byte        // Primary handler result
+	Err           error         // Primary handler error
+	Latency       time.Duration // Primary handler latency
+	Shadow        []byte        // Shadow handler result
+	ShadowErr     error         // Shadow handler error
+	ShadowLatency time.Duration // Shadow handler latency
+	Match         bool          // Results and errors are equal
+}
+
+// ShadowReporter is a function that receives shadow execution results.
+type ShadowReporter func(r ShadowResult)
+
+// SetShadow sets shadow handler of command. The shadow handler receives a
+// copy of every request executed by Exec after the command handler, its
+// result is compared with the command handler result and reported, but
+// never returned to clients. It is used to validate rewritten handler
+// against production traffic. Remote endpoint may be used as shadow with
+// HTTPShadow handler. The nil shadow turns shadow execution off.
+func (c *Commands) SetShadow(name string, shadow CommandHandler) error {
+	c.Lock()
+	defer c.Unlock()
+
+	key := c.key(name)
+	cmd, ok := c.m[key]
+	if !ok {
+		return fmt.Errorf("command '%s': %w", name, ErrCommandNotFound)
+	}
+	shadowed := *cmd
+	shadowed.Shadow = shadow
+	c.m[key] = &shadowed
+	c.replaceVersion(key, cmd, &shadowed)
+	c.refreeze()
+
+	return nil
+}
+
+// SetShadowReporter sets function that receives shadow execution results.
+// By default mismatched results are logged.
+func (c *Commands) SetShadowReporter(report ShadowReporter) {
+	c.Lock()
+	c.shadowReport = report
+	c.Unlock()
+}
+
+// shadow executes command shadow handler in goroutine and reports result.
+// The shadow run is dropped if MaxShadowRuns shadow handlers are executed.
+func (c *Commands) shadow(cmd *CommandData, processIn ProcessIn, data any,
+	res ShadowResult) {
+
+	if c.shadowRuns.Add(1) > MaxShadowRuns {
+		c.shadowRuns.Add(-1)
+		return
+	}
+
+	c.RLock()
+	report := c.shadowReport
+	c.RUnlock()
+	if report == nil {
+		report = logShadow
+	}
+
+	data = shadowRequest(data)
+	Go(GoShadow, func() {
+		defer c.shadowRuns.Add(-1)
+		start := time.Now()
+		res.Shadow, res.ShadowErr = cmd.Shadow(cmd, processIn, data)
+		res.ShadowLatency = time.Since(start)
+		res.Match = bytes.Equal(res.Result, res.Shadow) &&
+			fmt.Sprint(res.Err) == fmt.Sprint(res.ShadowErr)
+		report(res)
+	})
+}
+
+// shadowRequest returns copy of request passed to shadow handler. Request
+// data is already read by command handler, so the copy has it buffered and
+// request context which is not canceled when request finishes.
+func shadowRequest(data any) any {
+	r, ok := data.(RequestInterface)
+	if !ok {
+		return data
+	}
+	req := WrapRequest(r)
+	cp := &DefaultRequest{
+		Vars:             maps.Clone(req.GetVars()),
+		Data:             req.GetData(),
+		RemoteAddr:       req.GetRemoteAddr(),
+		User:             req.GetUser(),
+		Ctx:              context.WithoutCancel(req.GetContext()),
+		PeerCertificates: req.GetPeerCertificates(),
+	}
+	switch r := r.(type) {
+	case *HTTPRequest:
+		cp.Header = r.Request.Header.Clone()
+	case *WSRequest:
+		cp.Header = r.Header.Clone()
+	case *DefaultRequest:
+		cp.Header = r.Header.Clone()
+	}
+	return cp
+}
+
+// logShadow logs mismatched shadow execution results.
+func logShadow(r ShadowResult) {
+	if r.Match {
+		return
+	}
+	log.Printf("shadow mismatch of command '%s': result %q, %v; shadow %q, %v",
+		r.Command, r.Result, r.Err, r.Shadow, r.ShadowErr)
+}
+
+// HTTPShadow returns shadow handler which sends request to remote endpoint
+// with HTTP POST method. The request path is baseURL, command name and
+// escaped request variables in command parameters order, see EscapeParam,
+// the body is request data.
+// The client may be nil to use http.DefaultClient.
+func HTTPShadow(baseURL string, client *http.Client) CommandHandler {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+		path := []string{strings.TrimRight(baseURL, "/"), cmd.Cmd}
+		var body []byte
+		if r, ok := indata.(RequestInterface); ok {
+			vars := r.GetVars()
+			for _, param := range cmd.ParamsSlice() {
+				path = append(path, EscapeParam(vars[param]))
+			}
+			body = r.GetData()
+		}
+
+		res, err := client.Post(strings.Join(path, "/"), "",
+			bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		data, err := io.ReadAll(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s", strings.TrimSpace(string(data)))
+		}
+		return data, nil
+	}
+}
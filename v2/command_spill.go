@@ -0,0 +1,203 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Large payloads spill to disk module of Command processing golang package.
+
+package command
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultSpillThreshold is a default size of data kept in memory by
+// SpillBuffer, larger data is spilled to temporary file.
+const DefaultSpillThreshold = 4 << 20
+
+// ErrStreamNotSupported is an error returned by StreamResponse when request
+// transport can't stream responses and by Exec when streamed response
+// bypasses command result transformations, see StreamResponse.
+var ErrStreamNotSupported = fmt.Errorf("response streaming is not supported")
+
+// SpillBuffer is a buffer of large command responses and uploads which keeps
+// up to Threshold bytes in memory and spills larger data to temporary file,
+// so a few giant payloads don't exhaust gateway memory. The zero value is
+// an empty buffer ready to use. The buffer should be closed to remove its
+// temporary file.
+type SpillBuffer struct {
+	Threshold int64  // Memory size limit, DefaultSpillThreshold if 0
+	Dir       string // Temporary files directory, os.TempDir if empty
+
+	mem  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+// Write writes p to buffer, data is moved to temporary file when buffer size
+// exceeds threshold.
+func (b *SpillBuffer) Write(p []byte) (n int, err error) {
+	threshold := b.Threshold
+	if threshold <= 0 {
+		threshold = DefaultSpillThreshold
+	}
+	if b.file == nil && b.size+int64(len(p)) > threshold {
+		if b.file, err = os.CreateTemp(b.Dir, "command-spill-*"); err != nil {
+			return 0, err
+		}
+		if _, err = b.mem.WriteTo(b.file); err != nil {
+			return 0, err
+		}
+		b.mem = bytes.Buffer{}
+	}
+
+	if b.file != nil {
+		n, err = b.file.Write(p)
+	} else {
+		n, err = b.mem.Write(p)
+	}
+	b.size += int64(n)
+	return
+}
+
+// Size returns size of buffered data.
+func (b *SpillBuffer) Size() int64 { return b.size }
+
+// Spilled returns true if buffered data is in temporary file.
+func (b *SpillBuffer) Spilled() bool { return b.file != nil }
+
+// Reader returns reader of buffered data from the beginning. Closing the
+// reader closes the buffer and removes its temporary file, so it may be
+// passed to StreamResponse. The buffer should not be written after Reader
+// call.
+func (b *SpillBuffer) Reader() (io.ReadCloser, error) {
+	if b.file == nil {
+		return spillReader{bytes.NewReader(b.mem.Bytes()), b}, nil
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return spillReader{b.file, b}, nil
+}
+
+// Close removes temporary file and resets the buffer.
+func (b *SpillBuffer) Close() error {
+	b.mem, b.size = bytes.Buffer{}, 0
+	if b.file == nil {
+		return nil
+	}
+	f := b.file
+	b.file = nil
+	return errors.Join(f.Close(), os.Remove(f.Name()))
+}
+
+// spillReader is a reader of SpillBuffer data which closes the buffer.
+type spillReader struct {
+	io.Reader
+	b *SpillBuffer
+}
+
+func (r spillReader) Close() error { return r.b.Close() }
+
+// SpillData reads request data of input data to SpillBuffer with threshold
+// and temporary files directory dir. Handlers use it to receive large
+// uploads without full buffering in memory. The returned buffer should be
+// closed.
+func SpillData(indata any, threshold int64, dir string) (*SpillBuffer, error) {
+	req, err := ParseParams[RequestInterface](indata)
+	if err != nil {
+		return nil, err
+	}
+	b := &SpillBuffer{Threshold: threshold, Dir: dir}
+	if _, err = io.Copy(b, WrapRequest(req).GetDataReader()); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// ResponseStreamer is implemented by requests of transports which stream
+// large responses, like HTTPRequest.
+type ResponseStreamer interface {
+	// SetResponseReader sets reader of response streamed by transport
+	// instead of command handler answer. The transport closes it.
+	SetResponseReader(r io.ReadCloser)
+
+	// ResponseReader returns reader of streamed response or nil.
+	ResponseReader() io.ReadCloser
+}
+
+// StreamResponse sets reader of large response, like SpillBuffer Reader,
+// which is streamed to client by request transport instead of command
+// handler answer. The handler should return nil answer then. It returns
+// ErrStreamNotSupported if request transport can't stream responses, the
+// handler should return answer as usual or error then.
+//
+// Streamed response is not cached, limited by ResponseLimit or signed. Exec
+// closes the reader and returns ErrStreamNotSupported if command result is
+// transformed, see AddTransform, or redacted by roles, see RolesKeyword. The
+// reader is closed by Exec too if the handler returns error or exceeds hard
+// timeout, see Timeouts.
+//
+// Example usage:
+//
+//	b := &command.SpillBuffer{}
+//	export(b)
+//	r, err := b.Reader()
+//	if err != nil {
+//	    b.Close()
+//	    return nil, err
+//	}
+//	if err = c.StreamResponse(data, r); err != nil {
+//	    r.Close()
+//	    return nil, err
+//	}
+//	return nil, nil
+func (c *Commands) StreamResponse(indata any, r io.ReadCloser) error {
+	s, ok := indata.(ResponseStreamer)
+	if !ok {
+		return ErrStreamNotSupported
+	}
+	s.SetResponseReader(r)
+	return nil
+}
+
+// streamed returns response reader set to request data by StreamResponse or
+// nil.
+func streamed(indata any) io.ReadCloser {
+	if s, ok := indata.(ResponseStreamer); ok {
+		return s.ResponseReader()
+	}
+	return nil
+}
+
+// closeStream closes and removes response reader set to request data by
+// StreamResponse.
+func closeStream(indata any) {
+	if r := streamed(indata); r != nil {
+		r.Close()
+		indata.(ResponseStreamer).SetResponseReader(nil)
+	}
+}
+
+// checkStream closes response streamed by command and returns
+// ErrStreamNotSupported if command result is transformed or redacted by
+// roles, as streamed responses bypass them.
+func (c *Commands) checkStream(cmd *CommandData, indata any) error {
+	if streamed(indata) == nil {
+		return nil
+	}
+	c.RLock()
+	transformed := len(cmd.Transforms) > 0 || len(c.transforms) > 0
+	c.RUnlock()
+	if !transformed && (cmd.ResponseSchema == "" ||
+		!parseRolesSchema(cmd.ResponseSchema).hasRoles) {
+		return nil
+	}
+	closeStream(indata)
+	return fmt.Errorf("command '%s' result is transformed: %w", cmd.Cmd,
+		ErrStreamNotSupported)
+}
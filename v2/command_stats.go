@@ -0,0 +1,323 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Commands statistics and SLO module of Command processing golang package.
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrConcurrencyLimit is an error returned by Exec when command has maximum
+// number of executions in flight.
+var ErrConcurrencyLimit = fmt.Errorf("concurrency limit reached")
+
+// sloBuckets is number of buckets in SLO rolling window.
+const sloBuckets = 10
+
+// SLO is a command service level objective evaluated in rolling window.
+type SLO struct {
+	// Latency is latency objective: share of calls slower than Latency
+	// should not exceed 1-Percentile.
+	Latency    time.Duration `json:"latency_ns,omitempty"`
+	Percentile float64       `json:"percentile,omitempty"` // 0.99 by default
+
+	// ErrorRate is maximum share of failed calls, it is not checked if 0.
+	ErrorRate float64 `json:"error_rate,omitempty"`
+
+	// Window is rolling window duration, one minute by default.
+	Window time.Duration `json:"window_ns,omitempty"`
+
+	// MinCalls is minimum number of calls in window to evaluate the SLO.
+	MinCalls int `json:"min_calls,omitempty"`
+}
+
+// SLOState is a state of command SLO in current rolling window.
+type SLOState struct {
+	Calls     int     `json:"calls"`      // Calls in window
+	ErrorRate float64 `json:"error_rate"` // Share of failed calls
+	SlowRate  float64 `json:"slow_rate"`  // Share of calls slower than Latency
+	Violated  bool    `json:"violated"`   // SLO is violated
+}
+
+// SLOViolation is passed to SLO handler when command SLO is violated.
+type SLOViolation struct {
+	Command string
+	SLO     SLO
+	SLOState
+}
+
+// SLOHandler is a function called when command SLO becomes violated.
+type SLOHandler func(v SLOViolation)
+
+// CommandStats contains command execution counters.
+type CommandStats struct {
+	Calls      uint64        `json:"calls"`          // Executed calls
+	Errors     uint64        `json:"errors"`         // Failed calls
+	AvgLatency time.Duration `json:"avg_latency_ns"` // Average latency
+	MaxLatency time.Duration `json:"max_latency_ns"` // Maximum latency
+	SLO        *SLOState     `json:"slo,omitempty"`  // SLO state
+
+	// InFlight is number of executions in flight, MaxInFlight is maximum
+	// number of executions in flight in rolling window (SLO window or one
+	// minute).
+	InFlight    int `json:"in_flight"`
+	MaxInFlight int `json:"max_in_flight"`
+}
+
+// commandMetrics contains execution counters of commands.
+type commandMetrics struct {
+	m       map[string]*commandCounters
+	handler SLOHandler
+	sync.Mutex
+}
+
+// commandCounters contains command execution counters and SLO window.
+type commandCounters struct {
+	calls, errors uint64
+	latency       time.Duration // Total latency
+	maxLatency    time.Duration
+	inFlight      int
+
+	buckets  [sloBuckets]sloBucket
+	violated bool
+
+	draining bool          // Command is removed by DelDrain
+	drained  chan struct{} // Closed when draining command has no executions
+}
+
+// sloBucket contains counters of part of SLO window.
+type sloBucket struct {
+	start               time.Time
+	calls, errors, slow int
+	maxInFlight         int
+}
+
+// SetSLOHandler sets function called when command SLO becomes violated. It
+// is called once per violation, again after SLO is met and violated.
+func (c *Commands) SetSLOHandler(handler SLOHandler) {
+	c.metrics.Lock()
+	c.metrics.handler = handler
+	c.metrics.Unlock()
+}
+
+// counters returns command counters, it should be called under the metrics
+// lock.
+func (m *commandMetrics) counters(command string) *commandCounters {
+	if m.m == nil {
+		m.m = make(map[string]*commandCounters)
+	}
+	cnt, ok := m.m[command]
+	if !ok {
+		cnt = &commandCounters{}
+		m.m[command] = cnt
+	}
+	return cnt
+}
+
+// acquire counts execution in flight of command with full path. It returns
+// ErrConcurrencyLimit if command has CommandData.MaxConcurrency executions
+// in flight and ErrCommandNotFound if command is draining, see DelDrain. The
+// release should be called when handler of execution acquired successfully
+// returns.
+func (c *Commands) acquire(cmd *CommandData, path string) error {
+	m := &c.metrics
+	m.Lock()
+	defer m.Unlock()
+
+	cnt := m.counters(path)
+	if cnt.draining {
+		return fmt.Errorf("command '%s': %w", path, ErrCommandNotFound)
+	}
+	if cmd.MaxConcurrency > 0 && cnt.inFlight >= cmd.MaxConcurrency {
+		return fmt.Errorf("command '%s': %w", path, ErrConcurrencyLimit)
+	}
+	cnt.inFlight++
+	b := cnt.bucket(cmd.window(), time.Now())
+	b.maxInFlight = max(b.maxInFlight, cnt.inFlight)
+	return nil
+}
+
+// release finishes execution in flight of command with full path counted
+// by acquire.
+func (c *Commands) release(path string) {
+	m := &c.metrics
+	m.Lock()
+	defer m.Unlock()
+
+	cnt := m.counters(path)
+	cnt.inFlight = max(cnt.inFlight-1, 0)
+	if cnt.inFlight == 0 && cnt.drained != nil {
+		close(cnt.drained)
+		cnt.drained = nil
+	}
+}
+
+// record counts execution of command with full path and evaluates command
+// SLO.
+func (c *Commands) record(cmd *CommandData, path string, latency time.Duration,
+	err error) {
+
+	m := &c.metrics
+	m.Lock()
+	cnt := m.counters(path)
+	cnt.calls++
+	if err != nil {
+		cnt.errors++
+	}
+	cnt.latency += latency
+	cnt.maxLatency = max(cnt.maxLatency, latency)
+
+	var violation *SLOViolation
+	if cmd.SLO != nil {
+		slo := cmd.SLO.withDefaults()
+		b := cnt.bucket(slo, time.Now())
+		b.calls++
+		if err != nil {
+			b.errors++
+		}
+		if slo.Latency > 0 && latency > slo.Latency {
+			b.slow++
+		}
+		state := cnt.state(slo, time.Now())
+		if state.Violated && !cnt.violated {
+			violation = &SLOViolation{Command: path, SLO: slo, SLOState: state}
+		}
+		cnt.violated = state.Violated
+	}
+	handler := m.handler
+	m.Unlock()
+
+	if violation != nil && handler != nil {
+		handler(*violation)
+	}
+}
+
+// window returns command SLO or default SLO with rolling window size.
+func (cmd *CommandData) window() SLO {
+	if cmd.SLO != nil {
+		return cmd.SLO.withDefaults()
+	}
+	return SLO{}.withDefaults()
+}
+
+// withDefaults returns SLO with default values of empty fields.
+func (slo SLO) withDefaults() SLO {
+	if slo.Percentile <= 0 {
+		slo.Percentile = 0.99
+	}
+	if slo.Window <= 0 {
+		slo.Window = time.Minute
+	}
+	if slo.MinCalls <= 0 {
+		slo.MinCalls = 1
+	}
+	return slo
+}
+
+// bucket returns SLO window bucket of time now and resets outdated bucket.
+func (cnt *commandCounters) bucket(slo SLO, now time.Time) *sloBucket {
+	size := slo.Window / sloBuckets
+	start := now.Truncate(size)
+	b := &cnt.buckets[(start.UnixNano()/int64(size))%sloBuckets]
+	if !b.start.Equal(start) {
+		*b = sloBucket{start: start}
+	}
+	return b
+}
+
+// maxInFlight returns maximum number of executions in flight in window
+// ending at time now.
+func (cnt *commandCounters) maxInFlight(slo SLO, now time.Time) (n int) {
+	for _, b := range cnt.buckets {
+		if now.Sub(b.start) < slo.Window {
+			n = max(n, b.maxInFlight)
+		}
+	}
+	return
+}
+
+// state returns SLO state in window ending at time now.
+func (cnt *commandCounters) state(slo SLO, now time.Time) (state SLOState) {
+	var errors, slow int
+	for _, b := range cnt.buckets {
+		if now.Sub(b.start) >= slo.Window {
+			continue
+		}
+		state.Calls += b.calls
+		errors += b.errors
+		slow += b.slow
+	}
+	if state.Calls == 0 {
+		return
+	}
+	state.ErrorRate = float64(errors) / float64(state.Calls)
+	state.SlowRate = float64(slow) / float64(state.Calls)
+	state.Violated = state.Calls >= slo.MinCalls &&
+		((slo.ErrorRate > 0 && state.ErrorRate > slo.ErrorRate) ||
+			(slo.Latency > 0 && state.SlowRate > 1-slo.Percentile))
+	return
+}
+
+// Stats returns execution statistics of commands executed by Exec by
+// commands full paths, like "user/create" of sub-commands.
+func (c *Commands) Stats() map[string]CommandStats {
+	c.metrics.Lock()
+	commands := slices.Collect(maps.Keys(c.metrics.m))
+	c.metrics.Unlock()
+
+	slos := make(map[string]SLO)
+	windows := make(map[string]SLO)
+	for _, command := range commands {
+		cmd, ok := c.Get(command)
+		if !ok {
+			continue
+		}
+		if cmd.SLO != nil {
+			slos[command] = cmd.SLO.withDefaults()
+		}
+		windows[command] = cmd.window()
+	}
+
+	c.metrics.Lock()
+	defer c.metrics.Unlock()
+
+	stats := make(map[string]CommandStats, len(c.metrics.m))
+	now := time.Now()
+	for command, cnt := range c.metrics.m {
+		s := CommandStats{Calls: cnt.calls, Errors: cnt.errors,
+			MaxLatency: cnt.maxLatency, InFlight: cnt.inFlight}
+		if window, ok := windows[command]; ok {
+			s.MaxInFlight = max(cnt.maxInFlight(window, now), cnt.inFlight)
+		}
+		if cnt.calls > 0 {
+			s.AvgLatency = cnt.latency / time.Duration(cnt.calls)
+		}
+		if slo, ok := slos[command]; ok {
+			state := cnt.state(slo, now)
+			s.SLO = &state
+		}
+		stats[command] = s
+	}
+	return stats
+}
+
+// AddStatsCommand adds commstats command to commands map. The commstats
+// command returns commands execution statistics and SLO states in json
+// format.
+func (c *Commands) AddStatsCommand(processIn ProcessIn) {
+	c.Add("commstats", "Get commands statistics.", processIn, "",
+		"json commands statistics", "commstats",
+		`{"hello":{"calls":10,"errors":0,"avg_latency_ns":1200,...}}`,
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			return json.Marshal(c.Stats())
+		},
+	)
+}
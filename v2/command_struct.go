@@ -0,0 +1,121 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Struct methods registration module of Command processing golang package.
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// StructDescriber may be implemented by service object added with AddStruct
+// to describe its commands.
+type StructDescriber interface {
+	// Describe returns description of command of method. Non-empty fields
+	// of the returned spec override default command fields, the Cmd field
+	// overrides command name without prefix.
+	Describe(method string) CommandSpec
+}
+
+// AddStruct adds exported methods of service object svc as commands. Methods
+// with one of the following signatures are added, other methods are skipped:
+//
+//	func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error)
+//	func(req RequestInterfaceV2) ([]byte, error)
+//	func(ctx context.Context, req RequestInterfaceV2) ([]byte, error)
+//
+// The command name is prefix and snake case method name, like "user_" and
+// "ListActive" give "user_list_active". Commands are added to all transports
+// unless svc implements StructDescriber which sets command descriptions,
+// parameters and transports. Commands are validated and added at once like
+// AddBatch does. AddStruct returns an error if svc has no command methods.
+func (c *Commands) AddStruct(prefix string, svc any) error {
+	v := reflect.ValueOf(svc)
+	if !v.IsValid() {
+		return fmt.Errorf("nil service object")
+	}
+	describer, _ := svc.(StructDescriber)
+
+	var specs []CommandSpec
+	for i := range v.NumMethod() {
+		method := v.Type().Method(i)
+		handler := c.structHandler(v.Method(i))
+		if handler == nil {
+			continue
+		}
+
+		spec := CommandSpec{Cmd: snakeCase(method.Name), ProcessIn: All}
+		if describer != nil {
+			spec = mergeSpec(spec, describer.Describe(method.Name))
+		}
+		spec.Cmd = prefix + spec.Cmd
+		spec.Handler = handler
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		return fmt.Errorf("%T has no command methods", svc)
+	}
+
+	return c.AddBatch(specs)
+}
+
+// structHandler returns command handler calling method or nil if the method
+// has unknown signature.
+func (c *Commands) structHandler(method reflect.Value) CommandHandler {
+	switch f := method.Interface().(type) {
+	case func(*CommandData, ProcessIn, any) ([]byte, error):
+		return f
+	case func(RequestInterfaceV2) ([]byte, error):
+		return func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			req, err := c.Request(data)
+			if err != nil {
+				return nil, err
+			}
+			return f(req)
+		}
+	case func(context.Context, RequestInterfaceV2) ([]byte, error):
+		return func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			req, err := c.Request(data)
+			if err != nil {
+				return nil, err
+			}
+			return f(req.GetContext(), req)
+		}
+	}
+	return nil
+}
+
+// mergeSpec returns spec with non-empty fields of describe spec.
+func mergeSpec(spec, describe CommandSpec) CommandSpec {
+	dst, src := reflect.ValueOf(&spec).Elem(), reflect.ValueOf(describe)
+	for i := range src.NumField() {
+		if !src.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	return spec
+}
+
+// snakeCase converts method name to snake case, like "ListHTTPUsers" to
+// "list_http_users".
+func snakeCase(name string) string {
+	var sb strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) ||
+				i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				sb.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -1,8 +1,24 @@
 package command
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestParseCommand(t *testing.T) {
@@ -38,3 +54,2571 @@ func TestParseCommand(t *testing.T) {
 	// Value with slashes processed successfully in last parameter only
 	tst([]byte("test/value1/value2/{\"json string with slashes/subvalue\"}"))
 }
+
+func TestAddBatch(t *testing.T) {
+
+	c := New()
+	handler := func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		return []byte(cmd.Cmd), nil
+	}
+	c.Add("exists", "exists", HTTP, "", "", "", "", handler)
+
+	// Batch with error should not add any command
+	for _, specs := range [][]CommandSpec{
+		{{Cmd: "one", Handler: handler}, {Cmd: "one", Handler: handler}},
+		{{Cmd: "two", Handler: handler}, {Cmd: "exists", Handler: handler}},
+		{{Cmd: "three", Handler: handler}, {Cmd: "bad name", Handler: handler}},
+		{{Cmd: "four", Params: "{a}/b", Handler: handler}},
+		{{Cmd: "five", Params: "{a}/{a}", Handler: handler}},
+	} {
+		if err := c.AddBatch(specs); err == nil {
+			t.Errorf("batch %v should return error", specs[0].Cmd)
+		}
+		if _, ok := c.Get(specs[0].Cmd); ok {
+			t.Errorf("command %s should not be added", specs[0].Cmd)
+		}
+	}
+
+	// Valid batch adds all commands
+	err := c.AddBatch([]CommandSpec{
+		{Cmd: "one", ProcessIn: HTTP, Handler: handler},
+		{Cmd: "two", ProcessIn: WS, Params: "{a}/{b}", Handler: handler},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"one", "two"} {
+		if res, err := c.Exec(name, HTTP, nil); err != nil || string(res) != name {
+			t.Errorf("command %s: %s, %v", name, res, err)
+		}
+	}
+}
+
+func TestAddStrictReplace(t *testing.T) {
+
+	c := New()
+	handler := func(res string) CommandHandler {
+		return func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte(res), nil
+		}
+	}
+
+	if err := c.Replace("cmd", "", HTTP, "", "", "", "", handler("new")); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("replace of not existing command should fail, got %v", err)
+	}
+	if err := c.AddStrict("cmd", "", HTTP, "", "", "", "", handler("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddStrict("cmd", "", HTTP, "", "", "", "", handler("new")); !errors.Is(err, ErrCommandExists) {
+		t.Errorf("duplicate command should fail, got %v", err)
+	}
+	if err := c.Replace("cmd", "", HTTP, "", "", "", "", handler("new")); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := c.Exec("cmd", HTTP, nil); string(res) != "new" {
+		t.Errorf("command should be replaced, got %s", res)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+
+	for name, valid := range map[string]bool{
+		"hello": true, "get_user-v1.2": true, "9lives": true,
+		"": false, "/hello": false, "-hello": false, "hello world": false,
+		"hello/world": false, "привет": false, strings.Repeat("a", MaxNameLength+1): false,
+	} {
+		if err := ValidateName(name); (err == nil) != valid {
+			t.Errorf("name '%s' valid should be %v, got error %v", name, valid, err)
+		}
+	}
+
+	// Add skips invalid names
+	c := New()
+	for _, name := range []string{"/hello", strings.Repeat("a", MaxNameLength+1)} {
+		c.Add(name, "", HTTP, "", "", "", "", nil)
+		if _, ok := c.Get(name); ok {
+			t.Errorf("invalid name '%s' is added", name)
+		}
+	}
+	for name := range c.IterSorted() {
+		t.Errorf("invalid name '%s' is added", name)
+	}
+
+	// Custom validator
+	c = New()
+	c.SetNameValidator(func(name string) error {
+		if !strings.HasPrefix(name, "app.") {
+			return ErrInvalidName
+		}
+		return nil
+	})
+	if err := c.AddStrict("hello", "", HTTP, "", "", "", "", nil); err == nil {
+		t.Error("name without prefix should be rejected")
+	}
+	if err := c.AddStrict("app.hello", "", HTTP, "", "", "", "", nil); err != nil {
+		t.Error(err)
+	}
+	c.Add("world", "", HTTP, "", "", "", "", nil)
+	if _, ok := c.Get("world"); ok {
+		t.Error("name without prefix should not be added")
+	}
+	if name, _ := c.ParseCommand([]byte("hello/param")); name != "" {
+		t.Errorf("parse of invalid name should return empty name, got %s", name)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+
+	c := New()
+	c.Add("Hello", "", HTTP, "{name}", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte(cmd.Cmd), nil
+		},
+	)
+
+	if _, ok := c.Get("hello"); ok {
+		t.Error("command should not be found in case-sensitive mode")
+	}
+
+	c.SetCaseInsensitive(true)
+	for _, name := range []string{"hello", "HELLO", "Hello"} {
+		if res, err := c.Exec(name, HTTP, nil); err != nil || string(res) != "Hello" {
+			t.Errorf("command %s: %s, %v", name, res, err)
+		}
+	}
+	if _, vars := c.ParseCommand([]byte("hELLO/John")); vars["name"] != "John" {
+		t.Errorf("wrong parsed vars: %v", vars)
+	}
+	if err := c.AddStrict("HELLO", "", HTTP, "", "", "", "", nil); err == nil {
+		t.Error("command with different case should be duplicate")
+	}
+}
+
+func TestHTTPRequest(t *testing.T) {
+
+	c := New()
+	body := "request body"
+	r := httptest.NewRequest(http.MethodPost, "/api/test", strings.NewReader(body))
+	req := NewHTTPRequest(r, map[string]string{"name": "value"})
+
+	if vars, _ := c.Vars(req); vars["name"] != "value" {
+		t.Errorf("wrong vars: %v", vars)
+	}
+	for i := 0; i < 2; i++ {
+		if data, _ := c.Data(req); string(data) != body {
+			t.Errorf("wrong data: %s", data)
+		}
+	}
+
+	// Body exceeds limit
+	r = httptest.NewRequest(http.MethodPost, "/api/test", strings.NewReader(body))
+	req = NewHTTPRequest(r, nil)
+	req.MaxBodySize = 4
+	if data := req.GetData(); data != nil || req.DataErr() == nil {
+		t.Errorf("body over limit should return error, got %s", data)
+	}
+}
+
+func TestRequestV2(t *testing.T) {
+
+	c := New()
+
+	// HTTP request
+	r := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+	r.Header.Set("X-Token", "token")
+	req, err := c.Request(NewHTTPRequest(r, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.GetRemoteAddr() != r.RemoteAddr || req.GetHeader("X-Token") != "token" {
+		t.Errorf("wrong remote address or header: %s", req.GetRemoteAddr())
+	}
+	req.SetUser("user")
+	if req.GetUser() != "user" || req.GetContext() == nil {
+		t.Errorf("wrong user: %v", req.GetUser())
+	}
+
+	// HTTP request without http.Request returns zero values
+	empty := NewHTTPRequest(nil, nil)
+	if empty.GetHeader("X-Token") != "" || empty.GetPeerCertificates() != nil {
+		t.Errorf("wrong empty request values")
+	}
+
+	// Legacy request implementing RequestInterface only
+	req, err = c.Request(&legacyRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetUser("user")
+	if req.GetUser() != "user" || req.GetContext() == nil || req.GetRemoteAddr() != "" {
+		t.Errorf("wrong wrapped request: %v", req.GetUser())
+	}
+}
+
+func TestDataReader(t *testing.T) {
+
+	body := strings.Repeat("x", 100)
+	r := httptest.NewRequest(http.MethodPost, "/api/upload", strings.NewReader(body))
+	req := NewHTTPRequest(r, nil)
+	req.MaxBodySize = 10
+
+	// Streaming is not limited by MaxBodySize
+	data, err := io.ReadAll(req.GetDataReader())
+	if err != nil || string(data) != body {
+		t.Errorf("wrong streamed data %d: %v", len(data), err)
+	}
+	if data := req.GetData(); data != nil || !errors.Is(req.DataErr(), ErrDataStreamed) {
+		t.Errorf("data after streaming should be nil, got %s, %v", data, req.DataErr())
+	}
+
+	// Buffered data reader
+	r = httptest.NewRequest(http.MethodPost, "/api/upload", strings.NewReader(body))
+	req = NewHTTPRequest(r, nil)
+	req.GetData()
+	if data, _ := io.ReadAll(req.GetDataReader()); string(data) != body {
+		t.Errorf("wrong buffered data: %s", data)
+	}
+	if data, _ := io.ReadAll(WrapRequest(&legacyRequest{}).GetDataReader()); len(data) != 0 {
+		t.Errorf("wrong legacy request data: %s", data)
+	}
+}
+
+func TestWSFragmentation(t *testing.T) {
+
+	conn := &testWSConn{}
+	ch := NewWSChannel(conn)
+	var progress []int
+	ch.SetFragmentation(4, func(sent, total int) {
+		progress = append(progress, sent)
+	})
+
+	ch.Send([]byte("abc"))
+	ch.Send([]byte("0123456789"))
+	if len(conn.messages) != 2 || conn.messages[1] != "0123456789" {
+		t.Fatalf("wrong messages: %q", conn.messages)
+	}
+	if fmt.Sprint(conn.frames) != "[0123 4567 89]" {
+		t.Errorf("wrong frames: %q", conn.frames)
+	}
+	if fmt.Sprint(progress) != "[4 8 10]" {
+		t.Errorf("wrong progress: %v", progress)
+	}
+}
+
+// testWSConn is websocket connection which stores written messages and
+// frames of fragmented messages.
+type testWSConn struct {
+	messages []string
+	frames   []string
+}
+
+func (c *testWSConn) WriteMessage(messageType int, data []byte) error {
+	c.messages = append(c.messages, string(data))
+	return nil
+}
+
+func (c *testWSConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return &testWSWriter{c: c}, nil
+}
+
+// testWSWriter stores each write as frame.
+type testWSWriter struct {
+	c    *testWSConn
+	data string
+}
+
+func (w *testWSWriter) Write(p []byte) (int, error) {
+	w.c.frames = append(w.c.frames, string(p))
+	w.data += string(p)
+	return len(p), nil
+}
+
+func (w *testWSWriter) Close() error {
+	w.c.messages = append(w.c.messages, w.data)
+	return nil
+}
+
+func TestSigning(t *testing.T) {
+
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	keys := map[string]any{"hmac": []byte("secret"), "ed": pub}
+	v := NewVerifier(func(keyID string) (any, error) {
+		if key, ok := keys[keyID]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown key")
+	})
+
+	c := New()
+	c.Add("transfer", "", HTTP, "{to}", "", "", "",
+		v.Handler(func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte("ok"), nil
+		}),
+	)
+
+	vars, data := map[string]string{"to": "alice"}, []byte("100")
+	for _, signer := range []Signer{
+		&HMACSigner{KeyID: "hmac", Key: []byte("secret")},
+		&Ed25519Signer{KeyID: "ed", Key: priv},
+	} {
+		sig, err := SignRequest(signer, "transfer", vars, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := &DefaultRequest{Vars: vars, Data: data,
+			Header: http.Header{SignatureHeader: {sig}}}
+		if res, err := c.Exec("transfer", HTTP, req); err != nil || string(res) != "ok" {
+			t.Errorf("signed request: %s, %v", res, err)
+		}
+
+		// Changed data
+		req.Data = []byte("1000")
+		if _, err = c.Exec("transfer", HTTP, req); !errors.Is(err, ErrSignature) {
+			t.Errorf("changed request should be rejected, got %v", err)
+		}
+	}
+
+	// Not signed request
+	if _, err := c.Exec("transfer", HTTP, &DefaultRequest{Vars: vars}); !errors.Is(err, ErrSignature) {
+		t.Errorf("not signed request should be rejected, got %v", err)
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+
+	c := New()
+	handler := func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		return []byte(cmd.Cmd), nil
+	}
+	c.Add("old", "", HTTP, "", "", "", "", handler)
+	c.AddBatch([]CommandSpec{{Cmd: "new", ProcessIn: HTTP, Flag: "beta", Handler: handler}})
+
+	// Beta feature is enabled for beta tester only
+	c.SetFeatureFlags(FeatureFlagFunc(func(flag string, req RequestInterfaceV2) bool {
+		return flag != "beta" || (req != nil && req.GetUser() == "tester")
+	}))
+
+	if _, err := c.Exec("old", HTTP, nil); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.Exec("new", HTTP, &DefaultRequest{User: "user"}); !errors.Is(err, ErrFeatureDisabled) {
+		t.Errorf("disabled feature should fail, got %v", err)
+	}
+	if res, err := c.Exec("new", HTTP, &DefaultRequest{User: "tester"}); err != nil || string(res) != "new" {
+		t.Errorf("enabled feature: %s, %v", res, err)
+	}
+}
+
+func TestShadow(t *testing.T) {
+
+	c := New()
+	c.Add("sum", "", HTTP, "{a}/{b}", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			vars, _ := c.Vars(data)
+			a, _ := strconv.Atoi(vars["a"])
+			b, _ := strconv.Atoi(vars["b"])
+			return []byte(strconv.Itoa(a + b)), nil
+		},
+	)
+	if err := c.SetShadow("wrong", nil); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("shadow of not existing command should fail, got %v", err)
+	}
+
+	// Rewritten handler with a bug
+	c.SetShadow("sum", func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		vars, _ := c.Vars(data)
+		if vars["a"] == "0" {
+			return []byte("0"), nil
+		}
+		a, _ := strconv.Atoi(vars["a"])
+		b, _ := strconv.Atoi(vars["b"])
+		return []byte(strconv.Itoa(a + b)), nil
+	})
+	results := make(chan ShadowResult, 2)
+	c.SetShadowReporter(func(r ShadowResult) { results <- r })
+
+	for _, vars := range []map[string]string{{"a": "1", "b": "2"}, {"a": "0", "b": "2"}} {
+		res, err := c.Exec("sum", HTTP, &DefaultRequest{Vars: vars})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := <-results
+		if string(r.Result) != string(res) || r.Match != (vars["a"] == "1") {
+			t.Errorf("wrong shadow result: %+v", r)
+		}
+	}
+
+	// HTTP shadow escapes parameters
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		w.Write([]byte(r.URL.EscapedPath() + "?" + r.URL.RawQuery))
+	}))
+	defer ts.Close()
+	c.SetShadow("sum", HTTPShadow(ts.URL, nil))
+	c.Exec("sum", HTTP, &DefaultRequest{Vars: map[string]string{"a": "1/2?x",
+		"b": "#3"}})
+	if r := <-results; string(r.Shadow) != "/sum/1%2F2%3Fx/%233?" {
+		t.Errorf("wrong HTTP shadow request: %s, %v", r.Shadow, r.ShadowErr)
+	}
+
+	// Shadow runs over limit are dropped
+	release := make(chan struct{})
+	c.SetShadow("sum", func(cmd *CommandData, processIn ProcessIn, data any) (
+		[]byte, error) {
+		<-release
+		return nil, nil
+	})
+	results = make(chan ShadowResult, MaxShadowRuns+1)
+	c.SetShadowReporter(func(r ShadowResult) { results <- r })
+	for range MaxShadowRuns + 1 {
+		c.Exec("sum", HTTP, &DefaultRequest{})
+	}
+	close(release)
+	for range MaxShadowRuns {
+		<-results
+	}
+	select {
+	case <-results:
+		t.Error("shadow run over limit is not dropped")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestStatsSLO(t *testing.T) {
+
+	c := New()
+	c.AddStatsCommand(HTTP)
+	c.AddBatch([]CommandSpec{{
+		Cmd: "flaky", ProcessIn: HTTP, SLO: &SLO{ErrorRate: 0.3, MinCalls: 4},
+		Handler: func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			if data == "fail" {
+				return nil, fmt.Errorf("failed")
+			}
+			return []byte("ok"), nil
+		},
+	}})
+	var violations []SLOViolation
+	c.SetSLOHandler(func(v SLOViolation) { violations = append(violations, v) })
+
+	for _, data := range []string{"ok", "fail", "fail", "ok", "fail", "ok"} {
+		c.Exec("flaky", HTTP, data)
+	}
+	if len(violations) != 1 || violations[0].Command != "flaky" || violations[0].Calls != 4 {
+		t.Errorf("wrong violations: %+v", violations)
+	}
+
+	res, err := c.Exec("commstats", HTTP, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats map[string]CommandStats
+	if err = json.Unmarshal(res, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if s := stats["flaky"]; s.Calls != 6 || s.Errors != 3 || s.SLO == nil ||
+		!s.SLO.Violated || s.SLO.ErrorRate != 0.5 {
+		t.Errorf("wrong stats: %s", res)
+	}
+}
+
+func TestGroup(t *testing.T) {
+
+	c := New()
+	handler := func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		vars, _ := c.Vars(data)
+		return []byte(cmd.Cmd + " " + vars["name"]), nil
+	}
+	user := c.AddGroup("user", "user management", HTTP)
+	user.Add("create", "create user", HTTP, "{name}", "", "", "", handler)
+	user.Add("delete", "delete user", HTTP, "{name}", "", "", "", handler)
+	role := user.AddGroup("role", "user roles", HTTP)
+	role.Add("grant", "grant role", HTTP, "{name}", "", "", "", handler)
+
+	// Recursive routing
+	for message, want := range map[string]string{
+		"user/create/John":     "user/create",
+		"user/role/grant/John": "user/role/grant",
+		"user/wrong/John":      "user",
+	} {
+		name, vars := c.ParseCommand([]byte(message))
+		if name != want {
+			t.Errorf("%s: wrong name %s", message, name)
+		}
+		if want == "user" {
+			continue
+		}
+		res, err := c.Exec(name, HTTP, &DefaultRequest{Vars: vars})
+		if err != nil || string(res) != want[strings.LastIndex(want, "/")+1:]+" John" {
+			t.Errorf("%s: wrong result %s, %v", message, res, err)
+		}
+	}
+
+	// Help lists sub-commands
+	res, _ := c.Exec("user", HTTP, nil)
+	for _, s := range []string{"user/create/{name} - create user", "user/role/... - user roles"} {
+		if !strings.Contains(string(res), s) {
+			t.Errorf("help should contain %q: %s", s, res)
+		}
+	}
+
+	// Handled commands include sub-commands
+	var handled []string
+	c.HabdleCommands(HTTP, func(command, params string) {
+		handled = append(handled, command)
+	})
+	sort.Strings(handled)
+	if fmt.Sprint(handled) != "[user user/create user/delete user/role user/role/grant]" {
+		t.Errorf("wrong handled commands: %v", handled)
+	}
+
+	// Statistics of sub-commands are kept by full path
+	c.Add("create", "", HTTP, "{name}", "", "", "", handler)
+	c.Exec("create", HTTP, &DefaultRequest{})
+	stats := c.Stats()
+	if stats["create"].Calls != 1 || stats["user/create"].Calls != 1 ||
+		stats["user/role/grant"].Calls != 1 {
+		t.Errorf("wrong sub-commands stats: %+v", stats)
+	}
+
+	c.Del("user/delete")
+	if _, ok := c.Get("user/delete"); ok {
+		t.Error("sub-command should be deleted")
+	}
+}
+
+func TestComplete(t *testing.T) {
+
+	c := New()
+	c.AddCompleteCommand(WS)
+	names := []string{"John", "Jonathan", "Mary"}
+	c.AddBatch([]CommandSpec{{
+		Cmd: "hello", ProcessIn: WS, Params: "{name}/{greeting}",
+		Complete: func(param int, prefix string) (completions []string) {
+			if param != 0 {
+				return nil
+			}
+			for _, name := range names {
+				if strings.HasPrefix(name, prefix) {
+					completions = append(completions, name)
+				}
+			}
+			return
+		},
+	}})
+	c.Add("help", "", WS, "", "", "", "", nil)
+	c.AddGroup("user", "", WS).Add("create", "", WS, "", "", "", "", nil)
+
+	for line, want := range map[string]string{
+		"he":           "[hello help]",
+		"u":            "[user/]",
+		"user/c":       "[create]",
+		"hello/Jo":     "[John Jonathan]",
+		"hello/John/x": "[]",
+		"wrong/x":      "[]",
+	} {
+		if got := fmt.Sprint(c.Complete(line)); got != want {
+			t.Errorf("%s: got %s, want %s", line, got, want)
+		}
+	}
+
+	// Complete command
+	name, vars := c.ParseCommand([]byte("complete/hello/M"))
+	res, err := c.Exec(name, WS, &DefaultRequest{Vars: vars})
+	if err != nil || string(res) != `["Mary"]` {
+		t.Errorf("wrong complete command result: %s, %v", res, err)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+
+	c := New()
+	c.AddPipelineCommand(HTTP)
+	c.Add("users", "", HTTP, "{state}", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte(vars["state"] + "1," + vars["state"] + "2"), nil
+		},
+	)
+	c.Add("count", "", HTTP, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			d, _ := c.Data(data)
+			return []byte(strconv.Itoa(len(strings.Split(string(d), ",")))), nil
+		},
+	)
+	c.Add("repeat", "", HTTP, "{n}", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			vars, _ := c.Vars(data)
+			d, _ := c.Data(data)
+			n, _ := strconv.Atoi(vars["n"])
+			return bytes.Repeat(d, n), nil
+		},
+	)
+
+	for spec, want := range map[string]string{
+		"users/active | count": "2",
+		`[{"command":"users","vars":{"state":"new"}},{"command":"repeat","vars":{"n":"2"}}]`: "new1,new2new1,new2",
+	} {
+		res, err := c.Exec("pipeline", HTTP, &DefaultRequest{Data: []byte(spec)})
+		if err != nil || string(res) != want {
+			t.Errorf("%s: got %s, %v", spec, res, err)
+		}
+	}
+
+	// Intermediate size limit
+	steps, _ := c.ParsePipeline([]byte("users/a | repeat/1000 | count"))
+	if _, err := c.Pipeline(steps, HTTP, nil, 100); !errors.Is(err, ErrPipelineLimit) {
+		t.Errorf("pipeline over limit should fail, got %v", err)
+	}
+	if _, err := c.ParsePipeline([]byte("users/a | bad name")); err == nil {
+		t.Error("wrong pipeline should fail")
+	}
+}
+
+func TestPipelineConditions(t *testing.T) {
+
+	c := New()
+	c.Add("order", "", HTTP, "{id}", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			vars, _ := c.Vars(data)
+			if vars["id"] == "0" {
+				return nil, fmt.Errorf("order not found")
+			}
+			return []byte(`{"id":` + vars["id"] + `,"status":"paid","total":10}`), nil
+		},
+	)
+	c.Add("echo", "", HTTP, "{text}", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte(vars["text"]), nil
+		},
+	)
+
+	steps := func(id string, conditions ...string) []PipelineStep {
+		s := []PipelineStep{{Command: "order", Vars: map[string]string{"id": id}}}
+		for i, cond := range conditions {
+			s = append(s, PipelineStep{Command: "echo", If: cond,
+				Vars: map[string]string{"text": strconv.Itoa(i + 1)}})
+		}
+		return s
+	}
+
+	for _, test := range []struct {
+		steps []PipelineStep
+		want  string
+	}{
+		{steps("1", "ok"), "1"},
+		{steps("1", `result.status == "paid" && result.total == 10`), "1"},
+		{steps("1", "result.status != paid"), `{"id":1,"status":"paid","total":10}`},
+		{steps("0", "failed"), "1"},
+		{steps("0", "ok", "step0.failed || result.id == 1"), "2"},
+		{steps("1", "!ok", "step1.ok"), `{"id":1,"status":"paid","total":10}`},
+	} {
+		res, err := c.Pipeline(test.steps, HTTP, nil, 0)
+		if err != nil || string(res) != test.want {
+			t.Errorf("%s: got %s, %v", test.steps[len(test.steps)-1].If, res, err)
+		}
+	}
+
+	// Failed step without handler condition stops pipeline
+	if _, err := c.Pipeline(append(steps("0"), PipelineStep{Command: "echo"}), HTTP, nil, 0); err == nil {
+		t.Error("pipeline with failed step should fail")
+	}
+	for _, cond := range []string{"ok &&", "result ==", "wrong", `result == "x`, "step.ok", "ok ok"} {
+		if _, err := c.Pipeline(steps("1", cond), HTTP, nil, 0); !errors.Is(err, ErrCondition) {
+			t.Errorf("%s: wrong condition should fail, got %v", cond, err)
+		}
+	}
+}
+
+func TestTransaction(t *testing.T) {
+
+	balance := map[string]int{"1": 100, "2": 0}
+	c := New()
+	update := func(sign int) CommandHandler {
+		return func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			req, _ := c.Request(data)
+			vars := req.GetVars()
+			if _, ok := balance[vars["id"]]; !ok {
+				return nil, fmt.Errorf("account %s not found", vars["id"])
+			}
+			sum, _ := strconv.Atoi(vars["sum"])
+			balance[vars["id"]] += sign * sum
+			TransactionFrom(req.GetContext()).Compensate(func(ctx context.Context) error {
+				balance[vars["id"]] -= sign * sum
+				return nil
+			})
+			return []byte("ok"), nil
+		}
+	}
+	c.Add("withdraw", "", HTTP, "{id}/{sum}", "", "", "", update(-1))
+	c.Add("deposit", "", HTTP, "{id}/{sum}", "", "", "", update(1))
+	c.AddTransactionCommand(HTTP)
+
+	exec := func(spec string) error {
+		_, err := c.Exec("transaction", HTTP, &DefaultRequest{Data: []byte(spec)})
+		return err
+	}
+
+	if err := exec("withdraw/1/10 | deposit/2/10"); err != nil {
+		t.Fatal(err)
+	}
+	if balance["1"] != 90 || balance["2"] != 10 {
+		t.Fatalf("wrong balance after transaction: %v", balance)
+	}
+
+	// Failed deposit compensates withdraw
+	if err := exec("withdraw/1/10 | deposit/3/10"); err == nil {
+		t.Fatal("transaction should fail")
+	}
+	if balance["1"] != 90 || balance["2"] != 10 {
+		t.Fatalf("wrong balance after rollback: %v", balance)
+	}
+
+	// Compensation errors are returned with pipeline error
+	c.Add("fail", "", HTTP, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			req, _ := c.Request(data)
+			TransactionFrom(req.GetContext()).Compensate(func(ctx context.Context) error {
+				return fmt.Errorf("can't compensate")
+			})
+			return nil, nil
+		},
+	)
+	err := exec("fail | deposit/3/10")
+	if err == nil || !strings.Contains(err.Error(), "can't compensate") {
+		t.Fatalf("compensation error expected, got %v", err)
+	}
+
+	// Compensate without transaction does nothing
+	TransactionFrom(context.Background()).Compensate(func(ctx context.Context) error {
+		return nil
+	})
+}
+
+func TestQuota(t *testing.T) {
+
+	c := New()
+	c.AddBatch([]CommandSpec{{
+		Cmd: "export", ProcessIn: HTTP, Quota: &Quota{Limit: 2, Period: QuotaDaily},
+		Handler: func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte("ok"), nil
+		},
+	}})
+
+	// Quotas are not checked without store
+	for range 3 {
+		if _, err := c.Exec("export", HTTP, &DefaultRequest{User: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.SetQuotaStore(NewMemoryQuotaStore(), nil)
+	for i, test := range []struct {
+		user      string
+		remaining int64
+		err       error
+	}{
+		{"alice", 1, nil},
+		{"alice", 0, nil},
+		{"alice", 0, ErrQuotaExceeded},
+		{"bob", 1, nil},
+	} {
+		req := &DefaultRequest{User: test.user}
+		_, err := c.Exec("export", HTTP, req)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%d: wrong error: %v", i, err)
+		}
+		if req.Quota == nil || req.Quota.Limit != 2 ||
+			req.Quota.Remaining != test.remaining || req.Quota.Reset.IsZero() {
+			t.Errorf("%d: wrong quota usage: %+v", i, req.Quota)
+		}
+	}
+
+	// Monthly quota period
+	start, end := (&Quota{Period: QuotaMonthly}).reset(
+		time.Date(2024, 2, 10, 12, 0, 0, 0, time.UTC))
+	if start.Day() != 1 || end.Month() != 3 || end.Day() != 1 {
+		t.Errorf("wrong monthly period: %s - %s", start, end)
+	}
+}
+
+func TestCostBudget(t *testing.T) {
+
+	c := New()
+	handler := func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		return []byte("ok"), nil
+	}
+	c.AddBatch([]CommandSpec{
+		{Cmd: "read", ProcessIn: HTTP, Handler: handler},
+		{Cmd: "export", ProcessIn: HTTP, Cost: 5, Handler: handler},
+	})
+	c.SetCostBudget(6, time.Hour)
+
+	alice := &DefaultRequest{RemoteAddr: "10.0.0.1:1000"}
+	bob := &DefaultRequest{RemoteAddr: "10.0.0.2:1000"}
+	for i, test := range []struct {
+		command string
+		req     *DefaultRequest
+		err     error
+	}{
+		{"export", alice, nil},
+		{"export", alice, ErrCostBudget},
+		{"read", alice, nil},
+		{"read", alice, ErrCostBudget},
+		{"read", bob, nil},
+		{"export", bob, nil},
+	} {
+		if _, err := c.Exec(test.command, HTTP, test.req); !errors.Is(err, test.err) {
+			t.Errorf("%d: wrong error: %v", i, err)
+		}
+	}
+
+	// Shared budget of cluster nodes
+	store := NewMemoryQuotaStore()
+	nodes := []*Commands{New(), New()}
+	for _, node := range nodes {
+		node.AddBatch([]CommandSpec{{Cmd: "export", ProcessIn: HTTP, Cost: 5,
+			Handler: handler}})
+		node.SetCostBudget(12, time.Hour)
+		node.SetCostStore(store, nil)
+	}
+	for i, err := range []error{nil, nil, ErrCostBudget, ErrCostBudget} {
+		_, execErr := nodes[i%2].Exec("export", HTTP, &DefaultRequest{User: "alice"})
+		if !errors.Is(execErr, err) {
+			t.Errorf("node %d: wrong shared budget error: %v", i%2, execErr)
+		}
+	}
+	if _, err := nodes[0].Exec("export", HTTP, &DefaultRequest{User: "bob"}); err != nil {
+		t.Error(err)
+	}
+
+	// New window restores budget
+	c.SetCostBudget(5, 10*time.Millisecond)
+	c.Exec("export", HTTP, alice)
+	time.Sleep(20 * time.Millisecond)
+	if _, err := c.Exec("export", HTTP, alice); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+
+	c := New()
+	started, release := make(chan struct{}), make(chan struct{})
+	c.AddBatch([]CommandSpec{{
+		Cmd: "export", ProcessIn: HTTP, MaxConcurrency: 2,
+		Handler: func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			started <- struct{}{}
+			<-release
+			return []byte("ok"), nil
+		},
+	}})
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Exec("export", HTTP, nil)
+		}()
+		<-started
+	}
+	if _, err := c.Exec("export", HTTP, nil); !errors.Is(err, ErrConcurrencyLimit) {
+		t.Errorf("concurrency limit error expected, got %v", err)
+	}
+	if s := c.Stats()["export"]; s.InFlight != 2 || s.MaxInFlight != 2 {
+		t.Errorf("wrong in flight stats: %+v", s)
+	}
+
+	close(release)
+	wg.Wait()
+	if s := c.Stats()["export"]; s.InFlight != 0 || s.MaxInFlight != 2 || s.Calls != 2 {
+		t.Errorf("wrong stats after executions: %+v", s)
+	}
+}
+
+func TestServices(t *testing.T) {
+
+	type storage interface{ Get(key string) string }
+	type cache map[string]string
+
+	c := New()
+	c.Add("get", "", HTTP, "{key}", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			req, _ := c.Request(data)
+			db, err := Service[cache](req.GetContext())
+			if err != nil {
+				return nil, err
+			}
+			return []byte(db[req.GetVars()["key"]]), nil
+		},
+	)
+
+	// Service is not provided
+	_, err := c.Exec("get", HTTP, &DefaultRequest{Vars: map[string]string{"key": "a"}})
+	if !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("service not found error expected, got %v", err)
+	}
+
+	Provide(c, cache{"a": "1"})
+	res, err := c.Exec("get", HTTP, &DefaultRequest{Vars: map[string]string{"key": "a"}})
+	if err != nil || string(res) != "1" {
+		t.Errorf("wrong result: %s, %v", res, err)
+	}
+
+	// HTTP request context
+	r := httptest.NewRequest(http.MethodGet, "/get/a", nil)
+	res, err = c.Exec("get", HTTP, NewHTTPRequest(r, map[string]string{"key": "a"}))
+	if err != nil || string(res) != "1" {
+		t.Errorf("wrong HTTP result: %s, %v", res, err)
+	}
+
+	// Services outside of handlers, interface type
+	Provide[storage](c, nil)
+	if _, err := Service[storage](c.WithServices(context.Background())); err != nil {
+		t.Error(err)
+	}
+	if _, err := Service[*strings.Builder](c.WithServices(context.Background())); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("service not found error expected, got %v", err)
+	}
+}
+
+// userService is a service object added with AddStruct.
+type userService struct{ users []string }
+
+func (s *userService) Count(req RequestInterfaceV2) ([]byte, error) {
+	return []byte(strconv.Itoa(len(s.users))), nil
+}
+
+func (s *userService) GetUser(ctx context.Context, req RequestInterfaceV2) ([]byte, error) {
+	i, err := strconv.Atoi(req.GetVars()["id"])
+	if err != nil || i < 0 || i >= len(s.users) {
+		return nil, fmt.Errorf("user not found")
+	}
+	return []byte(s.users[i]), nil
+}
+
+func (s *userService) ListHTTPUsers(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+	return []byte(strings.Join(s.users, ",")), nil
+}
+
+func (s *userService) Describe(method string) CommandSpec {
+	switch method {
+	case "GetUser":
+		return CommandSpec{Cmd: "get", Descr: "Get user.", Params: "{id}"}
+	case "ListHTTPUsers":
+		return CommandSpec{ProcessIn: HTTP}
+	}
+	return CommandSpec{}
+}
+
+func (s *userService) Reset() { s.users = nil }
+
+func TestAddStruct(t *testing.T) {
+
+	c := New()
+	if err := c.AddStruct("user_", &userService{users: []string{"alice", "bob"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		command string
+		vars    map[string]string
+		want    string
+	}{
+		{"user_count", nil, "2"},
+		{"user_get", map[string]string{"id": "1"}, "bob"},
+		{"user_list_http_users", nil, "alice,bob"},
+	} {
+		res, err := c.Exec(test.command, HTTP, &DefaultRequest{Vars: test.vars})
+		if err != nil || string(res) != test.want {
+			t.Errorf("%s: got %s, %v", test.command, res, err)
+		}
+	}
+
+	if cmd, ok := c.Get("user_get"); !ok || cmd.Descr != "Get user." ||
+		cmd.Params != "{id}" || cmd.ProcessIn != All {
+		t.Errorf("wrong described command: %+v", cmd)
+	}
+	if cmd, _ := c.Get("user_list_http_users"); cmd.ProcessIn != HTTP {
+		t.Errorf("wrong command transports: %s", cmd.ProcessIn)
+	}
+	for _, name := range []string{"user_reset", "user_describe"} {
+		if _, ok := c.Get(name); ok {
+			t.Errorf("method %s should not be added", name)
+		}
+	}
+
+	// Duplicates and objects without command methods
+	if err := c.AddStruct("user_", &userService{}); !errors.Is(err, ErrCommandExists) {
+		t.Errorf("command exists error expected, got %v", err)
+	}
+	if err := c.AddStruct("", struct{}{}); err == nil {
+		t.Error("struct without methods should fail")
+	}
+}
+
+// countingCache is a CacheStore which counts stored items.
+type countingCache struct {
+	*MemoryCache
+	sets int
+}
+
+func (s *countingCache) Set(key string, data []byte, ttl time.Duration) error {
+	s.sets++
+	return s.MemoryCache.Set(key, data, ttl)
+}
+
+func TestCache(t *testing.T) {
+
+	c := New()
+	var calls int
+	c.AddBatch([]CommandSpec{{
+		Cmd: "report", ProcessIn: HTTP, Params: "{id}", CacheTTL: time.Minute,
+		Handler: func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			calls++
+			vars, _ := c.Vars(data)
+			if vars["id"] == "0" {
+				return nil, fmt.Errorf("wrong id")
+			}
+			return []byte(fmt.Sprintf("report %s #%d", vars["id"], calls)), nil
+		},
+	}})
+
+	exec := func(id string) string {
+		res, _ := c.Exec("report", HTTP, &DefaultRequest{Vars: map[string]string{"id": id}})
+		return string(res)
+	}
+	for _, test := range []struct{ id, want string }{
+		{"1", "report 1 #1"},
+		{"1", "report 1 #1"},
+		{"2", "report 2 #2"},
+		{"0", ""},
+		{"0", ""},
+	} {
+		if got := exec(test.id); got != test.want {
+			t.Errorf("wrong result of %s: %s", test.id, got)
+		}
+	}
+	if calls != 4 {
+		t.Errorf("errors should not be cached, got %d calls", calls)
+	}
+
+	// Shared store
+	store := &countingCache{MemoryCache: NewMemoryCache()}
+	c.SetCacheStore(store)
+	exec("3")
+	exec("3")
+	if store.sets != 1 || calls != 5 {
+		t.Errorf("wrong shared cache usage: %d sets, %d calls", store.sets, calls)
+	}
+
+	// Sub-commands results are cached by full path
+	cached := func(result string) CommandSpec {
+		return CommandSpec{Cmd: "create", ProcessIn: HTTP, CacheTTL: time.Minute,
+			Handler: func(cmd *CommandData, processIn ProcessIn, data any) (
+				[]byte, error) {
+				return []byte(result), nil
+			},
+		}
+	}
+	c.AddBatch([]CommandSpec{cached("top")})
+	c.AddGroup("user", "", HTTP).AddBatch([]CommandSpec{cached("sub")})
+	for name, want := range map[string]string{"create": "top", "user/create": "sub"} {
+		if res, _ := c.Exec(name, HTTP, &DefaultRequest{}); string(res) != want {
+			t.Errorf("wrong cached result of %s: %s", name, res)
+		}
+	}
+
+	// Expired items
+	store.Set("key", []byte("data"), -time.Second)
+	if _, ok, _ := store.Get("key"); ok {
+		t.Error("expired item should not be returned")
+	}
+}
+
+func TestScheduler(t *testing.T) {
+
+	var mut sync.Mutex
+	runs := make(map[string]int)
+
+	// Two nodes share leader lock, only one executes command
+	lock := NewMemoryLeaderLock()
+	var stops []func()
+	for _, node := range []string{"node1", "node2"} {
+		nodeCommands := New()
+		nodeCommands.Add("cleanup", "", HTTP, "", "", "", "",
+			func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+				mut.Lock()
+				runs[node]++
+				mut.Unlock()
+				return nil, nil
+			},
+		)
+		s := NewScheduler(nodeCommands, HTTP)
+		s.SetLeaderLock(lock, node)
+		if err := s.Every("cleanup", 10*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		stops = append(stops, s.Start())
+	}
+	time.Sleep(55 * time.Millisecond)
+
+	// Leader stops, other node takes over
+	mut.Lock()
+	leader, follower := "node1", "node2"
+	if runs["node2"] > 0 {
+		leader, follower = follower, leader
+	}
+	if runs[leader] == 0 || runs[follower] != 0 {
+		t.Errorf("command should run on one node: %v", runs)
+	}
+	mut.Unlock()
+	if leader == "node1" {
+		stops[0]()
+	} else {
+		stops[1]()
+	}
+	time.Sleep(55 * time.Millisecond)
+	for _, stop := range stops {
+		stop()
+	}
+	mut.Lock()
+	if runs[follower] == 0 {
+		t.Errorf("follower should take over: %v", runs)
+	}
+	mut.Unlock()
+
+	if err := NewScheduler(New(), HTTP).Every("unknown", time.Second); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("command not found error expected, got %v", err)
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+
+	// Backend command sees gateway request attributes
+	backend := New()
+	backend.Add("whoami", "", HTTP, "{name}", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			req, _ := backend.Request(data)
+			ctx := req.GetContext()
+			info, _ := CallInfoFrom(ctx)
+			_, hasDeadline := ctx.Deadline()
+			return []byte(fmt.Sprintf("%v %s %s %d %v", req.GetUser(),
+				req.GetVars()["name"], info.RequestID, info.Priority,
+				hasDeadline)), nil
+		},
+	)
+
+	// Gateway request
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx = WithCallInfo(ctx, CallInfo{RequestID: "r1", Priority: 5})
+	req := &DefaultRequest{Vars: map[string]string{"name": "x"}, User: "alice",
+		Ctx: ctx}
+
+	// Forward envelope
+	data, err := json.Marshal(NewEnvelope("whoami", req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e Envelope
+	if err = json.Unmarshal(data, &e); err != nil {
+		t.Fatal(err)
+	}
+	res, err := backend.ExecEnvelope(context.Background(), e, HTTP)
+	if err != nil || string(res) != "alice x r1 5 true" {
+		t.Errorf("wrong forwarded result: %s, %v", res, err)
+	}
+
+	// Expired deadline
+	e.Deadline = time.Now().Add(-time.Second)
+	if _, err = backend.ExecEnvelope(context.Background(), e, HTTP); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("deadline exceeded error expected, got %v", err)
+	}
+
+	// Envelope without deadline
+	e.Deadline = time.Time{}
+	if res, _ = backend.ExecEnvelope(context.Background(), e, HTTP); string(res) != "alice x r1 5 false" {
+		t.Errorf("wrong result without deadline: %s", res)
+	}
+}
+
+func TestSerializer(t *testing.T) {
+
+	type user struct {
+		Name string `json:"name"`
+	}
+	c := New()
+	c.SetSerializer(TRU|Teonet, RawSerializer{})
+	c.Add("user", "", All, "", "", "", "",
+		Typed(c, func(cmd *CommandData, processIn ProcessIn, data any) (user, error) {
+			return user{"alice"}, nil
+		}),
+	)
+	c.Add("name", "", All, "", "", "", "",
+		Typed(c, func(cmd *CommandData, processIn ProcessIn, data any) (string, error) {
+			return "alice", nil
+		}),
+	)
+	c.AddBatch([]CommandSpec{{Cmd: "raw", ProcessIn: All, Serializer: RawSerializer{},
+		Handler: Typed(c, func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte("bytes"), nil
+		}),
+	}})
+
+	for _, test := range []struct {
+		command   string
+		processIn ProcessIn
+		want      string
+		err       error
+	}{
+		{"user", HTTP, `{"name":"alice"}`, nil},
+		{"user", TRU, "", ErrSerialize},
+		{"name", HTTP, `"alice"`, nil},
+		{"name", Teonet, "alice", nil},
+		{"raw", HTTP, "bytes", nil},
+	} {
+		res, err := c.Exec(test.command, test.processIn, nil)
+		if string(res) != test.want || !errors.Is(err, test.err) {
+			t.Errorf("%s %s: got %s, %v", test.command, test.processIn, res, err)
+		}
+	}
+
+	var name string
+	if err := (RawSerializer{}).Unmarshal([]byte("bob"), &name); err != nil || name != "bob" {
+		t.Errorf("wrong raw unmarshal: %s, %v", name, err)
+	}
+}
+
+func TestRegisterProcessIn(t *testing.T) {
+
+	quic, err := RegisterProcessIn("QUIC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quic <= All || quic.Name() != "QUIC" || (HTTP|quic).String() != "http, quic" {
+		t.Errorf("wrong registered processing type: %d %s", quic, quic)
+	}
+	if _, err := RegisterProcessIn("http"); err == nil {
+		t.Error("duplicate processing type should fail")
+	}
+	if _, err := RegisterProcessIn("wrong name"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("invalid name error expected, got %v", err)
+	}
+
+	// Commands list filter of registered processing types
+	c := New()
+	c.AddCommandsList(HTTP)
+	c.Add("stream", "", quic, "", "", "", "", func(cmd *CommandData,
+		processIn ProcessIn, data any) ([]byte, error) {
+		return nil, nil
+	})
+	cmd, _ := c.Get("commfilt")
+	if cmd.Params != "{http}/{webrtc}/{tru}/{ws}" {
+		t.Errorf("wrong filter params: %s", cmd.Params)
+	}
+
+	vars := map[string]string{}
+	for _, pi := range ProcessIns() {
+		vars[processInKey(pi)] = strconv.FormatBool(pi == quic)
+	}
+	res, err := c.Exec("commfilt", HTTP, &DefaultRequest{Vars: vars})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(res, []byte(`id="quic"`)) || !bytes.Contains(res, []byte("commands/stream")) ||
+		bytes.Contains(res, []byte("commands/commands")) {
+		t.Errorf("wrong filtered commands list: %s", res)
+	}
+}
+
+func TestHandlerVariants(t *testing.T) {
+
+	c := New()
+	c.Add("user", "", HTTP|WS|TRU, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte(`{"name":"alice"}`), nil
+		},
+	)
+	err := c.SetHandler("user", HTTP, func(cmd *CommandData, processIn ProcessIn,
+		data any) ([]byte, error) {
+		return []byte("<b>alice</b>"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Command with variants only
+	c.Add("page", "", 0, "", "", "", "", nil)
+	c.SetHandler("page", HTTP, func(cmd *CommandData, processIn ProcessIn,
+		data any) ([]byte, error) {
+		return []byte("<p>page</p>"), nil
+	})
+
+	for _, test := range []struct {
+		command   string
+		processIn ProcessIn
+		want      string
+	}{
+		{"user", HTTP, "<b>alice</b>"},
+		{"user", WS, `{"name":"alice"}`},
+		{"user", TRU, `{"name":"alice"}`},
+		{"page", HTTP, "<p>page</p>"},
+		{"page", WS, ""},
+	} {
+		res, _ := c.Exec(test.command, test.processIn, nil)
+		if string(res) != test.want {
+			t.Errorf("%s %s: got %s", test.command, test.processIn, res)
+		}
+	}
+	var commands []string
+	c.HabdleCommands(HTTP, func(command, params string) {
+		commands = append(commands, command)
+	})
+	sort.Strings(commands)
+	if strings.Join(commands, ",") != "page,user" {
+		t.Errorf("wrong HTTP commands: %v", commands)
+	}
+
+	// Remove variant
+	c.SetHandler("user", HTTP, nil)
+	if res, _ := c.Exec("user", HTTP, nil); string(res) != `{"name":"alice"}` {
+		t.Errorf("variant should be removed, got %s", res)
+	}
+	if err := c.SetHandler("unknown", HTTP, nil); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("command not found error expected, got %v", err)
+	}
+}
+
+func TestValues(t *testing.T) {
+
+	type account struct{ ID int }
+	accountKey := NewKey[account]("account")
+	c := New()
+	c.AddBatch([]CommandSpec{{Cmd: "hello", ProcessIn: All,
+		Handler: func(cmd *CommandData, processIn ProcessIn, data any) (
+			[]byte, error) {
+
+			req, _ := c.Request(data)
+			ctx := req.GetContext()
+			user, _ := Value(ctx, UserKey)
+			tenant, _ := Value(ctx, TenantKey)
+			locale, _ := Value(ctx, LocaleKey)
+			id, _ := Value(ctx, RequestIDKey)
+			acc, _ := Value(ctx, accountKey)
+			return fmt.Appendf(nil, "%s %s %s %s %d", user, tenant, locale, id,
+				acc.ID), nil
+		},
+	}})
+
+	req := &DefaultRequest{User: "alice", Header: http.Header{
+		"Accept-Language": {"en-US,en;q=0.9"}, "X-Request-Id": {"r1"},
+	}}
+
+	// Middleware sets values before execution
+	if err := SetRequestValue(req, TenantKey, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	SetRequestValue(req, accountKey, account{ID: 7})
+	SetRequestValue(req, UserKey, "bob")
+
+	res, err := c.Exec("hello", HTTP, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != "bob acme en-US r1 7" {
+		t.Errorf("wrong values: %s", res)
+	}
+
+	// Standard values set by Exec
+	req = &DefaultRequest{User: "alice"}
+	res, _ = c.Exec("hello", HTTP, req)
+	if string(res) != "alice    0" {
+		t.Errorf("wrong default values: %q", res)
+	}
+
+	// Key types do not collide
+	v := NewValues()
+	Set(v, NewKey[int]("n"), 1)
+	if _, ok := Get(v, NewKey[string]("n")); ok {
+		t.Error("keys of different types should not collide")
+	}
+	if _, ok := Get(nil, UserKey); ok {
+		t.Error("nil values should have no values")
+	}
+	if err := SetRequestValue(legacyRequest{}, UserKey, "x"); err != ErrValues {
+		t.Errorf("ErrValues expected, got %v", err)
+	}
+}
+
+func TestFprint(t *testing.T) {
+
+	c := New()
+	handler := func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		return nil, nil
+	}
+	c.Add("version", "get version", HTTP, "", "", "", "", handler)
+	c.Add("hello", "say hello", HTTP|WS, "{name}", "", "", "", handler)
+	c.Add("push", "push data", WS, "", "", "", "", handler)
+	c.Exec("version", HTTP, nil)
+	c.Exec("version", HTTP, nil)
+	c.Exec("hello", HTTP, nil)
+
+	var buf bytes.Buffer
+	if err := c.Fprint(&buf, PrintOptions{ProcessIn: HTTP}); err != nil {
+		t.Fatal(err)
+	}
+	want := "NAME     PARAMS  PROCESS IN       DESCRIPTION\n" +
+		"hello    {name}  http, websocket  say hello\n" +
+		"version          http             get version\n"
+	if buf.String() != want {
+		t.Errorf("wrong commands table:\n%s", buf.String())
+	}
+
+	// Sort by calls with statistics
+	buf.Reset()
+	c.Fprint(&buf, PrintOptions{Stats: true, Sort: SortByCalls})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "version") ||
+		!strings.HasPrefix(lines[2], "hello") || !strings.Contains(lines[0], "CALLS") {
+		t.Errorf("wrong sorted commands table:\n%s", buf.String())
+	}
+}
+
+func TestIterSorted(t *testing.T) {
+
+	c := New()
+	for _, name := range []string{"zeta", "alpha", "mid", "beta"} {
+		c.Add(name, "", HTTP, "", "", "", "",
+			func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+				return nil, nil
+			},
+		)
+	}
+
+	var names []string
+	for name := range c.IterSorted() {
+		names = append(names, name)
+		if name == "beta" {
+			break
+		}
+	}
+	if strings.Join(names, ",") != "alpha,beta" {
+		t.Errorf("wrong sorted iteration: %v", names)
+	}
+
+	names = nil
+	c.HabdleCommands(HTTP, func(command, params string) {
+		names = append(names, command)
+	})
+	if strings.Join(names, ",") != "alpha,beta,mid,zeta" {
+		t.Errorf("wrong routes order: %v", names)
+	}
+}
+
+func TestRouteConflicts(t *testing.T) {
+
+	c := New()
+	handler := func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		return nil, nil
+	}
+	c.Add("user", "", HTTP, "{id}", "", "", "", handler)
+	admin := c.AddGroup("admin", "", HTTP)
+	admin.Add("list", "", HTTP, "{page}", "", "", "", handler)
+	if err := c.CheckRoutes(HTTP); err != nil {
+		t.Errorf("routes should not conflict: %v", err)
+	}
+
+	// Names with slashes of custom validator conflict with parameters and
+	// sub-commands
+	c.SetNameValidator(func(name string) error { return nil })
+	c.Add("user/me", "", HTTP, "", "", "", "", handler)
+	c.Add("admin/list", "", HTTP, "{n}", "", "", "", handler)
+	c.Add("user/ws", "", WS, "", "", "", "", handler)
+
+	conflicts := c.RouteConflicts(HTTP)
+	want := []RouteConflict{
+		{"admin/list/{n}", "admin/list/{page}", false},
+		{"user/me", "user/{id}", true},
+	}
+	if !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("wrong conflicts: %v", conflicts)
+	}
+	err := c.CheckRoutes(HTTP)
+	if !errors.Is(err, ErrRouteConflict) ||
+		!strings.Contains(err.Error(), "route 'user/me' shadows 'user/{id}'") {
+		t.Errorf("route conflict error expected, got %v", err)
+	}
+}
+
+func TestParamEscaping(t *testing.T) {
+
+	c := New()
+	c.Add("copy", "", WS, "{from}/{to}", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return nil, nil
+		},
+	)
+
+	for _, test := range []struct{ from, to string }{
+		{"a/b c", "x+y"},
+		{"100%", "привет/мир"},
+		{"", "?#&"},
+	} {
+		line := CommandLine("copy", test.from, test.to)
+		if strings.Count(line, "/") != 2 {
+			t.Errorf("parameters should be escaped: %s", line)
+		}
+		name, vars := c.ParseCommand([]byte(line))
+		if name != "copy" || vars["from"] != test.from || vars["to"] != test.to {
+			t.Errorf("wrong round trip of %s: %v", line, vars)
+		}
+	}
+
+	// Not escaped values are accepted as is
+	_, vars := c.ParseCommand([]byte("copy/100% sure/a+b/c"))
+	if vars["from"] != "100% sure" || vars["to"] != "a+b/c" {
+		t.Errorf("wrong not escaped values: %v", vars)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+
+	c := New()
+	c.Add("sleep", "", WS, "{ms}", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			vars, _ := c.Vars(data)
+			ms, _ := strconv.Atoi(vars["ms"])
+			req, _ := c.Request(data)
+			select {
+			case <-time.After(time.Duration(ms) * time.Millisecond):
+				return []byte("ok"), nil
+			case <-req.GetContext().Done():
+				return nil, req.GetContext().Err()
+			}
+		},
+	)
+
+	slow := make(chan SlowRequest, 1)
+	c.SetSlowHandler(func(r SlowRequest) { slow <- r })
+	c.SetTimeouts(Timeouts{Soft: 20 * time.Millisecond, Hard: 100 * time.Millisecond})
+
+	request := func(ms string) *DefaultRequest {
+		req := &DefaultRequest{Vars: map[string]string{"ms": ms}}
+		SetRequestValue(req, RequestIDKey, "req-"+ms)
+		return req
+	}
+
+	// Fast request is not reported
+	if res, err := c.Exec("sleep", WS, request("1")); err != nil ||
+		string(res) != "ok" {
+		t.Fatalf("wrong fast request result: %s, %v", res, err)
+	}
+	select {
+	case r := <-slow:
+		t.Errorf("fast request reported: %v", r)
+	default:
+	}
+
+	// Slow request is reported with request ID and vars
+	if _, err := c.Exec("sleep", WS, request("50")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-slow:
+		if r.Command != "sleep" || r.RequestID != "req-50" || r.Vars["ms"] != "50" {
+			t.Errorf("wrong slow request: %v", r)
+		}
+	default:
+		t.Error("slow request is not reported")
+	}
+
+	// Request exceeded hard timeout is canceled
+	if _, err := c.Exec("sleep", WS, request("1000")); !errors.Is(err, ErrTimeout) {
+		t.Errorf("wrong hard timeout error: %v", err)
+	}
+	<-slow
+
+	// Command timeouts override default timeouts
+	cmd, _ := c.Get("sleep")
+	cmd.Timeouts = &Timeouts{}
+	if _, err := c.Exec("sleep", WS, request("150")); err != nil {
+		t.Errorf("command timeouts are not used: %v", err)
+	}
+
+	// Timed out handler holds concurrency slot until it returns, hard
+	// timeout is used with nil data
+	release := make(chan struct{})
+	c.Add("block", "", WS, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			<-release
+			return nil, nil
+		},
+	)
+	cmd, _ = c.Get("block")
+	cmd.MaxConcurrency = 1
+	cmd.Timeouts = &Timeouts{Hard: 20 * time.Millisecond}
+	if _, err := c.Exec("block", WS, nil); !errors.Is(err, ErrTimeout) {
+		t.Errorf("wrong nil data hard timeout error: %v", err)
+	}
+	if _, err := c.Exec("block", WS, nil); !errors.Is(err, ErrConcurrencyLimit) {
+		t.Errorf("timed out handler slot is released: %v", err)
+	}
+	close(release)
+	for i := 0; c.Stats()["block"].InFlight > 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.Exec("block", WS, nil); err != nil {
+		t.Errorf("returned handler slot is not released: %v", err)
+	}
+}
+
+func TestResponseLimit(t *testing.T) {
+
+	c := New()
+	c.Add("big", "", WS, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte("0123456789"), nil
+		},
+	)
+
+	if res, err := c.Exec("big", WS, nil); err != nil || len(res) != 10 {
+		t.Errorf("wrong unlimited result: %s, %v", res, err)
+	}
+
+	c.SetResponseLimit(ResponseLimit{Size: 4})
+	if _, err := c.Exec("big", WS, nil); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("wrong too large response error: %v", err)
+	}
+
+	cmd, _ := c.Get("big")
+	cmd.ResponseLimit = &ResponseLimit{Size: 4, Truncate: true}
+	if res, err := c.Exec("big", WS, nil); err != nil || string(res) != "0123" {
+		t.Errorf("wrong truncated result: %s, %v", res, err)
+	}
+}
+
+func TestCrashReports(t *testing.T) {
+
+	c := New()
+	c.Add("crash", "", WS, "{id}", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			var m map[string]int
+			m["id"]++
+			return nil, nil
+		},
+	)
+	c.AddCrashCommand(WS)
+
+	var alerts atomic.Int32
+	c.SetCrashReports(2, func(r CrashReport) { alerts.Add(1) })
+
+	for _, id := range []string{"1", "2", "3"} {
+		_, err := c.Exec("crash", WS, &DefaultRequest{Vars: map[string]string{"id": id}})
+		if !errors.Is(err, ErrPanic) {
+			t.Fatalf("wrong panic error: %v", err)
+		}
+	}
+	if alerts.Load() != 3 {
+		t.Errorf("wrong number of panic alerts: %d", alerts.Load())
+	}
+
+	res, err := c.Exec("crashes", WS, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reports []CrashReport
+	if err := json.Unmarshal(res, &reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("wrong number of crash reports: %d", len(reports))
+	}
+	r := reports[1]
+	if r.Command != "crash" || r.ProcessIn != WS || r.Stack == "" ||
+		r.VarsHash != varsHash(map[string]string{"id": "3"}) ||
+		r.VarsHash == reports[0].VarsHash {
+		t.Errorf("wrong crash report: %v", r)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+
+	count := func(kind string) (n int) {
+		for _, st := range Diagnostics().Goroutines {
+			if st.Kind == kind {
+				n = st.Count
+			}
+		}
+		return
+	}
+
+	const kind = "test worker"
+	stop := make(chan struct{})
+	for range 3 {
+		Go(kind, func() { <-stop })
+	}
+	done := Track(kind)
+	time.Sleep(10 * time.Millisecond)
+
+	r := Diagnostics()
+	if count(kind) != 4 || r.Tracked < 4 || r.Total < 3 {
+		t.Fatalf("wrong diagnostics: %v", r)
+	}
+	for _, st := range r.Goroutines {
+		if st.Kind == kind && st.Oldest < 10*time.Millisecond {
+			t.Errorf("wrong oldest goroutine age: %s", st.Oldest)
+		}
+	}
+
+	done()
+	done()
+	close(stop)
+	for i := 0; count(kind) > 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := count(kind); n != 0 {
+		t.Errorf("finished goroutines are tracked: %d", n)
+	}
+}
+
+func TestDebugCommands(t *testing.T) {
+
+	c := New()
+	c.AddDebugCommands(WS, func(req RequestInterfaceV2) error {
+		if req.GetUser() != "admin" {
+			return fmt.Errorf("user is not admin")
+		}
+		return nil
+	})
+
+	request := func(user string, vars map[string]string) *DefaultRequest {
+		return &DefaultRequest{Vars: vars, User: user}
+	}
+
+	if _, err := c.Exec("debug/heap", WS, request("guest", nil)); !errors.Is(err,
+		ErrForbidden) {
+		t.Errorf("wrong not authorized request error: %v", err)
+	}
+
+	for _, name := range []string{"debug/heap", "debug/goroutine"} {
+		res, err := c.Exec(name, WS, request("admin", nil))
+		if err != nil || len(res) == 0 {
+			t.Errorf("wrong %s profile: %v", name, err)
+		}
+	}
+
+	res, err := c.Exec("debug/cpu", WS, request("admin",
+		map[string]string{"seconds": "1"}))
+	if err != nil || len(res) == 0 {
+		t.Errorf("wrong cpu profile: %v", err)
+	}
+	if _, err = c.Exec("debug/cpu", WS, request("admin",
+		map[string]string{"seconds": "x"})); !errors.Is(err, ErrIncorrectInputData) {
+		t.Errorf("wrong cpu seconds error: %v", err)
+	}
+
+	res, err = c.Exec("debug/vars", WS, request("admin", nil))
+	var vars map[string]json.RawMessage
+	if err != nil || json.Unmarshal(res, &vars) != nil || vars["memstats"] == nil {
+		t.Errorf("wrong expvar variables: %s, %v", res, err)
+	}
+
+	// Debug commands are rejected if authorizer is not set
+	c = New()
+	c.AddDebugCommands(WS, nil)
+	if _, err := c.Exec("debug/vars", WS, request("admin", nil)); !errors.Is(err,
+		ErrForbidden) {
+		t.Errorf("wrong request without authorizer error: %v", err)
+	}
+}
+
+func TestAbout(t *testing.T) {
+
+	c := New()
+	h := func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		return nil, nil
+	}
+	c.Add("one", "", HTTP, "", "", "", "", h)
+	c.Add("two", "", HTTP|WS, "", "", "", "", h)
+	c.AddAboutCommand(WS)
+	c.SetResponseLimit(ResponseLimit{Size: 1 << 20})
+
+	res, err := c.Exec("about", WS, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a About
+	if err := json.Unmarshal(res, &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.Module != ModulePath || a.GoVersion == "" || a.Version == "" {
+		t.Errorf("wrong build info: %v", a)
+	}
+	if !reflect.DeepEqual(a.Subsystems, []string{"metrics", "response limit"}) {
+		t.Errorf("wrong subsystems: %v", a.Subsystems)
+	}
+	if !reflect.DeepEqual(a.Transports, []string{"http", "websocket"}) {
+		t.Errorf("wrong transports: %v", a.Transports)
+	}
+	if a.Total != 3 || a.Commands["http"] != 2 || a.Commands["websocket"] != 2 {
+		t.Errorf("wrong commands count: %d %v", a.Total, a.Commands)
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	h := func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		return nil, nil
+	}
+	schema := `{"type":"object","required":["id"],` +
+		`"properties":{"id":{"type":"integer"},"tags":{"type":"array","items":{"type":"string"}}}}`
+
+	c := New()
+	c.Add("good", "", HTTP, "{id}/{name}", "", "good/1/a", `{"id":1}`, h)
+	c.AddBatch([]CommandSpec{
+		{Cmd: "nohandler", ProcessIn: HTTP},
+		{Cmd: "schema", ProcessIn: HTTP, Response: `{"id":1.5,"tags":[1]}`,
+			ResponseSchema: schema, Handler: h},
+		{Cmd: "badschema", ProcessIn: HTTP, RequestSchema: "{", Handler: h},
+		{Cmd: "old", ProcessIn: HTTP, Deprecated: true, Handler: h},
+		{Cmd: "older", ProcessIn: HTTP, Deprecated: true, ReplacedBy: "newest",
+			Handler: h},
+	})
+	c.Add("params", "", HTTP, "{id}/name/{id}", "", "", "", h)
+	cmd, _ := c.Get("good")
+	cmd.ResponseSchema = schema
+
+	var problems []string
+	for _, p := range c.Validate() {
+		problems = append(problems, p.Command+" "+p.Field)
+	}
+	expected := []string{"badschema RequestSchema", "nohandler Handler",
+		"old ReplacedBy", "older ReplacedBy", "params Params", "schema Response"}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("wrong validation problems: %v", problems)
+	}
+	if err := c.Validate().Err(); !errors.Is(err, ErrValidation) {
+		t.Errorf("wrong validation error: %v", err)
+	}
+
+	c = New()
+	c.Add("good", "", HTTP, "{id}", "", "", "", h)
+	if err := c.Validate().Err(); err != nil {
+		t.Errorf("valid commands have problems: %v", err)
+	}
+}
+
+func TestDelDrain(t *testing.T) {
+
+	c := New()
+	started, release := make(chan struct{}), make(chan struct{})
+	c.Add("slow", "", WS, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			started <- struct{}{}
+			<-release
+			return nil, nil
+		},
+	)
+	cmd, _ := c.Get("slow")
+
+	go c.Exec("slow", WS, nil)
+	<-started
+
+	// Drain times out while execution is in flight
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.DelDrain(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wrong drain timeout error: %v", err)
+	}
+	if _, ok := c.Get("slow"); ok {
+		t.Error("command is not removed")
+	}
+
+	// Drain waits for execution in flight and rejects new executions
+	c.AddBatch([]CommandSpec{CommandSpec(*cmd)})
+	drained := make(chan error)
+	go func() { drained <- c.DelDrain(context.Background(), "slow") }()
+	time.Sleep(10 * time.Millisecond)
+	if err := c.acquire(cmd, "slow"); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("execution of draining command is not rejected: %v", err)
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("drain finished with execution in flight: %v", err)
+	default:
+	}
+	close(release)
+	if err := <-drained; err != nil {
+		t.Errorf("wrong drain error: %v", err)
+	}
+
+	// Command may be added again after drain
+	c.AddBatch([]CommandSpec{CommandSpec(*cmd)})
+	go func() { <-started }()
+	if _, err := c.Exec("slow", WS, nil); err != nil {
+		t.Errorf("wrong execution of added again command: %v", err)
+	}
+	if err := c.DelDrain(context.Background(), "nope"); !errors.Is(err,
+		ErrCommandNotFound) {
+		t.Errorf("wrong drain of unknown command error: %v", err)
+	}
+}
+
+func TestReplaceHandler(t *testing.T) {
+
+	c := New()
+	c.Add("hello", "Say hello.", WS, "", "", "hello", "hello", func(
+		cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		return []byte("hello"), nil
+	})
+	group := c.AddGroup("user", "", WS)
+	group.Add("name", "", WS, "", "", "", "", func(
+		cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		return []byte("alice"), nil
+	})
+
+	// Command is always found while its handler is replaced
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if _, err := c.Exec("hello", WS, nil); err != nil {
+				t.Errorf("command is missing while replaced: %v", err)
+				return
+			}
+		}
+	}()
+
+	err := c.ReplaceHandler("hello", func(cmd *CommandData, processIn ProcessIn,
+		data any) ([]byte, error) {
+		return []byte("hi"), nil
+	}, func(cmd *CommandData) { cmd.Response = "hi" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	cmd, _ := c.Get("hello")
+	if res, _ := c.Exec("hello", WS, nil); string(res) != "hi" ||
+		cmd.Response != "hi" || cmd.Descr != "Say hello." {
+		t.Errorf("wrong replaced command: %s, %v", res, cmd)
+	}
+
+	err = c.ReplaceHandler("user/name", func(cmd *CommandData,
+		processIn ProcessIn, data any) ([]byte, error) {
+		return []byte("bob"), nil
+	})
+	if res, _ := c.Exec("user/name", WS, nil); err != nil || string(res) != "bob" {
+		t.Errorf("wrong replaced sub-command: %s, %v", res, err)
+	}
+
+	// Handler variants are removed by replaced handler
+	c.SetHandler("hello", WS, func(cmd *CommandData, processIn ProcessIn,
+		data any) ([]byte, error) {
+		return []byte("variant"), nil
+	})
+	c.ReplaceHandler("hello", func(cmd *CommandData, processIn ProcessIn,
+		data any) ([]byte, error) {
+		return []byte("hey"), nil
+	})
+	if res, _ := c.Exec("hello", WS, nil); string(res) != "hey" {
+		t.Errorf("handler variant should be removed, got %s", res)
+	}
+
+	if err := c.ReplaceHandler("user/nope", nil); !errors.Is(err,
+		ErrCommandNotFound) {
+		t.Errorf("wrong replace of unknown command error: %v", err)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+
+	h := func(res string) CommandHandler {
+		return func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte(res), nil
+		}
+	}
+
+	c := New()
+	c.SetCaseInsensitive(true)
+	c.Add("hello", "", WS, "", "", "", "", h("hello"))
+	c.AddGroup("user", "", WS).Add("name", "", WS, "", "", "", "", h("alice"))
+	c.Freeze()
+	if !c.Frozen() {
+		t.Fatal("commands are not frozen")
+	}
+
+	for name, expected := range map[string]string{"HELLO": "hello",
+		"user/name": "alice"} {
+		if res, err := c.Exec(name, WS, nil); err != nil || string(res) != expected {
+			t.Errorf("wrong frozen command %s result: %s, %v", name, res, err)
+		}
+	}
+
+	// Changes rebuild the snapshot
+	c.Add("bye", "", WS, "", "", "", "", h("bye"))
+	c.Del("hello")
+	if _, ok := c.Get("bye"); !ok {
+		t.Error("command added after freeze is not visible")
+	}
+	if res, err := c.Exec("hello", WS, nil); err == nil {
+		t.Errorf("command removed after freeze is executed: %s", res)
+	}
+	if err := c.ReplaceHandler("bye", h("goodbye")); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := c.Exec("bye", WS, nil); string(res) != "goodbye" {
+		t.Errorf("replaced handler is not used after freeze: %s", res)
+	}
+	c.Del("user/name")
+	if _, ok := c.Get("user/name"); ok {
+		t.Error("sub-command removed after freeze is visible")
+	}
+	if err := c.DelDrain(context.Background(), "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := c.Exec("bye", WS, nil); err == nil {
+		t.Errorf("drained command is executed after freeze: %s", res)
+	}
+	c.AddGroup("admin", "", WS).Add("list", "", WS, "", "", "", "", h("list"))
+	if res, err := c.Exec("admin/list", WS, nil); err != nil || string(res) != "list" {
+		t.Errorf("wrong group added after freeze result: %s, %v", res, err)
+	}
+
+	c.Unfreeze()
+	c.Add("hello", "", WS, "", "", "", "", h("hello"))
+	if c.Frozen() {
+		t.Error("commands are frozen after unfreeze")
+	}
+	if _, ok := c.Get("hello"); !ok {
+		t.Error("added command is not visible after unfreeze")
+	}
+}
+
+func TestVersions(t *testing.T) {
+
+	c := New()
+	for _, version := range []string{"1", "2.0", "2.1", "3"} {
+		err := c.AddVersion(CommandSpec{Cmd: "hello", ProcessIn: WS,
+			Params: "{name}", Version: version,
+			Handler: func(cmd *CommandData, processIn ProcessIn, data any) (
+				[]byte, error) {
+				vars, _ := c.Vars(data)
+				return []byte(version + " " + vars["name"]), nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.AddVersion(CommandSpec{Cmd: "hello", Version: "x"}); !errors.Is(err,
+		ErrInvalidVersion) {
+		t.Errorf("wrong invalid version error: %v", err)
+	}
+	if versions := c.Versions("hello"); !reflect.DeepEqual(versions,
+		[]string{"1", "2.0", "2.1", "3"}) {
+		t.Errorf("wrong versions: %v", versions)
+	}
+
+	exec := func(line, accept string) string {
+		name, vars := c.ParseCommand([]byte(line))
+		req := &DefaultRequest{Vars: vars, Header: http.Header{}}
+		if accept != "" {
+			req.Header.Set(AcceptVersionHeader, accept)
+		}
+		res, err := c.Exec(name, WS, req)
+		if err != nil {
+			return err.Error()
+		}
+		return string(res)
+	}
+	for _, test := range []struct{ line, accept, expected string }{
+		{"hello/bob", "", "3 bob"},
+		{"v1:hello/bob", "", "1 bob"},
+		{"v2:hello/bob", "", "2.1 bob"},
+		{"hello/bob", "2.0", "2.1 bob"},
+		{"hello/bob", "v1", "1 bob"},
+		{"v3:hello/bob", "1", "3 bob"},
+		{"hello/bob", "2.2", "command 'hello' not found"},
+		{"v4:hello/bob", "", "command 'v4:hello' not found"},
+	} {
+		if res := exec(test.line, test.accept); res != test.expected {
+			t.Errorf("wrong %s (%s) result: %s", test.line, test.accept, res)
+		}
+	}
+
+	if md := string(c.Markdown()); !strings.Contains(md, "- Versions: 1, 2.0, 2.1, 3") {
+		t.Errorf("versions are not documented:\n%s", md)
+	}
+
+	// Handler replacement changes the highest version
+	err := c.ReplaceHandler("hello", func(cmd *CommandData, processIn ProcessIn,
+		data any) ([]byte, error) {
+		return []byte("new"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct{ line, accept, expected string }{
+		{"hello/bob", "", "new"},
+		{"v3:hello/bob", "", "new"},
+		{"hello/bob", "3", "new"},
+		{"v1:hello/bob", "", "1 bob"},
+	} {
+		if res := exec(test.line, test.accept); res != test.expected {
+			t.Errorf("wrong replaced %s (%s) result: %s", test.line, test.accept,
+				res)
+		}
+	}
+
+	// Removed command versions are removed
+	c.Del("hello")
+	for _, test := range []struct{ line, accept string }{
+		{"v1:hello/bob", ""}, {"v3:hello/bob", ""}, {"hello/bob", "2.0"},
+	} {
+		if res := exec(test.line, test.accept); !strings.Contains(res, "not found") {
+			t.Errorf("removed %s (%s) is executed: %s", test.line, test.accept, res)
+		}
+	}
+	if versions := c.Versions("hello"); versions != nil {
+		t.Errorf("removed command has versions: %v", versions)
+	}
+
+	// Drained and replaced commands versions are removed
+	h := func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+		return []byte("bye"), nil
+	}
+	for _, version := range []string{"1", "2"} {
+		if err := c.AddVersion(CommandSpec{Cmd: "bye", ProcessIn: WS,
+			Version: version, Handler: h}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.DelDrain(context.Background(), "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if res := exec("v1:bye", ""); !strings.Contains(res, "not found") {
+		t.Errorf("drained command version is executed: %s", res)
+	}
+	c.AddVersion(CommandSpec{Cmd: "bye", ProcessIn: WS, Version: "1",
+		Handler: h})
+	if err := c.Replace("bye", "", WS, "", "", "", "", func(cmd *CommandData,
+		processIn ProcessIn, data any) ([]byte, error) {
+		return []byte("replaced"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if res := exec("v1:bye", ""); res != "replaced" || c.Versions("bye") != nil {
+		t.Errorf("replaced command version is executed: %s", res)
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+
+	c := New()
+	c.Add("hello", "", HTTP, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte("Hello!"), nil
+		},
+	)
+	c.Exec("hello", HTTP, &DefaultRequest{})
+
+	var buf bytes.Buffer
+	if err := c.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE command_calls_total counter\n",
+		`command_calls_total{command="hello"} 1` + "\n",
+		`command_errors_total{command="hello"} 0` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("metrics %q does not contain %q", buf.String(), want)
+		}
+	}
+
+	// Pushgateway and remote-write receivers
+	type pushed struct {
+		method, contentType string
+		body                []byte
+	}
+	ch := make(chan pushed, 2)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			ch <- pushed{r.Method, r.Header.Get("Content-Type"), body}
+		},
+	))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := c.PushMetrics(ctx, MetricsPush{URL: srv.URL + "/metrics/job/test"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("wrong push error: %v", err)
+	}
+	p := <-ch
+	if p.method != http.MethodPut || p.contentType != MetricsContentType ||
+		!bytes.Equal(p.body, buf.Bytes()) {
+		t.Fatalf("wrong pushgateway push: %s %s %q", p.method, p.contentType, p.body)
+	}
+
+	c.PushMetrics(ctx, MetricsPush{URL: srv.URL + "/api/v1/write",
+		RemoteWrite: true, Labels: map[string]string{"job": "test"}})
+	p = <-ch
+	if p.method != http.MethodPost || p.contentType != "application/x-protobuf" {
+		t.Fatalf("wrong remote-write push: %s %s", p.method, p.contentType)
+	}
+	// Literal only snappy block: length, literal tag and protobuf
+	req := c.writeRequest(map[string]string{"job": "test"}, time.Now())
+	if len(p.body) < len(req) || !bytes.Contains(p.body, []byte("command_in_flight")) ||
+		!bytes.Contains(req, []byte("\x03job\x12\x04test")) {
+		t.Fatalf("wrong remote-write body: %q", p.body)
+	}
+	long := bytes.Repeat([]byte("x"), 70000)
+	enc := snappyEncode(long)
+	if len(enc) != 3+3+65536+3+(70000-65536) {
+		t.Fatalf("wrong snappy encoding length: %d", len(enc))
+	}
+}
+
+func TestSpillBuffer(t *testing.T) {
+
+	dir := t.TempDir()
+	files := func() int {
+		entries, _ := os.ReadDir(dir)
+		return len(entries)
+	}
+
+	// Small data is kept in memory
+	b := &SpillBuffer{Threshold: 10, Dir: dir}
+	b.Write([]byte("0123456789"))
+	if b.Spilled() || files() != 0 {
+		t.Fatal("data under threshold spilled")
+	}
+
+	// Large data is spilled to temporary file
+	b.Write([]byte("abcdefghij"))
+	b.Write([]byte("klmno"))
+	if !b.Spilled() || b.Size() != 25 || files() != 1 {
+		t.Fatalf("data over threshold is not spilled: %d", b.Size())
+	}
+	r, err := b.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	if string(data) != "0123456789abcdefghijklmno" {
+		t.Fatalf("wrong spilled data: %s", data)
+	}
+	r.Close()
+	if files() != 0 {
+		t.Fatal("temporary file is not removed")
+	}
+
+	// Uploads
+	b, err = SpillData(&DefaultRequest{Data: bytes.Repeat([]byte("x"), 100)}, 50, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.Spilled() || b.Size() != 100 {
+		t.Fatalf("upload is not spilled: %d", b.Size())
+	}
+	b.Close()
+	if files() != 0 {
+		t.Fatal("temporary file is not removed")
+	}
+
+	// Streaming is not supported by DefaultRequest
+	c := New()
+	if err := c.StreamResponse(&DefaultRequest{}, io.NopCloser(nil)); !errors.Is(
+		err, ErrStreamNotSupported) {
+		t.Fatalf("wrong stream error: %v", err)
+	}
+	req := NewHTTPRequest(nil, nil)
+	rc := io.NopCloser(strings.NewReader("large"))
+	if err := c.StreamResponse(req, rc); err != nil || req.ResponseReader() != rc {
+		t.Fatalf("response reader is not set: %v", err)
+	}
+}
+
+func TestTransforms(t *testing.T) {
+
+	c := New()
+	c.Add("user", "", HTTP, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte(`{"name":"John","password":"secret","keys":[{"token":"t"}]}`), nil
+		},
+	)
+	c.Add("hello", "", HTTP, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte("Hello!"), nil
+		},
+	)
+	c.Add("fail", "", HTTP, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return nil, fmt.Errorf("failed")
+		},
+	)
+
+	// Command transformation
+	cmd, _ := c.Get("user")
+	cmd.Transforms = []ResponseTransform{RedactFields("password", "token")}
+	res, err := c.Exec("user", HTTP, &DefaultRequest{})
+	if err != nil || string(res) !=
+		`{"keys":[{"token":"[REDACTED]"}],"name":"John","password":"[REDACTED]"}` {
+		t.Fatalf("wrong redacted result: %s %v", res, err)
+	}
+
+	// Global envelope after command transformations
+	c.AddTransform(EnvelopeResult(nil))
+	for name, want := range map[string]string{
+		"user":  `{"data":{"keys":[{"token":"[REDACTED]"}],"name":"John","password":"[REDACTED]"},"meta":{"command":"user"}}`,
+		"hello": `{"data":"Hello!","meta":{"command":"hello"}}`,
+		"fail":  `{"error":"failed","meta":{"command":"fail"}}`,
+	} {
+		res, err := c.Exec(name, HTTP, &DefaultRequest{})
+		if err != nil || string(res) != want {
+			t.Errorf("wrong %s envelope: %s %v", name, res, err)
+		}
+	}
+
+	// Not found commands are not transformed
+	if _, err := c.Exec("unknown", HTTP, &DefaultRequest{}); err == nil {
+		t.Error("unknown command executed")
+	}
+}
+
+func TestErrorRedaction(t *testing.T) {
+
+	sqlErr := fmt.Errorf("pq: relation \"users\" does not exist")
+	c := New()
+	for name, err := range map[string]error{
+		"sql":    sqlErr,
+		"public": PublicError(fmt.Errorf("name is required")),
+		"quota":  fmt.Errorf("user: %w", ErrQuotaExceeded),
+	} {
+		c.Add(name, "", HTTP, "", "", "", "",
+			func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+				return nil, err
+			},
+		)
+	}
+
+	// Errors are not redacted by default
+	if _, err := c.Exec("sql", HTTP, &DefaultRequest{}); err != sqlErr {
+		t.Fatalf("error redacted without policy: %v", err)
+	}
+
+	var incident string
+	var logged error
+	c.SetErrorRedaction(&ErrorRedaction{
+		Log: func(id string, cmd *CommandData, err error) { incident, logged = id, err },
+	})
+	_, err := c.Exec("sql", HTTP, &DefaultRequest{})
+	var redacted *RedactedError
+	if !errors.As(err, &redacted) || redacted.Message != DefaultErrorMessage ||
+		strings.Contains(err.Error(), "relation") || !errors.Is(err, sqlErr) {
+		t.Fatalf("wrong redacted error: %v", err)
+	}
+	if incident == "" || redacted.Incident != incident || logged != sqlErr ||
+		!strings.Contains(err.Error(), incident) {
+		t.Fatalf("wrong incident: %s %s %v", incident, redacted.Incident, logged)
+	}
+
+	// Public and package errors are not redacted
+	if _, err := c.Exec("public", HTTP, &DefaultRequest{}); err.Error() != "name is required" {
+		t.Fatalf("public error redacted: %v", err)
+	}
+	if _, err := c.Exec("quota", HTTP, &DefaultRequest{}); !errors.Is(err, ErrQuotaExceeded) ||
+		err.Error() != "user: quota exceeded" {
+		t.Fatalf("package error redacted: %v", err)
+	}
+
+	// Command policy with errors mapping
+	cmd, _ := c.Get("sql")
+	cmd.ErrorRedaction = &ErrorRedaction{
+		Map: func(cmd *CommandData, err error) (string, bool) {
+			return "storage unavailable", strings.HasPrefix(err.Error(), "pq:")
+		},
+		Log: func(string, *CommandData, error) {},
+	}
+	if _, err := c.Exec("sql", HTTP, &DefaultRequest{}); !errors.As(err, &redacted) ||
+		redacted.Message != "storage unavailable" {
+		t.Fatalf("wrong mapped error: %v", err)
+	}
+}
+
+// testRolesUser is a request user with roles.
+type testRolesUser []string
+
+func (u testRolesUser) Roles() []string { return u }
+
+func TestRedactByRoles(t *testing.T) {
+
+	c := New()
+	c.Add("employee", "", HTTP, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte(`{"name":"John","salary":1000,"reviews":[` +
+				`{"score":5,"note":"good"}]}`), nil
+		},
+	)
+	cmd, _ := c.Get("employee")
+	cmd.ResponseSchema = `{"type":"object","properties":{
+		"name":{"type":"string"},
+		"salary":{"type":"number","x-roles":["admin","hr"]},
+		"reviews":{"type":"array","items":{"type":"object","properties":{
+			"score":{"type":"number"},
+			"note":{"type":"string","x-roles":["admin"]}}}}}}`
+	cmd.Response = `{"name":"John","salary":1000,"reviews":[{"score":5,"note":"good"}]}`
+	if err := c.Validate().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		user any
+		want string
+	}{
+		{testRolesUser{"admin"}, cmd.Response},
+		{testRolesUser{"hr"}, `{"name":"John","reviews":[{"score":5}],"salary":1000}`},
+		{testRolesUser{"user"}, `{"name":"John","reviews":[{"score":5}]}`},
+		{nil, `{"name":"John","reviews":[{"score":5}]}`},
+	} {
+		res, err := c.Exec("employee", HTTP, &DefaultRequest{User: test.user})
+		if err != nil || string(res) != test.want {
+			t.Errorf("wrong result of %v: %s %v", test.user, res, err)
+		}
+	}
+
+	// Roles function
+	c.SetRolesFunc(func(data any) []string { return []string{"admin"} })
+	res, _ := c.Exec("employee", HTTP, &DefaultRequest{})
+	if !strings.Contains(string(res), `"note":"good"`) {
+		t.Errorf("roles function is not used: %s", res)
+	}
+
+	// Redaction fails closed
+	c.SetRolesFunc(nil)
+	for _, result := range []string{`{"salary":1000} {"salary":2000}`,
+		`{"salary":1000`, `salary`} {
+		if err := c.ReplaceHandler("employee", func(cmd *CommandData,
+			processIn ProcessIn, data any) ([]byte, error) {
+			return []byte(result), nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if res, err := c.Exec("employee", HTTP, &DefaultRequest{}); !errors.Is(err,
+			ErrRolesRedaction) {
+			t.Errorf("wrong %s result: %s, %v", result, res, err)
+		}
+	}
+	for _, schema := range []string{
+		`{"type":"object","properties":{"a":{"$ref":"#/$defs/a"}},` +
+			`"$defs":{"a":{"x-roles":["admin"]}}}`,
+		`{"type":"object","additionalProperties":{"x-roles":["admin"]}}`,
+		`{"oneOf":[{"properties":{"salary":{"x-roles":["admin"]}}}]}`,
+		`{"properties":{"salary":{"x-roles":["admin"]}}`,
+	} {
+		cmd, _ := c.Get("employee")
+		cmd.ResponseSchema = schema
+		cmd.Response = ""
+		if _, err := c.Exec("employee", HTTP, &DefaultRequest{}); !errors.Is(err,
+			ErrRolesRedaction) {
+			t.Errorf("unsupported schema %s is used: %v", schema, err)
+		}
+		if c.Validate().Err() == nil {
+			t.Errorf("unsupported schema %s is valid", schema)
+		}
+	}
+}
+
+func TestTimeCommand(t *testing.T) {
+
+	c := New()
+	c.AddTimeCommand(HTTP)
+
+	get := func() ServerTime {
+		res, err := c.Exec("time", HTTP, &DefaultRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]json.RawMessage
+		if err = json.Unmarshal(res, &fields); err != nil || len(fields) != 2 {
+			t.Fatalf("wrong time format: %s, %v", res, err)
+		}
+		var st ServerTime
+		if err = json.Unmarshal(res, &st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	before := time.Now().UnixNano()
+	first := get()
+	second := get()
+	after := time.Now().UnixNano()
+	if first.Wall < before || second.Wall > after || first.Wall > second.Wall {
+		t.Errorf("wrong wall time: %d, %d", first.Wall, second.Wall)
+	}
+	if first.Mono <= 0 || second.Mono < first.Mono {
+		t.Errorf("wrong monotonic time: %d, %d", first.Mono, second.Mono)
+	}
+}
+
+// legacyRequest implements RequestInterface only.
+type legacyRequest struct{}
+
+func (r *legacyRequest) GetVars() map[string]string { return nil }
+func (r *legacyRequest) GetData() []byte            { return nil }
+
+func TestCommandDoc(t *testing.T) {
+
+	c := New()
+	c.AddCommandsList(HTTP)
+	c.AddBatch([]CommandSpec{{
+		Cmd: "hello", ProcessIn: HTTP, Params: "{name}", Descr: "say hello",
+		Version: "1.2", Tags: []string{"greeting"},
+		Handler: func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return nil, nil
+		},
+	}})
+
+	res, err := c.Exec("commdoc", HTTP, &DefaultRequest{Vars: map[string]string{"name": "hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"say hello", "v1.2", "greeting", "<td>name</td>"} {
+		if !strings.Contains(string(res), s) {
+			t.Errorf("doc page should contain %s", s)
+		}
+	}
+	if cmd, _ := c.Get("commdoc"); cmd.Route != "commands/{name}" {
+		t.Errorf("wrong doc page route: %s", cmd.Route)
+	}
+	if _, err = c.Exec("commdoc", HTTP, &DefaultRequest{Vars: map[string]string{"name": "wrong"}}); err == nil {
+		t.Error("doc of not existing command should return error")
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+
+	c := New()
+	c.Add("hello", "say hello", HTTP|WS, "{name}", "greeting", "hello/John",
+		"Hello John!", nil)
+	c.Add("version", "get version", HTTP, "", "version", "", "", nil)
+
+	md := string(c.Markdown())
+	for _, s := range []string{
+		"| [hello](#hello) | say hello | http, websocket |",
+		"## version", "- Path: `hello/{name}`", "```\nHello John!\n```",
+	} {
+		if !strings.Contains(md, s) {
+			t.Errorf("markdown should contain %q", s)
+		}
+	}
+	if strings.Index(md, "## hello") > strings.Index(md, "## version") {
+		t.Error("commands should be sorted")
+	}
+}
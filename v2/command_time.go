@@ -0,0 +1,40 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Server time module of Command processing golang package.
+
+package command
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// startTime is a process start time used to get monotonic time.
+var startTime = time.Now()
+
+// ServerTime is a server time returned by time command.
+type ServerTime struct {
+	Wall int64 `json:"wall"` // Wall clock time, unix nanoseconds
+	Mono int64 `json:"mono"` // Monotonic time since server start, nanoseconds
+}
+
+// Now returns current server time.
+func Now() ServerTime {
+	now := time.Now()
+	return ServerTime{Wall: now.UnixNano(), Mono: int64(now.Sub(startTime))}
+}
+
+// AddTimeCommand adds time command to commands map. The time command returns
+// server wall clock and monotonic time in json format. Clients use it to
+// estimate clock offset, see the client package, and order subscription
+// events by server time.
+func (c *Commands) AddTimeCommand(processIn ProcessIn) {
+	c.Add("time", "Get server time.", processIn, "", "json server time", "time",
+		`{"wall":1718000000000000000,"mono":123456789}`,
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			return json.Marshal(Now())
+		},
+	)
+}
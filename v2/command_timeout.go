@@ -0,0 +1,150 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Request timeouts module of Command processing golang package.
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrTimeout is an error returned by Exec when command execution exceeds
+// hard timeout, see SetTimeouts.
+var ErrTimeout = fmt.Errorf("command timeout")
+
+// Timeouts are command execution thresholds. Any threshold is not used if
+// it is 0.
+type Timeouts struct {
+	// Soft is execution time after which slow request is reported, see
+	// SetSlowHandler. The command execution is not interrupted.
+	Soft time.Duration
+
+	// Hard is execution time after which request context is canceled and
+	// Exec returns ErrTimeout. The handler should stop when context of
+	// its request is done. The request context is not canceled if input
+	// data has no context, like nil data, but Exec returns ErrTimeout
+	// anyway. The command execution is counted in flight, see
+	// CommandData.MaxConcurrency and DelDrain, until the handler returns.
+	Hard time.Duration
+}
+
+// SlowRequest is passed to slow request handler when command execution
+// exceeds soft timeout.
+type SlowRequest struct {
+	Command   string            // Command name
+	ProcessIn ProcessIn         // Input processing type
+	RequestID string            // Request ID, see RequestIDKey
+	Vars      map[string]string // Request variables
+	Elapsed   time.Duration     // Execution time
+}
+
+// SlowHandler is a function called when command execution exceeds soft
+// timeout.
+type SlowHandler func(r SlowRequest)
+
+// SetTimeouts sets default execution thresholds of commands. The
+// CommandData Timeouts field overrides them for the command.
+func (c *Commands) SetTimeouts(timeouts Timeouts) {
+	c.Lock()
+	defer c.Unlock()
+	c.timeouts = timeouts
+}
+
+// SetSlowHandler sets function called when command execution exceeds soft
+// timeout. Slow requests are logged if handler is nil.
+func (c *Commands) SetSlowHandler(handler SlowHandler) {
+	c.Lock()
+	defer c.Unlock()
+	c.slowHandler = handler
+}
+
+// execTimeout executes command handler with command thresholds and panics
+// recovery, see SetCrashReports. It releases execution of command with full
+// path acquired by acquire when the handler returns.
+func (c *Commands) execTimeout(cmd *CommandData, path string,
+	processIn ProcessIn, data any) ([]byte, error) {
+
+	handler := c.recovered(cmd.handler(processIn))
+	c.RLock()
+	timeouts, slowHandler := c.timeouts, c.slowHandler
+	c.RUnlock()
+	if cmd.Timeouts != nil {
+		timeouts = *cmd.Timeouts
+	}
+
+	// Report slow request when soft timeout is exceeded
+	if timeouts.Soft > 0 {
+		r := SlowRequest{Command: cmd.Cmd, ProcessIn: processIn,
+			Elapsed: timeouts.Soft}
+		r.Vars, _ = c.Vars(data)
+		if v := requestValues(data); v != nil {
+			r.RequestID, _ = Get(v, RequestIDKey)
+		}
+		timer := time.AfterFunc(timeouts.Soft, func() {
+			if slowHandler == nil {
+				log.Printf("warning: command '%s' is slow, exceeds %s, "+
+					"request id '%s', vars %v", r.Command, r.Elapsed,
+					r.RequestID, r.Vars)
+				return
+			}
+			slowHandler(r)
+		})
+		defer timer.Stop()
+	}
+
+	if timeouts.Hard <= 0 {
+		defer c.release(path)
+		res, err := handler(cmd, processIn, data)
+		if err != nil {
+			closeStream(data)
+		}
+		return res, err
+	}
+
+	// Cancel request context when hard timeout is exceeded, the timeout
+	// context is not passed to handler if data has no context
+	ctx := context.Background()
+	r, ok := data.(interface {
+		contextSetter
+		GetContext() context.Context
+	})
+	if ok {
+		ctx = r.GetContext()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Hard)
+	defer cancel()
+	if ok {
+		r.SetContext(ctx)
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	Go(GoHandler, func() {
+		data, err := handler(cmd, processIn, data)
+		c.release(path)
+		done <- result{data, err}
+	})
+	select {
+	case res := <-done:
+		if res.err != nil {
+			closeStream(data)
+		}
+		return res.data, res.err
+	case <-ctx.Done():
+		// Close response streamed by timed out handler when it returns
+		Go(GoHandler, func() {
+			<-done
+			closeStream(data)
+		})
+		return nil, fmt.Errorf("command '%s' exceeds %s: %w", cmd.Cmd,
+			timeouts.Hard, ErrTimeout)
+	}
+}
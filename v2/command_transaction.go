@@ -0,0 +1,125 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Transactions module of Command processing golang package.
+
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Compensation is a callback which reverts changes made by a command in a
+// failed transaction.
+type Compensation func(ctx context.Context) error
+
+// Transaction collects compensation callbacks of commands executed in
+// transactional pipeline.
+type Transaction struct {
+	compensations []Compensation
+	mut           sync.Mutex
+}
+
+// txKey is a context key of Transaction.
+type txKey struct{}
+
+// TransactionFrom returns transaction of the request context or nil if the
+// command is not executed in transaction.
+func TransactionFrom(ctx context.Context) *Transaction {
+	if ctx == nil {
+		return nil
+	}
+	tx, _ := ctx.Value(txKey{}).(*Transaction)
+	return tx
+}
+
+// Compensate registers compensation callback. Compensations run in reverse
+// order if a later command of transaction fails. It does nothing if tx is
+// nil, so handlers may call it without checking the transaction.
+func (tx *Transaction) Compensate(f Compensation) {
+	if tx == nil || f == nil {
+		return
+	}
+	tx.mut.Lock()
+	defer tx.mut.Unlock()
+	tx.compensations = append(tx.compensations, f)
+}
+
+// rollback runs compensations in reverse order and returns their joined
+// errors. Every compensation runs once even if some of them fail.
+func (tx *Transaction) rollback(ctx context.Context) error {
+	tx.mut.Lock()
+	compensations := tx.compensations
+	tx.compensations = nil
+	tx.mut.Unlock()
+
+	var errs []error
+	for i := len(compensations) - 1; i >= 0; i-- {
+		if err := compensations[i](ctx); err != nil {
+			errs = append(errs, fmt.Errorf("compensation %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Transaction executes pipeline steps in transaction: handlers register
+// compensation callbacks with TransactionFrom(ctx).Compensate, and if the
+// pipeline fails they are called in reverse order. This gives best-effort
+// atomicity for multi-command mutations. The returned error contains the
+// pipeline error and compensation errors.
+func (c *Commands) Transaction(steps []PipelineStep, processIn ProcessIn,
+	r RequestInterfaceV2, maxSize int) ([]byte, error) {
+
+	ctx := context.Background()
+	if r != nil && r.GetContext() != nil {
+		ctx = r.GetContext()
+	}
+	tx := &Transaction{}
+	txReq := &txRequest{r, context.WithValue(ctx, txKey{}, tx)}
+	if r == nil {
+		txReq.RequestInterfaceV2 = &DefaultRequest{}
+	}
+
+	data, err := c.Pipeline(steps, processIn, txReq, maxSize)
+	if err != nil {
+		// Compensations should run even if the request was canceled
+		return nil, errors.Join(err, tx.rollback(context.WithoutCancel(ctx)))
+	}
+	return data, nil
+}
+
+// AddTransactionCommand adds transaction command to commands map. The
+// transaction command gets pipeline spec in request data, see ParsePipeline,
+// and executes it in transaction, see Transaction.
+func (c *Commands) AddTransactionCommand(processIn ProcessIn) {
+	c.Add("transaction", "Execute commands pipeline in transaction.",
+		processIn, "", "result of the last transaction command",
+		"accounts/withdraw/1/10 | accounts/deposit/2/10", "",
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			req, err := c.Request(indata)
+			if err != nil {
+				return nil, err
+			}
+			steps, err := c.ParsePipeline(req.GetData())
+			if err != nil {
+				return nil, err
+			}
+			return c.Transaction(steps, processIn, &pipelineRequest{req}, 0)
+		},
+	)
+}
+
+// txRequest is a request with transaction context.
+type txRequest struct {
+	RequestInterfaceV2
+	ctx context.Context
+}
+
+// GetContext returns transaction context.
+func (r *txRequest) GetContext() context.Context {
+	return r.ctx
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Response transformations module of Command processing golang package.
+
+package command
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// RedactedValue replaces values of fields redacted by RedactFields.
+const RedactedValue = "[REDACTED]"
+
+// ResponseTransform is a command result post-processing function, like
+// wrapping into envelope, fields redaction or format conversion. It gets
+// command result and error and returns transformed result and error.
+type ResponseTransform func(cmd *CommandData, data any, res []byte, err error) (
+	[]byte, error)
+
+// AddTransform adds global result transformation applied by Exec to results
+// of all commands after command transformations, see CommandData
+// Transforms. Transformations are applied in order of adding.
+func (c *Commands) AddTransform(t ResponseTransform) {
+	c.Lock()
+	defer c.Unlock()
+	c.transforms = append(c.transforms, t)
+}
+
+// transform applies command and global transformations to command result.
+func (c *Commands) transform(cmd *CommandData, data any, res []byte,
+	err error) ([]byte, error) {
+
+	c.RLock()
+	global := c.transforms
+	c.RUnlock()
+	for _, t := range slices.Concat(cmd.Transforms, global) {
+		res, err = t(cmd, data, res, err)
+	}
+	return res, err
+}
+
+// ResultEnvelope is a standard result envelope of EnvelopeResult
+// transformation.
+type ResultEnvelope struct {
+	Data  json.RawMessage `json:"data,omitempty"`  // Json result or result string
+	Error string          `json:"error,omitempty"` // Error message
+	Meta  map[string]any  `json:"meta,omitempty"`  // Result metadata
+}
+
+// EnvelopeResult returns transformation which wraps command results and
+// errors into ResultEnvelope {data, error, meta} in json format. Json
+// results are embedded as is and other results as strings. The meta
+// function returns result metadata and may be nil, then meta contains
+// command name. Errors are wrapped into envelope and returned as successful
+// results.
+func EnvelopeResult(meta func(cmd *CommandData, data any) map[string]any) ResponseTransform {
+	return func(cmd *CommandData, data any, res []byte, err error) (
+		[]byte, error) {
+
+		var msg ResultEnvelope
+		switch {
+		case err != nil:
+			msg.Error = err.Error()
+		case json.Valid(res):
+			msg.Data = res
+		case len(res) > 0:
+			msg.Data, _ = json.Marshal(string(res))
+		}
+		if meta != nil {
+			msg.Meta = meta(cmd, data)
+		} else {
+			msg.Meta = map[string]any{"command": cmd.Cmd}
+		}
+		return json.Marshal(msg)
+	}
+}
+
+// RedactFields returns transformation which replaces values of json result
+// object fields with names in fields by RedactedValue at any depth. Not json
+// results and errors are returned as is.
+func RedactFields(fields ...string) ResponseTransform {
+	return func(cmd *CommandData, data any, res []byte, err error) (
+		[]byte, error) {
+
+		if err != nil || !json.Valid(res) {
+			return res, err
+		}
+		var v any
+		if json.Unmarshal(res, &v) != nil {
+			return res, err
+		}
+		if !redact(v, fields) {
+			return res, nil
+		}
+		return json.Marshal(v)
+	}
+}
+
+// redact replaces values of object fields in v and returns true if any
+// field was redacted.
+func redact(v any, fields []string) (redacted bool) {
+	switch x := v.(type) {
+	case map[string]any:
+		for k, value := range x {
+			if slices.Contains(fields, k) {
+				x[k], redacted = RedactedValue, true
+				continue
+			}
+			redacted = redact(value, fields) || redacted
+		}
+	case []any:
+		for _, value := range x {
+			redacted = redact(value, fields) || redacted
+		}
+	}
+	return
+}
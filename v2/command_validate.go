@@ -0,0 +1,187 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Commands validation module of Command processing golang package.
+
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrValidation is an error returned by ValidationReport Err method when
+// commands have problems.
+var ErrValidation = fmt.Errorf("commands validation failed")
+
+// ValidationProblem is a problem of registered command found by Validate.
+type ValidationProblem struct {
+	Command string // Command name, "parent/child" for sub-commands
+	Field   string // CommandData field name
+	Problem string // Problem description
+}
+
+// String returns description of validation problem.
+func (p ValidationProblem) String() string {
+	return fmt.Sprintf("command '%s' %s: %s", p.Command, p.Field, p.Problem)
+}
+
+// ValidationReport is a list of commands problems found by Validate.
+type ValidationReport []ValidationProblem
+
+// Err returns ErrValidation joined with descriptions of problems or nil if
+// report is empty.
+func (r ValidationReport) Err() error {
+	var errs []error
+	for _, p := range r {
+		errs = append(errs, fmt.Errorf("%s: %w", p, ErrValidation))
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks registered commands and sub-commands and returns report of
+// their problems: commands without handler or processing types, malformed
+// parameters placeholders, invalid json schemas, response schemas which
+// can't be used by roles redaction, examples not matching schemas,
+// deprecated commands without existing replacement and routes conflicts. It
+// may be called at startup to fail fast:
+//
+//	if err := c.Validate().Err(); err != nil {
+//		log.Fatal(err)
+//	}
+func (c *Commands) Validate() (report ValidationReport) {
+	c.validate("", c, &report)
+	for _, rc := range c.RouteConflicts(All) {
+		report = append(report, ValidationProblem{rc.Route, "Params", rc.String()})
+	}
+	return
+}
+
+// validate checks commands of group parent.
+func (c *Commands) validate(parent string, root *Commands,
+	report *ValidationReport) {
+
+	for name, cmd := range c.IterSorted() {
+		if parent != "" {
+			name = parent + "/" + name
+		}
+		add := func(field, format string, args ...any) {
+			*report = append(*report, ValidationProblem{name, field,
+				fmt.Sprintf(format, args...)})
+		}
+
+		if cmd.Handler == nil && len(cmd.Handlers) == 0 {
+			add("Handler", "command has no handler")
+		}
+		if cmd.ProcessIn == 0 {
+			add("ProcessIn", "command has no processing types")
+		}
+		if err := checkParams(cmd.Params); err != nil {
+			add("Params", "%s", err)
+		}
+		validateExample(add, "Request", cmd.Request, cmd.RequestSchema)
+		validateExample(add, "Response", cmd.Response, cmd.ResponseSchema)
+		if err := parseRolesSchema(cmd.ResponseSchema).err; err != nil {
+			add("ResponseSchema", "%s", err)
+		}
+		if cmd.Deprecated {
+			if cmd.ReplacedBy == "" {
+				add("ReplacedBy", "deprecated command has no replacement")
+			} else if _, ok := root.Get(cmd.ReplacedBy); !ok {
+				add("ReplacedBy", "replacement '%s' not found", cmd.ReplacedBy)
+			}
+		}
+
+		if cmd.Sub != nil {
+			cmd.Sub.validate(name, root, report)
+		}
+	}
+}
+
+// validateExample checks that json schema is valid and json example matches
+// it. Examples which are not json, like command lines, are not checked.
+func validateExample(add func(field, format string, args ...any),
+	field, example, schema string) {
+
+	if schema == "" {
+		return
+	}
+	var s map[string]any
+	if err := json.Unmarshal([]byte(schema), &s); err != nil {
+		add(field+"Schema", "invalid json schema: %s", err)
+		return
+	}
+	var v any
+	if example == "" || json.Unmarshal([]byte(example), &v) != nil {
+		return
+	}
+	if err := matchSchema(s, v, "$"); err != nil {
+		add(field, "example does not match schema: %s", err)
+	}
+}
+
+// matchSchema checks json value v against subset of json schema: type, enum,
+// properties, required and items keywords.
+func matchSchema(schema map[string]any, v any, path string) error {
+	if typ, ok := schema["type"].(string); ok && !matchType(typ, v) {
+		return fmt.Errorf("%s is not %s", path, typ)
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := slices.ContainsFunc(enum, func(e any) bool {
+			return fmt.Sprint(e) == fmt.Sprint(v)
+		})
+		if !found {
+			return fmt.Errorf("%s is not in enum", path)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := v[fmt.Sprint(name)]; !ok {
+				return fmt.Errorf("%s.%v is required", path, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for name, value := range v {
+			if s, ok := properties[name].(map[string]any); ok {
+				if err := matchSchema(s, value, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, value := range v {
+				err := matchSchema(items, value, fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// matchType returns true if json value v has json schema type typ.
+func matchType(typ string, v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case float64:
+		return typ == "number" || typ == "integer" && v == float64(int64(v))
+	case string:
+		return typ == "string"
+	case []any:
+		return typ == "array"
+	case map[string]any:
+		return typ == "object"
+	}
+	return false
+}
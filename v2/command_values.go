@@ -0,0 +1,158 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Execution values module of Command processing golang package.
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrValues is an error returned by SetRequestValue when request context
+// can't be replaced to store execution values.
+var ErrValues = fmt.Errorf("request can't store execution values")
+
+// Key is a typed key of execution value. Keys of different types never
+// collide even if they have the same name.
+type Key[T any] struct{ name string }
+
+// NewKey creates new execution value key of type T.
+func NewKey[T any](name string) Key[T] { return Key[T]{name} }
+
+// String returns key name.
+func (k Key[T]) String() string { return k.name }
+
+// Standard execution value keys. Exec sets user, request ID and locale from
+// request if they are not set by middleware.
+var (
+	UserKey      = NewKey[string]("user")       // User name
+	TenantKey    = NewKey[string]("tenant")     // Tenant
+	LocaleKey    = NewKey[string]("locale")     // Client locale, like "en-US"
+	RequestIDKey = NewKey[string]("request_id") // Request ID
+)
+
+// Values is a per-execution bag of typed values, like user, tenant, locale
+// and request ID, populated by middleware and read by handlers.
+type Values struct {
+	m map[any]any
+	*sync.RWMutex
+}
+
+// valuesKey is a context key of execution values.
+type valuesKey struct{}
+
+// NewValues creates new empty execution values bag.
+func NewValues() *Values {
+	return &Values{m: make(map[any]any), RWMutex: new(sync.RWMutex)}
+}
+
+// WithValues returns copy of ctx with execution values v.
+func WithValues(ctx context.Context, v *Values) context.Context {
+	return context.WithValue(ctx, valuesKey{}, v)
+}
+
+// ValuesFrom returns execution values of ctx or nil.
+func ValuesFrom(ctx context.Context) *Values {
+	v, _ := ctx.Value(valuesKey{}).(*Values)
+	return v
+}
+
+// Set sets value of key to execution values v.
+func Set[T any](v *Values, key Key[T], value T) {
+	v.Lock()
+	defer v.Unlock()
+	v.m[key] = value
+}
+
+// Get returns value of key from execution values v. It returns false if the
+// value is not set or v is nil.
+func Get[T any](v *Values, key Key[T]) (value T, ok bool) {
+	if v == nil {
+		return
+	}
+	v.RLock()
+	defer v.RUnlock()
+	value, ok = v.m[key].(T)
+	return
+}
+
+// Value returns execution value of key from ctx. Handlers read execution
+// values from request context:
+//
+//	req, _ := c.Request(data)
+//	tenant, _ := command.Value(req.GetContext(), command.TenantKey)
+func Value[T any](ctx context.Context, key Key[T]) (T, bool) {
+	return Get(ValuesFrom(ctx), key)
+}
+
+// SetRequestValue sets execution value of key to request input data. The
+// execution values bag is added to request context if it has not one yet.
+// It is used by middleware, like server hooks, before command execution.
+func SetRequestValue[T any](data any, key Key[T], value T) error {
+	v := requestValues(data)
+	if v == nil {
+		return ErrValues
+	}
+	Set(v, key, value)
+	return nil
+}
+
+// requestValues returns execution values of request input data and adds new
+// execution values to request context if it has not one. It returns nil if
+// the request context can't be replaced.
+func requestValues(data any) *Values {
+	r, ok := data.(interface {
+		contextSetter
+		GetContext() context.Context
+	})
+	if !ok {
+		return nil
+	}
+	ctx := r.GetContext()
+	if v := ValuesFrom(ctx); v != nil {
+		return v
+	}
+	v := NewValues()
+	r.SetContext(WithValues(ctx, v))
+	return v
+}
+
+// injectValues adds execution values to context of request input data and
+// sets standard values not set by middleware.
+func (c *Commands) injectValues(data any) {
+	v := requestValues(data)
+	if v == nil {
+		return
+	}
+	req, ok := data.(RequestInterfaceV2)
+	if !ok {
+		return
+	}
+
+	v.Lock()
+	defer v.Unlock()
+	setDefault := func(key Key[string], value string) {
+		if _, ok := v.m[key]; !ok && value != "" {
+			v.m[key] = value
+		}
+	}
+
+	switch user := req.GetUser().(type) {
+	case string:
+		setDefault(UserKey, user)
+	case fmt.Stringer:
+		setDefault(UserKey, user.String())
+	}
+	if info, ok := CallInfoFrom(req.GetContext()); ok {
+		setDefault(RequestIDKey, info.RequestID)
+	}
+	setDefault(RequestIDKey, req.GetHeader("X-Request-ID"))
+	locale, _, _ := strings.Cut(req.GetHeader("Accept-Language"), ",")
+	locale, _, _ = strings.Cut(locale, ";")
+	setDefault(LocaleKey, strings.TrimSpace(locale))
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Transport handler variants module of Command processing golang package.
+
+package command
+
+import "fmt"
+
+// SetHandler sets handler variant of command for transports processIn, for
+// example HTML output for HTTP and JSON for websocket. Exec selects handler
+// variant by its processIn and uses the command Handler if the transport has
+// no variant. The nil handler removes variants of processIn.
+func (c *Commands) SetHandler(name string, processIn ProcessIn,
+	handler CommandHandler) error {
+
+	c.Lock()
+	defer c.Unlock()
+
+	key := c.key(name)
+	cmd, ok := c.m[key]
+	if !ok {
+		return fmt.Errorf("command '%s': %w", name, ErrCommandNotFound)
+	}
+
+	// Copy command, so executed commands are not changed
+	changed := *cmd
+	changed.Handlers = make(map[ProcessIn]CommandHandler, len(cmd.Handlers)+1)
+	for pi, h := range cmd.Handlers {
+		changed.Handlers[pi] = h
+	}
+	for pi := ProcessIn(1); pi != 0; pi <<= 1 {
+		if processIn&pi == 0 {
+			continue
+		}
+		if handler == nil {
+			delete(changed.Handlers, pi)
+		} else {
+			changed.Handlers[pi] = handler
+		}
+	}
+	if handler != nil {
+		changed.ProcessIn |= processIn
+	}
+	c.m[key] = &changed
+	c.replaceVersion(key, cmd, &changed)
+	c.refreeze()
+
+	return nil
+}
+
+// handler returns command handler of transport processIn: the handler
+// variant, the command Handler or variant of any of transports processIn. It
+// returns nil if the command has no such handler.
+func (cmd *CommandData) handler(processIn ProcessIn) CommandHandler {
+	if h, ok := cmd.Handlers[processIn]; ok {
+		return h
+	}
+	if cmd.Handler != nil {
+		return cmd.Handler
+	}
+	for pi, h := range cmd.Handlers {
+		if pi&processIn != 0 {
+			return h
+		}
+	}
+	return nil
+}
+
+// HasHandler returns true if command has handler of any of transports
+// processIn: the command Handler or handler variant, see SetHandler.
+func (cmd *CommandData) HasHandler(processIn ProcessIn) bool {
+	return cmd.handler(processIn) != nil
+}
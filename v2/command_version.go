@@ -0,0 +1,171 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Commands versions module of Command processing golang package.
+
+package command
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// AcceptVersionHeader is a request header with requested command version.
+const AcceptVersionHeader = "Accept-Version"
+
+// ErrInvalidVersion is an error returned when command version is not valid.
+var ErrInvalidVersion = fmt.Errorf("invalid version")
+
+// version is a parsed command version: major, minor and patch numbers.
+type version [3]int
+
+// parseVersion parses version like "2", "v2", "2.1" or "v2.1.3".
+func parseVersion(s string) (v version, err error) {
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) > len(v) {
+		return v, fmt.Errorf("'%s': %w", s, ErrInvalidVersion)
+	}
+	for i, part := range parts {
+		if v[i], err = strconv.Atoi(part); err != nil || v[i] < 0 {
+			return v, fmt.Errorf("'%s': %w", s, ErrInvalidVersion)
+		}
+	}
+	return
+}
+
+// compare compares versions.
+func (v version) compare(other version) int {
+	return slices.Compare(v[:], other[:])
+}
+
+// versioned is a registered version of command.
+type versioned struct {
+	v   version
+	cmd *CommandData
+}
+
+// AddVersion adds version of command described by spec, its Version field
+// should be set, like "2" or "2.1". Commands may have several versions: the
+// highest one is used by Get and Exec and listed in documentation, other
+// versions are executed when requested by "v2:name" command name prefix or
+// AcceptVersionHeader request header. The highest registered version
+// compatible with requested one is executed: version with the same major
+// number and not lower minor and patch numbers. Versions of sub-commands are
+// not supported.
+func (c *Commands) AddVersion(spec CommandSpec) error {
+	v, err := parseVersion(spec.Version)
+	if err != nil {
+		return fmt.Errorf("command '%s': %w", spec.Cmd, err)
+	}
+	if err = c.CheckName(spec.Cmd); err != nil {
+		return err
+	}
+	if err = checkParams(spec.Params); err != nil {
+		return fmt.Errorf("command '%s': %w", spec.Cmd, err)
+	}
+	cmd := CommandData(spec)
+
+	c.Lock()
+	defer c.Unlock()
+
+	key := c.key(cmd.Cmd)
+	if c.versions == nil {
+		c.versions = make(map[string][]versioned)
+	}
+	list := slices.DeleteFunc(c.versions[key], func(vd versioned) bool {
+		return vd.v == v
+	})
+	list = append(list, versioned{v, &cmd})
+	slices.SortFunc(list, func(a, b versioned) int { return a.v.compare(b.v) })
+	c.versions[key] = list
+	c.m[key] = list[len(list)-1].cmd
+	c.refreeze()
+
+	return nil
+}
+
+// replaceVersion replaces changed version old of command with key by cmd.
+// It should be called under the Commands lock.
+func (c *Commands) replaceVersion(key string, old, cmd *CommandData) {
+	list := c.versions[key]
+	i := slices.IndexFunc(list, func(vd versioned) bool { return vd.cmd == old })
+	if i < 0 {
+		return
+	}
+	list = slices.Clone(list)
+	list[i].cmd = cmd
+	c.versions[key] = list
+}
+
+// Versions returns sorted versions of command added by AddVersion.
+func (c *Commands) Versions(name string) (versions []string) {
+	c.RLock()
+	defer c.RUnlock()
+	for _, vd := range c.versions[c.key(name)] {
+		versions = append(versions, vd.cmd.Version)
+	}
+	return
+}
+
+// GetVersion returns the highest version of command compatible with
+// requested version, see AddVersion. Command without versions added by
+// AddVersion is returned if its Version is empty or compatible. It returns
+// command like Get if requested version is empty.
+func (c *Commands) GetVersion(name, requested string) (cmd *CommandData,
+	ok bool) {
+
+	if requested == "" {
+		return c.Get(name)
+	}
+	r, err := parseVersion(requested)
+	if err != nil {
+		return nil, false
+	}
+	compatible := func(v version) bool {
+		return v[0] == r[0] && v.compare(r) >= 0
+	}
+
+	c.RLock()
+	list := c.versions[c.key(name)]
+	c.RUnlock()
+	if len(list) == 0 {
+		if cmd, ok = c.Get(name); !ok || cmd.Version == "" {
+			return
+		}
+		if v, err := parseVersion(cmd.Version); err != nil || !compatible(v) {
+			return nil, false
+		}
+		return
+	}
+	for i := len(list) - 1; i >= 0; i-- {
+		if compatible(list[i].v) {
+			return list[i].cmd, true
+		}
+	}
+	return nil, false
+}
+
+// splitVersion splits command name with version prefix, like "v2:name", to
+// version and name. The version is empty if name has no prefix.
+func splitVersion(name string) (version, base string) {
+	prefix, base, ok := strings.Cut(name, ":")
+	if !ok || !strings.HasPrefix(prefix, "v") {
+		return "", name
+	}
+	return prefix, base
+}
+
+// resolve returns command by name with optional version prefix or version
+// requested by AcceptVersionHeader header of request data.
+func (c *Commands) resolve(name string, data any) (cmd *CommandData, ok bool) {
+	version, name := splitVersion(name)
+	if version == "" {
+		if req, isReq := data.(RequestInterfaceV2); isReq {
+			version = req.GetHeader(AcceptVersionHeader)
+		}
+	}
+	return c.GetVersion(name, version)
+}
@@ -0,0 +1,309 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package connect is a Connect protocol adapter of the Command processing
+// package registry.
+//
+// The Handler serves CommandService with two procedures: unary Execute,
+// which executes command, and server streaming Subscribe, which subscribes
+// the stream to command with the command Subscription engine and streams its
+// pushes. Browser and backend clients generated by Connect tools from the
+// service definition below call them over HTTP/1.1 or HTTP/2:
+//
+//	syntax = "proto3";
+//	package command.v1;
+//
+//	service CommandService {
+//	  rpc Execute(ExecuteRequest) returns (ExecuteResponse);
+//	  rpc Subscribe(SubscribeRequest) returns (stream SubscribeResponse);
+//	}
+//	message ExecuteRequest {
+//	  string command = 1;
+//	  map<string, string> vars = 2;
+//	  bytes data = 3;
+//	}
+//	message ExecuteResponse { bytes data = 1; }
+//	message SubscribeRequest { string command = 1; }
+//	message SubscribeResponse { bytes data = 1; }
+//
+// Only the json codec is supported, clients should use json format. The
+// gRPC-Web protocol and binary protobuf codec are not supported as they need
+// generated protobuf code.
+package connect
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// Procedures paths.
+const (
+	ServiceName   = "command.v1.CommandService"
+	ExecutePath   = "/" + ServiceName + "/Execute"
+	SubscribePath = "/" + ServiceName + "/Subscribe"
+)
+
+// Connect protocol content types.
+const (
+	unaryContentType  = "application/json"
+	streamContentType = "application/connect+json"
+)
+
+// Envelope flags of streaming messages.
+const (
+	flagEndStream = 0x02
+)
+
+// MaxMessageSize is a maximum size of request message.
+const MaxMessageSize = 4 << 20
+
+// ExecuteRequest is a request of Execute procedure.
+type ExecuteRequest struct {
+	Command string            `json:"command"`
+	Vars    map[string]string `json:"vars,omitempty"`
+	Data    []byte            `json:"data,omitempty"`
+}
+
+// ExecuteResponse is a response of Execute procedure.
+type ExecuteResponse struct {
+	Data []byte `json:"data,omitempty"`
+}
+
+// SubscribeRequest is a request of Subscribe procedure.
+type SubscribeRequest struct {
+	Command string `json:"command"`
+}
+
+// SubscribeResponse is a message of Subscribe procedure stream.
+type SubscribeResponse struct {
+	Data []byte `json:"data,omitempty"`
+}
+
+// Error is a Connect protocol error.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// Handler serves CommandService procedures with commands registry.
+type Handler struct {
+	c         *command.Commands
+	s         *command.Subscription
+	processIn command.ProcessIn
+	mux       *http.ServeMux
+}
+
+// New creates Connect handler of commands registry. Commands are executed
+// with processIn input processing type. The subscription s is used by
+// Subscribe procedure which returns unimplemented error if s is nil.
+func New(c *command.Commands, s *command.Subscription,
+	processIn command.ProcessIn) *Handler {
+
+	h := &Handler{c: c, s: s, processIn: processIn, mux: http.NewServeMux()}
+	h.mux.HandleFunc("POST "+ExecutePath, h.execute)
+	h.mux.HandleFunc("POST "+SubscribePath, h.subscribe)
+	return h
+}
+
+// ServeHTTP serves CommandService procedures. Register the handler on
+// "/command.v1.CommandService/" path.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// execute serves unary Execute procedure.
+func (h *Handler) execute(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), unaryContentType) {
+		writeError(w, Error{"invalid_argument", "unsupported content type"})
+		return
+	}
+	var req ExecuteRequest
+	body := io.LimitReader(r.Body, MaxMessageSize)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		writeError(w, Error{"invalid_argument", err.Error()})
+		return
+	}
+	if _, ok := h.c.Get(req.Command); !ok {
+		writeError(w, Error{"not_found",
+			fmt.Sprintf("command '%s' not found", req.Command)})
+		return
+	}
+
+	data, err := h.c.Exec(req.Command, h.processIn, &command.DefaultRequest{
+		Vars: req.Vars, Data: req.Data, RemoteAddr: r.RemoteAddr,
+		Header: r.Header, Ctx: r.Context(),
+	})
+	if err != nil {
+		writeError(w, errorOf(err))
+		return
+	}
+	w.Header().Set("Content-Type", unaryContentType)
+	json.NewEncoder(w).Encode(ExecuteResponse{data})
+}
+
+// subscribe serves server streaming Subscribe procedure.
+func (h *Handler) subscribe(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), streamContentType) {
+		writeError(w, Error{"invalid_argument", "unsupported content type"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, Error{"internal", "streaming is not supported"})
+		return
+	}
+	w.Header().Set("Content-Type", streamContentType)
+	stream := &stream{w: w, flusher: flusher}
+
+	// Read enveloped request
+	var req SubscribeRequest
+	if _, data, err := readEnvelope(r.Body); err != nil {
+		stream.end(&Error{"invalid_argument", err.Error()})
+		return
+	} else if err = json.Unmarshal(data, &req); err != nil {
+		stream.end(&Error{"invalid_argument", err.Error()})
+		return
+	}
+	if h.s == nil {
+		stream.end(&Error{"unimplemented", "subscription is not attached"})
+		return
+	}
+	if _, ok := h.c.Get(req.Command); !ok && !command.IsPattern(req.Command) {
+		stream.end(&Error{"not_found",
+			fmt.Sprintf("command '%s' not found", req.Command)})
+		return
+	}
+
+	// Stream pushes until client disconnects
+	request := &command.DefaultRequest{RemoteAddr: r.RemoteAddr,
+		Header: r.Header, Ctx: r.Context(), Channel: stream}
+	h.s.SubscribeCmd(stream, req.Command, command.WrapSubscriptionHandler(
+		func(name string) ([]byte, error) {
+			return h.c.Exec(name, h.processIn, request)
+		},
+	))
+	defer h.s.Disconnect(stream)
+	defer stream.close()
+	stream.flush()
+	<-r.Context().Done()
+}
+
+// stream is a connection channel of Subscribe procedure stream.
+type stream struct {
+	w       io.Writer
+	flusher http.Flusher
+	closed  bool
+	sync.Mutex
+}
+
+// Send sends data pushed to stream in enveloped SubscribeResponse message.
+func (s *stream) Send(data []byte) error {
+	msg, err := json.Marshal(SubscribeResponse{data})
+	if err != nil {
+		return err
+	}
+	return s.write(0, msg)
+}
+
+// end sends end of stream message with optional error.
+func (s *stream) end(e *Error) {
+	msg, _ := json.Marshal(struct {
+		Error *Error `json:"error,omitempty"`
+	}{e})
+	s.write(flagEndStream, msg)
+	s.close()
+}
+
+// close closes stream, pushes are not sent to closed stream.
+func (s *stream) close() {
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+}
+
+// write writes enveloped message and flushes it.
+func (s *stream) write(flags byte, msg []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return io.ErrClosedPipe
+	}
+	header := make([]byte, 5)
+	header[0] = flags
+	binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+	if _, err := s.w.Write(append(header, msg...)); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// flush flushes stream headers.
+func (s *stream) flush() {
+	s.Lock()
+	defer s.Unlock()
+	s.flusher.Flush()
+}
+
+// readEnvelope reads enveloped streaming message.
+func readEnvelope(r io.Reader) (flags byte, data []byte, err error) {
+	header := make([]byte, 5)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxMessageSize {
+		return 0, nil, fmt.Errorf("message size %d exceeds %d", size,
+			MaxMessageSize)
+	}
+	data = make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	return header[0], data, err
+}
+
+// errorOf returns Connect error of command execution error.
+func errorOf(err error) Error {
+	code := "unknown"
+	switch {
+	case errors.Is(err, command.ErrCommandNotFound):
+		code = "not_found"
+	case errors.Is(err, command.ErrQuotaExceeded),
+		errors.Is(err, command.ErrConcurrencyLimit):
+		code = "resource_exhausted"
+	case errors.Is(err, command.ErrForbidden):
+		code = "permission_denied"
+	case errors.Is(err, command.ErrTimeout):
+		code = "deadline_exceeded"
+	case errors.Is(err, command.ErrIncorrectInputData):
+		code = "invalid_argument"
+	}
+	return Error{code, err.Error()}
+}
+
+// statuses are HTTP statuses of Connect unary errors codes.
+var statuses = map[string]int{
+	"invalid_argument":   http.StatusBadRequest,
+	"not_found":          http.StatusNotFound,
+	"permission_denied":  http.StatusForbidden,
+	"resource_exhausted": http.StatusTooManyRequests,
+	"deadline_exceeded":  http.StatusGatewayTimeout,
+	"unimplemented":      http.StatusNotImplemented,
+	"internal":           http.StatusInternalServerError,
+	"unknown":            http.StatusInternalServerError,
+}
+
+// writeError writes Connect unary error.
+func writeError(w http.ResponseWriter, e Error) {
+	w.Header().Set("Content-Type", unaryContentType)
+	w.WriteHeader(statuses[e.Code])
+	json.NewEncoder(w).Encode(e)
+}
@@ -0,0 +1,101 @@
+package connect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+func TestConnect(t *testing.T) {
+
+	c := command.New()
+	c.Add("hello", "", command.HTTP, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte("Hello " + vars["name"] + "!"), nil
+		},
+	)
+	s := command.NewSubscription()
+	ts := httptest.NewServer(New(c, s, command.HTTP))
+	defer ts.Close()
+
+	// Unary Execute
+	execute := func(req ExecuteRequest) (int, []byte) {
+		body, _ := json.Marshal(req)
+		res, err := http.Post(ts.URL+ExecutePath, "application/json",
+			bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, _ := io.ReadAll(res.Body)
+		return res.StatusCode, data
+	}
+
+	status, data := execute(ExecuteRequest{Command: "hello",
+		Vars: map[string]string{"name": "Connect"}})
+	var res ExecuteResponse
+	if err := json.Unmarshal(data, &res); err != nil || status != http.StatusOK ||
+		string(res.Data) != "Hello Connect!" {
+		t.Errorf("wrong execute response: %d %s, %v", status, data, err)
+	}
+
+	status, data = execute(ExecuteRequest{Command: "nope"})
+	var e Error
+	if err := json.Unmarshal(data, &e); err != nil ||
+		status != http.StatusNotFound || e.Code != "not_found" {
+		t.Errorf("wrong execute error: %d %s, %v", status, data, err)
+	}
+
+	// Server streaming Subscribe
+	msg, _ := json.Marshal(SubscribeRequest{Command: "hello"})
+	body := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	body = append(body, msg...)
+	stream, err := http.Post(ts.URL+SubscribePath, "application/connect+json",
+		bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+
+	for i := 0; s.ConnectionsCount("hello") == 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Broadcast("hello", []byte("news")); err != nil {
+		t.Fatal(err)
+	}
+	flags, data, err := readEnvelope(stream.Body)
+	var push SubscribeResponse
+	if err != nil || flags != 0 || json.Unmarshal(data, &push) != nil {
+		t.Fatalf("wrong stream message: %d %s, %v", flags, data, err)
+	}
+	var sm command.SubscriptionMessage
+	if err := json.Unmarshal(push.Data, &sm); err != nil ||
+		sm.Command != "hello" || string(sm.Data) != "news" {
+		t.Errorf("wrong pushed message: %s, %v", push.Data, err)
+	}
+
+	// Stream of unknown command ends with error
+	msg, _ = json.Marshal(SubscribeRequest{Command: "nope"})
+	body = append(body[:5], msg...)
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	stream, err = http.Post(ts.URL+SubscribePath, "application/connect+json",
+		bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	flags, data, err = readEnvelope(stream.Body)
+	if err != nil || flags != flagEndStream || !bytes.Contains(data, []byte("not_found")) {
+		t.Errorf("wrong end of stream: %d %s, %v", flags, data, err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Goroutines diagnostics module of Command processing golang package.
+
+package command
+
+import (
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of goroutines tracked by the package, see Diagnostics.
+const (
+	GoScheduler   = "scheduler"            // Scheduled command job
+	GoDispatcher  = "dispatcher"           // Subscription notify dispatcher
+	GoShadow      = "shadow"               // Shadow handler execution
+	GoHandler     = "handler"              // Command handler with hard timeout
+	GoBroadcast   = "broadcast worker"     // Subscription push worker
+	GoSend        = "send"                 // Subscription send with timeout
+	GoWebsocket   = "websocket connection" // Server websocket connection
+	GoHTTP3Server = "http3 server"         // Server HTTP/3 listener
+	GoACMEServer  = "acme server"          // Server ACME challenge listener
+)
+
+// GoroutineStats are statistics of running goroutines of one kind.
+type GoroutineStats struct {
+	Kind   string        `json:"kind"`   // Goroutine kind
+	Count  int           `json:"count"`  // Number of running goroutines
+	Oldest time.Duration `json:"oldest"` // Age of the oldest goroutine
+}
+
+// DiagnosticsReport is a report of goroutines running in the package.
+type DiagnosticsReport struct {
+	Goroutines []GoroutineStats `json:"goroutines"` // Tracked goroutines sorted by kind
+	Tracked    int              `json:"tracked"`    // Number of tracked goroutines
+	Total      int              `json:"total"`      // Number of process goroutines
+}
+
+// tracker is a registry of running goroutines.
+type tracker struct {
+	m    map[uint64]trackedGoroutine
+	next uint64
+	*sync.Mutex
+}
+
+// trackedGoroutine is a running goroutine kind and start time.
+type trackedGoroutine struct {
+	kind  string
+	start time.Time
+}
+
+// goroutines is a registry of goroutines spawned by the package.
+var goroutines = tracker{m: make(map[uint64]trackedGoroutine),
+	Mutex: new(sync.Mutex)}
+
+// Track registers running goroutine of kind in the package goroutines
+// registry, see Diagnostics. It returns function which should be called
+// when the goroutine finishes. It may be used by transports to track their
+// goroutines, like websocket connections loops.
+func Track(kind string) (done func()) {
+	goroutines.Lock()
+	id := goroutines.next
+	goroutines.next++
+	goroutines.m[id] = trackedGoroutine{kind, time.Now()}
+	goroutines.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			goroutines.Lock()
+			delete(goroutines.m, id)
+			goroutines.Unlock()
+		})
+	}
+}
+
+// Go runs f in goroutine of kind tracked by Diagnostics.
+func Go(kind string, f func()) {
+	done := Track(kind)
+	go func() {
+		defer done()
+		f()
+	}()
+}
+
+// Diagnostics returns counts and ages of running goroutines spawned by the
+// package. Growing count or age of a goroutines kind in long-running
+// gateway points to goroutines leak.
+func Diagnostics() DiagnosticsReport {
+	now := time.Now()
+	stats := make(map[string]*GoroutineStats)
+
+	goroutines.Lock()
+	r := DiagnosticsReport{Tracked: len(goroutines.m)}
+	for _, g := range goroutines.m {
+		st, ok := stats[g.kind]
+		if !ok {
+			st = &GoroutineStats{Kind: g.kind}
+			stats[g.kind] = st
+		}
+		st.Count++
+		st.Oldest = max(st.Oldest, now.Sub(g.start))
+	}
+	goroutines.Unlock()
+
+	r.Total = runtime.NumGoroutine()
+	r.Goroutines = make([]GoroutineStats, 0, len(stats))
+	for _, st := range stats {
+		r.Goroutines = append(r.Goroutines, *st)
+	}
+	slices.SortFunc(r.Goroutines, func(a, b GoroutineStats) int {
+		return strings.Compare(a.Kind, b.Kind)
+	})
+	return r
+}
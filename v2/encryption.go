@@ -0,0 +1,191 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Payload encryption module of Command processing golang package.
+
+package command
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// ErrDecrypt is an error returned when encrypted data can't be decrypted.
+var ErrDecrypt = fmt.Errorf("can't decrypt data")
+
+// ErrNoKey is an error returned when connection has no negotiated key.
+var ErrNoKey = fmt.Errorf("connection has no encryption key")
+
+// nonceSize is secretbox nonce size.
+const nonceSize = 24
+
+// Encryption keeps per-connection keys used to encrypt command data and
+// subscription pushes for transports without TLS, like raw TRU or relayed
+// WebRTC. The keys are negotiated with NaCl box key exchange: client sends
+// its public key with the encrypt command or Negotiate method and gets
+// server public key, then both sides use shared key to encrypt data with
+// NaCl secretbox. Encrypted data is 24 bytes nonce followed by secretbox.
+type Encryption struct {
+	m map[ConnectionChannel]*[32]byte
+	*sync.RWMutex
+}
+
+// NewEncryption creates and initializes Encryption object.
+func NewEncryption() *Encryption {
+	return &Encryption{
+		m:       make(map[ConnectionChannel]*[32]byte),
+		RWMutex: new(sync.RWMutex),
+	}
+}
+
+// Negotiate makes shared key of connection from client public key and
+// returns server public key which client uses to make the same shared key,
+// see SharedKey.
+func (e *Encryption) Negotiate(con ConnectionChannel, clientKey []byte) (
+	[]byte, error) {
+
+	if len(clientKey) != 32 {
+		return nil, fmt.Errorf("wrong client key length %d: %w",
+			len(clientKey), ErrIncorrectInputData)
+	}
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key := SharedKey((*[32]byte)(clientKey), priv)
+	e.Lock()
+	e.m[con] = key
+	e.Unlock()
+
+	return pub[:], nil
+}
+
+// Key returns shared key of connection.
+func (e *Encryption) Key(con ConnectionChannel) (key *[32]byte, ok bool) {
+	e.RLock()
+	key, ok = e.m[con]
+	e.RUnlock()
+	return
+}
+
+// Remove removes connection key. It should be called when connection is
+// closed.
+func (e *Encryption) Remove(con ConnectionChannel) {
+	e.Lock()
+	delete(e.m, con)
+	e.Unlock()
+}
+
+// Seal encrypts data with connection key. It returns ErrNoKey if key of the
+// connection is not negotiated.
+func (e *Encryption) Seal(con ConnectionChannel, data []byte) ([]byte, error) {
+	key, ok := e.Key(con)
+	if !ok {
+		return nil, ErrNoKey
+	}
+	return Seal(key, data)
+}
+
+// Open decrypts data with connection key. It returns ErrNoKey if key of the
+// connection is not negotiated.
+func (e *Encryption) Open(con ConnectionChannel, data []byte) ([]byte, error) {
+	key, ok := e.Key(con)
+	if !ok {
+		return nil, ErrNoKey
+	}
+	return Open(key, data)
+}
+
+// AddCommands adds encrypt command to commands map. The encrypt command gets
+// base64 url encoded client public key, negotiates shared key of request
+// connection and returns base64 url encoded server public key. Transports
+// should decrypt incoming data and encrypt answers of connections with
+// negotiated keys by Open and Seal methods.
+func (e *Encryption) AddCommands(c *Commands, processIn ProcessIn) {
+	c.Add("encrypt", "Negotiate connection encryption key.", processIn, "{key}",
+		"server public key", "encrypt/base64-client-public-key",
+		"base64-server-public-key",
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			req, err := c.Request(indata)
+			if err != nil {
+				return nil, err
+			}
+			con := req.GetConnectionChannel()
+			if con == nil {
+				return nil, ErrNoConnectionChannel
+			}
+			clientKey, err := base64.RawURLEncoding.DecodeString(
+				req.GetVars()["key"])
+			if err != nil {
+				return nil, ErrIncorrectInputData
+			}
+			serverKey, err := e.Negotiate(con, clientKey)
+			if err != nil {
+				return nil, err
+			}
+			return []byte(base64.RawURLEncoding.EncodeToString(serverKey)), nil
+		},
+	)
+}
+
+// SetEncryption sets encryption of subscription pushes. Pushes to
+// connections with negotiated keys are encrypted, and the keys are removed
+// when connections are unsubscribed from all commands.
+func (s *Subscription) SetEncryption(e *Encryption) {
+	s.encryption.Store(e)
+}
+
+// seal encrypts data sent to connection if it has negotiated key.
+func (s *Subscription) seal(con ConnectionChannel, data []byte) ([]byte, error) {
+	e := s.encryption.Load()
+	if e == nil {
+		return data, nil
+	}
+	key, ok := e.Key(con)
+	if !ok {
+		return data, nil
+	}
+	return Seal(key, data)
+}
+
+// GenerateKey generates client key pair used to negotiate connection key.
+func GenerateKey() (publicKey, privateKey *[32]byte, err error) {
+	return box.GenerateKey(rand.Reader)
+}
+
+// SharedKey makes shared key from peer public key and own private key.
+func SharedKey(peerKey, privateKey *[32]byte) *[32]byte {
+	key := new([32]byte)
+	box.Precompute(key, peerKey, privateKey)
+	return key
+}
+
+// Seal encrypts data with shared key.
+func Seal(key *[32]byte, data []byte) ([]byte, error) {
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	return secretbox.Seal(nonce[:], data, &nonce, key), nil
+}
+
+// Open decrypts data encrypted with shared key.
+func Open(key *[32]byte, data []byte) ([]byte, error) {
+	if len(data) < nonceSize+secretbox.Overhead {
+		return nil, ErrDecrypt
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], data)
+	out, ok := secretbox.Open(nil, data[nonceSize:], &nonce, key)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
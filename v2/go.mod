@@ -1,3 +1,14 @@
 module github.com/kirill-scherba/command/v2
 
 go 1.23.2
+
+require (
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/crypto v0.36.0
+)
+
+require (
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
+)
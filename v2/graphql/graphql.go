@@ -0,0 +1,263 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package graphql is a GraphQL facade over the Command processing package
+// registry.
+//
+// Every registered command is exposed as a field of Query, Mutation and
+// Subscription types named by command names with characters other than
+// letters, digits and underscore replaced by underscore, like "user_get" of
+// "user.get" command. Command parameters are field arguments and command
+// result is field value: raw json if the result is valid json or a string
+// otherwise. Subscription operations subscribe connection to commands with
+// the command Subscription engine.
+//
+// Only a subset of GraphQL is supported: one operation per document, top
+// level fields with aliases and arguments, variables and comments. Fragments,
+// directives and nested selections are not supported as commands return
+// untyped data.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// Request is a GraphQL request.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response is a GraphQL response.
+type Response struct {
+	Data   map[string]json.RawMessage `json:"data,omitempty"`
+	Errors []Error                    `json:"errors,omitempty"`
+}
+
+// Error is a GraphQL response error.
+type Error struct {
+	Message string   `json:"message"`
+	Path    []string `json:"path,omitempty"`
+}
+
+// Facade executes GraphQL requests with commands registry.
+type Facade struct {
+	c         *command.Commands
+	s         *command.Subscription
+	processIn command.ProcessIn
+}
+
+// New creates GraphQL facade over commands registry. Commands are executed
+// with processIn input processing type and only commands processed in it are
+// exposed. The subscription s is used for subscription operations and may be
+// nil.
+func New(c *command.Commands, s *command.Subscription,
+	processIn command.ProcessIn) *Facade {
+	return &Facade{c, s, processIn}
+}
+
+// ServeHTTP executes GraphQL request received in HTTP POST json body or GET
+// query parameters.
+func (f *Facade) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		body := http.MaxBytesReader(w, r.Body, command.MaxBodySize)
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := f.Execute(req, func(vars map[string]string) any {
+		return command.NewHTTPRequest(r, vars)
+	}, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// Execute executes GraphQL request. The newRequest function creates command
+// request data from field arguments, if it is nil command.DefaultRequest is
+// used. The con connection channel is used for subscription operations.
+func (f *Facade) Execute(req Request, newRequest func(vars map[string]string) any,
+	con command.ConnectionChannel) (res Response) {
+
+	op, err := parse(req.Query)
+	if err != nil {
+		res.Errors = append(res.Errors, Error{Message: err.Error()})
+		return
+	}
+	if req.OperationName != "" && op.name != req.OperationName {
+		res.Errors = append(res.Errors, Error{
+			Message: fmt.Sprintf("operation '%s' not found", req.OperationName),
+		})
+		return
+	}
+	if newRequest == nil {
+		newRequest = func(vars map[string]string) any {
+			return &command.DefaultRequest{Vars: vars, Channel: con}
+		}
+	}
+
+	fields := f.fields()
+	res.Data = make(map[string]json.RawMessage)
+	for _, fld := range op.fields {
+		name, err := f.command(fields, fld.name)
+		var vars map[string]string
+		if err == nil {
+			vars, err = fld.vars(req.Variables)
+		}
+		if err == nil {
+			if op.typ == "subscription" {
+				err = f.subscribe(con, name, newRequest(vars))
+				if err == nil {
+					res.Data[fld.alias] = json.RawMessage(`"ok"`)
+				}
+			} else {
+				res.Data[fld.alias], err = f.exec(name, newRequest(vars))
+			}
+		}
+		if err != nil {
+			res.Data[fld.alias] = json.RawMessage("null")
+			res.Errors = append(res.Errors, Error{
+				Message: err.Error(), Path: []string{fld.alias},
+			})
+		}
+	}
+
+	return
+}
+
+// exec executes command and returns its result as json value.
+func (f *Facade) exec(name string, request any) (json.RawMessage, error) {
+	data, err := f.c.Exec(name, f.processIn, request)
+	if err != nil {
+		return nil, err
+	}
+	if json.Valid(data) {
+		return data, nil
+	}
+	return json.Marshal(string(data))
+}
+
+// subscribe subscribes connection to command.
+func (f *Facade) subscribe(con command.ConnectionChannel, name string,
+	request any) error {
+
+	if f.s == nil {
+		return command.ErrNoSubscription
+	}
+	if con == nil {
+		return command.ErrNoConnectionChannel
+	}
+	f.s.SubscribeCmd(con, name, func(ctx context.Context,
+		con command.ConnectionChannel, name string) ([]byte, error) {
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return f.c.Exec(name, f.processIn, request)
+	})
+	return nil
+}
+
+// fields returns names of exposed commands by GraphQL field names, see
+// fieldName. Commands are exposed if they have handler of facade input
+// processing type. The first command by name is exposed if names of commands
+// give the same field name.
+func (f *Facade) fields() map[string]string {
+	fields := make(map[string]string)
+	f.c.HabdleCommands(f.processIn, func(name, params string) {
+		if field := fieldName(name); fields[field] == "" {
+			fields[field] = name
+		}
+	})
+	return fields
+}
+
+// command returns name of command exposed as GraphQL field or error if
+// there is no such command.
+func (f *Facade) command(fields map[string]string, field string) (string,
+	error) {
+
+	name, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("command '%s': %w", field,
+			command.ErrCommandNotFound)
+	}
+	return name, nil
+}
+
+// fieldName returns GraphQL field name of command name, its characters other
+// than letters, digits and underscore are replaced by underscore, like
+// "user_get" of "user.get" or "user/get".
+func fieldName(name string) string {
+	b := []byte(name)
+	for i, ch := range b {
+		if ch != '_' && !(ch >= 'a' && ch <= 'z') && !(ch >= 'A' && ch <= 'Z') &&
+			!(i > 0 && ch >= '0' && ch <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// Schema returns GraphQL schema definition of exposed commands, see
+// fieldName. All arguments and results are typed as String as commands are
+// untyped.
+func (f *Facade) Schema() string {
+	fieldNames := f.fields()
+	var fields []string
+	for field, name := range fieldNames {
+		cmd, ok := f.c.Get(name)
+		if !ok {
+			continue
+		}
+		if args := cmd.ParamsSlice(); len(args) > 0 {
+			field += "(" + strings.Join(args, ": String, ") + ": String)"
+		}
+		field = "  " + field + ": String"
+		if cmd.Descr != "" {
+			field = fmt.Sprintf("  %q\n%s", cmd.Descr, field)
+		}
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return strings.TrimLeft(fields[i], ` "`) < strings.TrimLeft(fields[j], ` "`)
+	})
+
+	var sb strings.Builder
+	for _, typ := range []string{"Query", "Mutation", "Subscription"} {
+		if typ == "Subscription" && f.s == nil {
+			continue
+		}
+		fmt.Fprintf(&sb, "type %s {\n%s\n}\n\n", typ, strings.Join(fields, "\n"))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// ErrSyntax is an error returned when GraphQL query can't be parsed.
+var ErrSyntax = errors.New("graphql syntax error")
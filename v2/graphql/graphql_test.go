@@ -0,0 +1,127 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+type channel struct{ data [][]byte }
+
+func (ch *channel) Send(data []byte) error {
+	ch.data = append(ch.data, data)
+	return nil
+}
+
+func TestGraphQL(t *testing.T) {
+
+	c := command.New()
+	c.Add("hello", "say hello", command.HTTP, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte(fmt.Sprintf("Hello %s!", vars["name"])), nil
+		},
+	)
+	c.Add("user", "get user", command.HTTP, "", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			return []byte(`{"id":42}`), nil
+		},
+	)
+	s := command.NewSubscription()
+	f := New(c, s, command.HTTP)
+
+	// HTTP query with alias, variables and json result
+	body := `{"query":"query Q($n: String) { a: hello(name: $n) user, wrong }",
+		"variables":{"n":"John"}}`
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/graphql",
+		strings.NewReader(body)))
+
+	var res Response
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data["a"]) != `"Hello John!"` || string(res.Data["user"]) != `{"id":42}` {
+		t.Errorf("wrong data: %s", w.Body)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].Path[0] != "wrong" {
+		t.Errorf("wrong errors: %v", res.Errors)
+	}
+
+	// Syntax error
+	res = f.Execute(Request{Query: "{ hello(name: "}, nil, nil)
+	if len(res.Errors) != 1 || res.Data != nil {
+		t.Errorf("syntax error expected: %v", res)
+	}
+
+	// Subscription
+	ch := &channel{}
+	res = f.Execute(Request{Query: `subscription { hello(name: "Sub") }`}, nil, ch)
+	if len(res.Errors) != 0 {
+		t.Fatal(res.Errors)
+	}
+	s.ExecCmd("hello")
+	var msg command.SubscriptionMessage
+	if len(ch.data) != 1 || json.Unmarshal(ch.data[0], &msg) != nil ||
+		string(msg.Data) != "Hello Sub!" {
+		t.Errorf("wrong subscription push: %q", ch.data)
+	}
+
+	// Schema
+	if schema := f.Schema(); !strings.Contains(schema, "hello(name: String): String") {
+		t.Errorf("wrong schema: %s", schema)
+	}
+}
+
+func TestFieldNames(t *testing.T) {
+
+	c := command.New()
+	handler := func(res string) command.CommandHandler {
+		return func(cmd *command.CommandData, processIn command.ProcessIn,
+			data any) ([]byte, error) {
+			return []byte(res), nil
+		}
+	}
+	c.Add("user.get", "", command.WS, "{id}", "", "", "", handler("ws"))
+	if err := c.SetHandler("user.get", command.HTTP, handler(`"get"`)); err != nil {
+		t.Fatal(err)
+	}
+	c.Add("user-list", "", command.HTTP, "", "", "", "", handler(`"list"`))
+	c.AddGroup("admin", "", command.HTTP).Add("stats", "", command.HTTP, "", "",
+		"", "", handler(`"stats"`))
+	f := New(c, nil, command.HTTP)
+
+	schema := f.Schema()
+	for _, field := range []string{"  user_get(id: String): String",
+		"  user_list: String", "  admin_stats: String"} {
+		if !strings.Contains(schema, field+"\n") {
+			t.Errorf("field %s is not in schema:\n%s", field, schema)
+		}
+	}
+	if strings.ContainsAny(schema, ".-/") {
+		t.Errorf("invalid field names in schema:\n%s", schema)
+	}
+
+	res := f.Execute(Request{Query: `{ user_get(id: "1") user_list admin_stats }`},
+		nil, nil)
+	if len(res.Errors) != 0 {
+		t.Fatal(res.Errors)
+	}
+	if string(res.Data["user_get"]) != `"get"` || string(res.Data["user_list"]) !=
+		`"list"` || string(res.Data["admin_stats"]) != `"stats"` {
+		t.Errorf("wrong data: %v", res.Data)
+	}
+
+	// Command names are not fields
+	res = f.Execute(Request{Query: `{ user_get user }`}, nil, nil)
+	if len(res.Errors) != 1 || res.Errors[0].Path[0] != "user" {
+		t.Errorf("wrong errors: %v", res.Errors)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// GraphQL query parser.
+
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// operation is a parsed GraphQL operation.
+type operation struct {
+	typ    string  // Operation type: query, mutation or subscription
+	name   string  // Operation name
+	fields []field // Top level fields
+}
+
+// field is a parsed GraphQL field.
+type field struct {
+	alias string           // Field alias or name
+	name  string           // Field name
+	args  map[string]value // Field arguments
+}
+
+// value is a parsed GraphQL argument value. If variable is set the value is
+// taken from request variables.
+type value struct {
+	literal  string
+	variable string
+}
+
+// vars returns field arguments as command variables.
+func (f field) vars(variables map[string]any) (map[string]string, error) {
+	vars := make(map[string]string, len(f.args))
+	for name, v := range f.args {
+		if v.variable == "" {
+			vars[name] = v.literal
+			continue
+		}
+		val, ok := variables[v.variable]
+		if !ok {
+			return nil, fmt.Errorf("variable '$%s' is not defined", v.variable)
+		}
+		if val != nil {
+			vars[name] = fmt.Sprint(val)
+		}
+	}
+	return vars, nil
+}
+
+// parser is a GraphQL query parser.
+type parser struct {
+	s   string
+	pos int
+}
+
+// parse parses GraphQL query.
+func parse(query string) (op operation, err error) {
+	p := &parser{s: query}
+	op.typ = "query"
+
+	// Operation type, name and variables definitions
+	if p.skip(); p.peek() != '{' {
+		op.typ = p.name()
+		switch op.typ {
+		case "query", "mutation", "subscription":
+		default:
+			return op, p.errorf("unknown operation '%s'", op.typ)
+		}
+		if p.skip(); p.peek() != '{' && p.peek() != '(' {
+			op.name = p.name()
+		}
+		if p.skip(); p.peek() == '(' {
+			if err = p.skipGroup('(', ')'); err != nil {
+				return
+			}
+		}
+	}
+
+	// Selection set
+	if op.fields, err = p.selectionSet(); err != nil {
+		return
+	}
+	if p.skip(); p.pos < len(p.s) {
+		return op, p.errorf("only one operation is supported")
+	}
+
+	return
+}
+
+// selectionSet parses top level selection set.
+func (p *parser) selectionSet() (fields []field, err error) {
+	p.skip()
+	if err = p.expect('{'); err != nil {
+		return
+	}
+	for {
+		if p.skip(); p.peek() == '}' {
+			p.pos++
+			break
+		}
+		if p.pos >= len(p.s) {
+			return nil, p.errorf("unexpected end of query")
+		}
+
+		f := field{name: p.name(), args: make(map[string]value)}
+		if f.name == "" {
+			return nil, p.errorf("field name expected")
+		}
+		if p.skip(); p.peek() == ':' {
+			p.pos++
+			p.skip()
+			f.alias, f.name = f.name, p.name()
+		}
+		if f.alias == "" {
+			f.alias = f.name
+		}
+		if p.skip(); p.peek() == '(' {
+			if err = p.arguments(f.args); err != nil {
+				return
+			}
+		}
+		if p.skip(); p.peek() == '{' {
+			return nil, p.errorf("nested selection of '%s' is not supported",
+				f.name)
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, p.errorf("empty selection set")
+	}
+	return
+}
+
+// arguments parses field arguments.
+func (p *parser) arguments(args map[string]value) error {
+	p.pos++
+	for {
+		if p.skip(); p.peek() == ')' {
+			p.pos++
+			return nil
+		}
+		name := p.name()
+		if name == "" {
+			return p.errorf("argument name expected")
+		}
+		p.skip()
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		p.skip()
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		args[name] = v
+	}
+}
+
+// value parses argument value.
+func (p *parser) value() (v value, err error) {
+	switch ch := p.peek(); {
+	case ch == '$':
+		p.pos++
+		v.variable = p.name()
+	case ch == '"':
+		start := p.pos
+		for p.pos++; p.pos < len(p.s) && p.s[p.pos] != '"'; p.pos++ {
+			if p.s[p.pos] == '\\' {
+				p.pos++
+			}
+		}
+		if p.pos >= len(p.s) {
+			return v, p.errorf("unterminated string")
+		}
+		p.pos++
+		v.literal, err = strconv.Unquote(p.s[start:p.pos])
+	case ch == '-' || ch >= '0' && ch <= '9':
+		start := p.pos
+		for p.pos++; p.pos < len(p.s) && strings.ContainsRune("0123456789.eE+-",
+			rune(p.s[p.pos])); p.pos++ {
+		}
+		v.literal = p.s[start:p.pos]
+	default:
+		v.literal = p.name()
+		if v.literal == "" {
+			return v, p.errorf("value expected")
+		}
+		if v.literal == "null" {
+			v.literal = ""
+		}
+	}
+	return
+}
+
+// name parses GraphQL name.
+func (p *parser) name() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		ch := rune(p.s[p.pos])
+		if ch != '_' && !unicode.IsLetter(ch) &&
+			!(unicode.IsDigit(ch) && p.pos > start) {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// skipGroup skips characters until closing bracket.
+func (p *parser) skipGroup(open, close byte) error {
+	depth := 0
+	for ; p.pos < len(p.s); p.pos++ {
+		switch p.s[p.pos] {
+		case open:
+			depth++
+		case close:
+			if depth--; depth == 0 {
+				p.pos++
+				return nil
+			}
+		}
+	}
+	return p.errorf("unexpected end of query")
+}
+
+// skip skips white spaces, commas and comments.
+func (p *parser) skip() {
+	for p.pos < len(p.s) {
+		switch ch := p.s[p.pos]; {
+		case ch == '#':
+			for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+				p.pos++
+			}
+		case ch == ',' || unicode.IsSpace(rune(ch)):
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// peek returns current character or zero at the end of query.
+func (p *parser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+// expect checks current character and moves to the next one.
+func (p *parser) expect(ch byte) error {
+	if p.peek() != ch {
+		return p.errorf("'%c' expected", ch)
+	}
+	p.pos++
+	return nil
+}
+
+// errorf returns syntax error at current position.
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w at %d: %s", ErrSyntax, p.pos,
+		fmt.Sprintf(format, args...))
+}
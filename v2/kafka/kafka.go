@@ -0,0 +1,161 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package kafka is a Kafka adapter of the Command processing package
+// registry.
+//
+// The Consumer executes commands from Kafka topic messages: the message key
+// is command name, headers are command variables and the message value is
+// command data. Message offset is committed after successful execution,
+// failed messages are written to dead letter queue. The optional events
+// writer receives execution event of every message, so the registry is a
+// step of event-driven pipelines.
+//
+// The package does not depend on Kafka client library. Reader and Writer
+// interfaces are small wrappers around client methods, for example
+// github.com/segmentio/kafka-go Reader FetchMessage and CommitMessages and
+// Writer WriteMessages methods.
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// Headers of dead letter queue messages.
+const (
+	ErrorHeader = "error" // Command execution error
+	TopicHeader = "topic" // Source topic
+)
+
+// Header is a Kafka message header.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message is a Kafka message.
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	Time      time.Time
+}
+
+// Reader reads messages from topic and commits their offsets.
+type Reader interface {
+	FetchMessage(ctx context.Context) (Message, error)
+	CommitMessages(ctx context.Context, msgs ...Message) error
+}
+
+// Writer writes messages to topic.
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...Message) error
+}
+
+// Event is an execution event written to events writer in json format.
+type Event struct {
+	Command   string            `json:"command"`
+	Vars      map[string]string `json:"vars,omitempty"`
+	Topic     string            `json:"topic"`
+	Partition int               `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Result    []byte            `json:"result,omitempty"`
+	Err       string            `json:"err,omitempty"`
+	Latency   time.Duration     `json:"latency"`
+	Time      time.Time         `json:"time"`
+}
+
+// Consumer executes commands from Kafka messages.
+type Consumer struct {
+	c         *command.Commands
+	r         Reader
+	processIn command.ProcessIn
+
+	// DLQ is dead letter queue writer of failed messages. The message is
+	// written with ErrorHeader and TopicHeader headers. Failed messages are
+	// committed and skipped if it is nil.
+	DLQ Writer
+
+	// Events is writer of execution events, events are not written if it is
+	// nil. The event message key is command name.
+	Events Writer
+}
+
+// New creates Kafka consumer of commands registry reading messages from
+// reader r. Commands are executed with processIn input processing type,
+// like the one registered by command.RegisterProcessIn("Kafka").
+func New(c *command.Commands, r Reader, processIn command.ProcessIn) *Consumer {
+	return &Consumer{c: c, r: r, processIn: processIn}
+}
+
+// Consume executes commands of messages in order until ctx is done or
+// reader, dead letter queue or events writer fails. The message offset is
+// committed after the command is executed successfully or the failed message
+// is written to dead letter queue, so not committed messages are executed
+// again after restart.
+func (cons *Consumer) Consume(ctx context.Context) error {
+	for {
+		msg, err := cons.r.FetchMessage(ctx)
+		if err != nil {
+			return err
+		}
+		if err = cons.handle(ctx, msg); err != nil {
+			return err
+		}
+		if err = cons.r.CommitMessages(ctx, msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle executes message command and writes its event and failed message.
+func (cons *Consumer) handle(ctx context.Context, msg Message) error {
+	e := Event{Command: string(msg.Key), Vars: make(map[string]string),
+		Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}
+	for _, h := range msg.Headers {
+		e.Vars[h.Key] = string(h.Value)
+	}
+
+	start := time.Now()
+	res, err := cons.c.Exec(e.Command, cons.processIn,
+		&command.DefaultRequest{Vars: e.Vars, Data: msg.Value, Ctx: ctx})
+	e.Latency, e.Time = time.Since(start), time.Now()
+	e.Result = res
+	if err != nil {
+		e.Err = err.Error()
+	}
+
+	// Write execution event
+	if cons.Events != nil {
+		data, jerr := json.Marshal(e)
+		if jerr != nil {
+			return jerr
+		}
+		jerr = cons.Events.WriteMessages(ctx, Message{Key: msg.Key, Value: data,
+			Time: e.Time})
+		if jerr != nil {
+			return fmt.Errorf("write event: %w", jerr)
+		}
+	}
+
+	// Write failed message to dead letter queue
+	if err != nil && cons.DLQ != nil {
+		dlq := Message{Key: msg.Key, Value: msg.Value, Time: e.Time,
+			Headers: append(append([]Header(nil), msg.Headers...),
+				Header{ErrorHeader, []byte(e.Err)},
+				Header{TopicHeader, []byte(msg.Topic)})}
+		if err = cons.DLQ.WriteMessages(ctx, dlq); err != nil {
+			return fmt.Errorf("write dead letter: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// testTopic is in memory topic reader and writer.
+type testTopic struct {
+	msgs      []Message
+	committed []int64
+	fail      bool
+}
+
+func (t *testTopic) FetchMessage(ctx context.Context) (Message, error) {
+	if len(t.msgs) == 0 {
+		return Message{}, context.Canceled
+	}
+	msg := t.msgs[0]
+	t.msgs = t.msgs[1:]
+	return msg, nil
+}
+
+func (t *testTopic) CommitMessages(ctx context.Context, msgs ...Message) error {
+	for _, msg := range msgs {
+		t.committed = append(t.committed, msg.Offset)
+	}
+	return nil
+}
+
+func (t *testTopic) WriteMessages(ctx context.Context, msgs ...Message) error {
+	if t.fail {
+		return fmt.Errorf("write failed")
+	}
+	t.msgs = append(t.msgs, msgs...)
+	return nil
+}
+
+func TestConsumer(t *testing.T) {
+
+	c := command.New()
+	c.Add("hello", "", command.All, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte("Hello " + vars["name"] + "!"), nil
+		},
+	)
+
+	in := &testTopic{msgs: []Message{
+		{Topic: "cmd", Offset: 1, Key: []byte("hello"),
+			Headers: []Header{{"name", []byte("Kafka")}}},
+		{Topic: "cmd", Offset: 2, Key: []byte("nope")},
+	}}
+	dlq, events := &testTopic{}, &testTopic{}
+	cons := New(c, in, command.All)
+	cons.DLQ, cons.Events = dlq, events
+
+	if err := cons.Consume(context.Background()); err != context.Canceled {
+		t.Fatalf("wrong consume error: %v", err)
+	}
+	if fmt.Sprint(in.committed) != "[1 2]" {
+		t.Errorf("wrong committed offsets: %v", in.committed)
+	}
+
+	// Execution events
+	if len(events.msgs) != 2 {
+		t.Fatalf("wrong number of events: %d", len(events.msgs))
+	}
+	var e Event
+	if err := json.Unmarshal(events.msgs[0].Value, &e); err != nil ||
+		string(e.Result) != "Hello Kafka!" || e.Err != "" || e.Offset != 1 {
+		t.Errorf("wrong event: %s, %v", events.msgs[0].Value, err)
+	}
+
+	// Dead letter queue
+	if len(dlq.msgs) != 1 || string(dlq.msgs[0].Key) != "nope" ||
+		dlq.msgs[0].Headers[0].Key != ErrorHeader {
+		t.Errorf("wrong dead letters: %v", dlq.msgs)
+	}
+
+	// Message is not committed if dead letter queue fails
+	in.msgs, in.committed = []Message{{Offset: 3, Key: []byte("nope")}}, nil
+	dlq.fail = true
+	if err := cons.Consume(context.Background()); err == nil ||
+		len(in.committed) != 0 {
+		t.Errorf("failed message committed: %v, %v", err, in.committed)
+	}
+}
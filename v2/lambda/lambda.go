@@ -0,0 +1,161 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lambda is a serverless functions adapter of the Command processing
+// package registry.
+//
+// The Handler adapts commands registry to AWS Lambda function invoked by API
+// Gateway HTTP API or function URL events, payload format version 2.0:
+//
+//	lambda.Start(cmdlambda.Handler(c, command.HTTP, "/api"))
+//
+// The HTTPHandler adapts it to HTTP functions, like Google Cloud Functions:
+//
+//	functions.HTTP("api", cmdlambda.HTTPHandler(c, command.HTTP, "/api"))
+//
+// Request path after prefix is command name with parameters, like
+// "/api/hello/John", and request body is command data. The package does not
+// depend on cloud SDKs, the events are described by its own types.
+package lambda
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// MaxBodySize is a maximum size of HTTP function request body.
+const MaxBodySize = 10 << 20
+
+// APIGatewayRequest is an API Gateway HTTP API event, payload format version
+// 2.0.
+type APIGatewayRequest struct {
+	RawPath         string            `json:"rawPath"`
+	RawQueryString  string            `json:"rawQueryString"`
+	Headers         map[string]string `json:"headers"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+	RequestContext  struct {
+		RequestID string `json:"requestId"`
+		HTTP      struct {
+			Method   string `json:"method"`
+			Path     string `json:"path"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+	} `json:"requestContext"`
+}
+
+// APIGatewayResponse is an API Gateway HTTP API response.
+type APIGatewayResponse struct {
+	StatusCode      int               `json:"statusCode"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+}
+
+// Handler returns AWS Lambda handler of commands c processed in processIn.
+// Not text answers are returned base64 encoded.
+func Handler(c *command.Commands, processIn command.ProcessIn, prefix string) func(
+	ctx context.Context, event APIGatewayRequest) (APIGatewayResponse, error) {
+
+	return func(ctx context.Context, event APIGatewayRequest) (
+		APIGatewayResponse, error) {
+
+		body := []byte(event.Body)
+		if event.IsBase64Encoded {
+			var err error
+			if body, err = base64.StdEncoding.DecodeString(event.Body); err != nil {
+				return APIGatewayResponse{StatusCode: http.StatusBadRequest,
+					Body: err.Error()}, nil
+			}
+		}
+		header := make(http.Header, len(event.Headers))
+		for k, v := range event.Headers {
+			header.Set(k, v)
+		}
+		if event.RequestContext.RequestID != "" && header.Get("X-Request-ID") == "" {
+			header.Set("X-Request-ID", event.RequestContext.RequestID)
+		}
+		path := event.RawPath
+		if path == "" {
+			path = event.RequestContext.HTTP.Path
+		}
+
+		status, data := exec(c, processIn, prefix, path, &command.DefaultRequest{
+			Data: body, Header: header, Ctx: ctx,
+			RemoteAddr: event.RequestContext.HTTP.SourceIP,
+		})
+		res := APIGatewayResponse{StatusCode: status,
+			Headers: map[string]string{"Content-Type": contentType(data)}}
+		if utf8.Valid(data) {
+			res.Body = string(data)
+		} else {
+			res.Body = base64.StdEncoding.EncodeToString(data)
+			res.IsBase64Encoded = true
+		}
+		return res, nil
+	}
+}
+
+// HTTPHandler returns HTTP function handler of commands c processed in
+// processIn.
+func HTTPHandler(c *command.Commands, processIn command.ProcessIn,
+	prefix string) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status, data := exec(c, processIn, prefix, r.URL.EscapedPath(),
+			&command.DefaultRequest{Data: body, Header: r.Header,
+				Ctx: r.Context(), RemoteAddr: r.RemoteAddr})
+		w.Header().Set("Content-Type", contentType(data))
+		w.WriteHeader(status)
+		w.Write(data)
+	}
+}
+
+// exec executes command of request path and returns HTTP status and answer.
+func exec(c *command.Commands, processIn command.ProcessIn, prefix, path string,
+	req *command.DefaultRequest) (int, []byte) {
+
+	line, ok := strings.CutPrefix(path, "/"+strings.Trim(prefix, "/"))
+	if !ok {
+		return http.StatusNotFound, []byte("not found")
+	}
+	name, vars := c.ParseCommand([]byte(strings.TrimPrefix(line, "/")))
+	req.Vars = vars
+
+	if _, ok := c.Get(name); !ok {
+		return http.StatusNotFound, []byte("command '" + name + "' not found")
+	}
+	data, err := c.Exec(name, processIn, req)
+	switch {
+	case err == nil:
+		return http.StatusOK, data
+	case errors.Is(err, command.ErrQuotaExceeded):
+		return http.StatusTooManyRequests, []byte(err.Error())
+	case errors.Is(err, command.ErrForbidden):
+		return http.StatusForbidden, []byte(err.Error())
+	case errors.Is(err, command.ErrTimeout):
+		return http.StatusGatewayTimeout, []byte(err.Error())
+	}
+	return http.StatusBadRequest, []byte(err.Error())
+}
+
+// contentType returns content type of answer.
+func contentType(data []byte) string {
+	if utf8.Valid(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
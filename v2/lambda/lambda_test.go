@@ -0,0 +1,69 @@
+package lambda
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+func TestHandlers(t *testing.T) {
+
+	c := command.New()
+	c.Add("hello", "", command.HTTP, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			body, _ := c.Data(data)
+			return []byte("Hello " + vars["name"] + string(body) + "!"), nil
+		},
+	)
+	c.Add("bin", "", command.HTTP, "", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			return []byte{0xff, 0xfe}, nil
+		},
+	)
+
+	// AWS Lambda
+	h := Handler(c, command.HTTP, "/api/")
+	for _, test := range []struct {
+		path, body string
+		base64     bool
+		status     int
+		expected   string
+	}{
+		{"/api/hello/a%2Fb", "", false, 200, "Hello a/b!"},
+		{"/api/hello/John", base64.StdEncoding.EncodeToString([]byte(" Doe")),
+			true, 200, "Hello John Doe!"},
+		{"/api/bin", "", false, 200, base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe})},
+		{"/api/nope", "", false, 404, "command 'nope' not found"},
+		{"/other/hello", "", false, 404, "not found"},
+	} {
+		event := APIGatewayRequest{RawPath: test.path, Body: test.body,
+			IsBase64Encoded: test.base64}
+		res, err := h(context.Background(), event)
+		if err != nil || res.StatusCode != test.status || res.Body != test.expected {
+			t.Errorf("wrong %s response: %v, %v", test.path, res, err)
+		}
+	}
+
+	// HTTP function
+	ts := httptest.NewServer(HTTPHandler(c, command.HTTP, "api"))
+	defer ts.Close()
+	res, err := http.Post(ts.URL+"/api/hello/Cloud", "text/plain",
+		strings.NewReader(" Function"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 200 || string(body) != "Hello Cloud Function!" {
+		t.Errorf("wrong HTTP function response: %d %s", res.StatusCode, body)
+	}
+}
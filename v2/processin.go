@@ -6,7 +6,11 @@
 
 package command
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
 
 const (
 	HTTP   ProcessIn = 1 << iota // HTTP request
@@ -17,55 +21,98 @@ const (
 	All    = HTTP | TRU | WebRTC | Teonet | WS
 )
 
-// ProcessIn represents the source of a command.
-type ProcessIn byte
+// ProcessIn represents the source of a command. Custom transports are added
+// with RegisterProcessIn.
+type ProcessIn uint32
 
-// String returns a string representation of the ProcessIn.
-//
-// The string representation includes the names of the sources separated by commas.
-// If the source is unknown, it is omitted from the result.
-// The result is lowercased and trimmed of trailing commas.
-func (pi ProcessIn) String() string {
-	// Create a strings.Builder to efficiently build the result string.
-	var sb strings.Builder
+// ErrProcessInLimit is an error returned by RegisterProcessIn when all
+// ProcessIn bits are registered.
+var ErrProcessInLimit = fmt.Errorf("too many processing types")
 
-	// Check each bit of the ProcessIn byte and append the corresponding source name
-	// to the strings.Builder if the bit is set.
+// processInName is a name of registered ProcessIn.
+type processInName struct {
+	pi   ProcessIn
+	name string
+}
 
-	// HTTP source
-	if pi&HTTP != 0 {
-		sb.WriteString("HTTP, ")
+// processIns contains registered ProcessIn names.
+var processIns = struct {
+	list []processInName
+	sync.RWMutex
+}{list: []processInName{
+	{HTTP, "HTTP"}, {TRU, "TRU"}, {WebRTC, "WebRTC"}, {Teonet, "Teonet"},
+	{WS, "Websocket"},
+}}
+
+// RegisterProcessIn registers custom transport and returns its ProcessIn
+// flag. Registered names are used by ProcessIn String method and commands
+// list filter. It should be called at program start, before commands are
+// added. It returns an error if the name is already registered or all
+// ProcessIn bits are used.
+func RegisterProcessIn(name string) (ProcessIn, error) {
+	if err := ValidateName(name); err != nil {
+		return 0, err
 	}
 
-	// TRU source
-	if pi&TRU != 0 {
-		sb.WriteString("TRU, ")
-	}
+	processIns.Lock()
+	defer processIns.Unlock()
 
-	// WebRTC source
-	if pi&WebRTC != 0 {
-		sb.WriteString("WebRTC, ")
+	var used ProcessIn
+	for _, p := range processIns.list {
+		if strings.EqualFold(p.name, name) {
+			return 0, fmt.Errorf("processing type '%s' already registered", name)
+		}
+		used |= p.pi
 	}
-
-	// Teonet source
-	if pi&Teonet != 0 {
-		sb.WriteString("Teonet, ")
+	for pi := ProcessIn(1); pi != 0; pi <<= 1 {
+		if used&pi == 0 {
+			processIns.list = append(processIns.list, processInName{pi, name})
+			return pi, nil
+		}
 	}
+	return 0, ErrProcessInLimit
+}
 
-	// Websocket source
-	if pi&WS != 0 {
-		sb.WriteString("Websocket, ")
-	}
+// ProcessIns returns all registered ProcessIn flags in registration order.
+func ProcessIns() []ProcessIn {
+	processIns.RLock()
+	defer processIns.RUnlock()
 
-	// Get the result string from the strings.Builder.
-	result := sb.String()
+	list := make([]ProcessIn, len(processIns.list))
+	for i, p := range processIns.list {
+		list[i] = p.pi
+	}
+	return list
+}
 
-	// Trim the trailing comma and space from the result string.
-	result = strings.TrimRight(result, ", ")
+// Name returns name of single ProcessIn flag, like "HTTP", or empty string if
+// the flag is not registered.
+func (pi ProcessIn) Name() string {
+	processIns.RLock()
+	defer processIns.RUnlock()
 
-	// Convert the result string to lowercase.
-	result = strings.ToLower(result)
+	for _, p := range processIns.list {
+		if p.pi == pi {
+			return p.name
+		}
+	}
+	return ""
+}
 
-	// Return the result string.
-	return result
+// String returns a string representation of the ProcessIn.
+//
+// The string representation includes the names of the sources separated by
+// commas in registration order. Unregistered sources are omitted from the
+// result. The result is lowercased.
+func (pi ProcessIn) String() string {
+	processIns.RLock()
+	defer processIns.RUnlock()
+
+	names := make([]string, 0, len(processIns.list))
+	for _, p := range processIns.list {
+		if pi&p.pi != 0 {
+			names = append(names, strings.ToLower(p.name))
+		}
+	}
+	return strings.Join(names, ", ")
 }
@@ -0,0 +1,491 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Request module of Command processing golang package.
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// RequestInterfaceV2 extends RequestInterface with transport independent
+// request information used by middleware like authentication, rate limiting
+// and audit.
+type RequestInterfaceV2 interface {
+	RequestInterface
+
+	// GetRemoteAddr returns remote address of request.
+	GetRemoteAddr() string
+
+	// GetHeader returns request header value by name.
+	GetHeader(name string) string
+
+	// GetUser returns user set to request.
+	GetUser() any
+
+	// SetUser sets user to request.
+	SetUser(user any)
+
+	// GetContext returns request context.
+	GetContext() context.Context
+
+	// GetConnectionChannel returns connection channel of request or nil if
+	// the request transport can't receive server pushes.
+	GetConnectionChannel() ConnectionChannel
+
+	// GetPeerCertificates returns verified client certificates of mutual TLS
+	// connection or nil.
+	GetPeerCertificates() []*x509.Certificate
+
+	// GetDataReader returns request data reader. Handlers may use it instead
+	// of GetData to stream large request data without full buffering. Only
+	// one of GetData and GetDataReader should be used by handler.
+	GetDataReader() io.Reader
+}
+
+// ErrDataStreamed is an error returned by HTTPRequest.DataErr when request
+// body was taken by GetDataReader and can't be returned by GetData.
+var ErrDataStreamed = fmt.Errorf("request data is read by data reader")
+
+// WrapRequest returns RequestInterfaceV2 for the request r. If r implements
+// RequestInterfaceV2 it is returned as is. Otherwise r is wrapped into
+// request which returns empty remote address and headers, background
+// context and stores user set by SetUser.
+func WrapRequest(r RequestInterface) RequestInterfaceV2 {
+	if r2, ok := r.(RequestInterfaceV2); ok {
+		return r2
+	}
+	return &wrappedRequest{RequestInterface: r}
+}
+
+// Request returns RequestInterfaceV2 from input data. Requests implementing
+// only RequestInterface are wrapped with WrapRequest.
+func (c *Commands) Request(indata any) (RequestInterfaceV2, error) {
+	req, err := ParseParams[RequestInterface](indata)
+	if err != nil {
+		return nil, err
+	}
+	return WrapRequest(req), nil
+}
+
+// wrappedRequest adds RequestInterfaceV2 methods to RequestInterface.
+type wrappedRequest struct {
+	RequestInterface
+	user any
+}
+
+func (r *wrappedRequest) GetRemoteAddr() string        { return "" }
+func (r *wrappedRequest) GetHeader(name string) string { return "" }
+func (r *wrappedRequest) GetUser() any                 { return r.user }
+func (r *wrappedRequest) SetUser(user any)             { r.user = user }
+func (r *wrappedRequest) GetContext() context.Context  { return context.Background() }
+
+// GetConnectionChannel returns connection channel of wrapped request if it
+// has GetConnectionChannel method or nil.
+func (r *wrappedRequest) GetConnectionChannel() ConnectionChannel {
+	if req, ok := r.RequestInterface.(interface {
+		GetConnectionChannel() ConnectionChannel
+	}); ok {
+		return req.GetConnectionChannel()
+	}
+	return nil
+}
+
+// GetPeerCertificates returns nil as wrapped request has no client
+// certificates.
+func (r *wrappedRequest) GetPeerCertificates() []*x509.Certificate {
+	return nil
+}
+
+// GetDataReader returns data reader of wrapped request if it has
+// GetDataReader method or reader of request data.
+func (r *wrappedRequest) GetDataReader() io.Reader {
+	if req, ok := r.RequestInterface.(interface {
+		GetDataReader() io.Reader
+	}); ok {
+		return req.GetDataReader()
+	}
+	return bytes.NewReader(r.GetData())
+}
+
+// DefaultRequest is a transport independent request. It implements
+// RequestInterfaceV2 and may be embedded into transport requests.
+type DefaultRequest struct {
+	Vars       map[string]string // Request variables
+	Data       []byte            // Request data
+	RemoteAddr string            // Remote address
+	Header     http.Header       // Request headers
+	User       any               // User
+	Ctx        context.Context   // Request context
+	Channel    ConnectionChannel // Connection channel
+
+	PeerCertificates []*x509.Certificate // Client certificates
+	Quota            *QuotaUsage         // Quota usage of executed command
+}
+
+// SetQuotaUsage sets quota usage of executed command.
+func (r *DefaultRequest) SetQuotaUsage(usage QuotaUsage) {
+	r.Quota = &usage
+}
+
+// GetVars returns map of request variables.
+func (r *DefaultRequest) GetVars() map[string]string {
+	return r.Vars
+}
+
+// GetData returns request data.
+func (r *DefaultRequest) GetData() []byte {
+	return r.Data
+}
+
+// GetRemoteAddr returns remote address of request.
+func (r *DefaultRequest) GetRemoteAddr() string {
+	return r.RemoteAddr
+}
+
+// GetHeader returns request header value by name.
+func (r *DefaultRequest) GetHeader(name string) string {
+	return r.Header.Get(name)
+}
+
+// GetUser returns user set to request.
+func (r *DefaultRequest) GetUser() any {
+	return r.User
+}
+
+// SetUser sets user to request.
+func (r *DefaultRequest) SetUser(user any) {
+	r.User = user
+}
+
+// GetContext returns request context or background context if it is not set.
+func (r *DefaultRequest) GetContext() context.Context {
+	if r.Ctx == nil {
+		return context.Background()
+	}
+	return r.Ctx
+}
+
+// SetContext sets request context.
+func (r *DefaultRequest) SetContext(ctx context.Context) {
+	r.Ctx = ctx
+}
+
+// GetConnectionChannel returns connection channel of request.
+func (r *DefaultRequest) GetConnectionChannel() ConnectionChannel {
+	return r.Channel
+}
+
+// GetPeerCertificates returns client certificates of request.
+func (r *DefaultRequest) GetPeerCertificates() []*x509.Certificate {
+	return r.PeerCertificates
+}
+
+// GetDataReader returns reader of request data.
+func (r *DefaultRequest) GetDataReader() io.Reader {
+	return bytes.NewReader(r.Data)
+}
+
+// MaxBodySize is the default maximum size of HTTP request body read by
+// HTTPRequest.GetData.
+const MaxBodySize = 1 << 20
+
+// HTTPRequest contains HTTP request and request variables. It implements
+// RequestInterfaceV2.
+type HTTPRequest struct {
+	*http.Request
+	Vars        map[string]string // Request variables
+	MaxBodySize int64             // Maximum size of request body
+	User        any               // User
+	Quota       *QuotaUsage       // Quota usage of executed command
+
+	data     []byte        // Request body
+	dataErr  error         // Request body read error
+	read     bool          // Request body was read
+	response io.ReadCloser // Streamed response
+}
+
+// NewHTTPRequest creates new HTTPRequest from HTTP request and request
+// variables. Request body is read by GetData method and is limited to
+// MaxBodySize bytes.
+func NewHTTPRequest(r *http.Request, vars map[string]string) *HTTPRequest {
+	return &HTTPRequest{Request: r, Vars: vars, MaxBodySize: MaxBodySize}
+}
+
+// SetQuotaUsage sets quota usage of executed command.
+func (r *HTTPRequest) SetQuotaUsage(usage QuotaUsage) {
+	r.Quota = &usage
+}
+
+// GetVars returns map of request variables.
+func (r *HTTPRequest) GetVars() map[string]string {
+	return r.Vars
+}
+
+// GetData returns HTTP request body. The body is read once on first call and
+// is limited to MaxBodySize bytes. It returns nil if the body is empty, can't
+// be read or exceeds the limit; use DataErr to get the read error.
+func (r *HTTPRequest) GetData() []byte {
+	if !r.read {
+		r.read = true
+		r.data, r.dataErr = readBody(r.Request, r.MaxBodySize)
+	}
+	return r.data
+}
+
+// DataErr returns HTTP request body read error.
+func (r *HTTPRequest) DataErr() error {
+	r.GetData()
+	return r.dataErr
+}
+
+// GetDataReader returns HTTP request body reader to stream large uploads.
+// The body is not limited by MaxBodySize, handlers should limit it
+// themselves if needed. If the body was already read by GetData, reader of
+// the read data is returned. After GetDataReader call GetData returns nil and
+// DataErr returns ErrDataStreamed.
+func (r *HTTPRequest) GetDataReader() io.Reader {
+	if r.read {
+		return bytes.NewReader(r.data)
+	}
+	r.read = true
+	r.dataErr = ErrDataStreamed
+	if r.Request == nil || r.Request.Body == nil {
+		return http.NoBody
+	}
+	return r.Request.Body
+}
+
+// SetResponseReader sets reader of response streamed to HTTP client, see
+// StreamResponse.
+func (r *HTTPRequest) SetResponseReader(rc io.ReadCloser) {
+	r.response = rc
+}
+
+// ResponseReader returns reader of streamed response or nil.
+func (r *HTTPRequest) ResponseReader() io.ReadCloser {
+	return r.response
+}
+
+// GetRemoteAddr returns remote address of HTTP request.
+func (r *HTTPRequest) GetRemoteAddr() string {
+	if r.Request == nil {
+		return ""
+	}
+	return r.Request.RemoteAddr
+}
+
+// GetHeader returns HTTP request header value by name.
+func (r *HTTPRequest) GetHeader(name string) string {
+	if r.Request == nil {
+		return ""
+	}
+	return r.Request.Header.Get(name)
+}
+
+// GetUser returns user set to request.
+func (r *HTTPRequest) GetUser() any {
+	return r.User
+}
+
+// SetUser sets user to request.
+func (r *HTTPRequest) SetUser(user any) {
+	r.User = user
+}
+
+// GetContext returns HTTP request context or background context if the
+// request has no HTTP request.
+func (r *HTTPRequest) GetContext() context.Context {
+	if r.Request == nil {
+		return context.Background()
+	}
+	return r.Request.Context()
+}
+
+// SetContext sets HTTP request context. It does nothing if the request has
+// no HTTP request.
+func (r *HTTPRequest) SetContext(ctx context.Context) {
+	if r.Request == nil {
+		return
+	}
+	r.Request = r.Request.WithContext(ctx)
+}
+
+// GetConnectionChannel returns nil as HTTP request can't receive server
+// pushes.
+func (r *HTTPRequest) GetConnectionChannel() ConnectionChannel {
+	return nil
+}
+
+// GetPeerCertificates returns client certificates of HTTP request TLS
+// connection.
+func (r *HTTPRequest) GetPeerCertificates() []*x509.Certificate {
+	if r.Request == nil || r.Request.TLS == nil {
+		return nil
+	}
+	return r.Request.TLS.PeerCertificates
+}
+
+// readBody reads HTTP request body limited to maxSize bytes.
+func readBody(r *http.Request, maxSize int64) ([]byte, error) {
+	if r == nil || r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if maxSize <= 0 {
+		maxSize = MaxBodySize
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, &http.MaxBytesError{Limit: maxSize}
+	}
+
+	return data, nil
+}
+
+// WSConn is a websocket connection interface. The gorilla websocket
+// connection implements it.
+type WSConn interface {
+	WriteMessage(messageType int, data []byte) error
+}
+
+// WSRequest contains websocket connection, request variables and request
+// data. It implements RequestInterfaceV2. The remote address, headers and
+// context of the websocket handshake request may be set by transport.
+type WSRequest struct {
+	Conn WSConn // Websocket connection
+	DefaultRequest
+}
+
+// NewWSRequest creates new WSRequest from websocket connection, request
+// variables and request data. If conn implements ConnectionChannel (like
+// WSChannel does) it is used as the request connection channel.
+func NewWSRequest(conn WSConn, vars map[string]string, data []byte) *WSRequest {
+	r := &WSRequest{Conn: conn, DefaultRequest: DefaultRequest{Vars: vars, Data: data}}
+	if ch, ok := conn.(ConnectionChannel); ok {
+		r.Channel = ch
+	}
+	return r
+}
+
+// wsTextMessage is websocket text message type.
+const wsTextMessage = 1
+
+// WSChannel is a websocket connection channel. It serializes writes to
+// websocket connection, so one WSChannel should be created per connection
+// and used for both command answers and subscription pushes.
+type WSChannel struct {
+	conn      WSConn
+	frameSize int        // Fragmentation frame size
+	progress  WSProgress // Fragmented message progress callback
+	compress  int        // Minimal size of compressed message, off if 0
+	mut       sync.Mutex
+}
+
+// wsCompressor is a websocket connection with per-message deflate
+// compression. The gorilla websocket connection implements it.
+type wsCompressor interface {
+	EnableWriteCompression(enable bool)
+}
+
+// WSProgress is a callback of fragmented message sending progress. It gets
+// number of sent bytes and total message size.
+type WSProgress func(sent, total int)
+
+// wsNextWriter is a websocket connection which can write message by frames.
+// The gorilla websocket connection implements it.
+type wsNextWriter interface {
+	NextWriter(messageType int) (io.WriteCloser, error)
+}
+
+// NewWSChannel creates new WSChannel for websocket connection.
+func NewWSChannel(conn WSConn) *WSChannel {
+	return &WSChannel{conn: conn}
+}
+
+// Send sends data to websocket connection as text message.
+func (ch *WSChannel) Send(data []byte) error {
+	return ch.WriteMessage(wsTextMessage, data)
+}
+
+// Close closes websocket connection if it has Close method.
+func (ch *WSChannel) Close() error {
+	if closer, ok := ch.conn.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// SetFragmentation sets frame size of large messages. Messages larger than
+// frameSize are written to connection by frameSize parts, so big command
+// results don't exceed proxies frame limits. The progress callback is called
+// after each part and may be nil. Fragmentation needs connection with
+// NextWriter method, like gorilla websocket connection, which write buffer
+// size should not exceed frameSize. The receiver reassembles fragmented
+// message automatically. Fragmentation is off if frameSize is 0.
+func (ch *WSChannel) SetFragmentation(frameSize int, progress WSProgress) {
+	ch.mut.Lock()
+	defer ch.mut.Unlock()
+	ch.frameSize, ch.progress = frameSize, progress
+}
+
+// SetCompression sets minimal size of messages compressed with websocket
+// per-message deflate, small messages are sent uncompressed. Compression
+// needs connection with EnableWriteCompression method, like gorilla websocket
+// connection, and should be negotiated with client during handshake.
+// Compression is off if threshold is 0.
+func (ch *WSChannel) SetCompression(threshold int) {
+	ch.mut.Lock()
+	defer ch.mut.Unlock()
+	ch.compress = threshold
+}
+
+// WriteMessage writes message to websocket connection.
+func (ch *WSChannel) WriteMessage(messageType int, data []byte) error {
+	ch.mut.Lock()
+	defer ch.mut.Unlock()
+
+	if conn, ok := ch.conn.(wsCompressor); ok && ch.compress > 0 {
+		conn.EnableWriteCompression(len(data) > ch.compress)
+	}
+
+	if conn, ok := ch.conn.(wsNextWriter); ok && ch.frameSize > 0 &&
+		len(data) > ch.frameSize {
+		return ch.writeFragmented(conn, messageType, data)
+	}
+	return ch.conn.WriteMessage(messageType, data)
+}
+
+// writeFragmented writes message to connection by frame size parts. It
+// should be called under the WSChannel lock.
+func (ch *WSChannel) writeFragmented(conn wsNextWriter, messageType int,
+	data []byte) error {
+
+	w, err := conn.NextWriter(messageType)
+	if err != nil {
+		return err
+	}
+	for sent := 0; sent < len(data); {
+		n := min(ch.frameSize, len(data)-sent)
+		if _, err = w.Write(data[sent : sent+n]); err != nil {
+			w.Close()
+			return err
+		}
+		sent += n
+		if ch.progress != nil {
+			ch.progress(sent, len(data))
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,223 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Network access control lists and trusted proxies.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ACL is network access control list. It checks client address against
+// CIDR allow and deny lists and resolves real client address of requests
+// received from trusted proxies by X-Forwarded-For header.
+type ACL struct {
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+	trusted []netip.Prefix
+}
+
+// NewACL creates ACL from CIDR lists, single addresses are accepted too. If
+// allow list is empty all addresses not in deny list are allowed. Proxies
+// from trusted list may set client address in X-Forwarded-For header or
+// PROXY protocol header.
+func NewACL(allow, deny, trusted []string) (acl *ACL, err error) {
+	acl = &ACL{}
+	if acl.allow, err = parsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if acl.deny, err = parsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	if acl.trusted, err = parsePrefixes(trusted); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// parsePrefixes parses CIDR or address strings.
+func parsePrefixes(list []string) (prefixes []netip.Prefix, err error) {
+	for _, s := range list {
+		var prefix netip.Prefix
+		if strings.Contains(s, "/") {
+			prefix, err = netip.ParsePrefix(s)
+		} else {
+			var addr netip.Addr
+			addr, err = netip.ParseAddr(s)
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("wrong acl address '%s': %w", s, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return
+}
+
+// contains returns true if addr is in prefixes list.
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed returns true if client address is allowed.
+func (acl *ACL) Allowed(addr netip.Addr) bool {
+	if contains(acl.deny, addr) {
+		return false
+	}
+	return len(acl.allow) == 0 || contains(acl.allow, addr)
+}
+
+// ClientAddr returns real client address of request. The X-Forwarded-For
+// header is processed from right to left while addresses belong to trusted
+// proxies, so clients can't spoof their address.
+func (acl *ACL) ClientAddr(r *http.Request) (netip.Addr, error) {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr := addrPort.Addr()
+	if !contains(acl.trusted, addr) {
+		return addr, nil
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"),
+		","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		s := strings.TrimSpace(forwarded[i])
+		if s == "" {
+			continue
+		}
+		next, err := netip.ParseAddr(s)
+		if err != nil {
+			break
+		}
+		addr = next
+		if !contains(acl.trusted, addr) {
+			break
+		}
+	}
+	return addr, nil
+}
+
+// Handler returns HTTP handler which rejects requests of not allowed clients
+// with 403 status and sets request RemoteAddr to real client address before
+// calling next handler.
+func (acl *ACL) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, err := acl.ClientAddr(r)
+		if err != nil || !acl.Allowed(addr) {
+			http.Error(w, http.StatusText(http.StatusForbidden),
+				http.StatusForbidden)
+			return
+		}
+		if remote, _ := netip.ParseAddrPort(r.RemoteAddr); remote.Addr() != addr {
+			r = r.Clone(r.Context())
+			r.RemoteAddr = netip.AddrPortFrom(addr, 0).String()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// proxyHeaderTimeout is time to read PROXY protocol header.
+const proxyHeaderTimeout = 5 * time.Second
+
+// ProxyListener returns listener which reads PROXY protocol v1 header of
+// connections accepted from trusted proxies and uses its source address as
+// connection remote address. Connections from other addresses are accepted
+// as is.
+func (acl *ACL) ProxyListener(l net.Listener) net.Listener {
+	return &proxyListener{Listener: l, acl: acl}
+}
+
+// proxyListener is PROXY protocol listener.
+type proxyListener struct {
+	net.Listener
+	acl *ACL
+}
+
+// Accept accepts connection and wraps connections from trusted proxies.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil || !contains(l.acl.trusted, addrPort.Addr()) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn is connection with PROXY protocol header. The header is read
+// lazily on first Read or RemoteAddr call, so Accept is not blocked.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+	err    error
+	once   sync.Once
+}
+
+// readHeader reads PROXY protocol v1 header like
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func (c *proxyConn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.err = err
+		return
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		c.err = fmt.Errorf("wrong proxy protocol header")
+		return
+	}
+	if fields[1] == "UNKNOWN" {
+		return
+	}
+	if len(fields) != 6 {
+		c.err = fmt.Errorf("wrong proxy protocol header")
+		return
+	}
+	addrPort, err := netip.ParseAddrPort(net.JoinHostPort(fields[2], fields[4]))
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.remote = net.TCPAddrFromAddrPort(addrPort)
+}
+
+// Read reads connection data after PROXY protocol header.
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns client address from PROXY protocol header.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
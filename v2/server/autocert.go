@@ -0,0 +1,70 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Let's Encrypt certificates of server.
+
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+
+	"github.com/kirill-scherba/command/v2"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// AutocertOptions contains options of obtaining and renewing certificates
+// automatically via ACME (Let's Encrypt).
+type AutocertOptions struct {
+	Hosts []string // Allowed host names, required
+	Email string   // Contact email, optional
+
+	// Cache stores certificates. It may be any autocert.Cache implementation,
+	// like database or cloud storage. The autocert.DirCache("certs") is used
+	// if it is nil.
+	Cache autocert.Cache
+
+	// HTTPAddr is address of HTTP server which serves ACME HTTP-01
+	// challenges and redirects other requests to HTTPS, usually ":80". The
+	// HTTP server is not started if it is empty, then TLS-ALPN-01 challenge
+	// is used.
+	HTTPAddr string
+}
+
+// autocertTLSConfig returns TLS configuration which obtains and renews
+// certificates automatically and starts challenge HTTP server if configured.
+func (srv *Server) autocertTLSConfig(cfg *tls.Config) *tls.Config {
+	opts := srv.opts.Autocert
+	m := opts.manager()
+
+	if opts.HTTPAddr != "" {
+		srv.acme = &http.Server{Addr: opts.HTTPAddr, Handler: m.HTTPHandler(nil)}
+		command.Go(command.GoACMEServer, func() {
+			err := srv.acme.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				log.Println("ACME HTTP server stopped:", err)
+			}
+		})
+	}
+
+	autoCfg := m.TLSConfig()
+	cfg.GetCertificate = autoCfg.GetCertificate
+	cfg.NextProtos = append(cfg.NextProtos, autoCfg.NextProtos...)
+	return cfg
+}
+
+// manager returns ACME certificates manager of options.
+func (opts *AutocertOptions) manager() *autocert.Manager {
+	cache := opts.Cache
+	if cache == nil {
+		cache = autocert.DirCache("certs")
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(opts.Hosts...),
+		Cache:      cache,
+		Email:      opts.Email,
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Server hooks.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// Hooks are server callbacks which let applications inject headers, enrich
+// requests or veto connections without changing server code. Any hook may be
+// nil.
+type Hooks struct {
+	// OnConnect is called when HTTP command request or websocket connection
+	// is received, before the websocket upgrade. Headers set to w are sent
+	// to client. The connection is rejected with 403 status if it returns an
+	// error.
+	OnConnect func(w http.ResponseWriter, r *http.Request) error
+
+	// OnDisconnect is called when websocket connection is closed.
+	OnDisconnect func(r *http.Request, ch *command.WSChannel)
+
+	// OnMessage is called before command is executed. It may enrich the
+	// request, like set user. The command is not executed and the error is
+	// returned to client if it returns an error.
+	OnMessage func(r *http.Request, name string, req command.RequestInterfaceV2) error
+
+	// OnResponse is called after command is executed and may change its
+	// result or error. The header is HTTP response header or nil for
+	// websocket commands.
+	OnResponse func(r *http.Request, name string, header http.Header,
+		data []byte, err error) ([]byte, error)
+}
+
+// onConnect calls OnConnect hook and writes 403 status if the connection is
+// rejected. It returns false if the connection is rejected.
+func (h *Hooks) onConnect(w http.ResponseWriter, r *http.Request) bool {
+	if h.OnConnect == nil {
+		return true
+	}
+	if err := h.OnConnect(w, r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// exec executes command of commands c with OnMessage and OnResponse hooks.
+func (srv *Server) exec(c *command.Commands, r *http.Request, name string,
+	processIn command.ProcessIn, req command.RequestInterfaceV2,
+	header http.Header) ([]byte, error) {
+
+	h := &srv.opts.Hooks
+	var data []byte
+	var err error
+	if h.OnMessage != nil {
+		err = h.OnMessage(r, name, req)
+	}
+	if err == nil {
+		data, err = c.Exec(name, processIn, req)
+	}
+	if h.OnResponse != nil {
+		failed := err != nil
+		data, err = h.OnResponse(r, name, header, data, err)
+
+		// Close response streamed by command which result is rejected
+		if s, ok := req.(command.ResponseStreamer); ok && !failed && err != nil {
+			if rc := s.ResponseReader(); rc != nil {
+				rc.Close()
+				s.SetResponseReader(nil)
+			}
+		}
+	}
+	return data, err
+}
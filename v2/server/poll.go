@@ -0,0 +1,311 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Long polling transport.
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// PollSessionHeader is a header with long polling session ID. Server creates
+// new session and returns its ID in this header if request has no session.
+const PollSessionHeader = "X-Poll-Session"
+
+// Long polling defaults.
+const (
+	DefaultPollWait  = 30 * time.Second // Wait of GET request without wait parameter
+	MaxPollWait      = time.Minute      // Maximum wait of GET request
+	DefaultPollIdle  = time.Minute      // Idle timeout of session
+	DefaultPollQueue = 1000             // Maximum queued pushes of session
+)
+
+// ErrPollQueueFull is an error returned when push is sent to long polling
+// session which queue is full.
+var ErrPollQueueFull = fmt.Errorf("poll queue is full")
+
+// pollChannel is a long polling session connection channel. It queues
+// pushes until they are received by GET request.
+type pollChannel struct {
+	queue   [][]byte
+	max     int
+	notify  chan struct{}
+	last    time.Time // Last request time
+	waiting int       // Number of waiting GET requests
+	ctx     context.Context
+	cancel  context.CancelFunc
+	*sync.Mutex
+}
+
+// Send queues data pushed to long polling session.
+func (ch *pollChannel) Send(data []byte) error {
+	ch.Lock()
+	if len(ch.queue) >= ch.max {
+		ch.Unlock()
+		return ErrPollQueueFull
+	}
+	ch.queue = append(ch.queue, append([]byte(nil), data...))
+	ch.Unlock()
+
+	select {
+	case ch.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// take returns and removes queued pushes.
+func (ch *pollChannel) take() (queue [][]byte) {
+	ch.Lock()
+	defer ch.Unlock()
+	queue, ch.queue = ch.queue, nil
+	return
+}
+
+// pollSessions are long polling sessions by ID.
+type pollSessions struct {
+	m map[string]*pollChannel
+	*sync.Mutex
+}
+
+// backlog returns the longest queue and number of queued pushes of long
+// polling sessions, it is subscription backlog queue.
+func (ps pollSessions) backlog() (longest, total int) {
+	ps.Lock()
+	channels := make([]*pollChannel, 0, len(ps.m))
+	for _, ch := range ps.m {
+		channels = append(channels, ch)
+	}
+	ps.Unlock()
+
+	for _, ch := range channels {
+		ch.Lock()
+		n := len(ch.queue)
+		ch.Unlock()
+		total += n
+		longest = max(longest, n)
+	}
+	return
+}
+
+// servePoll is long polling handler. POST request executes command from
+// request body, like websocket message, and returns its answer. GET request
+// waits for pushes up to wait query parameter seconds and returns json array
+// of queued pushes: json pushes as is and other pushes as strings. DELETE
+// request closes the session, it requires session ID.
+func (srv *Server) servePoll(w http.ResponseWriter, r *http.Request) {
+	if !srv.opts.Hooks.onConnect(w, r) {
+		return
+	}
+
+	// Check method, session is required to close it
+	id := r.Header.Get(PollSessionHeader)
+	switch r.Method {
+	case http.MethodPost, http.MethodGet:
+	case http.MethodDelete:
+		if id == "" {
+			http.Error(w, "poll session required", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get or create session
+	ch, ok := srv.pollSession(id)
+	if !ok {
+		http.Error(w, "poll session not found", http.StatusNotFound)
+		return
+	}
+	if id == "" {
+		id, ch = srv.newPollSession()
+	}
+	w.Header().Set(PollSessionHeader, id)
+	defer srv.touchPoll(ch)
+
+	switch r.Method {
+	case http.MethodPost:
+		srv.pollCommand(w, r, ch)
+	case http.MethodGet:
+		srv.pollPushes(w, r, ch)
+	case http.MethodDelete:
+		srv.closePoll(id)
+	}
+}
+
+// pollCommand executes command from request body. Body larger than
+// WSReadLimit is rejected.
+func (srv *Server) pollCommand(w http.ResponseWriter, r *http.Request,
+	ch *pollChannel) {
+
+	limit := srv.opts.WSReadLimit
+	body := io.Reader(r.Body)
+	if limit > 0 {
+		body = io.LimitReader(body, limit+1)
+	}
+	message, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit > 0 && int64(len(message)) > limit {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	name, vars := srv.c.ParseCommand(message)
+	request := &command.DefaultRequest{Vars: vars, RemoteAddr: r.RemoteAddr,
+		Header: r.Header, Ctx: ch.ctx, Channel: ch}
+	if r.TLS != nil {
+		request.PeerCertificates = r.TLS.PeerCertificates
+	}
+	res, err := srv.exec(srv.c, r, name, command.WS, request, w.Header())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write(res)
+}
+
+// pollPushes waits for pushes and writes them.
+func (srv *Server) pollPushes(w http.ResponseWriter, r *http.Request,
+	ch *pollChannel) {
+
+	wait := DefaultPollWait
+	if s := r.URL.Query().Get("wait"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds < 0 {
+			http.Error(w, "wrong wait parameter", http.StatusBadRequest)
+			return
+		}
+		wait = min(time.Duration(seconds)*time.Second, MaxPollWait)
+	}
+
+	ch.Lock()
+	ch.waiting++
+	ch.Unlock()
+	defer func() {
+		ch.Lock()
+		ch.waiting--
+		ch.Unlock()
+	}()
+
+	queue := ch.take()
+	if len(queue) == 0 && wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ch.notify:
+			queue = ch.take()
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		case <-ch.ctx.Done():
+		}
+	}
+
+	pushes := make([]json.RawMessage, 0, len(queue))
+	for _, data := range queue {
+		if !json.Valid(data) {
+			data, _ = json.Marshal(string(data))
+		}
+		pushes = append(pushes, data)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pushes)
+}
+
+// pollSession returns session by ID and closes expired sessions. It returns
+// nil channel and true if id is empty.
+func (srv *Server) pollSession(id string) (*pollChannel, bool) {
+	idle := srv.opts.PollIdle
+	if idle <= 0 {
+		idle = DefaultPollIdle
+	}
+
+	var expired []*pollChannel
+	srv.polls.Lock()
+	for sid, ch := range srv.polls.m {
+		ch.Lock()
+		if ch.waiting == 0 && time.Since(ch.last) > idle {
+			delete(srv.polls.m, sid)
+			expired = append(expired, ch)
+		}
+		ch.Unlock()
+	}
+	ch, ok := srv.polls.m[id]
+	srv.polls.Unlock()
+
+	for _, ch := range expired {
+		srv.disconnectPoll(ch)
+	}
+	if id == "" {
+		return nil, true
+	}
+	if ok {
+		srv.touchPoll(ch)
+	}
+	return ch, ok
+}
+
+// newPollSession creates new long polling session.
+func (srv *Server) newPollSession() (string, *pollChannel) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	max := srv.opts.PollQueue
+	if max <= 0 {
+		max = DefaultPollQueue
+	}
+	ch := &pollChannel{max: max, notify: make(chan struct{}, 1),
+		last: time.Now(), Mutex: new(sync.Mutex)}
+	ch.ctx, ch.cancel = context.WithCancel(context.Background())
+
+	srv.polls.Lock()
+	srv.polls.m[id] = ch
+	srv.polls.Unlock()
+	return id, ch
+}
+
+// touchPoll sets last request time of session.
+func (srv *Server) touchPoll(ch *pollChannel) {
+	ch.Lock()
+	ch.last = time.Now()
+	ch.Unlock()
+}
+
+// closePoll closes long polling session.
+func (srv *Server) closePoll(id string) {
+	srv.polls.Lock()
+	ch, ok := srv.polls.m[id]
+	delete(srv.polls.m, id)
+	srv.polls.Unlock()
+	if ok {
+		srv.disconnectPoll(ch)
+	}
+}
+
+// disconnectPoll unsubscribes closed session and cancels its context.
+func (srv *Server) disconnectPoll(ch *pollChannel) {
+	ch.cancel()
+	if srv.s != nil {
+		srv.s.Disconnect(ch)
+	}
+}
@@ -0,0 +1,241 @@
+package server_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kirill-scherba/command/v2"
+	"github.com/kirill-scherba/command/v2/server"
+	"github.com/kirill-scherba/command/v2/servertest"
+)
+
+func TestServer(t *testing.T) {
+
+	c := command.New()
+	c.Add("hello", "say hello", command.HTTP|command.WS, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte(fmt.Sprintf("Hello %s!", vars["name"])), nil
+		},
+	)
+	s := command.NewSubscription()
+	s.AddCommands(c, command.WS)
+
+	ts := servertest.NewServer(c, s, server.Options{Prefix: "/api/v1", WSPath: "/ws"})
+	defer ts.Close()
+
+	// HTTP command
+	if res := ts.Call(t, "hello", "John"); string(res) != "Hello John!" {
+		t.Errorf("wrong HTTP answer: %s", res)
+	}
+
+	// Websocket command
+	conn := ts.Dial(t, nil)
+	defer conn.Close()
+	for _, test := range []struct{ send, want string }{
+		{"hello/Ws", "Hello Ws!"},
+		{"subscribe/hello", "ok"},
+	} {
+		if res := conn.Exec(t, test.send); string(res) != test.want {
+			t.Errorf("wrong websocket answer: %s", res)
+		}
+	}
+	if n := s.ConnectionsCount("hello"); n != 1 {
+		t.Errorf("websocket connection should be subscribed, got %d", n)
+	}
+}
+
+func TestQuotaHeaders(t *testing.T) {
+
+	c := command.New()
+	c.AddBatch([]command.CommandSpec{{
+		Cmd: "export", ProcessIn: command.HTTP, Quota: &command.Quota{Limit: 1},
+		Handler: func(cmd *command.CommandData, processIn command.ProcessIn,
+			data any) ([]byte, error) {
+			return []byte("ok"), nil
+		},
+	}})
+	c.SetQuotaStore(command.NewMemoryQuotaStore(), nil)
+	ts := servertest.NewServer(c, nil, server.Options{})
+	defer ts.Close()
+
+	for _, test := range []struct {
+		status    int
+		remaining string
+	}{
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, "0"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL("export"), nil)
+		req.Header.Set(command.APIKeyHeader, "key1")
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != test.status ||
+			res.Header.Get(command.QuotaLimitHeader) != "1" ||
+			res.Header.Get(command.QuotaRemainingHeader) != test.remaining {
+			t.Errorf("wrong quota answer: %d %v", res.StatusCode, res.Header)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+
+	c := command.New()
+	c.Add("whoami", "", command.HTTP|command.WS, "", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			req, _ := c.Request(data)
+			return []byte(fmt.Sprint(req.GetUser())), nil
+		},
+	)
+
+	disconnected := make(chan struct{})
+	ts := servertest.NewServer(c, nil, server.Options{Hooks: server.Hooks{
+		OnConnect: func(w http.ResponseWriter, r *http.Request) error {
+			if r.Header.Get("X-Token") == "" {
+				return fmt.Errorf("token required")
+			}
+			w.Header().Set("X-Node", "node1")
+			return nil
+		},
+		OnDisconnect: func(r *http.Request, ch *command.WSChannel) {
+			close(disconnected)
+		},
+		OnMessage: func(r *http.Request, name string, req command.RequestInterfaceV2) error {
+			req.SetUser(r.Header.Get("X-Token"))
+			return nil
+		},
+		OnResponse: func(r *http.Request, name string, header http.Header,
+			data []byte, err error) ([]byte, error) {
+			if header != nil {
+				header.Set("X-Command", name)
+			}
+			return append(data, '!'), err
+		},
+	}})
+	defer ts.Close()
+
+	// Rejected connection
+	if _, status, err := ts.Do(http.MethodGet, nil, "whoami"); err != nil ||
+		status != http.StatusForbidden {
+		t.Errorf("connection should be rejected, got %d, %v", status, err)
+	}
+
+	// HTTP command
+	req, _ := http.NewRequest(http.MethodGet, ts.URL("whoami"), nil)
+	req.Header.Set("X-Token", "alice")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "alice!" || res.Header.Get("X-Node") != "node1" ||
+		res.Header.Get("X-Command") != "whoami" {
+		t.Errorf("wrong HTTP answer: %s, %v", body, res.Header)
+	}
+
+	// Websocket command
+	conn := ts.Dial(t, http.Header{"X-Token": {"bob"}})
+	if conn.Response.Header.Get("X-Node") != "node1" {
+		t.Errorf("wrong websocket handshake headers: %v", conn.Response.Header)
+	}
+	if res := conn.Exec(t, "whoami"); string(res) != "bob!" {
+		t.Errorf("wrong websocket answer: %s", res)
+	}
+	conn.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Error("OnDisconnect should be called")
+	}
+}
+
+func TestWSFragmentation(t *testing.T) {
+
+	c := command.New()
+	c.Add("big", "", command.WS, "", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			return []byte(strings.Repeat("x", 10000)), nil
+		},
+	)
+	var frames atomic.Int32
+	ts := servertest.NewServer(c, nil, server.Options{WSFrameSize: 1024,
+		WSProgress: func(sent, total int) { frames.Add(1) }})
+	defer ts.Close()
+
+	conn := ts.Dial(t, nil)
+	defer conn.Close()
+	if res := conn.Exec(t, "big"); len(res) != 10000 {
+		t.Fatalf("wrong reassembled message %d", len(res))
+	}
+	if n := frames.Load(); n != 10 {
+		t.Errorf("wrong number of frames: %d", n)
+	}
+}
+
+func TestRouteConflicts(t *testing.T) {
+
+	c := command.New()
+	admin := c.AddGroup("admin", "", command.HTTP)
+	admin.Add("list", "", command.HTTP, "{page}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			return []byte("group"), nil
+		},
+	)
+	c.SetNameValidator(func(name string) error { return nil })
+	c.Add("admin/list", "", command.HTTP, "{n}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			return []byte("duplicate"), nil
+		},
+	)
+
+	// Conflicts are reported, duplicate route is not registered
+	var conflicts []command.RouteConflict
+	ts := servertest.NewServer(c, nil, server.Options{
+		OnRouteConflict: func(mount string, rc command.RouteConflict) {
+			if mount != "/" {
+				t.Errorf("wrong conflict mount: %s", mount)
+			}
+			conflicts = append(conflicts, rc)
+		},
+	})
+	defer ts.Close()
+	if res := ts.Call(t, "admin/list", "1"); string(res) != "group" {
+		t.Errorf("first registered route should be served, got %s", res)
+	}
+	if len(conflicts) != 1 || conflicts[0].Shadow {
+		t.Errorf("wrong route conflicts: %v", conflicts)
+	}
+}
+
+func TestParamEscaping(t *testing.T) {
+
+	c := command.New()
+	c.Add("echo", "", command.HTTP, "{a}/{b}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte(vars["a"] + "|" + vars["b"]), nil
+		},
+	)
+	ts := servertest.NewServer(c, nil, server.Options{})
+	defer ts.Close()
+
+	res := ts.Call(t, "echo", command.EscapeParam("a/b c"), command.EscapeParam("x+ü"))
+	if string(res) != "a/b c|x+ü" {
+		t.Errorf("wrong escaped parameters: %s", res)
+	}
+}
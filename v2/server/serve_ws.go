@@ -0,0 +1,84 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Handle and process websocket commands.
+
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/kirill-scherba/command/v2"
+)
+
+// serveWS upgrades HTTP connection to websocket and processes commands
+// received from it.
+func (srv *Server) serveWS(w http.ResponseWriter, r *http.Request) {
+	if !srv.opts.Hooks.onConnect(w, r) {
+		return
+	}
+
+	// Upgrade HTTP connection to WebSocket
+	upgrader := websocket.Upgrader{
+		WriteBufferSize:   srv.opts.WSFrameSize,
+		EnableCompression: srv.opts.WSCompression > 0,
+	}
+	conn, err := upgrader.Upgrade(w, r, w.Header())
+	if err != nil {
+		log.Println("failed to upgrade connection:", err)
+		return
+	}
+	defer conn.Close()
+	defer command.Track(command.GoWebsocket)()
+	if srv.opts.WSReadLimit > 0 {
+		conn.SetReadLimit(srv.opts.WSReadLimit)
+	}
+
+	ch := command.NewWSChannel(conn)
+	ch.SetFragmentation(srv.opts.WSFrameSize, srv.opts.WSProgress)
+	ch.SetCompression(srv.opts.WSCompression)
+	if srv.s != nil {
+		defer srv.s.Disconnect(ch)
+	}
+	if srv.opts.Hooks.OnDisconnect != nil {
+		defer srv.opts.Hooks.OnDisconnect(r, ch)
+	}
+
+	for {
+		// Read message from client
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		// Process message
+		srv.processMessage(r, ch, message)
+	}
+}
+
+// processMessage executes command from websocket message and writes answer.
+func (srv *Server) processMessage(r *http.Request, ch *command.WSChannel,
+	message []byte) {
+
+	// Parse message
+	name, vars := srv.c.ParseCommand(message)
+
+	// Execute command
+	request := command.NewWSRequest(ch, vars, nil)
+	request.RemoteAddr = r.RemoteAddr
+	request.Header = r.Header
+	request.Ctx = r.Context()
+	if r.TLS != nil {
+		request.PeerCertificates = r.TLS.PeerCertificates
+	}
+	res, err := srv.exec(srv.c, r, name, command.WS, request, nil)
+	if err != nil {
+		res = []byte(err.Error())
+	}
+
+	// Write answer
+	ch.Send(res)
+}
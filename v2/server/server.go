@@ -0,0 +1,433 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server is HTTP and websocket transport adapter of the Command
+// processing package.
+//
+// The Server registers HTTP handlers for commands processed in command.HTTP
+// and websocket handler for commands processed in command.WS, and serves
+// them over HTTP/1.1, HTTP/2 with TLS and optional HTTP/3.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// Options contains Server options.
+type Options struct {
+	Addr   string // Server local address, ":8080" by default
+	Prefix string // Commands path prefix, "/" by default
+	WSPath string // Websocket handler path, websocket is off if empty
+
+	// WSFrameSize is maximum websocket frame size. Larger messages are sent
+	// fragmented, it is 4096 bytes by default.
+	WSFrameSize int
+
+	// WSReadLimit is maximum size of reassembled websocket message received
+	// from client, it is not limited if 0.
+	WSReadLimit int64
+
+	// WSCompression is minimal size of websocket messages compressed with
+	// per-message deflate if client supports it, compression is off if 0.
+	WSCompression int
+
+	// WSProgress is called after each frame of fragmented websocket message
+	// and may be nil.
+	WSProgress command.WSProgress
+
+	// PollPath is long polling handler path for clients which networks
+	// block websocket, long polling is off if empty. Commands processed in
+	// command.WS are executed by POST requests and their pushes are received
+	// by GET requests, see PollSessionHeader.
+	PollPath string
+
+	// PollIdle is idle timeout of long polling sessions, DefaultPollIdle by
+	// default.
+	PollIdle time.Duration
+
+	// PollQueue is maximum number of pushes queued in long polling session,
+	// DefaultPollQueue by default.
+	PollQueue int
+
+	// MetricsPath is Prometheus scrape endpoint path of commands execution
+	// statistics, metrics are off if empty. See also Commands PushMetrics.
+	MetricsPath string
+
+	// ReadyPath is readiness probe path, it is off if empty. The probe
+	// answers subscription health report in json format with status 200
+	// when subscription is healthy and 503 when its backlog exceeds
+	// thresholds, see command.Subscription SetBacklogThresholds.
+	ReadyPath string
+
+	// TLS configuration. TLS is on when TLSConfig, CertFile and KeyFile or
+	// Autocert are set.
+	TLSConfig *tls.Config
+	CertFile  string
+	KeyFile   string
+
+	// Autocert obtains and renews certificates automatically via ACME when
+	// it is not nil.
+	Autocert *AutocertOptions
+
+	// ClientCAs turns on mutual TLS: clients should present certificates
+	// signed by these CAs. Verified client certificates are available to
+	// command handlers by the request GetPeerCertificates method.
+	ClientCAs *x509.CertPool
+
+	// ClientAuth is client certificates policy used with ClientCAs,
+	// tls.RequireAndVerifyClientCert by default.
+	ClientAuth tls.ClientAuthType
+
+	// ACL checks client addresses and resolves real client address behind
+	// trusted proxies, it is off if nil.
+	ACL *ACL
+
+	// ProxyProtocol turns on PROXY protocol header processing of connections
+	// from ACL trusted proxies.
+	ProxyProtocol bool
+
+	// Signer signs HTTP responses, the signature is sent in
+	// command.SignatureHeader. Responses are not signed if nil.
+	Signer command.Signer
+
+	// Hooks are server callbacks, see Hooks.
+	Hooks Hooks
+
+	// OnRouteConflict is called with routes conflicts of commands mounted
+	// by New and Mount, see command.Commands RouteConflicts. The mount is
+	// host and path prefix of mounted commands, like "/api/v2/". Duplicate
+	// routes are not registered, so only the first of them is served. It
+	// may be nil.
+	OnRouteConflict func(mount string, rc command.RouteConflict)
+
+	// DisableHTTP2 turns off HTTP/2 which is on by default with TLS.
+	DisableHTTP2 bool
+
+	// HTTP3 creates HTTP/3 server, like quic-go http3.Server, which is
+	// started with TLS server on the same address.
+	HTTP3 func(addr string, tlsConfig *tls.Config, handler http.Handler) HTTP3Server
+}
+
+// HTTP3Server is HTTP/3 server interface implemented by quic-go
+// http3.Server.
+type HTTP3Server interface {
+	ListenAndServe() error
+	Close() error
+}
+
+// Server is HTTP and websocket server of commands.
+type Server struct {
+	c    *command.Commands
+	s    *command.Subscription
+	opts Options
+	mux  *http.ServeMux
+	srv  *http.Server
+	h3   HTTP3Server
+	acme *http.Server // ACME challenge HTTP server
+
+	polls pollSessions // Long polling sessions
+}
+
+// New creates new Server for commands c and registers commands handlers. The
+// subscription s is used to unsubscribe closed websocket connections and may
+// be nil.
+func New(c *command.Commands, s *command.Subscription, opts Options) *Server {
+	if opts.Addr == "" {
+		opts.Addr = ":8080"
+	}
+	if opts.WSFrameSize <= 0 {
+		opts.WSFrameSize = 4096
+	}
+	opts.Prefix = cleanPrefix(opts.Prefix)
+
+	srv := &Server{c: c, s: s, opts: opts, mux: http.NewServeMux(),
+		polls: pollSessions{m: make(map[string]*pollChannel),
+			Mutex: new(sync.Mutex)}}
+
+	// Commands HTTP handlers
+	srv.Mount("", opts.Prefix, c)
+
+	// Websocket handler
+	if opts.WSPath != "" {
+		srv.mux.HandleFunc(opts.WSPath, srv.serveWS)
+	}
+
+	// Long polling handler
+	if opts.PollPath != "" {
+		srv.mux.HandleFunc(opts.PollPath, srv.servePoll)
+		if s != nil {
+			s.AddBacklogQueue(srv.polls.backlog)
+		}
+	}
+
+	// Readiness probe handler
+	if opts.ReadyPath != "" {
+		srv.mux.HandleFunc(opts.ReadyPath, srv.serveReady)
+	}
+
+	// Prometheus metrics handler
+	if opts.MetricsPath != "" {
+		srv.mux.HandleFunc(opts.MetricsPath, func(w http.ResponseWriter,
+			r *http.Request) {
+			w.Header().Set("Content-Type", command.MetricsContentType)
+			c.WritePrometheus(w)
+		})
+	}
+
+	return srv
+}
+
+// serveReady is readiness probe handler.
+func (srv *Server) serveReady(w http.ResponseWriter, r *http.Request) {
+	var health command.SubscriptionHealth
+	if srv.s != nil {
+		health = srv.s.Health()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if health.Degraded {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
+// Mount registers HTTP handlers of commands c processed in command.HTTP with
+// path prefix on host, so several commands registries, like API versions,
+// are served side by side:
+//
+//	srv.Mount("", "/api/v2", v2)
+//	srv.Mount("admin.example.com", "/", admin)
+//
+// The host is matched with request Host header, any host matches if it is
+// empty. Server commands are mounted by New with Options Prefix, websocket
+// connections execute Server commands only. Commands with Route are served
+// at their routes, like commands documentation pages. Routes conflicts of
+// commands c are reported to Options OnRouteConflict. It panics if routes
+// conflict with already registered routes, like http.ServeMux Handle.
+func (srv *Server) Mount(host, prefix string, c *command.Commands) {
+	prefix = host + cleanPrefix(prefix)
+
+	// Routes conflicts, duplicate routes are not registered
+	duplicates := make(map[string]bool)
+	for _, rc := range c.RouteConflicts(command.HTTP) {
+		if srv.opts.OnRouteConflict != nil {
+			srv.opts.OnRouteConflict(prefix, rc)
+		}
+		if !rc.Shadow {
+			duplicates[rc.Route] = true
+		}
+	}
+
+	c.HabdleCommands(command.HTTP, func(name, params string) {
+		route := name
+		if params != "" {
+			route += "/" + params
+		}
+		if duplicates[route] {
+			return
+		}
+		pattern := Path(prefix, name, params)
+		if cmd, ok := c.Get(name); ok && cmd.Route != "" {
+			pattern = Path(prefix, cmd.Route, "")
+		}
+		srv.mux.HandleFunc(pattern, srv.handleCommand(c, name))
+	})
+}
+
+// cleanPrefix returns path prefix with leading and trailing slashes.
+func cleanPrefix(prefix string) string {
+	prefix = "/" + strings.Trim(prefix, "/") + "/"
+	if prefix == "//" {
+		prefix = "/"
+	}
+	return prefix
+}
+
+// Path returns HTTP pattern of command with prefix and parameters.
+func Path(prefix, name, params string) string {
+	path := strings.TrimRight(prefix, "/") + "/" + name
+	if params != "" {
+		path += "/" + params
+	}
+	return path
+}
+
+// Handle registers additional handler for the given pattern, for example
+// static files handler.
+func (srv *Server) Handle(pattern string, handler http.Handler) {
+	srv.mux.Handle(pattern, handler)
+}
+
+// Handler returns server HTTP handler.
+func (srv *Server) Handler() http.Handler {
+	var h http.Handler = srv.mux
+
+	// Advertise HTTP/3
+	if srv.opts.HTTP3 != nil {
+		_, port, _ := net.SplitHostPort(srv.opts.Addr)
+		altSvc := fmt.Sprintf(`h3=":%s"; ma=86400`, port)
+		next := h
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Alt-Svc", altSvc)
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	// Check client address
+	if srv.opts.ACL != nil {
+		h = srv.opts.ACL.Handler(h)
+	}
+
+	return h
+}
+
+// handleCommand returns HTTP handler of command of commands c.
+func (srv *Server) handleCommand(c *command.Commands, name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !srv.opts.Hooks.onConnect(w, r) {
+			return
+		}
+
+		// Get request variables
+		vars := make(map[string]string)
+		if cmd, ok := c.Get(name); ok {
+			for _, param := range cmd.ParamsSlice() {
+				vars[param] = r.PathValue(param)
+			}
+		}
+
+		// Execute command
+		req := command.NewHTTPRequest(r, vars)
+		data, err := srv.exec(c, r, name, command.HTTP, req, w.Header())
+		if req.Quota != nil {
+			h := w.Header()
+			h.Set(command.QuotaLimitHeader, strconv.FormatInt(req.Quota.Limit, 10))
+			h.Set(command.QuotaRemainingHeader,
+				strconv.FormatInt(req.Quota.Remaining, 10))
+			h.Set(command.QuotaResetHeader, strconv.FormatInt(req.Quota.Reset.Unix(), 10))
+		}
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, command.ErrQuotaExceeded) {
+				status = http.StatusTooManyRequests
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		// Stream large response, it is not signed. The reader of failed
+		// command is closed by Exec
+		if rc := req.ResponseReader(); rc != nil {
+			defer rc.Close()
+			io.Copy(w, rc)
+			return
+		}
+
+		// Sign response
+		if srv.opts.Signer != nil {
+			sig, err := command.SignResponse(srv.opts.Signer, name, data)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set(command.SignatureHeader, sig)
+		}
+
+		w.Write(data)
+	}
+}
+
+// ListenAndServe starts server. It serves TLS with HTTP/2 and optional
+// HTTP/3 when TLS is configured, or plain HTTP otherwise. It returns
+// http.ErrServerClosed after Shutdown.
+func (srv *Server) ListenAndServe() error {
+	srv.srv = &http.Server{Addr: srv.opts.Addr, Handler: srv.Handler()}
+	if srv.opts.DisableHTTP2 {
+		srv.srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn,
+			http.Handler))
+	}
+
+	// Listener
+	l, err := net.Listen("tcp", srv.opts.Addr)
+	if err != nil {
+		return err
+	}
+	if srv.opts.ProxyProtocol && srv.opts.ACL != nil {
+		l = srv.opts.ACL.ProxyListener(l)
+	}
+
+	// Plain HTTP
+	if srv.opts.TLSConfig == nil && srv.opts.CertFile == "" &&
+		srv.opts.Autocert == nil {
+		log.Printf("start listening for HTTP requests on %s", srv.opts.Addr)
+		return srv.srv.Serve(l)
+	}
+
+	// TLS configuration
+	cfg := &tls.Config{}
+	if srv.opts.TLSConfig != nil {
+		cfg = srv.opts.TLSConfig.Clone()
+	}
+	if srv.opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(srv.opts.CertFile, srv.opts.KeyFile)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+	if srv.opts.Autocert != nil {
+		cfg = srv.autocertTLSConfig(cfg)
+	}
+	if srv.opts.ClientCAs != nil {
+		cfg.ClientCAs = srv.opts.ClientCAs
+		cfg.ClientAuth = srv.opts.ClientAuth
+		if cfg.ClientAuth == tls.NoClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	srv.srv.TLSConfig = cfg
+
+	// HTTP/3
+	if srv.opts.HTTP3 != nil {
+		srv.h3 = srv.opts.HTTP3(srv.opts.Addr, cfg, srv.srv.Handler)
+		command.Go(command.GoHTTP3Server, func() {
+			if err := srv.h3.ListenAndServe(); err != nil {
+				log.Println("HTTP/3 server stopped:", err)
+			}
+		})
+	}
+
+	log.Printf("start listening for HTTPS requests on %s", srv.opts.Addr)
+	return srv.srv.ServeTLS(l, "", "")
+}
+
+// Shutdown gracefully shuts down the server: stops accepting connections and
+// waits for active requests until ctx is done.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	if srv.h3 != nil {
+		srv.h3.Close()
+	}
+	if srv.acme != nil {
+		srv.acme.Shutdown(ctx)
+	}
+	if srv.srv == nil {
+		return nil
+	}
+	return srv.srv.Shutdown(ctx)
+}
@@ -0,0 +1,508 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kirill-scherba/command/v2"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestClientCertificates(t *testing.T) {
+
+	c := command.New()
+	c.Add("whoami", "", command.HTTP, "", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			req, err := c.Request(data)
+			if err != nil {
+				return nil, err
+			}
+			certs := req.GetPeerCertificates()
+			if len(certs) == 0 {
+				return nil, fmt.Errorf("no client certificate")
+			}
+			return []byte(certs[0].Subject.CommonName), nil
+		},
+	)
+
+	// TLS server with client certificate required
+	ts := httptest.NewUnstartedServer(New(c, nil, Options{}).Handler())
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	// Client with self signed certificate
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client-1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	client := ts.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{
+		{Certificate: [][]byte{der}, PrivateKey: key},
+	}
+
+	res, err := client.Get(ts.URL + "/whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if body, _ := io.ReadAll(res.Body); string(body) != "client-1" {
+		t.Errorf("wrong client identity: %s", body)
+	}
+}
+
+func TestACL(t *testing.T) {
+
+	if _, err := NewACL([]string{"10.0.0.0/33"}, nil, nil); err == nil {
+		t.Error("wrong cidr should return error")
+	}
+	acl, err := NewACL([]string{"10.0.0.0/8", "192.0.2.1"}, []string{"10.1.0.0/16"},
+		[]string{"192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var remote string
+	h := acl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote = r.RemoteAddr
+	}))
+
+	for _, test := range []struct {
+		remote, forwarded string
+		status            int
+		client            string
+	}{
+		{"10.2.0.1:1000", "", http.StatusOK, "10.2.0.1:1000"},
+		{"10.1.0.1:1000", "", http.StatusForbidden, ""},
+		{"203.0.113.1:1000", "", http.StatusForbidden, ""},
+		{"192.0.2.1:1000", "10.2.0.5", http.StatusOK, "10.2.0.5:0"},
+		{"192.0.2.1:1000", "10.2.0.5, 10.1.0.5", http.StatusForbidden, ""},
+		{"10.2.0.1:1000", "192.0.2.1", http.StatusOK, "10.2.0.1:1000"},
+	} {
+		remote = ""
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status || remote != test.client {
+			t.Errorf("%s %s: wrong status %d or client %s", test.remote,
+				test.forwarded, w.Code, remote)
+		}
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+
+	acl, _ := NewACL(nil, nil, []string{"127.0.0.1"})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l = acl.ProxyListener(l)
+	defer l.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("PROXY TCP4 192.0.2.7 127.0.0.1 5000 80\r\nhello"))
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if addr := conn.RemoteAddr().String(); addr != "192.0.2.7:5000" {
+		t.Errorf("wrong remote address: %s", addr)
+	}
+	if data, _ := io.ReadAll(conn); string(data) != "hello" {
+		t.Errorf("wrong data: %s", data)
+	}
+}
+
+func TestMount(t *testing.T) {
+
+	registry := func(res string) *command.Commands {
+		c := command.New()
+		c.Add("version", "", command.HTTP, "", "", "", "",
+			func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+				[]byte, error) {
+				return []byte(res), nil
+			},
+		)
+		return c
+	}
+
+	v2 := registry("v2")
+	v2.AddCommandsList(command.HTTP)
+	srv := New(registry("v1"), nil, Options{Prefix: "/api/v1"})
+	srv.Mount("", "/api/v2/", v2)
+	srv.Mount("admin.example.com", "", registry("admin"))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	get := func(host, path string) string {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Host = host
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+
+	for _, test := range []struct{ host, path, expected string }{
+		{"", "/api/v1/version", "v1"},
+		{"", "/api/v2/version", "v2"},
+		{"admin.example.com", "/version", "admin"},
+		{"admin.example.com", "/api/v2/version", "v2"},
+	} {
+		if res := get(test.host, test.path); res != test.expected {
+			t.Errorf("wrong %s%s result: %s", test.host, test.path, res)
+		}
+	}
+
+	// Commands list links documentation pages relative to prefix
+	if res := get("", "/api/v2/commands"); !strings.Contains(res,
+		`href="commands/version"`) {
+		t.Errorf("wrong commands list: %s", res)
+	}
+	if res := get("", "/api/v2/commfilt/true/true/true/true"); !strings.Contains(res,
+		`href="../../../../commands/version"`) {
+		t.Errorf("wrong filtered commands list: %s", res)
+	}
+	if res := get("", "/api/v2/commfilt/false/true/true/true?teonet=false"); strings.Contains(res,
+		`commands/version"`) || !strings.Contains(res,
+		`id="teonet" name="teonet" onclick="onClickHandler()" />`) {
+		t.Errorf("wrong query filtered commands list: %s", res)
+	}
+	if res := get("", "/api/v2/commands/version"); !strings.Contains(res,
+		"<h1>version") || !strings.Contains(res, `href="../commands"`) {
+		t.Errorf("wrong command documentation page: %s", res)
+	}
+}
+
+func TestLongPolling(t *testing.T) {
+
+	c := command.New()
+	c.Add("hello", "", command.WS, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte("Hello " + vars["name"] + "!"), nil
+		},
+	)
+	s := command.NewSubscription()
+	s.AddCommands(c, command.WS)
+
+	ts := httptest.NewServer(New(c, s, Options{PollPath: "/poll",
+		WSReadLimit: 64}).Handler())
+	defer ts.Close()
+
+	session := ""
+	do := func(method, path, body string) (int, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if session != "" {
+			req.Header.Set(PollSessionHeader, session)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		session = res.Header.Get(PollSessionHeader)
+		data, _ := io.ReadAll(res.Body)
+		return res.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	// Session is not created by DELETE request
+	if status, _ := do(http.MethodDelete, "/poll", ""); status != http.StatusBadRequest ||
+		session != "" {
+		t.Errorf("wrong delete without session status: %d", status)
+	}
+
+	// Execute commands
+	for _, test := range []struct{ send, want string }{
+		{"hello/Poll", "Hello Poll!"},
+		{"subscribe/hello", "ok"},
+	} {
+		if _, res := do(http.MethodPost, "/poll", test.send); res != test.want {
+			t.Errorf("wrong long polling answer: %s", res)
+		}
+	}
+	if session == "" || s.ConnectionsCount("hello") != 1 {
+		t.Fatalf("session is not subscribed: %s", session)
+	}
+	if status, _ := do(http.MethodPost, "/poll", "hello/"+strings.Repeat("x", 64)); status !=
+		http.StatusRequestEntityTooLarge {
+		t.Errorf("wrong too large command status: %d", status)
+	}
+
+	// Receive pushes
+	if _, res := do(http.MethodGet, "/poll?wait=0", ""); res != "[]" {
+		t.Errorf("wrong empty pushes: %s", res)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		s.Broadcast("hello", []byte("news"))
+	}()
+	_, res := do(http.MethodGet, "/poll?wait=5", "")
+	var pushes []json.RawMessage
+	if err := json.Unmarshal([]byte(res), &pushes); err != nil || len(pushes) != 1 ||
+		!strings.Contains(string(pushes[0]), `"command":"hello"`) {
+		t.Errorf("wrong pushes: %s, %v", res, err)
+	}
+
+	// Close session
+	do(http.MethodDelete, "/poll", "")
+	if n := s.ConnectionsCount("hello"); n != 0 {
+		t.Errorf("closed session is subscribed: %d", n)
+	}
+	session = "unknown"
+	if status, _ := do(http.MethodGet, "/poll", ""); status != http.StatusNotFound {
+		t.Errorf("wrong unknown session status: %d", status)
+	}
+}
+
+func TestMetricsPath(t *testing.T) {
+
+	c := command.New()
+	c.Add("hello", "", command.HTTP, "", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			return []byte("Hello!"), nil
+		},
+	)
+	srv := New(c, nil, Options{MetricsPath: "/metrics"})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	res, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.Header.Get("Content-Type") != command.MetricsContentType ||
+		!strings.Contains(string(body), `command_calls_total{command="hello"} 1`) {
+		t.Fatalf("wrong metrics: %s", body)
+	}
+}
+
+func TestReadyPath(t *testing.T) {
+
+	s := command.NewSubscription()
+	srv := New(command.New(), s, Options{ReadyPath: "/ready",
+		PollPath: "/poll"})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	ready := func() (int, command.SubscriptionHealth) {
+		res, err := http.Get(ts.URL + "/ready")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var h command.SubscriptionHealth
+		if err := json.NewDecoder(res.Body).Decode(&h); err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, h
+	}
+	if status, h := ready(); status != http.StatusOK || h.Degraded {
+		t.Fatalf("wrong ready status: %d %+v", status, h)
+	}
+
+	// Long polling session queue exceeds threshold
+	ch := &pollChannel{max: 10, notify: make(chan struct{}, 1),
+		Mutex: new(sync.Mutex)}
+	srv.polls.Lock()
+	srv.polls.m["session"] = ch
+	srv.polls.Unlock()
+	ch.Send([]byte("1"))
+	ch.Send([]byte("2"))
+	s.SetBacklogThresholds(command.BacklogThresholds{ConnQueue: 1})
+
+	status, h := ready()
+	if status != http.StatusServiceUnavailable || !h.Degraded ||
+		h.Backlog.ConnQueue != 2 {
+		t.Fatalf("wrong degraded status: %d %+v", status, h)
+	}
+}
+
+func TestStreamResponse(t *testing.T) {
+
+	dir := t.TempDir()
+	c := command.New()
+	export := func(delay time.Duration) command.CommandHandler {
+		return func(cmd *command.CommandData, processIn command.ProcessIn,
+			data any) ([]byte, error) {
+			time.Sleep(delay)
+			b := &command.SpillBuffer{Threshold: 1024, Dir: dir}
+			for i := range 1000 {
+				fmt.Fprintf(b, "line %d\n", i)
+			}
+			r, err := b.Reader()
+			if err != nil {
+				b.Close()
+				return nil, err
+			}
+			if err = c.StreamResponse(data, r); err != nil {
+				r.Close()
+				return nil, err
+			}
+			return nil, nil
+		}
+	}
+	c.Add("export", "", command.HTTP, "", "", "", "", export(0))
+	c.Add("transformed", "", command.HTTP, "", "", "", "", export(0))
+	c.Add("slow", "", command.HTTP, "", "", "", "", export(50*time.Millisecond))
+	cmd, _ := c.Get("export")
+	cmd.CacheTTL = time.Minute
+	cmd, _ = c.Get("transformed")
+	cmd.Transforms = []command.ResponseTransform{command.RedactFields("id")}
+	cmd, _ = c.Get("slow")
+	cmd.Timeouts = &command.Timeouts{Hard: 10 * time.Millisecond}
+	ts := httptest.NewServer(New(c, nil, Options{}).Handler())
+	defer ts.Close()
+
+	get := func(name string) (int, string) {
+		res, err := http.Get(ts.URL + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return res.StatusCode, string(body)
+	}
+	removed := func() bool {
+		for range 100 {
+			if entries, _ := os.ReadDir(dir); len(entries) == 0 {
+				return true
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return false
+	}
+
+	// Streamed response is not cached
+	for range 2 {
+		_, body := get("export")
+		if lines := strings.Count(body, "\n"); lines != 1000 ||
+			!strings.HasSuffix(body, "line 999\n") {
+			t.Fatalf("wrong streamed response: %d lines", lines)
+		}
+		if !removed() {
+			t.Fatal("temporary file is not removed")
+		}
+	}
+
+	// Transformed and timed out streams are closed
+	if status, body := get("transformed"); status != http.StatusBadRequest ||
+		!strings.Contains(body, command.ErrStreamNotSupported.Error()) {
+		t.Errorf("wrong transformed stream response: %d %s", status, body)
+	}
+	if !removed() {
+		t.Error("temporary file of transformed stream is not removed")
+	}
+	if status, body := get("slow"); status != http.StatusBadRequest ||
+		!strings.Contains(body, command.ErrTimeout.Error()) {
+		t.Errorf("wrong timed out stream response: %d %s", status, body)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !removed() {
+		t.Error("temporary file of timed out stream is not removed")
+	}
+}
+
+func TestAutocert(t *testing.T) {
+
+	opts := &AutocertOptions{Hosts: []string{"example.com"},
+		Email: "admin@example.com", Cache: autocert.DirCache(t.TempDir())}
+
+	// Manager accepts configured hosts only
+	m := opts.manager()
+	if m.Email != opts.Email || m.Cache != opts.Cache {
+		t.Errorf("wrong manager options: %v, %v", m.Email, m.Cache)
+	}
+	if err := m.HostPolicy(context.Background(), "example.com"); err != nil {
+		t.Errorf("configured host rejected: %v", err)
+	}
+	if err := m.HostPolicy(context.Background(), "other.com"); err == nil {
+		t.Error("not configured host accepted")
+	}
+	if (&AutocertOptions{}).manager().Cache != autocert.DirCache("certs") {
+		t.Error("wrong default cache")
+	}
+
+	// HTTP-01 handler answers challenges and redirects other requests
+	h := m.HTTPHandler(nil)
+	for path, status := range map[string]int{
+		"/.well-known/acme-challenge/token": http.StatusNotFound,
+		"/hello":                            http.StatusFound,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil))
+		if w.Code != status {
+			t.Errorf("wrong %s status: %d", path, w.Code)
+		}
+		if status == http.StatusFound &&
+			w.Header().Get("Location") != "https://example.com"+path {
+			t.Errorf("wrong redirect: %s", w.Header().Get("Location"))
+		}
+	}
+
+	// TLS configuration gets certificates from manager and supports
+	// TLS-ALPN-01 challenge
+	srv := New(command.New(), nil, Options{Autocert: opts})
+	cfg := srv.autocertTLSConfig(&tls.Config{NextProtos: []string{"h2"}})
+	if cfg.GetCertificate == nil || !slices.Contains(cfg.NextProtos, "h2") ||
+		!slices.Contains(cfg.NextProtos, acme.ALPNProto) {
+		t.Fatalf("wrong TLS config: %v", cfg.NextProtos)
+	}
+	if _, err := cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.com"}); err == nil {
+		t.Error("certificate of not configured host")
+	}
+	if srv.acme != nil {
+		t.Error("challenge HTTP server started without HTTPAddr")
+	}
+}
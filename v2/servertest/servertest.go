@@ -0,0 +1,236 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package servertest provides in-process HTTP and websocket server of the
+// Command processing package for end-to-end tests.
+//
+// The Server runs server.Server around Commands and Subscription on
+// httptest server. Tests call HTTP commands with Call, execute websocket
+// commands with Conn.Exec and check subscription pushes with Conn.Push and
+// Conn.ExpectPush:
+//
+//	ts := servertest.NewServer(c, s, server.Options{})
+//	defer ts.Close()
+//
+//	conn := ts.Dial(t, nil)
+//	defer conn.Close()
+//	conn.Exec(t, "subscribe/news")
+//	s.Broadcast("news", []byte("hello"))
+//	conn.ExpectPush(t, "news", "hello")
+package servertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kirill-scherba/command/v2"
+	"github.com/kirill-scherba/command/v2/server"
+)
+
+// DefaultTimeout is a default timeout of websocket answers and pushes.
+const DefaultTimeout = time.Second
+
+// Server is in-process HTTP and websocket server of commands.
+type Server struct {
+	*httptest.Server
+	Options server.Options // Server options with defaults applied
+}
+
+// NewServer creates and starts server of commands c and subscription s, s
+// may be nil. Commands prefix is "/" and websocket path is "/ws" if they are
+// not set in opts. The Close method should be called when test is done.
+func NewServer(c *command.Commands, s *command.Subscription,
+	opts server.Options) *Server {
+
+	if opts.WSPath == "" {
+		opts.WSPath = "/ws"
+	}
+	opts.Prefix = "/" + strings.Trim(opts.Prefix, "/") + "/"
+	if opts.Prefix == "//" {
+		opts.Prefix = "/"
+	}
+	srv := server.New(c, s, opts)
+	return &Server{Server: httptest.NewServer(srv.Handler()), Options: opts}
+}
+
+// Close shuts down the server.
+func (ts *Server) Close() { ts.Server.Close() }
+
+// URL returns HTTP URL of command with parameters, like
+// URL("hello", "John").
+func (ts *Server) URL(name string, params ...string) string {
+	return ts.Server.URL + ts.Options.Prefix +
+		strings.Join(append([]string{name}, params...), "/")
+}
+
+// Call executes HTTP command with parameters and returns response body. It
+// fails the test if request fails or response status is not 200.
+func (ts *Server) Call(t testing.TB, name string, params ...string) []byte {
+	t.Helper()
+	body, status, err := ts.Do(http.MethodGet, nil, name, params...)
+	if err != nil {
+		t.Fatalf("command '%s': %v", name, err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("command '%s': status %d: %s", name, status, body)
+	}
+	return body
+}
+
+// Do executes HTTP command with method, request body, which may be nil, and
+// parameters. It returns response body and status.
+func (ts *Server) Do(method string, body io.Reader, name string,
+	params ...string) ([]byte, int, error) {
+
+	req, err := http.NewRequest(method, ts.URL(name, params...), body)
+	if err != nil {
+		return nil, 0, err
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	return data, res.StatusCode, err
+}
+
+// Dial connects to server websocket with request header, which may be nil.
+// It fails the test if connection fails.
+func (ts *Server) Dial(t testing.TB, header http.Header) *Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(ts.Server.URL, "http") + ts.Options.WSPath
+	ws, res, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("websocket dial: %v", err)
+	}
+
+	conn := &Conn{ws: ws, Response: res, Timeout: DefaultTimeout,
+		answers: make(chan []byte, 64),
+		pushes:  make(chan command.SubscriptionMessage, 64),
+		closing: make(chan struct{}), done: make(chan struct{})}
+	go conn.read()
+	return conn
+}
+
+// Conn is websocket connection to server. It splits received messages to
+// commands answers and subscription pushes: messages which are json
+// command.SubscriptionMessage with command name are pushes.
+type Conn struct {
+	ws      *websocket.Conn
+	answers chan []byte
+	pushes  chan command.SubscriptionMessage
+	closing chan struct{}
+	done    chan struct{}
+
+	// Timeout of answers and pushes, DefaultTimeout by default.
+	Timeout time.Duration
+
+	// Response is websocket handshake response, its body is closed.
+	Response *http.Response
+}
+
+// read reads websocket messages until connection is closed.
+func (conn *Conn) read() {
+	defer close(conn.done)
+	for {
+		_, data, err := conn.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg command.SubscriptionMessage
+		if json.Unmarshal(data, &msg) == nil && msg.Command != "" {
+			select {
+			case conn.pushes <- msg:
+			case <-conn.closing:
+				return
+			}
+			continue
+		}
+		select {
+		case conn.answers <- data:
+		case <-conn.closing:
+			return
+		}
+	}
+}
+
+// Close closes websocket connection.
+func (conn *Conn) Close() error {
+	close(conn.closing)
+	err := conn.ws.Close()
+	<-conn.done
+	return err
+}
+
+// Send sends websocket command, like "hello/John", and returns its answer.
+func (conn *Conn) Send(cmd string) ([]byte, error) {
+	if err := conn.ws.WriteMessage(websocket.TextMessage, []byte(cmd)); err != nil {
+		return nil, err
+	}
+	select {
+	case data := <-conn.answers:
+		return data, nil
+	case <-conn.done:
+		return nil, fmt.Errorf("connection closed")
+	case <-time.After(conn.Timeout):
+		return nil, fmt.Errorf("command '%s' answer timeout", cmd)
+	}
+}
+
+// Exec sends websocket command and returns its answer. It fails the test if
+// the answer is not received.
+func (conn *Conn) Exec(t testing.TB, cmd string) []byte {
+	t.Helper()
+	data, err := conn.Send(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Push returns next subscription push received by connection.
+func (conn *Conn) Push() (command.SubscriptionMessage, error) {
+	select {
+	case msg := <-conn.pushes:
+		return msg, nil
+	case <-conn.done:
+		return command.SubscriptionMessage{}, fmt.Errorf("connection closed")
+	case <-time.After(conn.Timeout):
+		return command.SubscriptionMessage{}, fmt.Errorf("push timeout")
+	}
+}
+
+// ExpectPush receives next subscription push and checks its command and
+// data. It fails the test if the push is not received or differs.
+func (conn *Conn) ExpectPush(t testing.TB, cmd, data string) command.SubscriptionMessage {
+	t.Helper()
+	msg, err := conn.Push()
+	if err != nil {
+		t.Fatalf("expected push of '%s': %v", cmd, err)
+	}
+	if msg.Command != cmd || string(msg.Data) != data || msg.Err != "" {
+		t.Fatalf("expected push of '%s' %q, got '%s' %q %s", cmd, data,
+			msg.Command, msg.Data, msg.Err)
+	}
+	return msg
+}
+
+// ExpectNoPush checks that connection receives no subscription pushes
+// during duration d.
+func (conn *Conn) ExpectNoPush(t testing.TB, d time.Duration) {
+	t.Helper()
+	select {
+	case msg := <-conn.pushes:
+		t.Fatalf("unexpected push of '%s' %q", msg.Command, msg.Data)
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,63 @@
+package servertest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirill-scherba/command/v2"
+	"github.com/kirill-scherba/command/v2/server"
+)
+
+func TestServer(t *testing.T) {
+
+	c := command.New()
+	c.Add("hello", "say hello", command.HTTP|command.WS, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte(fmt.Sprintf("Hello %s!", vars["name"])), nil
+		},
+	)
+	s := command.NewSubscription()
+	s.AddCommands(c, command.WS)
+
+	ts := NewServer(c, s, server.Options{Prefix: "/api/v1"})
+	defer ts.Close()
+
+	// HTTP command
+	if res := ts.Call(t, "hello", "John"); string(res) != "Hello John!" {
+		t.Errorf("wrong HTTP answer: %s", res)
+	}
+	if _, status, _ := ts.Do(http.MethodGet, nil, "unknown"); status != http.StatusNotFound {
+		t.Errorf("unknown command status 404 expected, got %d", status)
+	}
+
+	// Websocket commands and pushes
+	conn := ts.Dial(t, nil)
+	defer conn.Close()
+	if res := conn.Exec(t, "hello/Ws"); string(res) != "Hello Ws!" {
+		t.Errorf("wrong websocket answer: %s", res)
+	}
+	if res := conn.Exec(t, "subscribe/hello"); string(res) != "ok" {
+		t.Fatalf("wrong subscribe answer: %s", res)
+	}
+	s.Broadcast("hello", []byte("news"))
+	conn.ExpectPush(t, "hello", "news")
+
+	// Answers and pushes are split
+	s.Broadcast("hello", []byte("more news"))
+	if res := conn.Exec(t, "hello/again"); string(res) != "Hello again!" {
+		t.Errorf("wrong websocket answer: %s", res)
+	}
+	conn.ExpectPush(t, "hello", "more news")
+	conn.ExpectNoPush(t, 20*time.Millisecond)
+
+	// Push timeout
+	conn.Timeout = 10 * time.Millisecond
+	if _, err := conn.Push(); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("push timeout expected, got %v", err)
+	}
+}
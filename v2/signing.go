@@ -0,0 +1,198 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Payload signing module of Command processing golang package.
+
+package command
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ErrSignature is an error returned when signature is missing or not valid.
+var ErrSignature = fmt.Errorf("invalid signature")
+
+// SignatureHeader is a header which contains request or response signature.
+const SignatureHeader = "X-Command-Signature"
+
+// Signature algorithms.
+const (
+	SignHMAC    = "hmac-sha256"
+	SignEd25519 = "ed25519"
+)
+
+// Signature is a signature of command request or response. Its string form
+// "alg:keyID:base64-signature" is sent in SignatureHeader.
+type Signature struct {
+	Alg   string // Signature algorithm
+	KeyID string // Signing key identifier
+	Sig   []byte // Signature
+}
+
+// String returns signature string form.
+func (s Signature) String() string {
+	return s.Alg + ":" + s.KeyID + ":" + base64.RawURLEncoding.EncodeToString(s.Sig)
+}
+
+// ParseSignature parses signature string form.
+func ParseSignature(s string) (sig Signature, err error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return sig, ErrSignature
+	}
+	sig.Alg, sig.KeyID = parts[0], parts[1]
+	if sig.Sig, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return sig, ErrSignature
+	}
+	return
+}
+
+// Signer signs data.
+type Signer interface {
+	Sign(data []byte) (Signature, error)
+}
+
+// HMACSigner signs data with HMAC-SHA256 shared key.
+type HMACSigner struct {
+	KeyID string // Key identifier
+	Key   []byte // Shared key
+}
+
+// Sign signs data with HMAC-SHA256.
+func (s *HMACSigner) Sign(data []byte) (Signature, error) {
+	return Signature{Alg: SignHMAC, KeyID: s.KeyID, Sig: hmacSum(s.Key, data)}, nil
+}
+
+// Ed25519Signer signs data with Ed25519 private key.
+type Ed25519Signer struct {
+	KeyID string             // Key identifier
+	Key   ed25519.PrivateKey // Private key
+}
+
+// Sign signs data with Ed25519.
+func (s *Ed25519Signer) Sign(data []byte) (Signature, error) {
+	return Signature{Alg: SignEd25519, KeyID: s.KeyID,
+		Sig: ed25519.Sign(s.Key, data)}, nil
+}
+
+// KeyProvider returns verification key by key identifier: []byte HMAC
+// shared key or ed25519.PublicKey. It is a key management hook, so keys may
+// be rotated or loaded from external storage.
+type KeyProvider func(keyID string) (key any, err error)
+
+// Verifier verifies signatures with keys returned by Keys provider.
+type Verifier struct {
+	Keys KeyProvider
+}
+
+// NewVerifier creates Verifier with keys provider.
+func NewVerifier(keys KeyProvider) *Verifier {
+	return &Verifier{Keys: keys}
+}
+
+// Verify verifies signature string form of data.
+func (v *Verifier) Verify(data []byte, signature string) error {
+	sig, err := ParseSignature(signature)
+	if err != nil {
+		return err
+	}
+	key, err := v.Keys(sig.KeyID)
+	if err != nil {
+		return fmt.Errorf("key '%s': %w", sig.KeyID, err)
+	}
+
+	switch key := key.(type) {
+	case []byte:
+		if sig.Alg == SignHMAC && hmac.Equal(sig.Sig, hmacSum(key, data)) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if sig.Alg == SignEd25519 && ed25519.Verify(key, data, sig.Sig) {
+			return nil
+		}
+	}
+	return ErrSignature
+}
+
+// VerifyRequest verifies signature of command request from SignatureHeader.
+func (v *Verifier) VerifyRequest(command string, req RequestInterfaceV2) error {
+	signature := req.GetHeader(SignatureHeader)
+	if signature == "" {
+		return ErrSignature
+	}
+	return v.Verify(SigningData(command, req.GetVars(), req.GetData()), signature)
+}
+
+// Handler returns command handler which verifies request signature before
+// calling handler. Requests with missing or not valid signatures are
+// rejected with ErrSignature.
+func (v *Verifier) Handler(handler CommandHandler) CommandHandler {
+	return func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+		req, err := ParseParams[RequestInterface](indata)
+		if err != nil {
+			return nil, err
+		}
+		if err = v.VerifyRequest(cmd.Cmd, WrapRequest(req)); err != nil {
+			return nil, err
+		}
+		return handler(cmd, processIn, indata)
+	}
+}
+
+// SignRequest returns signature string form of command request which is
+// sent in SignatureHeader.
+func SignRequest(s Signer, command string, vars map[string]string,
+	data []byte) (string, error) {
+
+	sig, err := s.Sign(SigningData(command, vars, data))
+	if err != nil {
+		return "", err
+	}
+	return sig.String(), nil
+}
+
+// SignResponse returns signature string form of command response data.
+func SignResponse(s Signer, command string, data []byte) (string, error) {
+	return SignRequest(s, command, nil, data)
+}
+
+// SigningData returns canonical form of command request or response signed
+// by signers: command name, url encoded sorted request variables and data
+// separated by new lines.
+func SigningData(command string, vars map[string]string, data []byte) []byte {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var buf bytes.Buffer
+	buf.WriteString(command)
+	buf.WriteByte('\n')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte('&')
+		}
+		buf.WriteString(url.QueryEscape(key) + "=" + url.QueryEscape(vars[key]))
+	}
+	buf.WriteByte('\n')
+	buf.Write(data)
+
+	return buf.Bytes()
+}
+
+// hmacSum returns HMAC-SHA256 of data.
+func hmacSum(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,299 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ssh is an SSH server adapter of the Command processing package
+// registry for secure remote administration.
+//
+// Authenticated SSH sessions get a REPL over the commands registry: command
+// lines, like "hello/John", are executed and their answers are printed, Tab
+// completes command names and parameters, "help" lists commands and "exit"
+// closes the session. Command line passed to ssh client, like
+// "ssh admin@host hello/John", is executed without REPL. The SSH user name
+// is set as commands request user.
+//
+// Authentication is configured by ssh.ServerConfig, AuthorizedKeys returns
+// public key callback accepting listed keys.
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/kirill-scherba/command/v2"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// DefaultPrompt is a default REPL prompt.
+const DefaultPrompt = "> "
+
+// Server serves SSH sessions with REPL over commands registry.
+type Server struct {
+	c         *command.Commands
+	processIn command.ProcessIn
+	config    *gossh.ServerConfig
+
+	// Prompt is REPL prompt, DefaultPrompt by default.
+	Prompt string
+}
+
+// New creates SSH server of commands registry. Commands are executed with
+// processIn input processing type, like the one registered by
+// command.RegisterProcessIn("SSH"). The config should have host key and
+// authentication callbacks.
+func New(c *command.Commands, processIn command.ProcessIn,
+	config *gossh.ServerConfig) *Server {
+	return &Server{c: c, processIn: processIn, config: config}
+}
+
+// AuthorizedKeys returns public key callback of ssh.ServerConfig which
+// accepts users with listed public keys.
+func AuthorizedKeys(keys ...gossh.PublicKey) func(conn gossh.ConnMetadata,
+	key gossh.PublicKey) (*gossh.Permissions, error) {
+
+	return func(conn gossh.ConnMetadata, key gossh.PublicKey) (
+		*gossh.Permissions, error) {
+
+		for _, k := range keys {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				return &gossh.Permissions{}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown public key for %s", conn.User())
+	}
+}
+
+// Serve accepts SSH connections from listener l until it is closed or ctx
+// is done.
+func (srv *Server) Serve(ctx context.Context, l net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		command.Go("ssh connection", func() { srv.serveConn(ctx, conn) })
+	}
+}
+
+// serveConn serves SSH connection.
+func (srv *Server) serveConn(ctx context.Context, conn net.Conn) {
+	sconn, chans, reqs, err := gossh.NewServerConn(conn, srv.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	command.Go("ssh requests", func() { gossh.DiscardRequests(reqs) })
+
+	user, remoteAddr := sconn.User(), sconn.RemoteAddr().String()
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(gossh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, reqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		command.Go("ssh session", func() {
+			srv.serveSession(ctx, ch, reqs, user, remoteAddr)
+		})
+	}
+}
+
+// serveSession serves SSH session channel requests.
+func (srv *Server) serveSession(ctx context.Context, ch gossh.Channel,
+	reqs <-chan *gossh.Request, user, remoteAddr string) {
+
+	defer ch.Close()
+	s := &session{srv: srv, ch: ch, user: user, remoteAddr: remoteAddr,
+		ctx: ctx}
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			s.pty = true
+			req.Reply(true, nil)
+		case "env", "window-change":
+			req.Reply(true, nil)
+		case "exec":
+			req.Reply(true, nil)
+			var payload struct{ Command string }
+			gossh.Unmarshal(req.Payload, &payload)
+			_, err := s.exec(strings.TrimSpace(payload.Command))
+			s.exit(err)
+			return
+		case "shell":
+			req.Reply(true, nil)
+			s.repl()
+			s.exit(nil)
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// session is an SSH session.
+type session struct {
+	srv        *Server
+	ch         gossh.Channel
+	user       string
+	remoteAddr string
+	pty        bool
+	ctx        context.Context
+	mut        sync.Mutex
+}
+
+// write writes text to session, newlines are converted to CRLF in pty mode.
+func (s *session) write(text string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.pty {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	io.WriteString(s.ch, text)
+}
+
+// exec executes command line and writes its answer or error.
+func (s *session) exec(line string) (ok bool, err error) {
+	switch line {
+	case "":
+		return true, nil
+	case "exit", "quit":
+		return false, nil
+	case "help":
+		var buf bytes.Buffer
+		err = s.srv.c.Fprint(&buf, command.PrintOptions{ProcessIn: s.srv.processIn})
+		s.write(buf.String())
+		return true, err
+	}
+
+	name, vars := s.srv.c.ParseCommand([]byte(line))
+	data, err := s.srv.c.Exec(name, s.srv.processIn, &command.DefaultRequest{
+		Vars: vars, User: s.user, RemoteAddr: s.remoteAddr, Ctx: s.ctx})
+	if err != nil {
+		s.write("error: " + err.Error() + "\n")
+		return true, err
+	}
+	text := string(data)
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	s.write(text)
+	return true, nil
+}
+
+// exit sends exit status and closes session.
+func (s *session) exit(err error) {
+	status := make([]byte, 4)
+	if err != nil {
+		binary.BigEndian.PutUint32(status, 1)
+	}
+	s.ch.SendRequest("exit-status", false, status)
+}
+
+// repl reads and executes command lines until exit command or end of input.
+func (s *session) repl() {
+	prompt := s.srv.Prompt
+	if prompt == "" {
+		prompt = DefaultPrompt
+	}
+
+	var line []rune
+	s.write(prompt)
+	buf := make([]byte, 256)
+	for {
+		n, err := s.ch.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, r := range string(buf[:n]) {
+			switch r {
+			case '\r', '\n':
+				if r == '\n' && s.pty {
+					continue
+				}
+				if s.pty {
+					s.write("\n")
+				}
+				if ok, _ := s.exec(strings.TrimSpace(string(line))); !ok {
+					return
+				}
+				line = line[:0]
+				s.write(prompt)
+			case 3: // Ctrl-C
+				line = line[:0]
+				s.write("^C\n" + prompt)
+			case 4: // Ctrl-D
+				if len(line) == 0 {
+					return
+				}
+			case 127, '\b':
+				if len(line) > 0 {
+					line = line[:len(line)-1]
+					if s.pty {
+						s.write("\b \b")
+					}
+				}
+			case '\t':
+				line = s.complete(line, prompt)
+			default:
+				if r < ' ' {
+					continue
+				}
+				line = append(line, r)
+				if s.pty {
+					s.write(string(r))
+				}
+			}
+		}
+	}
+}
+
+// complete completes line by commands completions. It writes completed line
+// or list of completions.
+func (s *session) complete(line []rune, prompt string) []rune {
+	completions := s.srv.c.Complete(string(line))
+	if len(completions) == 0 {
+		return line
+	}
+
+	// Complete the last segment by common prefix of completions
+	prefix := completions[0]
+	for _, c := range completions[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	head := string(line)
+	if i := strings.LastIndex(head, "/"); i >= 0 {
+		head = head[:i+1]
+	} else {
+		head = ""
+	}
+	completed := head + prefix
+	if len(completions) > 1 && completed == string(line) {
+		s.write("\n" + strings.Join(completions, "  ") + "\n" + prompt + completed)
+		return line
+	}
+	if s.pty {
+		s.write(strings.TrimPrefix(completed, string(line)))
+	}
+	return []rune(completed)
+}
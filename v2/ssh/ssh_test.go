@@ -0,0 +1,117 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirill-scherba/command/v2"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestServer(t *testing.T) {
+
+	c := command.New()
+	c.Add("hello", "", command.All, "{name}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			return []byte("Hello " + vars["name"] + "!"), nil
+		},
+	)
+	c.Add("whoami", "", command.All, "", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			return []byte(data.(*command.DefaultRequest).User.(string)), nil
+		},
+	)
+
+	// Host and client keys
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, err := gossh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, clientKey, _ := ed25519.GenerateKey(rand.Reader)
+	clientSigner, err := gossh.NewSignerFromKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := gossh.NewSignerFromKey(otherKey)
+
+	config := &gossh.ServerConfig{
+		PublicKeyCallback: AuthorizedKeys(clientSigner.PublicKey()),
+	}
+	config.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := New(c, command.All, config)
+	go srv.Serve(ctx, l)
+
+	dial := func(signer gossh.Signer) (*gossh.Client, error) {
+		return gossh.Dial("tcp", l.Addr().String(), &gossh.ClientConfig{
+			User:            "admin",
+			Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+			HostKeyCallback: gossh.FixedHostKey(hostSigner.PublicKey()),
+			Timeout:         time.Second,
+		})
+	}
+
+	// Unknown key is rejected
+	if _, err := dial(otherSigner); err == nil {
+		t.Fatal("unknown key accepted")
+	}
+
+	client, err := dial(clientSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Exec command line
+	sess, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := sess.Output("hello/John")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "Hello John!\n" {
+		t.Fatalf("wrong exec answer: %q", out)
+	}
+
+	// Exec unknown command returns exit status
+	sess, _ = client.NewSession()
+	if _, err := sess.Output("unknown"); err == nil {
+		t.Fatal("unknown command succeeded")
+	}
+
+	// Shell session
+	sess, _ = client.NewSession()
+	var stdout bytes.Buffer
+	sess.Stdout = &stdout
+	sess.Stdin = strings.NewReader("who\t\nhello/Kate\nhelp\nexit\n")
+	if err := sess.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"admin\n", "Hello Kate!\n", "whoami"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Fatalf("shell output %q does not contain %q", stdout.String(), want)
+		}
+	}
+}
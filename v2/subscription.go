@@ -0,0 +1,596 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription module of Command processing golang package.
+
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoConnectionChannel is an error returned when request has no connection
+// channel to send subscription pushes to.
+var ErrNoConnectionChannel = fmt.Errorf("request has no connection channel")
+
+// ErrNoSubscription is an error returned when Subscription is not attached
+// to Commands object.
+var ErrNoSubscription = fmt.Errorf("subscription is not attached")
+
+// SetSubscription attaches subscription to Commands object. The attached
+// subscription is used by Broadcast method.
+func (c *Commands) SetSubscription(s *Subscription) {
+	c.Lock()
+	c.subscription = s
+	c.Unlock()
+}
+
+// Broadcast pushes server initiated data to all connections subscribed to
+// command with attached Subscription. It returns ErrNoSubscription if
+// subscription is not attached or joined errors of failed sends.
+func (c *Commands) Broadcast(command string, data []byte) error {
+	c.RLock()
+	s := c.subscription
+	c.RUnlock()
+
+	if s == nil {
+		return ErrNoSubscription
+	}
+	return s.Broadcast(command, data)
+}
+
+// ConnectionChannel is a client connection which can receive server pushes.
+// Connection channels are used as map keys, so implementations should be
+// comparable, usually pointers, and one value should be used per connection.
+type ConnectionChannel interface {
+	// Send sends data to connection.
+	Send(data []byte) error
+}
+
+// SubscriptionHandler is a function that returns data pushed to subscribed
+// connection con when subscription command is executed. The ctx is canceled
+// when ExecCmdContext context is done or handler timeout expires, see
+// SetHandlerTimeout. Use WrapSubscriptionHandler to convert handlers without
+// context.
+type SubscriptionHandler func(ctx context.Context, con ConnectionChannel,
+	command string) ([]byte, error)
+
+// SubscriptionMessage is a message pushed to subscribed connections.
+type SubscriptionMessage struct {
+	Seq     uint64 `json:"seq,omitempty"`     // Sequence number in ack mode
+	CmdSeq  uint64 `json:"cmd_seq,omitempty"` // Command sequence number
+	Command string `json:"command"`           // Command name
+	Data    []byte `json:"data,omitempty"`    // Command data
+	Err     string `json:"error,omitempty"`   // Command error
+	Patch   bool   `json:"patch,omitempty"`   // Data is JSON Patch
+	Base    uint64 `json:"base,omitempty"`    // Patch base message in ack mode
+
+	// Pattern is a subscription pattern matched by Command, see
+	// SubscribeCmd.
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// SubscribeOptions contains optional parameters of connection subscription.
+type SubscribeOptions struct {
+	// Filter is applied to data pushed to connection. It may change data or
+	// skip push, see FilterExpr.
+	Filter SubscriptionFilter
+
+	// MinInterval is minimal interval between pushes to connection. Pushes
+	// made during the interval are coalesced into one push of the latest
+	// data at the end of the interval.
+	MinInterval time.Duration
+
+	// Debounce delays push until no new pushes are made during the debounce
+	// period, only the latest data is pushed.
+	Debounce time.Duration
+
+	// Patch turns on patch mode for subscriptions with large json data.
+	// Pushes contain RFC 6902 JSON Patch relative to the previous state
+	// when it is smaller than data, see SubscriptionMessage Patch and Base
+	// fields and ApplyJSONPatch.
+	Patch bool
+
+	// ErrorPolicy defines what is done when subscription handler returns
+	// error, see SetErrorPolicy for default policy.
+	ErrorPolicy *ErrorPolicy
+}
+
+// subscriber contains connection subscription handler and options.
+type subscriber struct {
+	handler  SubscriptionHandler
+	throttle throttle
+	patch    patchState
+	failures atomic.Int32 // Consecutive handler failures
+	pattern  string       // Subscription pattern
+	SubscribeOptions
+}
+
+// Subscription is a struct that contains map of connections subscribed to
+// commands and a read-write mutex for synchronizing access to the map.
+type Subscription struct {
+	m        map[string]map[ConnectionChannel]*subscriber
+	snap     atomic.Pointer[subscribersSnapshot] // Copy-on-write snapshot of m
+	patterns atomic.Pointer[[]string]            // Subscribed patterns
+	presence bool                                // Send presence events
+
+	sessions     map[string]*subscriptionSession // Resume sessions
+	resumeGrace  time.Duration                   // Suspended session lifetime
+	resumeReplay int                             // Replay buffer size
+	tokens       *SessionTokens                  // Session tokens
+
+	backplane Backplane // Cluster backplane
+	node      string    // Cluster node name
+
+	history subscriptionHistory // Last broadcast payloads
+	acks    subscriptionAcks    // Messages waiting for acknowledgment
+	notify  subscriptionNotify  // Commands marked dirty
+	drain   subscriptionDrain   // In-flight sends and draining connections
+	order   subscriptionOrder   // Command sequence numbers
+
+	counters   subscriptionCounters       // Push counters
+	health     subscriptionHealth         // Backlog thresholds
+	encryption atomic.Pointer[Encryption] // Pushes encryption
+
+	parallelism    atomic.Int32 // Concurrent pushes limit
+	pushWaiting    atomic.Int64 // Pushes waiting for push worker
+	sendTimeout    atomic.Int64 // Connection send timeout
+	handlerTimeout atomic.Int64 // Subscription handler timeout
+
+	errorPolicy atomic.Pointer[ErrorPolicy] // Default handler error policy
+
+	serializer Serializer             // Typed payloads serializer
+	payloads   map[string]payloadType // Typed payloads of commands
+	*sync.RWMutex
+}
+
+// NewSubscription creates and initializes Subscription object.
+func NewSubscription() *Subscription {
+	return &Subscription{
+		m:       make(map[string]map[ConnectionChannel]*subscriber),
+		RWMutex: new(sync.RWMutex),
+	}
+}
+
+// SubscribeCmd subscribes connection to command. The handler is executed by
+// ExecCmd and ExecConCmd methods and its result is pushed to the connection.
+// The handler may be nil if the connection should receive only data sent
+// directly to subscribers. Optional opts sets subscription options.
+//
+// The command may be a pattern, like "metrics.*", see path.Match for syntax.
+// Then ExecCmd and Broadcast of any matching command reach the connection,
+// the handler gets the matching command name and pushed messages contain
+// the pattern, see SubscriptionMessage. Patch option is not applied to
+// pattern subscriptions.
+func (s *Subscription) SubscribeCmd(con ConnectionChannel, command string,
+	handler SubscriptionHandler, opts ...SubscribeOptions) {
+
+	sub := &subscriber{handler: handler}
+	if IsPattern(command) {
+		sub.pattern = command
+	}
+	if len(opts) > 0 {
+		sub.SubscribeOptions = opts[0]
+	}
+
+	s.Lock()
+	cons, ok := s.m[command]
+	if !ok {
+		cons = make(map[ConnectionChannel]*subscriber)
+		s.m[command] = cons
+	}
+	_, exists := cons[con]
+	cons[con] = sub
+	s.publish(command)
+	count, presence := len(cons), s.presence
+	s.Unlock()
+
+	if presence && !exists {
+		s.presenceChanged(command, PresenceSubscribe, count)
+	}
+}
+
+// UnsubscribeCmd unsubscribes connection from command.
+func (s *Subscription) UnsubscribeCmd(con ConnectionChannel, command string) {
+	s.Lock()
+	count, ok := s.unsubscribe(con, command)
+	if ok {
+		s.publish(command)
+	}
+	presence := s.presence
+	s.Unlock()
+
+	if presence && ok {
+		s.presenceChanged(command, PresenceUnsubscribe, count)
+	}
+}
+
+// UnsubscribeAll unsubscribes connection from all commands. It should be
+// called when connection is closed.
+func (s *Subscription) UnsubscribeAll(con ConnectionChannel) {
+	s.Lock()
+	counts := make(map[string]int)
+	var commands []string
+	for command := range s.m {
+		if count, ok := s.unsubscribe(con, command); ok {
+			counts[command] = count
+			commands = append(commands, command)
+		}
+	}
+	s.publish(commands...)
+	presence := s.presence
+	s.Unlock()
+
+	s.dropConn(con)
+	if presence {
+		for command, count := range counts {
+			s.presenceChanged(command, PresenceUnsubscribe, count)
+		}
+	}
+}
+
+// dropConn drops acknowledgment, ordering and encryption state of
+// connection.
+func (s *Subscription) dropConn(con ConnectionChannel) {
+	s.dropPending(con)
+	s.dropOrder(con)
+	if e := s.encryption.Load(); e != nil {
+		e.Remove(con)
+	}
+}
+
+// unsubscribe removes connection from command subscribers. It returns number
+// of command subscribers left and true if the connection was subscribed. It
+// should be called under the Subscription lock.
+func (s *Subscription) unsubscribe(con ConnectionChannel, command string) (
+	int, bool) {
+
+	cons, ok := s.m[command]
+	if !ok {
+		return 0, false
+	}
+	if _, ok = cons[con]; !ok {
+		return len(cons), false
+	}
+	delete(cons, con)
+	if len(cons) == 0 {
+		delete(s.m, command)
+	}
+	return len(cons), true
+}
+
+// ExecCmd executes subscription handlers of all connections subscribed to
+// command and sends results to the connections. It returns joined errors of
+// failed handlers and sends. The number of concurrent executions and send
+// timeout are set by SetParallelism. Other nodes execute the command too if
+// backplane is set, see SetBackplane.
+func (s *Subscription) ExecCmd(command string) error {
+	return s.ExecCmdContext(context.Background(), command)
+}
+
+// ExecCmdContext executes subscription handlers like ExecCmd with handlers
+// context derived from ctx.
+func (s *Subscription) ExecCmdContext(ctx context.Context, command string) error {
+	return errors.Join(s.execCmd(ctx, command),
+		s.publishBackplane(backplaneExec, command, nil))
+}
+
+// execCmd executes subscription handlers of connections of this node.
+func (s *Subscription) execCmd(ctx context.Context, command string) error {
+	subs, parallelism := s.snapshot(command)
+
+	g := newPushGroup(parallelism, &s.pushWaiting)
+	g.queue(len(subs))
+	for _, sub := range subs {
+		if sub.handler == nil {
+			g.queue(-1)
+			continue
+		}
+		g.Go(func() error { return s.exec(ctx, sub.con, command, sub.subscriber) })
+	}
+	return g.Wait()
+}
+
+// ExecConCmd executes subscription handler of connection subscribed to
+// command and sends result to the connection.
+func (s *Subscription) ExecConCmd(con ConnectionChannel, command string) error {
+	return s.ExecConCmdContext(context.Background(), con, command)
+}
+
+// ExecConCmdContext executes subscription handler like ExecConCmd with
+// handler context derived from ctx.
+func (s *Subscription) ExecConCmdContext(ctx context.Context,
+	con ConnectionChannel, command string) error {
+
+	s.RLock()
+	sub, ok := s.m[command][con]
+	if !ok {
+		sub, ok = s.matchCon(con, command)
+	}
+	s.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("connection is not subscribed to command '%s'", command)
+	}
+	if sub.handler == nil {
+		return nil
+	}
+	return s.exec(ctx, con, command, sub)
+}
+
+// Broadcast sends data to all connections subscribed to command and adds it
+// to command history. It returns joined errors of failed sends. The number
+// of concurrent sends and send timeout are set by SetParallelism. The data
+// is sent to connections of other nodes too if backplane is set, see
+// SetBackplane.
+func (s *Subscription) Broadcast(command string, data []byte) error {
+	return errors.Join(s.broadcast(command, data),
+		s.publishBackplane(backplaneBroadcast, command, data))
+}
+
+// broadcast sends data to connections of this node.
+func (s *Subscription) broadcast(command string, data []byte) error {
+	s.addHistory(command, data)
+	subs, parallelism := s.snapshot(command)
+
+	g := newPushGroup(parallelism, &s.pushWaiting)
+	g.queue(len(subs))
+	for _, sub := range subs {
+		g.Go(func() error {
+			return s.push(sub.con, command, sub.subscriber,
+				func() ([]byte, error) { return data, nil })
+		})
+	}
+	return g.Wait()
+}
+
+// connSubscriber is a connection subscriber in subscribers snapshot.
+type connSubscriber struct {
+	con ConnectionChannel
+	*subscriber
+}
+
+// subscribersSnapshot is an immutable copy of commands subscribers. It is
+// replaced on every subscribers change, so broadcasts read subscribers
+// without the lock and don't contend with subscribe and unsubscribe churn.
+type subscribersSnapshot map[string][]connSubscriber
+
+// snapshot returns snapshot of command subscribers and pushes parallelism
+// limit. Subscription handlers and sends are executed on the snapshot
+// without the lock, so handlers may subscribe and unsubscribe connections.
+func (s *Subscription) snapshot(command string) ([]connSubscriber, int) {
+	parallelism := int(s.parallelism.Load())
+	snap := s.snap.Load()
+	if snap == nil {
+		return nil, parallelism
+	}
+	subs := (*snap)[command]
+	if patterns := s.patterns.Load(); patterns != nil {
+		subs = matchSubscribers(*snap, *patterns, command, subs)
+	}
+	return subs, parallelism
+}
+
+// publish replaces subscribers snapshot of changed commands. It should be
+// called under the Subscription lock.
+func (s *Subscription) publish(commands ...string) {
+	if len(commands) == 0 {
+		return
+	}
+
+	snap := make(subscribersSnapshot)
+	if old := s.snap.Load(); old != nil {
+		for command, subs := range *old {
+			snap[command] = subs
+		}
+	}
+	for _, command := range commands {
+		cons, ok := s.m[command]
+		if !ok {
+			delete(snap, command)
+			continue
+		}
+		subs := make([]connSubscriber, 0, len(cons))
+		for con, sub := range cons {
+			subs = append(subs, connSubscriber{con, sub})
+		}
+		snap[command] = subs
+	}
+	s.snap.Store(&snap)
+	s.publishPatterns(commands...)
+}
+
+// exec executes subscription handler and sends result to connection. The
+// handler context is created when the handler is executed, so throttled
+// pushes get full handler timeout too.
+func (s *Subscription) exec(ctx context.Context, con ConnectionChannel,
+	command string, sub *subscriber) error {
+
+	return s.push(con, command, sub, func() ([]byte, error) {
+		ctx, cancel := s.handlerContext(ctx)
+		defer cancel()
+		return s.handle(ctx, con, command, sub)
+	})
+}
+
+// send applies subscriber options to data and sends it to connection.
+func (s *Subscription) send(con ConnectionChannel, command string,
+	sub *subscriber, data []byte, err error) error {
+
+	if err == errSuppressed {
+		return nil
+	}
+	if sub.Filter != nil && err == nil {
+		var ok bool
+		if data, ok = sub.Filter(data); !ok {
+			return nil
+		}
+	}
+	if sub.pattern != "" {
+		return s.sendPattern(con, command, sub.pattern, data, err)
+	}
+	if sub.Patch && err == nil {
+		return s.sendPatch(con, command, sub, data)
+	}
+	return s.Send(con, command, data, err)
+}
+
+// Send sends command data or error to connection in SubscriptionMessage. In
+// acknowledgment mode the message gets sequence number and is redelivered
+// until client acknowledges it, see SetAck.
+func (s *Subscription) Send(con ConnectionChannel, command string, data []byte,
+	err error) error {
+
+	msg := SubscriptionMessage{Command: command, Data: data}
+	if err != nil {
+		msg.Err = err.Error()
+	}
+	_, err = s.sendMessage(con, msg)
+	return err
+}
+
+// sendMessage sets sequence number of message and sends it to connection.
+// It returns the message sequence number.
+func (s *Subscription) sendMessage(con ConnectionChannel,
+	msg SubscriptionMessage) (uint64, error) {
+
+	if !s.beginSend(con) {
+		return 0, nil
+	}
+	defer s.endSend(con)
+
+	if o, strict := s.connOrder(con); o != nil {
+		if strict {
+			o.send.Lock()
+			defer o.send.Unlock()
+		}
+		msg.CmdSeq = o.next(msg.Command)
+	}
+	msg.Seq = s.nextSeq(con)
+	out, err := json.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+	if out, err = s.seal(con, out); err != nil {
+		return 0, err
+	}
+	if msg.Seq != 0 {
+		if paused, err := s.addPending(con, msg.Seq, out); paused {
+			return msg.Seq, err
+		}
+	}
+
+	start := time.Now()
+	err = s.sendConn(con, out)
+	s.counters.count(time.Since(start), err)
+
+	return msg.Seq, err
+}
+
+// AddCommands adds subscribe, unsubscribe, resume and ack commands to
+// commands map and attaches the subscription to Commands object. The subscribe command
+// subscribes request connection to command, and each ExecCmd of the command
+// executes it with the subscribe request and pushes result to the
+// connection. The unsubscribe command unsubscribes request connection from
+// command. The resume command attaches request connection to client session,
+// see the Resume method. The subscribe and resume commands return session
+// token if session tokens are set, see SetSessionTokens. The ack command
+// acknowledges pushed message, see the SetAck method.
+func (s *Subscription) AddCommands(c *Commands, processIn ProcessIn) {
+	c.SetSubscription(s)
+
+	// connection returns request connection channel and checks command
+	// parameter
+	connection := func(indata any) (ConnectionChannel, string, error) {
+		req, err := c.Request(indata)
+		if err != nil {
+			return nil, "", err
+		}
+		con := req.GetConnectionChannel()
+		if con == nil {
+			return nil, "", ErrNoConnectionChannel
+		}
+		command := req.GetVars()["command"]
+		if _, ok := c.Get(command); !ok && command != PresenceCommand &&
+			!IsPattern(command) {
+			return nil, "", fmt.Errorf("command '%s': %w", command,
+				ErrCommandNotFound)
+		}
+		return con, command, nil
+	}
+
+	c.Add("subscribe", "Subscribe to command.", processIn, "{command}",
+		"ok or error", "subscribe/hello", "ok",
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			con, command, err := connection(indata)
+			if err != nil {
+				return nil, err
+			}
+			s.SubscribeCmd(con, command, func(ctx context.Context,
+				con ConnectionChannel, command string) ([]byte, error) {
+
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				return c.Exec(command, processIn, indata)
+			})
+			if token, err := s.sessionToken(con); err != nil || token != nil {
+				return token, err
+			}
+			return []byte("ok"), nil
+		},
+	)
+
+	c.Add("unsubscribe", "Unsubscribe from command.", processIn, "{command}",
+		"ok or error", "unsubscribe/hello", "ok",
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			con, command, err := connection(indata)
+			if err != nil {
+				return nil, err
+			}
+			s.UnsubscribeCmd(con, command)
+			return []byte("ok"), nil
+		},
+	)
+
+	s.addAckCommand(c, processIn)
+
+	c.Add("resume", "Attach connection to session and resume its subscriptions.",
+		processIn, "{session}", "ok or error", "resume/a1b2c3", "ok",
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			req, err := c.Request(indata)
+			if err != nil {
+				return nil, err
+			}
+			con := req.GetConnectionChannel()
+			if con == nil {
+				return nil, ErrNoConnectionChannel
+			}
+			session := req.GetVars()["session"]
+			s.RLock()
+			tokens := s.tokens
+			s.RUnlock()
+			if tokens != nil {
+				tok, err := tokens.Validate(session)
+				if err != nil {
+					return nil, err
+				}
+				session = tok.Session
+			}
+			if _, err = s.Resume(con, session); err != nil {
+				return nil, err
+			}
+			if tokens != nil {
+				return s.sessionToken(con)
+			}
+			return []byte("ok"), nil
+		},
+	)
+}
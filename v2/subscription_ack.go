@@ -0,0 +1,182 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription acknowledgment module of Command processing golang package.
+
+package command
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// subscriptionAcks keeps pushed messages waiting for client acknowledgment.
+type subscriptionAcks struct {
+	m       map[ConnectionChannel]map[uint64]*pendingMessage
+	seq     uint64        // Last message sequence number
+	timeout time.Duration // Acknowledgment timeout
+	retries int           // Max number of redeliveries
+
+	credits  int                                   // Flow control credits
+	queueMax int                                   // Paused messages limit
+	queue    map[ConnectionChannel][]queuedMessage // Paused messages
+	sync.Mutex
+}
+
+// pendingMessage is a pushed message waiting for acknowledgment.
+type pendingMessage struct {
+	data     []byte
+	attempts int
+	timer    *time.Timer
+}
+
+// SetAck turns acknowledgment mode on when timeout is greater than zero.
+// In this mode pushed messages carry sequence numbers and clients should
+// acknowledge them with the ack command or Ack method. Message which is not
+// acknowledged during timeout is redelivered up to retries times.
+func (s *Subscription) SetAck(timeout time.Duration, retries int) {
+	s.acks.Lock()
+	defer s.acks.Unlock()
+
+	s.acks.timeout, s.acks.retries = timeout, retries
+	if timeout > 0 && s.acks.m == nil {
+		s.acks.m = make(map[ConnectionChannel]map[uint64]*pendingMessage)
+	}
+}
+
+// Ack acknowledges message with sequence number seq sent to connection.
+func (s *Subscription) Ack(con ConnectionChannel, seq uint64) {
+	s.acks.Lock()
+	p, ok := s.acks.m[con][seq]
+	if ok {
+		p.timer.Stop()
+		s.deletePending(con, seq)
+	}
+	s.acks.Unlock()
+
+	if ok {
+		s.ackPatch(con, seq)
+		s.resume(con)
+	}
+}
+
+// nextSeq returns next message sequence number or zero if acknowledgment
+// mode is off.
+func (s *Subscription) nextSeq(con ConnectionChannel) uint64 {
+	if _, ok := con.(*sessionChannel); ok {
+		return 0
+	}
+
+	s.acks.Lock()
+	defer s.acks.Unlock()
+
+	if s.acks.timeout <= 0 {
+		return 0
+	}
+	s.acks.seq++
+	return s.acks.seq
+}
+
+// addPending adds message sent to connection to pending messages and starts
+// its redelivery timer. It returns true if the message is paused by flow
+// control and should not be sent now, see SetCredits.
+func (s *Subscription) addPending(con ConnectionChannel, seq uint64,
+	data []byte) (bool, error) {
+
+	s.acks.Lock()
+	defer s.acks.Unlock()
+
+	if paused, err := s.hold(con, seq, data); paused {
+		return true, err
+	}
+	s.startPending(con, seq, data)
+	return false, nil
+}
+
+// startPending adds message to pending messages and starts its redelivery
+// timer. It should be called under the acks lock.
+func (s *Subscription) startPending(con ConnectionChannel, seq uint64,
+	data []byte) {
+
+	msgs, ok := s.acks.m[con]
+	if !ok {
+		msgs = make(map[uint64]*pendingMessage)
+		s.acks.m[con] = msgs
+	}
+	p := &pendingMessage{data: data}
+	p.timer = time.AfterFunc(s.acks.timeout, func() { s.redeliver(con, seq) })
+	msgs[seq] = p
+}
+
+// redeliver resends not acknowledged message or drops it when number of
+// redeliveries exceeds the limit. Messages are resent with send timeout and
+// are not resent to draining connections.
+func (s *Subscription) redeliver(con ConnectionChannel, seq uint64) {
+	s.acks.Lock()
+	p, ok := s.acks.m[con][seq]
+	if !ok {
+		s.acks.Unlock()
+		return
+	}
+	p.attempts++
+	if p.attempts > s.acks.retries {
+		s.deletePending(con, seq)
+		s.acks.Unlock()
+		s.resume(con)
+		return
+	}
+	p.timer.Reset(s.acks.timeout)
+	s.acks.Unlock()
+
+	if !s.beginSend(con) {
+		return
+	}
+	defer s.endSend(con)
+	s.sendConn(con, p.data)
+}
+
+// dropPending drops all pending messages of connection.
+func (s *Subscription) dropPending(con ConnectionChannel) {
+	s.acks.Lock()
+	defer s.acks.Unlock()
+
+	for seq, p := range s.acks.m[con] {
+		p.timer.Stop()
+		s.deletePending(con, seq)
+	}
+	delete(s.acks.queue, con)
+}
+
+// deletePending deletes pending message. It should be called under the acks
+// lock.
+func (s *Subscription) deletePending(con ConnectionChannel, seq uint64) {
+	delete(s.acks.m[con], seq)
+	if len(s.acks.m[con]) == 0 {
+		delete(s.acks.m, con)
+	}
+}
+
+// addAckCommand adds ack command to commands map.
+func (s *Subscription) addAckCommand(c *Commands, processIn ProcessIn) {
+	c.Add("ack", "Acknowledge subscription message.", processIn, "{seq}",
+		"ok or error", "ack/12", "ok",
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			req, err := c.Request(indata)
+			if err != nil {
+				return nil, err
+			}
+			con := req.GetConnectionChannel()
+			if con == nil {
+				return nil, ErrNoConnectionChannel
+			}
+			seq, err := strconv.ParseUint(req.GetVars()["seq"], 10, 64)
+			if err != nil {
+				return nil, ErrIncorrectInputData
+			}
+			s.Ack(con, seq)
+			return []byte("ok"), nil
+		},
+	)
+}
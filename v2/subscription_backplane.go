@@ -0,0 +1,120 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription backplane module of Command processing golang package.
+
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+)
+
+// Backplane is a cluster pub/sub transport, like Redis pub/sub or NATS,
+// which delivers subscription pushes to all nodes. A Redis implementation
+// publishes messages with PUBLISH to one channel and calls receive for each
+// message of SUBSCRIBE to this channel.
+type Backplane interface {
+	// Publish publishes message to all nodes.
+	Publish(msg []byte) error
+
+	// Subscribe sets function called for each message published by any
+	// node, including this one.
+	Subscribe(receive func(msg []byte)) error
+}
+
+// Backplane message operations.
+const (
+	backplaneExec      = "exec"
+	backplaneBroadcast = "broadcast"
+)
+
+// backplaneMessage is a message published to backplane.
+type backplaneMessage struct {
+	Node    string `json:"node"`           // Publisher node
+	Op      string `json:"op"`             // Operation
+	Command string `json:"command"`        // Command name
+	Data    []byte `json:"data,omitempty"` // Broadcast data
+}
+
+// SetBackplane connects subscription to cluster backplane. After that
+// ExecCmd and Broadcast on any node reach subscribers connected to all nodes.
+// The node is unique name of this node used to skip own messages.
+func (s *Subscription) SetBackplane(b Backplane, node string) error {
+	s.Lock()
+	s.backplane, s.node = b, node
+	s.Unlock()
+
+	return b.Subscribe(func(data []byte) {
+		var msg backplaneMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Println("wrong backplane message:", err)
+			return
+		}
+		if msg.Node == node {
+			return
+		}
+		switch msg.Op {
+		case backplaneExec:
+			s.execCmd(context.Background(), msg.Command)
+		case backplaneBroadcast:
+			s.broadcast(msg.Command, msg.Data)
+		}
+	})
+}
+
+// publishBackplane publishes operation to backplane if it is set.
+func (s *Subscription) publishBackplane(op, command string, data []byte) error {
+	s.RLock()
+	b, node := s.backplane, s.node
+	s.RUnlock()
+	if b == nil {
+		return nil
+	}
+
+	msg, err := json.Marshal(backplaneMessage{Node: node, Op: op,
+		Command: command, Data: data})
+	if err != nil {
+		return err
+	}
+	return b.Publish(msg)
+}
+
+// MemoryBackplane is in-process Backplane. It connects subscriptions of one
+// process and is used in tests and single process clusters.
+type MemoryBackplane struct {
+	receivers []func(msg []byte)
+	*sync.RWMutex
+}
+
+// NewMemoryBackplane creates new in-process Backplane.
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{RWMutex: new(sync.RWMutex)}
+}
+
+// Publish calls all subscribed receivers.
+func (b *MemoryBackplane) Publish(msg []byte) error {
+	b.RLock()
+	receivers := b.receivers
+	b.RUnlock()
+
+	for _, receive := range receivers {
+		receive(msg)
+	}
+	return nil
+}
+
+// Subscribe adds receiver.
+func (b *MemoryBackplane) Subscribe(receive func(msg []byte)) error {
+	if receive == nil {
+		return errors.New("nil receiver")
+	}
+	b.Lock()
+	b.receivers = append(b.receivers, receive)
+	b.Unlock()
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription compression module of Command processing golang package.
+
+package command
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// gzipWriters is a pool of gzip writers.
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// GzipChannel is a connection channel which gzips data larger than Threshold
+// bytes before sending it to the wrapped channel. It is used for transports
+// without built-in compression, like WebRTC data channels or TRU, when client
+// supports compressed pushes. The client detects compressed data by gzip
+// header, see Decompress. One GzipChannel should be created per connection
+// and used both to subscribe and to unsubscribe the connection.
+type GzipChannel struct {
+	ConnectionChannel     // Wrapped connection channel
+	Threshold         int // Minimal size of compressed data
+}
+
+// NewGzipChannel creates GzipChannel which compresses data larger than
+// threshold bytes and sends it to ch.
+func NewGzipChannel(ch ConnectionChannel, threshold int) *GzipChannel {
+	return &GzipChannel{ConnectionChannel: ch, Threshold: threshold}
+}
+
+// Send compresses data if it is larger than threshold and sends it to the
+// wrapped channel.
+func (ch *GzipChannel) Send(data []byte) error {
+	if len(data) <= ch.Threshold {
+		return ch.ConnectionChannel.Send(data)
+	}
+
+	var buf bytes.Buffer
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return ch.ConnectionChannel.Send(buf.Bytes())
+}
+
+// Decompress returns uncompressed data received from GzipChannel. Data
+// without gzip header is returned as is.
+func Decompress(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
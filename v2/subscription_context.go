@@ -0,0 +1,48 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription handlers context module of Command processing golang package.
+
+package command
+
+import (
+	"context"
+	"time"
+)
+
+// SubscriptionHandlerV1 is a subscription handler without context and
+// connection channel used before SubscriptionHandler got them.
+type SubscriptionHandlerV1 func(command string) ([]byte, error)
+
+// WrapSubscriptionHandler returns SubscriptionHandler which calls handler h
+// without context and connection channel. It returns nil if h is nil.
+func WrapSubscriptionHandler(h SubscriptionHandlerV1) SubscriptionHandler {
+	if h == nil {
+		return nil
+	}
+	return func(ctx context.Context, con ConnectionChannel, command string) (
+		[]byte, error) {
+
+		return h(command)
+	}
+}
+
+// SetHandlerTimeout sets timeout of subscription handlers executed by
+// ExecCmd and ExecConCmd. The handler context is canceled when the timeout
+// expires, so periodic push handlers may stop long queries. Handlers have no
+// timeout if timeout is 0.
+func (s *Subscription) SetHandlerTimeout(timeout time.Duration) {
+	s.handlerTimeout.Store(int64(timeout))
+}
+
+// handlerContext returns subscription handler context derived from ctx with
+// handler timeout.
+func (s *Subscription) handlerContext(ctx context.Context) (context.Context,
+	context.CancelFunc) {
+
+	if timeout := time.Duration(s.handlerTimeout.Load()); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
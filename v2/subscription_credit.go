@@ -0,0 +1,83 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription flow control module of Command processing golang package.
+
+package command
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrNoCredits is an error returned when connection has no flow control
+// credits and its queue of paused messages is full.
+var ErrNoCredits = fmt.Errorf("connection has no flow control credits")
+
+// queuedMessage is a message paused until connection gets credit.
+type queuedMessage struct {
+	seq  uint64
+	data []byte
+}
+
+// SetCredits turns credit-based flow control on when credits is greater than
+// zero. Connection may have up to credits not acknowledged messages, next
+// pushes are paused in connection queue and sent when client acknowledges
+// messages. Pushes return ErrNoCredits when the queue has queue messages.
+// Flow control protects constrained connections, like WebRTC data channels,
+// and works in acknowledgment mode only, see SetAck.
+func (s *Subscription) SetCredits(credits, queue int) {
+	s.acks.Lock()
+	defer s.acks.Unlock()
+
+	s.acks.credits, s.acks.queueMax = credits, queue
+	if credits > 0 && s.acks.queue == nil {
+		s.acks.queue = make(map[ConnectionChannel][]queuedMessage)
+	}
+}
+
+// hold adds message to connection queue if the connection has no credits or
+// already has paused messages. It returns true if message is paused. It
+// should be called under the acks lock.
+func (s *Subscription) hold(con ConnectionChannel, seq uint64,
+	data []byte) (bool, error) {
+
+	if s.acks.credits <= 0 {
+		return false, nil
+	}
+	queue := s.acks.queue[con]
+	if len(queue) == 0 && len(s.acks.m[con]) < s.acks.credits {
+		return false, nil
+	}
+	if len(queue) >= s.acks.queueMax {
+		return true, ErrNoCredits
+	}
+	s.acks.queue[con] = append(queue, queuedMessage{seq, data})
+	return true, nil
+}
+
+// resume sends paused messages of connection while it has credits.
+func (s *Subscription) resume(con ConnectionChannel) {
+	s.acks.Lock()
+	var msgs []queuedMessage
+	for queue := s.acks.queue[con]; len(queue) > 0 &&
+		len(s.acks.m[con]) < s.acks.credits; queue = queue[1:] {
+
+		msg := queue[0]
+		s.startPending(con, msg.seq, msg.data)
+		msgs = append(msgs, msg)
+		if len(queue) == 1 {
+			delete(s.acks.queue, con)
+		} else {
+			s.acks.queue[con] = queue[1:]
+		}
+	}
+	s.acks.Unlock()
+
+	for _, msg := range msgs {
+		start := time.Now()
+		err := s.sendConn(con, msg.data)
+		s.counters.count(time.Since(start), err)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription connections draining module of Command processing golang
+// package.
+
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// DrainCommand is a control message command sent by Drain before connection
+// is closed. Clients receiving it should reconnect, usually to other server.
+const DrainCommand = "drain"
+
+// subscriptionDrain tracks in-flight sends of connections and draining
+// connections.
+type subscriptionDrain struct {
+	m map[ConnectionChannel]*connDrain
+	sync.Mutex
+}
+
+// connDrain is a connection sends state.
+type connDrain struct {
+	inFlight int
+	draining bool
+	idle     chan struct{} // Closed when draining connection has no sends
+}
+
+// Drain gracefully closes connection: it stops delivering new pushes to the
+// connection, waits up to timeout for in-flight sends, sends DrainCommand
+// control message, unsubscribes the connection from all commands and closes
+// it if the connection has Close method. It is used for rolling restarts.
+// Drain returns ErrSendTimeout if in-flight sends were not completed during
+// timeout, the connection is closed anyway.
+func (s *Subscription) Drain(con ConnectionChannel, timeout time.Duration) error {
+
+	// Stop new pushes
+	s.drain.Lock()
+	if s.drain.m == nil {
+		s.drain.m = make(map[ConnectionChannel]*connDrain)
+	}
+	d, ok := s.drain.m[con]
+	if !ok {
+		d = &connDrain{}
+		s.drain.m[con] = d
+	}
+	if d.draining {
+		s.drain.Unlock()
+		return fmt.Errorf("connection is already draining")
+	}
+	d.draining, d.idle = true, make(chan struct{})
+	if d.inFlight == 0 {
+		close(d.idle)
+	}
+	s.drain.Unlock()
+
+	// Wait for in-flight sends
+	var errs []error
+	timer := time.NewTimer(timeout)
+	select {
+	case <-d.idle:
+	case <-timer.C:
+		errs = append(errs, fmt.Errorf("drain in-flight sends: %w", ErrSendTimeout))
+	}
+	timer.Stop()
+
+	// Notify client
+	if err := s.sendControl(con, SubscriptionMessage{Command: DrainCommand}); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Unsubscribe and close connection
+	s.UnsubscribeAll(con)
+	if closer, ok := con.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	s.drain.Lock()
+	delete(s.drain.m, con)
+	s.drain.Unlock()
+
+	return errors.Join(errs...)
+}
+
+// sendControl sends control message to connection without sequence number.
+func (s *Subscription) sendControl(con ConnectionChannel,
+	msg SubscriptionMessage) error {
+
+	out, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if out, err = s.seal(con, out); err != nil {
+		return err
+	}
+	return s.sendConn(con, out)
+}
+
+// beginSend counts connection send in flight. It returns false if the
+// connection is draining and the send should be skipped. The endSend should
+// be called when send started successfully is finished.
+func (s *Subscription) beginSend(con ConnectionChannel) bool {
+	s.drain.Lock()
+	defer s.drain.Unlock()
+
+	d, ok := s.drain.m[con]
+	if ok && d.draining {
+		return false
+	}
+	if !ok {
+		if s.drain.m == nil {
+			s.drain.m = make(map[ConnectionChannel]*connDrain)
+		}
+		d = &connDrain{}
+		s.drain.m[con] = d
+	}
+	d.inFlight++
+	return true
+}
+
+// endSend finishes connection send in flight.
+func (s *Subscription) endSend(con ConnectionChannel) {
+	s.drain.Lock()
+	defer s.drain.Unlock()
+
+	d, ok := s.drain.m[con]
+	if !ok {
+		return
+	}
+	if d.inFlight--; d.inFlight > 0 {
+		return
+	}
+	if d.draining {
+		close(d.idle)
+		return
+	}
+	delete(s.drain.m, con)
+}
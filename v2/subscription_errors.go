@@ -0,0 +1,97 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription handler errors module of Command processing golang package.
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// errSuppressed is returned by subscription handler wrapper when handler
+// error should not be pushed to connection.
+var errSuppressed = fmt.Errorf("subscription error suppressed")
+
+// ErrorAction is an action applied to subscription handler error.
+type ErrorAction int
+
+// Subscription handler error actions.
+const (
+	ErrorSend     ErrorAction = iota // Push error to connection
+	ErrorSuppress                    // Skip push
+	ErrorRetry                       // Retry handler, then push error
+)
+
+// ErrorPolicy defines what is done when subscription handler returns error.
+type ErrorPolicy struct {
+	// Action is an action applied to handler error, ErrorSend by default.
+	Action ErrorAction
+
+	// Retries is a number of handler retries of ErrorRetry action.
+	Retries int
+
+	// Backoff is a delay before first retry, doubled on every next retry.
+	Backoff time.Duration
+
+	// MaxFailures unsubscribes connection from command after number of
+	// consecutive handler failures. Connection is never unsubscribed if
+	// MaxFailures is 0.
+	MaxFailures int
+}
+
+// SetErrorPolicy sets default error policy of subscription handlers. It is
+// used by subscriptions without ErrorPolicy option, including subscriptions
+// made by the subscribe command. Handler errors are pushed to connections
+// if policy is nil.
+func (s *Subscription) SetErrorPolicy(policy *ErrorPolicy) {
+	s.errorPolicy.Store(policy)
+}
+
+// handle executes subscription handler applying subscriber error policy.
+// It returns errSuppressed if the error should not be pushed.
+func (s *Subscription) handle(ctx context.Context, con ConnectionChannel,
+	command string, sub *subscriber) ([]byte, error) {
+
+	policy := sub.ErrorPolicy
+	if policy == nil {
+		policy = s.errorPolicy.Load()
+	}
+	data, err := sub.handler(ctx, con, command)
+	if policy == nil {
+		return data, err
+	}
+
+	// Retry with backoff
+	if err != nil && policy.Action == ErrorRetry {
+		delay := policy.Backoff
+		for i := 0; i < policy.Retries && err != nil; i++ {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+			data, err = sub.handler(ctx, con, command)
+		}
+	}
+
+	if err == nil {
+		sub.failures.Store(0)
+		return data, nil
+	}
+
+	// Unsubscribe after max failures
+	if n := sub.failures.Add(1); policy.MaxFailures > 0 &&
+		int(n) >= policy.MaxFailures {
+		s.UnsubscribeCmd(con, sub.key(command))
+	}
+
+	if policy.Action == ErrorSuppress {
+		return nil, errSuppressed
+	}
+	return nil, err
+}
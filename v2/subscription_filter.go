@@ -0,0 +1,83 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription filter module of Command processing golang package.
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SubscriptionFilter is a function that is applied to data pushed to
+// subscribed connection. It returns data to push and false if nothing should
+// be pushed.
+type SubscriptionFilter func(data []byte) ([]byte, bool)
+
+// FilterExpr creates SubscriptionFilter from expression "field=value" or
+// "field!=value". The field may be a dot separated path to nested field,
+// like "user.id". The filter is applied to json data: a json object is pushed
+// if it matches the expression, matching items are pushed from a json array
+// and nothing is pushed if no items match. Data which is not json object or
+// array is never pushed.
+func FilterExpr(expr string) (SubscriptionFilter, error) {
+
+	// Parse expression
+	op := "="
+	field, value, ok := strings.Cut(expr, "!=")
+	if ok {
+		op = "!="
+	} else if field, value, ok = strings.Cut(expr, "="); !ok {
+		return nil, fmt.Errorf("wrong filter expression '%s'", expr)
+	}
+	field = strings.TrimSpace(field)
+	if field == "" {
+		return nil, fmt.Errorf("empty field in filter expression '%s'", expr)
+	}
+	path := strings.Split(field, ".")
+	value = strings.TrimSpace(value)
+
+	// match checks json item by expression
+	match := func(item any) bool {
+		for _, name := range path {
+			obj, ok := item.(map[string]any)
+			if !ok {
+				return false
+			}
+			if item, ok = obj[name]; !ok {
+				return op == "!="
+			}
+		}
+		return (fmt.Sprint(item) == value) == (op == "=")
+	}
+
+	return func(data []byte) ([]byte, bool) {
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, false
+		}
+
+		switch v := v.(type) {
+		case map[string]any:
+			return data, match(v)
+
+		case []any:
+			items := make([]any, 0, len(v))
+			for _, item := range v {
+				if match(item) {
+					items = append(items, item)
+				}
+			}
+			if len(items) == 0 {
+				return nil, false
+			}
+			out, err := json.Marshal(items)
+			return out, err == nil
+		}
+
+		return nil, false
+	}, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription pushes concurrency module of Command processing golang package.
+
+package command
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSendTimeout is an error returned when connection send exceeds send
+// timeout.
+var ErrSendTimeout = fmt.Errorf("send timeout")
+
+// SetParallelism sets maximum number of concurrent pushes of ExecCmd and
+// Broadcast and connection send timeout. The number of concurrent pushes is
+// not limited if limit is 0, and sends have no timeout if timeout is 0.
+// Sends which exceed timeout return ErrSendTimeout, while the connection
+// Send call itself is left to finish in background.
+func (s *Subscription) SetParallelism(limit int, timeout time.Duration) {
+	s.parallelism.Store(int32(limit))
+	s.sendTimeout.Store(int64(timeout))
+}
+
+// pushGroup runs pushes in goroutines with limited parallelism and collects
+// their errors.
+type pushGroup struct {
+	sem     chan struct{}
+	waiting *atomic.Int64 // Pushes waiting for free goroutine
+	wg      sync.WaitGroup
+	errs    []error
+	mut     sync.Mutex
+}
+
+// newPushGroup creates push group with parallelism limit, not limited if
+// limit is 0. The waiting counts pushes waiting for free goroutine.
+func newPushGroup(limit int, waiting *atomic.Int64) *pushGroup {
+	g := &pushGroup{waiting: waiting}
+	if limit > 0 {
+		g.sem = make(chan struct{}, limit)
+	}
+	return g
+}
+
+// queue counts n pushes waiting for free goroutine, Go uncounts push when
+// it starts.
+func (g *pushGroup) queue(n int) {
+	g.waiting.Add(int64(n))
+}
+
+// Go runs f in goroutine when number of running goroutines is below the
+// limit.
+func (g *pushGroup) Go(f func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.waiting.Add(-1)
+	g.wg.Add(1)
+	Go(GoBroadcast, func() {
+		defer func() {
+			if g.sem != nil {
+				<-g.sem
+			}
+			g.wg.Done()
+		}()
+		if err := f(); err != nil {
+			g.mut.Lock()
+			g.errs = append(g.errs, err)
+			g.mut.Unlock()
+		}
+	})
+}
+
+// Wait waits for all goroutines and returns joined errors.
+func (g *pushGroup) Wait() error {
+	g.wg.Wait()
+	return errors.Join(g.errs...)
+}
+
+// sendConn sends data to connection with send timeout.
+func (s *Subscription) sendConn(con ConnectionChannel, data []byte) error {
+	timeout := time.Duration(s.sendTimeout.Load())
+	if timeout <= 0 {
+		return con.Send(data)
+	}
+
+	done := make(chan error, 1)
+	Go(GoSend, func() { done <- con.Send(data) })
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return ErrSendTimeout
+	}
+}
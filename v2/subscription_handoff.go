@@ -0,0 +1,244 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription sessions handoff module of Command processing golang package.
+
+package command
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrSessionToken is an error returned when session token is malformed,
+	// has wrong signature or is expired.
+	ErrSessionToken = fmt.Errorf("wrong session token")
+
+	// ErrWrongNode is an error returned when session token was issued by
+	// another node. Client should reconnect to the token node or the session
+	// should be handed off to this node.
+	ErrWrongNode = fmt.Errorf("session belongs to another node")
+
+	// ErrSessionNotFound is an error returned when session is not found.
+	ErrSessionNotFound = fmt.Errorf("session not found")
+)
+
+// DefaultSessionTokenTTL is a default lifetime of session tokens returned by
+// the subscribe and resume commands.
+const DefaultSessionTokenTTL = 24 * time.Hour
+
+// SessionToken is a signed session token payload.
+type SessionToken struct {
+	Session string    `json:"session"` // Session ID
+	Node    string    `json:"node"`    // Serving node
+	Expires time.Time `json:"expires"` // Token expiration time
+}
+
+// SessionTokens issues and validates session tokens signed with HMAC-SHA256.
+// The token encodes node which serves the session, so load balancer or
+// client can route resumed connection to the node keeping session
+// subscriptions. All nodes should use the same key.
+type SessionTokens struct {
+	Node string        // This node name
+	TTL  time.Duration // Commands tokens lifetime, DefaultSessionTokenTTL if 0
+	key  []byte
+}
+
+// NewSessionTokens creates session tokens of node signed with key.
+func NewSessionTokens(node string, key []byte) *SessionTokens {
+	return &SessionTokens{Node: node, key: key}
+}
+
+// Issue returns token of session served by this node valid for ttl.
+func (t *SessionTokens) Issue(session string, ttl time.Duration) (string, error) {
+	payload, err := json.Marshal(SessionToken{Session: session, Node: t.Node,
+		Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." +
+		enc.EncodeToString(hmacSum(t.key, payload)), nil
+}
+
+// Parse checks token signature and expiration time and returns token
+// payload. The token may be issued by any node.
+func (t *SessionTokens) Parse(token string) (tok SessionToken, err error) {
+	enc := base64.RawURLEncoding
+	p, s, ok := strings.Cut(token, ".")
+	if !ok {
+		err = fmt.Errorf("no signature: %w", ErrSessionToken)
+		return
+	}
+	payload, err1 := enc.DecodeString(p)
+	sig, err2 := enc.DecodeString(s)
+	if err1 != nil || err2 != nil {
+		err = fmt.Errorf("wrong encoding: %w", ErrSessionToken)
+		return
+	}
+	if !hmac.Equal(sig, hmacSum(t.key, payload)) {
+		err = fmt.Errorf("wrong signature: %w", ErrSessionToken)
+		return
+	}
+	if err = json.Unmarshal(payload, &tok); err != nil {
+		err = fmt.Errorf("wrong payload: %w", ErrSessionToken)
+		return
+	}
+	if time.Now().After(tok.Expires) {
+		err = fmt.Errorf("token expired: %w", ErrSessionToken)
+	}
+	return
+}
+
+// Validate parses token and checks that the session is served by this node.
+// It returns ErrWrongNode if the token was issued by another node.
+func (t *SessionTokens) Validate(token string) (SessionToken, error) {
+	tok, err := t.Parse(token)
+	if err == nil && tok.Node != t.Node {
+		err = fmt.Errorf("node '%s': %w", tok.Node, ErrWrongNode)
+	}
+	return tok, err
+}
+
+// SetSessionTokens sets session tokens. When it is set, the subscribe
+// command attaches connection without session to new session and returns
+// session token instead of "ok", the resume command accepts session token
+// instead of session ID, rejects tokens of other nodes with ErrWrongNode and
+// returns refreshed session token.
+func (s *Subscription) SetSessionTokens(tokens *SessionTokens) {
+	s.Lock()
+	s.tokens = tokens
+	s.Unlock()
+}
+
+// sessionToken returns session token of session attached to connection, the
+// connection is attached to new session if it has no session. It returns nil
+// if session tokens are not set.
+func (s *Subscription) sessionToken(con ConnectionChannel) ([]byte, error) {
+	s.Lock()
+	tokens := s.tokens
+	if tokens == nil {
+		s.Unlock()
+		return nil, nil
+	}
+	session, sess := s.conSession(con)
+	if sess == nil {
+		b := make([]byte, 16)
+		rand.Read(b)
+		session = hex.EncodeToString(b)
+		if s.sessions == nil {
+			s.sessions = make(map[string]*subscriptionSession)
+		}
+		s.sessions[session] = &subscriptionSession{con: con}
+	}
+	s.Unlock()
+
+	ttl := tokens.TTL
+	if ttl <= 0 {
+		ttl = DefaultSessionTokenTTL
+	}
+	token, err := tokens.Issue(session, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(token), nil
+}
+
+// SessionState is a handoff state of suspended session. It is json encoded
+// and transferred from node which served the session to new node.
+type SessionState struct {
+	Session  string   `json:"session"`            // Session ID
+	Commands []string `json:"commands"`           // Subscribed commands
+	Messages [][]byte `json:"messages,omitempty"` // Buffered messages
+}
+
+// ExportSession removes suspended session from this node and returns its
+// state to hand it off to another node, see ImportSession. It returns
+// ErrSessionInUse if the session is attached to connection.
+func (s *Subscription) ExportSession(session string) (SessionState, error) {
+	s.Lock()
+	sess, ok := s.sessions[session]
+	switch {
+	case !ok:
+		s.Unlock()
+		return SessionState{}, fmt.Errorf("session '%s': %w", session,
+			ErrSessionNotFound)
+	case sess.ch == nil:
+		s.Unlock()
+		return SessionState{}, fmt.Errorf("session '%s': %w", session,
+			ErrSessionInUse)
+	}
+	sess.timer.Stop()
+	delete(s.sessions, session)
+	ch := sess.ch
+	state := SessionState{Session: session}
+	for command, cons := range s.m {
+		if _, ok := cons[ch]; ok {
+			state.Commands = append(state.Commands, command)
+		}
+	}
+	s.Unlock()
+
+	ch.Lock()
+	state.Messages = ch.messages
+	ch.Unlock()
+
+	s.UnsubscribeAll(ch)
+	return state, nil
+}
+
+// ImportSession creates suspended session from state exported by another
+// node. Client resumes it with the resume command or Resume method during
+// resume grace period, see SetResume. The handler function returns
+// subscription handler of command and may be nil, or return nil handler, if
+// subscriptions receive only broadcasts.
+func (s *Subscription) ImportSession(state SessionState,
+	handler func(command string) SubscriptionHandler) error {
+
+	if state.Session == "" {
+		return fmt.Errorf("empty session id")
+	}
+
+	s.Lock()
+	if _, ok := s.sessions[state.Session]; ok {
+		s.Unlock()
+		return fmt.Errorf("session '%s': %w", state.Session, ErrSessionInUse)
+	}
+	if s.resumeGrace <= 0 {
+		s.Unlock()
+		return fmt.Errorf("resume sessions are off")
+	}
+	ch := &sessionChannel{max: s.resumeReplay}
+	if n := len(state.Messages) - ch.max; n > 0 {
+		state.Messages = state.Messages[n:]
+	}
+	ch.messages = state.Messages
+	s.Unlock()
+
+	for _, command := range state.Commands {
+		var h SubscriptionHandler
+		if handler != nil {
+			h = handler(command)
+		}
+		s.SubscribeCmd(ch, command, h)
+	}
+
+	s.Lock()
+	if s.sessions == nil {
+		s.sessions = make(map[string]*subscriptionSession)
+	}
+	sess := &subscriptionSession{}
+	s.sessions[state.Session] = sess
+	s.suspend(state.Session, sess, ch)
+	s.Unlock()
+
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription backlog health module of Command processing golang package.
+
+package command
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Backlog is a subscription pushes backlog.
+type Backlog struct {
+	ConnSends int `json:"conn_sends"` // Maximum sends in flight of one connection
+	ConnQueue int `json:"conn_queue"` // Maximum queued messages of one connection
+	Sends     int `json:"sends"`      // Sends in flight of all connections
+	Queued    int `json:"queued"`     // Queued messages of all connections
+	Workers   int `json:"workers"`    // Pushes waiting for push worker
+}
+
+// BacklogThresholds are subscription backlog thresholds, the backlog is
+// not checked if threshold is 0.
+type BacklogThresholds struct {
+	ConnSends int // Maximum sends in flight of one connection
+	ConnQueue int // Maximum queued messages of one connection
+	Workers   int // Maximum pushes waiting for push worker
+}
+
+// SubscriptionHealth is a subscription health report. Subscription is
+// degraded when its backlog exceeds thresholds, see SetBacklogThresholds.
+type SubscriptionHealth struct {
+	Backlog  Backlog  `json:"backlog"`
+	Degraded bool     `json:"degraded"`
+	Reasons  []string `json:"reasons,omitempty"` // Exceeded thresholds
+}
+
+// BacklogQueue returns the longest connection queue and total number of
+// queued messages of transport connections, see AddBacklogQueue.
+type BacklogQueue func() (longest, total int)
+
+// subscriptionHealth keeps backlog thresholds and transport queues.
+type subscriptionHealth struct {
+	thresholds BacklogThresholds
+	queues     []BacklogQueue
+	sync.Mutex
+}
+
+// SetBacklogThresholds sets subscription backlog thresholds used by Health.
+func (s *Subscription) SetBacklogThresholds(thresholds BacklogThresholds) {
+	s.health.Lock()
+	defer s.health.Unlock()
+	s.health.thresholds = thresholds
+}
+
+// AddBacklogQueue adds transport connections queue to subscription backlog,
+// like long polling sessions queues of server.
+func (s *Subscription) AddBacklogQueue(queue BacklogQueue) {
+	s.health.Lock()
+	defer s.health.Unlock()
+	s.health.queues = append(s.health.queues, queue)
+}
+
+// Backlog returns subscription pushes backlog: sends in flight of
+// connections, messages paused by flow control credits and queued by
+// transports and pushes waiting for push worker, see SetParallelism.
+func (s *Subscription) Backlog() (b Backlog) {
+	s.drain.Lock()
+	for _, d := range s.drain.m {
+		b.Sends += d.inFlight
+		b.ConnSends = max(b.ConnSends, d.inFlight)
+	}
+	s.drain.Unlock()
+
+	s.acks.Lock()
+	for _, queue := range s.acks.queue {
+		b.Queued += len(queue)
+		b.ConnQueue = max(b.ConnQueue, len(queue))
+	}
+	s.acks.Unlock()
+
+	s.health.Lock()
+	queues := s.health.queues
+	s.health.Unlock()
+	for _, queue := range queues {
+		longest, total := queue()
+		b.Queued += total
+		b.ConnQueue = max(b.ConnQueue, longest)
+	}
+
+	b.Workers = int(s.pushWaiting.Load())
+	return
+}
+
+// Health returns subscription health report. The subscription is degraded
+// when backlog exceeds thresholds, so readiness probes can shed traffic
+// before the server is overloaded.
+func (s *Subscription) Health() (h SubscriptionHealth) {
+	h.Backlog = s.Backlog()
+
+	s.health.Lock()
+	t := s.health.thresholds
+	s.health.Unlock()
+
+	for _, check := range []struct {
+		name             string
+		value, threshold int
+	}{
+		{"connection sends", h.Backlog.ConnSends, t.ConnSends},
+		{"connection queue", h.Backlog.ConnQueue, t.ConnQueue},
+		{"push workers queue", h.Backlog.Workers, t.Workers},
+	} {
+		if check.threshold > 0 && check.value > check.threshold {
+			h.Reasons = append(h.Reasons, fmt.Sprintf("%s %d exceeds %d",
+				check.name, check.value, check.threshold))
+		}
+	}
+	h.Degraded = len(h.Reasons) > 0
+	return
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription history module of Command processing golang package.
+
+package command
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+)
+
+// subscriptionHistory keeps last broadcast payloads of commands.
+type subscriptionHistory struct {
+	m    map[string]*ringBuffer // Command payloads
+	size int                    // Max number of payloads per command
+	sync.Mutex
+}
+
+// ringBuffer is a fixed size buffer which keeps last added items.
+type ringBuffer struct {
+	items [][]byte
+	next  int
+	full  bool
+}
+
+// newRingBuffer creates ring buffer of size items.
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{items: make([][]byte, size)}
+}
+
+// add adds item to buffer overwriting the oldest one when buffer is full.
+func (b *ringBuffer) add(item []byte) {
+	b.items[b.next] = item
+	b.next = (b.next + 1) % len(b.items)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// last returns up to n last items in order they were added.
+func (b *ringBuffer) last(n int) [][]byte {
+	size := b.next
+	if b.full {
+		size = len(b.items)
+	}
+	if n <= 0 || n > size {
+		n = size
+	}
+
+	items := make([][]byte, 0, n)
+	for i := n; i > 0; i-- {
+		idx := (b.next - i + len(b.items)) % len(b.items)
+		items = append(items, b.items[idx])
+	}
+	return items
+}
+
+// SetHistory sets number of last broadcast payloads kept per command. Zero
+// size turns history off and removes kept payloads.
+func (s *Subscription) SetHistory(size int) {
+	s.history.Lock()
+	defer s.history.Unlock()
+
+	s.history.size = size
+	s.history.m = nil
+	if size > 0 {
+		s.history.m = make(map[string]*ringBuffer)
+	}
+}
+
+// History returns up to n last broadcast payloads of command in order they
+// were sent. If n is less than or equal to zero all kept payloads are
+// returned.
+func (s *Subscription) History(command string, n int) [][]byte {
+	s.history.Lock()
+	defer s.history.Unlock()
+
+	buf, ok := s.history.m[command]
+	if !ok {
+		return nil
+	}
+	return buf.last(n)
+}
+
+// addHistory adds broadcast payload to command history.
+func (s *Subscription) addHistory(command string, data []byte) {
+	s.history.Lock()
+	defer s.history.Unlock()
+
+	if s.history.size <= 0 {
+		return
+	}
+	buf, ok := s.history.m[command]
+	if !ok {
+		buf = newRingBuffer(s.history.size)
+		s.history.m[command] = buf
+	}
+	buf.add(data)
+}
+
+// AddReplayCommand adds replay command to commands map. The replay command
+// returns json array of up to n last SubscriptionMessage broadcast to
+// command subscribers.
+func (s *Subscription) AddReplayCommand(c *Commands, processIn ProcessIn) {
+	c.Add("replay", "Get last messages broadcast to command subscribers.",
+		processIn, "{command}/{n}", "json array of subscription messages",
+		"replay/news/10", `[{"command":"news","data":"MQ=="}]`,
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			vars, err := c.Vars(indata)
+			if err != nil {
+				return nil, err
+			}
+			n, err := strconv.Atoi(vars["n"])
+			if err != nil {
+				return nil, ErrIncorrectInputData
+			}
+
+			command := vars["command"]
+			messages := []SubscriptionMessage{}
+			for _, data := range s.History(command, n) {
+				messages = append(messages, SubscriptionMessage{
+					Command: command, Data: data,
+				})
+			}
+			return json.Marshal(messages)
+		},
+	)
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription push dispatcher module of Command processing golang package.
+
+package command
+
+import (
+	"sync"
+	"time"
+)
+
+// subscriptionNotify keeps commands marked dirty by Notify.
+type subscriptionNotify struct {
+	dirty   map[string]struct{} // Dirty commands
+	running bool                // Dispatcher is running
+	sync.Mutex
+}
+
+// Notify marks command dirty. The dispatcher started with StartDispatcher
+// executes dirty commands with ExecCmd on next tick, so many notifications
+// of one command between ticks produce one push. If dispatcher is not
+// running Notify executes ExecCmd immediately.
+func (s *Subscription) Notify(command string) {
+	s.notify.Lock()
+	if !s.notify.running {
+		s.notify.Unlock()
+		s.ExecCmd(command)
+		return
+	}
+	s.notify.dirty[command] = struct{}{}
+	s.notify.Unlock()
+}
+
+// StartDispatcher starts dispatcher which executes commands marked dirty by
+// Notify every tick. It returns function which stops the dispatcher, dirty
+// commands are executed before the dispatcher stops.
+func (s *Subscription) StartDispatcher(tick time.Duration) (stop func()) {
+	s.notify.Lock()
+	s.notify.dirty = make(map[string]struct{})
+	s.notify.running = true
+	s.notify.Unlock()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	Go(GoDispatcher, func() {
+		defer close(stopped)
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.dispatch(false)
+			case <-done:
+				s.dispatch(true)
+				return
+			}
+		}
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// dispatch executes dirty commands. If stop is true, the dispatcher is
+// marked as stopped.
+func (s *Subscription) dispatch(stop bool) {
+	s.notify.Lock()
+	dirty := s.notify.dirty
+	s.notify.dirty = make(map[string]struct{})
+	if stop {
+		s.notify.running = false
+	}
+	s.notify.Unlock()
+
+	for command := range dirty {
+		s.ExecCmd(command)
+	}
+}
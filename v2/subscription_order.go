@@ -0,0 +1,91 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription events ordering module of Command processing golang package.
+
+package command
+
+import "sync"
+
+// Ordering is a subscription pushes ordering mode.
+type Ordering int
+
+// Subscription pushes ordering modes.
+const (
+	// OrderingOff sends pushes without command sequence numbers.
+	OrderingOff Ordering = iota
+
+	// OrderingSeq adds per connection command sequence numbers to pushes,
+	// so clients can detect dropped and reordered messages.
+	OrderingSeq
+
+	// OrderingStrict adds command sequence numbers and sends pushes of
+	// connection one by one in sequence numbers order.
+	OrderingStrict
+)
+
+// subscriptionOrder keeps connections command sequence numbers.
+type subscriptionOrder struct {
+	mode Ordering
+	m    map[ConnectionChannel]*connOrder
+	sync.Mutex
+}
+
+// connOrder keeps connection command sequence numbers and serializes
+// connection sends in strict ordering mode.
+type connOrder struct {
+	seqs map[string]uint64 // Last sequence numbers of commands
+	mut  sync.Mutex        // Sequence numbers mutex
+	send sync.Mutex        // Sends mutex of strict mode
+}
+
+// SetOrdering sets subscription pushes ordering mode. In OrderingSeq and
+// OrderingStrict modes every push to connection gets monotonically
+// increasing sequence number of its command, see SubscriptionMessage CmdSeq
+// field. In OrderingStrict mode pushes of connection are sent one by one,
+// so they are delivered in sequence numbers order.
+func (s *Subscription) SetOrdering(mode Ordering) {
+	s.order.Lock()
+	defer s.order.Unlock()
+
+	s.order.mode = mode
+	if mode == OrderingOff {
+		s.order.m = nil
+	}
+}
+
+// connOrder returns ordering state of connection and true in strict mode.
+// It returns nil if ordering is off.
+func (s *Subscription) connOrder(con ConnectionChannel) (*connOrder, bool) {
+	s.order.Lock()
+	defer s.order.Unlock()
+
+	if s.order.mode == OrderingOff {
+		return nil, false
+	}
+	if s.order.m == nil {
+		s.order.m = make(map[ConnectionChannel]*connOrder)
+	}
+	o, ok := s.order.m[con]
+	if !ok {
+		o = &connOrder{seqs: make(map[string]uint64)}
+		s.order.m[con] = o
+	}
+	return o, s.order.mode == OrderingStrict
+}
+
+// next returns next sequence number of command.
+func (o *connOrder) next(command string) uint64 {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	o.seqs[command]++
+	return o.seqs[command]
+}
+
+// dropOrder drops ordering state of connection.
+func (s *Subscription) dropOrder(con ConnectionChannel) {
+	s.order.Lock()
+	defer s.order.Unlock()
+	delete(s.order.m, con)
+}
@@ -0,0 +1,266 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription JSON Patch module of Command processing golang package.
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// patchState keeps subscriber state used to make patches in patch mode.
+type patchState struct {
+	base    []byte            // State the client has
+	baseSeq uint64            // Sequence number of base state message
+	pending map[uint64][]byte // States sent in not acknowledged messages
+	sync.Mutex
+}
+
+// sendPatch sends data to connection in patch mode. Data is sent as RFC 6902
+// JSON Patch relative to base state when the patch is smaller than data. In
+// acknowledgment mode the base state is the last acknowledged one and the
+// message Base field contains its sequence number, otherwise it is the last
+// sent state.
+func (s *Subscription) sendPatch(con ConnectionChannel, command string,
+	sub *subscriber, data []byte) error {
+
+	p := &sub.patch
+	p.Lock()
+	defer p.Unlock()
+
+	msg := SubscriptionMessage{Command: command, Data: data}
+	if p.base != nil {
+		if patch, err := JSONPatch(p.base, data); err == nil &&
+			len(patch) < len(data) {
+			msg.Data, msg.Patch, msg.Base = patch, true, p.baseSeq
+		}
+	}
+
+	seq, err := s.sendMessage(con, msg)
+	if err != nil {
+		return err
+	}
+	if seq == 0 {
+		p.base = data
+		return nil
+	}
+	if p.pending == nil {
+		p.pending = make(map[uint64][]byte)
+	}
+	p.pending[seq] = data
+
+	return nil
+}
+
+// ackPatch makes acknowledged message state the base state of connection
+// subscribers in patch mode.
+func (s *Subscription) ackPatch(con ConnectionChannel, seq uint64) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, cons := range s.m {
+		if sub, ok := cons[con]; ok && sub.Patch {
+			sub.patch.ack(seq)
+		}
+	}
+}
+
+// ack makes state of acknowledged message the base state and drops older
+// pending states.
+func (p *patchState) ack(seq uint64) {
+	p.Lock()
+	defer p.Unlock()
+
+	data, ok := p.pending[seq]
+	if !ok {
+		return
+	}
+	p.base, p.baseSeq = data, seq
+	for n := range p.pending {
+		if n <= seq {
+			delete(p.pending, n)
+		}
+	}
+}
+
+// patchOperation is RFC 6902 JSON Patch operation.
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// JSONPatch returns RFC 6902 JSON Patch which transforms json document from
+// to json document to. The patch contains add, remove and replace
+// operations. Objects are compared by fields, arrays of the same length by
+// items and other arrays are replaced.
+func JSONPatch(from, to []byte) ([]byte, error) {
+	var a, b any
+	if err := json.Unmarshal(from, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(to, &b); err != nil {
+		return nil, err
+	}
+
+	ops := diffJSON("", a, b, []patchOperation{})
+	return json.Marshal(ops)
+}
+
+// diffJSON appends operations transforming a to b to ops.
+func diffJSON(path string, a, b any, ops []patchOperation) []patchOperation {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		for _, key := range sortedKeys(a) {
+			if _, ok := b[key]; !ok {
+				ops = append(ops, patchOperation{Op: "remove",
+					Path: path + "/" + escapePointer(key)})
+			}
+		}
+		for _, key := range sortedKeys(b) {
+			av, ok := a[key]
+			if !ok {
+				ops = append(ops, patchOperation{Op: "add",
+					Path: path + "/" + escapePointer(key), Value: b[key]})
+				continue
+			}
+			ops = diffJSON(path+"/"+escapePointer(key), av, b[key], ops)
+		}
+		return ops
+
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			break
+		}
+		for i := range a {
+			ops = diffJSON(path+"/"+strconv.Itoa(i), a[i], b[i], ops)
+		}
+		return ops
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		ops = append(ops, patchOperation{Op: "replace", Path: path, Value: b})
+	}
+	return ops
+}
+
+// ApplyJSONPatch applies RFC 6902 JSON Patch with add, remove and replace
+// operations to json document and returns patched document. It may be used
+// by Go clients to restore data pushed in patch mode.
+func ApplyJSONPatch(doc, patch []byte) ([]byte, error) {
+	var v any
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, err
+	}
+	var ops []patchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, err
+	}
+
+	for _, op := range ops {
+		var err error
+		if v, err = applyOperation(v, op, splitPointer(op.Path)); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(v)
+}
+
+// applyOperation applies patch operation to value by path tokens and
+// returns changed value.
+func applyOperation(v any, op patchOperation, tokens []string) (any, error) {
+	if len(tokens) == 0 {
+		if op.Op == "remove" {
+			return nil, nil
+		}
+		return op.Value, nil
+	}
+
+	token, last := tokens[0], len(tokens) == 1
+	switch v := v.(type) {
+	case map[string]any:
+		if last {
+			switch op.Op {
+			case "remove":
+				delete(v, token)
+			case "add", "replace":
+				v[token] = op.Value
+			default:
+				return nil, fmt.Errorf("unsupported patch operation '%s'", op.Op)
+			}
+			return v, nil
+		}
+		child, ok := v[token]
+		if !ok {
+			return nil, fmt.Errorf("wrong patch path '%s'", op.Path)
+		}
+		child, err := applyOperation(child, op, tokens[1:])
+		v[token] = child
+		return v, err
+
+	case []any:
+		i, err := strconv.Atoi(token)
+		if token == "-" && last && op.Op == "add" {
+			return append(v, op.Value), nil
+		}
+		if err != nil || i < 0 || i > len(v) || (i == len(v) && op.Op != "add") {
+			return nil, fmt.Errorf("wrong patch path '%s'", op.Path)
+		}
+		if !last {
+			v[i], err = applyOperation(v[i], op, tokens[1:])
+			return v, err
+		}
+		switch op.Op {
+		case "remove":
+			return append(v[:i], v[i+1:]...), nil
+		case "add":
+			return append(v[:i], append([]any{op.Value}, v[i:]...)...), nil
+		case "replace":
+			v[i] = op.Value
+			return v, nil
+		}
+		return nil, fmt.Errorf("unsupported patch operation '%s'", op.Op)
+	}
+
+	return nil, fmt.Errorf("wrong patch path '%s'", op.Path)
+}
+
+// escapePointer escapes JSON Pointer token.
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// splitPointer splits JSON Pointer to unescaped tokens.
+func splitPointer(path string) []string {
+	if path == "" {
+		return nil
+	}
+	tokens := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens
+}
+
+// sortedKeys returns sorted keys of map.
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription presence module of Command processing golang package.
+
+package command
+
+import (
+	"encoding/json"
+	"iter"
+)
+
+// PresenceCommand is a meta command name. Connections subscribed to it
+// receive PresenceEvent when other connections subscribe to or unsubscribe
+// from commands, if presence events are on. Clients may subscribe to it with
+// the subscribe command though it is not added to commands map.
+const PresenceCommand = "presence"
+
+// Presence event types.
+const (
+	PresenceSubscribe   = "subscribe"
+	PresenceUnsubscribe = "unsubscribe"
+)
+
+// PresenceEvent is pushed to PresenceCommand subscribers when subscribers of
+// command are changed.
+type PresenceEvent struct {
+	Command string `json:"command"` // Command name
+	Event   string `json:"event"`   // Event type: subscribe or unsubscribe
+	Count   int    `json:"count"`   // Number of command subscribers
+}
+
+// SetPresence turns presence events on or off. When it is on, PresenceEvent
+// is sent to PresenceCommand subscribers each time connection subscribes to
+// or unsubscribes from command.
+func (s *Subscription) SetPresence(on bool) {
+	s.Lock()
+	s.presence = on
+	s.Unlock()
+}
+
+// Connections returns iterator over connections subscribed to command. It
+// iterates over snapshot of subscribers, so subscription may be changed
+// during iteration.
+func (s *Subscription) Connections(command string) iter.Seq[ConnectionChannel] {
+	s.RLock()
+	cons := make([]ConnectionChannel, 0, len(s.m[command]))
+	for con := range s.m[command] {
+		cons = append(cons, con)
+	}
+	s.RUnlock()
+
+	return func(yield func(ConnectionChannel) bool) {
+		for _, con := range cons {
+			if !yield(con) {
+				return
+			}
+		}
+	}
+}
+
+// ConnectionsCount returns number of connections subscribed to command.
+func (s *Subscription) ConnectionsCount(command string) int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.m[command])
+}
+
+// Commands returns iterator over commands the connection is subscribed to.
+// It iterates over snapshot of subscriptions, so subscription may be changed
+// during iteration.
+func (s *Subscription) Commands(con ConnectionChannel) iter.Seq[string] {
+	s.RLock()
+	var commands []string
+	for command, cons := range s.m {
+		if _, ok := cons[con]; ok {
+			commands = append(commands, command)
+		}
+	}
+	s.RUnlock()
+
+	return func(yield func(string) bool) {
+		for _, command := range commands {
+			if !yield(command) {
+				return
+			}
+		}
+	}
+}
+
+// CommandsCount returns number of commands the connection is subscribed to.
+func (s *Subscription) CommandsCount(con ConnectionChannel) (count int) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, cons := range s.m {
+		if _, ok := cons[con]; ok {
+			count++
+		}
+	}
+	return
+}
+
+// presenceChanged sends PresenceEvent to PresenceCommand subscribers. It
+// should be called without the Subscription lock.
+func (s *Subscription) presenceChanged(command, event string, count int) {
+	if command == PresenceCommand {
+		return
+	}
+	data, err := json.Marshal(PresenceEvent{command, event, count})
+	if err != nil {
+		return
+	}
+	s.broadcast(PresenceCommand, data) // Counts are local to this node
+}
@@ -0,0 +1,244 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription rooms module of Command processing golang package.
+
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrNoUser is an error returned by rooms commands when request has no user.
+var ErrNoUser = fmt.Errorf("request has no user")
+
+// RoomPrefix is a prefix of room subscription command names.
+const RoomPrefix = "room."
+
+// Room message events.
+const (
+	RoomJoin    = "join"
+	RoomLeave   = "leave"
+	RoomMessage = "message"
+)
+
+// RoomEvent is pushed to room members when user joins or leaves the room or
+// says message to the room.
+type RoomEvent struct {
+	Room    string    `json:"room"`              // Room name
+	User    string    `json:"user"`              // User name
+	Event   string    `json:"event"`             // Event: join, leave or message
+	Text    string    `json:"text,omitempty"`    // Message text
+	Members []string  `json:"members,omitempty"` // Room members on join and leave
+	Time    time.Time `json:"time"`              // Event time
+}
+
+// Rooms are chat rooms built on Subscription. Room members are connections
+// subscribed to RoomPrefix+room command, every connection joins the room
+// with user name and the room members list is its unique user names.
+type Rooms struct {
+	s *Subscription
+	m map[string]map[ConnectionChannel]string // Rooms connections users
+	*sync.RWMutex
+}
+
+// NewRooms creates new Rooms on subscription s.
+func NewRooms(s *Subscription) *Rooms {
+	return &Rooms{s: s, m: make(map[string]map[ConnectionChannel]string),
+		RWMutex: new(sync.RWMutex)}
+}
+
+// Join adds connection of user to room and pushes join event to room
+// members.
+func (r *Rooms) Join(con ConnectionChannel, room, user string) error {
+	if err := ValidateName(room); err != nil {
+		return err
+	}
+
+	r.Lock()
+	cons, ok := r.m[room]
+	if !ok {
+		cons = make(map[ConnectionChannel]string)
+		r.m[room] = cons
+	}
+	cons[con] = user
+	members := r.members(room)
+	r.Unlock()
+
+	r.s.SubscribeCmd(con, RoomPrefix+room, nil)
+	return r.push(RoomEvent{Room: room, User: user, Event: RoomJoin,
+		Members: members})
+}
+
+// Leave removes connection from room and pushes leave event to room
+// members.
+func (r *Rooms) Leave(con ConnectionChannel, room string) error {
+	r.Lock()
+	user, ok := r.m[room][con]
+	if ok {
+		delete(r.m[room], con)
+		if len(r.m[room]) == 0 {
+			delete(r.m, room)
+		}
+	}
+	members := r.members(room)
+	r.Unlock()
+
+	if !ok {
+		return nil
+	}
+	r.s.UnsubscribeCmd(con, RoomPrefix+room)
+	return r.push(RoomEvent{Room: room, User: user, Event: RoomLeave,
+		Members: members})
+}
+
+// LeaveAll removes connection from all rooms. It should be called when
+// connection is closed.
+func (r *Rooms) LeaveAll(con ConnectionChannel) error {
+	r.RLock()
+	var rooms []string
+	for room, cons := range r.m {
+		if _, ok := cons[con]; ok {
+			rooms = append(rooms, room)
+		}
+	}
+	r.RUnlock()
+
+	var errs []error
+	for _, room := range rooms {
+		errs = append(errs, r.Leave(con, room))
+	}
+	return errors.Join(errs...)
+}
+
+// Members returns sorted unique user names of room members.
+func (r *Rooms) Members(room string) []string {
+	r.RLock()
+	defer r.RUnlock()
+	return r.members(room)
+}
+
+// members returns room members. It should be called under the Rooms lock.
+func (r *Rooms) members(room string) []string {
+	members := make([]string, 0, len(r.m[room]))
+	for _, user := range r.m[room] {
+		members = append(members, user)
+	}
+	slices.Sort(members)
+	return slices.Compact(members)
+}
+
+// Say pushes message of user to room members.
+func (r *Rooms) Say(room, user, text string) error {
+	return r.push(RoomEvent{Room: room, User: user, Event: RoomMessage,
+		Text: text})
+}
+
+// push pushes room event to room members.
+func (r *Rooms) push(e RoomEvent) error {
+	e.Time = time.Now()
+	return BroadcastTyped(r.s, RoomPrefix+e.Room, e)
+}
+
+// AddCommands adds join, leave, say and members commands to commands map.
+// The join and leave commands add request connection to room and remove
+// it from room, say command pushes text parameter or request data, if the
+// parameter is empty, as message text to room and members command returns json list of room members. The join and say
+// commands use request user name set by authentication middleware, like
+// server OnMessage hook, and return ErrNoUser if request has no user.
+func (r *Rooms) AddCommands(c *Commands, processIn ProcessIn) {
+
+	// request returns request, its user and room.
+	request := func(indata any) (req RequestInterfaceV2, user, room string,
+		err error) {
+
+		if req, err = c.Request(indata); err != nil {
+			return
+		}
+		switch u := req.GetUser().(type) {
+		case string:
+			user = u
+		case fmt.Stringer:
+			user = u.String()
+		}
+		room = req.GetVars()["room"]
+		return
+	}
+
+	c.Add("join", "Join chat room.", processIn, "{room}", "ok or error",
+		"join/lobby", "ok",
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			req, user, room, err := request(indata)
+			if err != nil {
+				return nil, err
+			}
+			con := req.GetConnectionChannel()
+			if con == nil {
+				return nil, ErrNoConnectionChannel
+			}
+			if user == "" {
+				return nil, ErrNoUser
+			}
+			if err = r.Join(con, room, user); err != nil {
+				return nil, err
+			}
+			return []byte("ok"), nil
+		},
+	)
+
+	c.Add("leave", "Leave chat room.", processIn, "{room}", "ok or error",
+		"leave/lobby", "ok",
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			req, _, room, err := request(indata)
+			if err != nil {
+				return nil, err
+			}
+			con := req.GetConnectionChannel()
+			if con == nil {
+				return nil, ErrNoConnectionChannel
+			}
+			if err = r.Leave(con, room); err != nil {
+				return nil, err
+			}
+			return []byte("ok"), nil
+		},
+	)
+
+	c.Add("say", "Say message to chat room.", processIn, "{room}/{text}",
+		"ok or error", "say/lobby/Hello!", "ok",
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			req, user, room, err := request(indata)
+			if err != nil {
+				return nil, err
+			}
+			if user == "" {
+				return nil, ErrNoUser
+			}
+			text := req.GetVars()["text"]
+			if text == "" {
+				text = string(req.GetData())
+			}
+			if err = r.Say(room, user, text); err != nil {
+				return nil, err
+			}
+			return []byte("ok"), nil
+		},
+	)
+
+	c.Add("members", "Get chat room members.", processIn, "{room}",
+		"json list of user names", "members/lobby", `["alice","bob"]`,
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			_, _, room, err := request(indata)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(r.Members(room))
+		},
+	)
+}
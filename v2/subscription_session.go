@@ -0,0 +1,182 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription sessions module of Command processing golang package.
+
+package command
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrSessionInUse is an error returned when resumed session is attached to
+// another connection.
+var ErrSessionInUse = fmt.Errorf("session is in use")
+
+// subscriptionSession is a client session which keeps subscriptions of
+// dropped connection.
+type subscriptionSession struct {
+	con   ConnectionChannel // Attached connection
+	ch    *sessionChannel   // Suspended session channel
+	timer *time.Timer       // Suspended session expire timer
+}
+
+// sessionChannel is a connection channel which keeps subscriptions of
+// suspended session and buffers last pushed messages.
+type sessionChannel struct {
+	messages [][]byte
+	max      int
+	sync.Mutex
+}
+
+// Send buffers data, the oldest messages are dropped when buffer is full.
+func (ch *sessionChannel) Send(data []byte) error {
+	if ch.max <= 0 {
+		return nil
+	}
+	ch.Lock()
+	defer ch.Unlock()
+	if len(ch.messages) == ch.max {
+		ch.messages = ch.messages[1:]
+	}
+	ch.messages = append(ch.messages, data)
+	return nil
+}
+
+// SetResume sets resume sessions parameters. When grace is greater than
+// zero, subscriptions of connection attached to session survive connection
+// drop for grace period and are reattached when client resumes the session
+// from new connection. Up to replay messages pushed to suspended session are
+// buffered and sent to resumed connection.
+func (s *Subscription) SetResume(grace time.Duration, replay int) {
+	s.Lock()
+	s.resumeGrace, s.resumeReplay = grace, replay
+	s.Unlock()
+}
+
+// Resume attaches connection to session identified by client provided
+// session ID. If the session was suspended its subscriptions are moved to
+// the connection and buffered messages are sent to it. It returns number of
+// replayed messages.
+func (s *Subscription) Resume(con ConnectionChannel, session string) (
+	int, error) {
+
+	if session == "" {
+		return 0, fmt.Errorf("empty session id")
+	}
+
+	s.Lock()
+	if s.sessions == nil {
+		s.sessions = make(map[string]*subscriptionSession)
+	}
+	sess, ok := s.sessions[session]
+	switch {
+	case !ok:
+		s.sessions[session] = &subscriptionSession{con: con}
+		s.Unlock()
+		return 0, nil
+	case sess.con != nil && sess.con != con:
+		s.Unlock()
+		return 0, ErrSessionInUse
+	case sess.ch == nil:
+		s.Unlock()
+		return 0, nil
+	}
+
+	// Move suspended subscriptions to connection
+	sess.timer.Stop()
+	s.move(sess.ch, con)
+	ch := sess.ch
+	sess.con, sess.ch, sess.timer = con, nil, nil
+	s.Unlock()
+
+	// Replay buffered messages
+	ch.Lock()
+	messages := ch.messages
+	ch.Unlock()
+	for i, data := range messages {
+		data, err := s.seal(con, data)
+		if err != nil {
+			return i, err
+		}
+		if err = con.Send(data); err != nil {
+			return i, err
+		}
+	}
+
+	return len(messages), nil
+}
+
+// Disconnect should be called when connection is closed. If the connection
+// is attached to session and resume grace period is set, its subscriptions
+// are suspended until session is resumed or grace period expires. Otherwise
+// the connection is unsubscribed from all commands.
+func (s *Subscription) Disconnect(con ConnectionChannel) {
+	s.Lock()
+	session, sess := s.conSession(con)
+	if sess == nil || s.resumeGrace <= 0 {
+		if sess != nil {
+			delete(s.sessions, session)
+		}
+		s.Unlock()
+		s.UnsubscribeAll(con)
+		return
+	}
+
+	// Suspend session and drop state of closed connection, resumed
+	// connection starts with new state
+	ch := &sessionChannel{max: s.resumeReplay}
+	s.move(con, ch)
+	s.suspend(session, sess, ch)
+	s.Unlock()
+	s.dropConn(con)
+}
+
+// suspend attaches suspended session channel to session and starts session
+// expire timer. It should be called under the Subscription lock.
+func (s *Subscription) suspend(session string, sess *subscriptionSession,
+	ch *sessionChannel) {
+
+	sess.con, sess.ch = nil, ch
+	sess.timer = time.AfterFunc(s.resumeGrace, func() {
+		s.Lock()
+		expired := sess.ch == ch
+		if expired {
+			delete(s.sessions, session)
+		}
+		s.Unlock()
+		if expired {
+			s.UnsubscribeAll(ch)
+		}
+	})
+}
+
+// conSession returns session attached to connection. It should be called
+// under the Subscription lock.
+func (s *Subscription) conSession(con ConnectionChannel) (string,
+	*subscriptionSession) {
+
+	for session, sess := range s.sessions {
+		if sess.con == con {
+			return session, sess
+		}
+	}
+	return "", nil
+}
+
+// move moves subscriptions from one connection to another. It should be
+// called under the Subscription lock.
+func (s *Subscription) move(from, to ConnectionChannel) {
+	var commands []string
+	for command, cons := range s.m {
+		if sub, ok := cons[from]; ok {
+			delete(cons, from)
+			cons[to] = sub
+			commands = append(commands, command)
+		}
+	}
+	s.publish(commands...)
+}
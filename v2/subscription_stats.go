@@ -0,0 +1,88 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription statistics module of Command processing golang package.
+
+package command
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+)
+
+// SubscriptionStats contains subscription counters and gauges.
+type SubscriptionStats struct {
+	Subscriptions map[string]int `json:"subscriptions"`  // Subscribers per command
+	Connections   int            `json:"connections"`    // Subscribed connections
+	Pushes        uint64         `json:"pushes"`         // Pushes sent
+	SendErrors    uint64         `json:"send_errors"`    // Failed sends
+	AvgLatency    time.Duration  `json:"avg_latency_ns"` // Average push send latency
+	MaxLatency    time.Duration  `json:"max_latency_ns"` // Maximum push send latency
+}
+
+// subscriptionCounters contains subscription push counters.
+type subscriptionCounters struct {
+	pushes     atomic.Uint64
+	sendErrors atomic.Uint64
+	latency    atomic.Int64 // Total send latency
+	maxLatency atomic.Int64
+}
+
+// count counts push send result and latency.
+func (c *subscriptionCounters) count(latency time.Duration, err error) {
+	if err != nil {
+		c.sendErrors.Add(1)
+		return
+	}
+	c.pushes.Add(1)
+	c.latency.Add(int64(latency))
+	for {
+		max := c.maxLatency.Load()
+		if int64(latency) <= max ||
+			c.maxLatency.CompareAndSwap(max, int64(latency)) {
+			break
+		}
+	}
+}
+
+// Stats returns subscription statistics.
+func (s *Subscription) Stats() SubscriptionStats {
+	stats := SubscriptionStats{
+		Subscriptions: make(map[string]int),
+		Pushes:        s.counters.pushes.Load(),
+		SendErrors:    s.counters.sendErrors.Load(),
+		MaxLatency:    time.Duration(s.counters.maxLatency.Load()),
+	}
+	if stats.Pushes > 0 {
+		stats.AvgLatency = time.Duration(
+			uint64(s.counters.latency.Load()) / stats.Pushes)
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	cons := make(map[ConnectionChannel]struct{})
+	for command, subs := range s.m {
+		stats.Subscriptions[command] = len(subs)
+		for con := range subs {
+			cons[con] = struct{}{}
+		}
+	}
+	stats.Connections = len(cons)
+
+	return stats
+}
+
+// AddStatsCommand adds substats command to commands map. The substats
+// command returns subscription statistics in json format.
+func (s *Subscription) AddStatsCommand(c *Commands, processIn ProcessIn) {
+	c.Add("substats", "Get subscription statistics.", processIn, "",
+		"json subscription statistics", "substats",
+		`{"subscriptions":{"news":2},"connections":2,"pushes":10,...}`,
+		func(cmd *CommandData, processIn ProcessIn, indata any) ([]byte, error) {
+			return json.Marshal(s.Stats())
+		},
+	)
+}
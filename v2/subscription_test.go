@@ -0,0 +1,1350 @@
+package command
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testChannel is a connection channel which stores sent messages.
+type testChannel struct {
+	messages []SubscriptionMessage
+	sync.Mutex
+}
+
+func (ch *testChannel) Send(data []byte) error {
+	var msg SubscriptionMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	ch.Lock()
+	ch.messages = append(ch.messages, msg)
+	ch.Unlock()
+	return nil
+}
+
+func (ch *testChannel) last() (msg SubscriptionMessage, n int) {
+	ch.Lock()
+	defer ch.Unlock()
+	if n = len(ch.messages); n > 0 {
+		msg = ch.messages[n-1]
+	}
+	return
+}
+
+func TestSubscription(t *testing.T) {
+
+	c := New()
+	s := NewSubscription()
+	s.AddCommands(c, WS)
+
+	counter := 0
+	c.Add("counter", "", WS, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			counter++
+			return []byte{byte('0' + counter)}, nil
+		},
+	)
+
+	// Subscribe from request without connection channel
+	_, err := c.Exec("subscribe", WS, NewHTTPRequest(nil, map[string]string{"command": "counter"}))
+	if !errors.Is(err, ErrNoConnectionChannel) {
+		t.Errorf("subscribe without channel should fail, got %v", err)
+	}
+
+	// Subscribe to not existing command
+	ch := &testChannel{}
+	req := &DefaultRequest{Vars: map[string]string{"command": "wrong"}, Channel: ch}
+	if _, err = c.Exec("subscribe", WS, req); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("subscribe to wrong command should fail, got %v", err)
+	}
+
+	// Subscribe and execute
+	req = &DefaultRequest{Vars: map[string]string{"command": "counter"}, Channel: ch}
+	if _, err = c.Exec("subscribe", WS, req); err != nil {
+		t.Fatal(err)
+	}
+	s.ExecCmd("counter")
+	if msg, n := ch.last(); n != 1 || msg.Command != "counter" || string(msg.Data) != "1" {
+		t.Errorf("wrong push %d: %v", n, msg)
+	}
+
+	// Unsubscribe
+	if _, err = c.Exec("unsubscribe", WS, req); err != nil {
+		t.Fatal(err)
+	}
+	s.ExecCmd("counter")
+	if _, n := ch.last(); n != 1 {
+		t.Errorf("unsubscribed connection should not receive pushes, got %d", n)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+
+	c := New()
+	if err := c.Broadcast("news", []byte("hello")); !errors.Is(err, ErrNoSubscription) {
+		t.Errorf("broadcast without subscription should fail, got %v", err)
+	}
+
+	s := NewSubscription()
+	c.SetSubscription(s)
+
+	ch1, ch2 := &testChannel{}, &testChannel{}
+	s.SubscribeCmd(ch1, "news", nil)
+	s.SubscribeCmd(ch2, "news", nil)
+	if err := c.Broadcast("news", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range []*testChannel{ch1, ch2} {
+		if msg, n := ch.last(); n != 1 || string(msg.Data) != "hello" {
+			t.Errorf("wrong push %d: %v", n, msg)
+		}
+	}
+}
+
+func TestPresence(t *testing.T) {
+
+	s := NewSubscription()
+	s.SetPresence(true)
+
+	watcher, ch1, ch2 := &testChannel{}, &testChannel{}, &testChannel{}
+	s.SubscribeCmd(watcher, PresenceCommand, nil)
+	s.SubscribeCmd(ch1, "news", nil)
+	s.SubscribeCmd(ch2, "news", nil)
+	s.SubscribeCmd(ch2, "weather", nil)
+
+	if n := s.ConnectionsCount("news"); n != 2 {
+		t.Errorf("wrong connections count: %d", n)
+	}
+	if n := s.CommandsCount(ch2); n != 2 {
+		t.Errorf("wrong commands count: %d", n)
+	}
+	for con := range s.Connections("weather") {
+		if con != ch2 {
+			t.Errorf("wrong connection: %v", con)
+		}
+	}
+
+	s.UnsubscribeAll(ch2)
+	if n := s.CommandsCount(ch2); n != 0 {
+		t.Errorf("wrong commands count after unsubscribe: %d", n)
+	}
+
+	// Watcher receives 3 subscribe and 2 unsubscribe events
+	watcher.Lock()
+	defer watcher.Unlock()
+	if len(watcher.messages) != 5 {
+		t.Fatalf("wrong number of presence events: %d", len(watcher.messages))
+	}
+	var event PresenceEvent
+	json.Unmarshal(watcher.messages[1].Data, &event)
+	if event != (PresenceEvent{"news", PresenceSubscribe, 2}) {
+		t.Errorf("wrong presence event: %v", event)
+	}
+}
+
+func TestResume(t *testing.T) {
+
+	s := NewSubscription()
+	s.SetResume(time.Minute, 2)
+	s.SetOrdering(OrderingSeq)
+
+	// Subscribe connection attached to session
+	ch1 := &testChannel{}
+	if _, err := s.Resume(ch1, "session"); err != nil {
+		t.Fatal(err)
+	}
+	s.SubscribeCmd(ch1, "news", nil)
+	s.SetAck(time.Minute, 1)
+	s.Broadcast("news", []byte("0"))
+	s.SetAck(0, 0)
+
+	// Drop connection and push messages to suspended session, state of
+	// dropped connection is removed
+	s.Disconnect(ch1)
+	s.acks.Lock()
+	_, pending := s.acks.m[ch1]
+	s.acks.Unlock()
+	s.order.Lock()
+	_, ordered := s.order.m[ch1]
+	s.order.Unlock()
+	if pending || ordered {
+		t.Errorf("state of suspended connection is not removed")
+	}
+	for _, data := range []string{"1", "2", "3"} {
+		s.Broadcast("news", []byte(data))
+	}
+
+	// Resume session from new connection
+	ch2 := &testChannel{}
+	n, err := s.Resume(ch2, "session")
+	if err != nil || n != 2 {
+		t.Fatalf("wrong replay %d, %v", n, err)
+	}
+	s.Broadcast("news", []byte("4"))
+	ch2.Lock()
+	defer ch2.Unlock()
+	var got string
+	for _, msg := range ch2.messages {
+		got += string(msg.Data)
+	}
+	if got != "234" {
+		t.Errorf("wrong messages received: %s", got)
+	}
+	if _, err = s.Resume(&testChannel{}, "session"); !errors.Is(err, ErrSessionInUse) {
+		t.Errorf("resume of active session should fail, got %v", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+
+	c := New()
+	s := NewSubscription()
+	s.SetHistory(3)
+	s.AddReplayCommand(c, WS)
+
+	for _, data := range []string{"1", "2", "3", "4"} {
+		s.Broadcast("news", []byte(data))
+	}
+
+	for n, want := range map[int]string{0: "234", 2: "34", 10: "234"} {
+		var got string
+		for _, data := range s.History("news", n) {
+			got += string(data)
+		}
+		if got != want {
+			t.Errorf("history %d: got %s, want %s", n, got, want)
+		}
+	}
+
+	res, err := c.Exec("replay", WS, &DefaultRequest{
+		Vars: map[string]string{"command": "news", "n": "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []SubscriptionMessage
+	if err = json.Unmarshal(res, &messages); err != nil || len(messages) != 1 ||
+		string(messages[0].Data) != "4" {
+		t.Errorf("wrong replay: %s, %v", res, err)
+	}
+}
+
+func TestAck(t *testing.T) {
+
+	c := New()
+	s := NewSubscription()
+	s.AddCommands(c, WS)
+	s.SetAck(10*time.Millisecond, 2)
+
+	ch := &testChannel{}
+	s.SubscribeCmd(ch, "news", nil)
+	s.Broadcast("news", []byte("1"))
+
+	// Not acknowledged message is redelivered 2 times
+	time.Sleep(100 * time.Millisecond)
+	msg, n := ch.last()
+	if n != 3 || msg.Seq == 0 {
+		t.Errorf("wrong number of deliveries %d, seq %d", n, msg.Seq)
+	}
+
+	// Acknowledged message is not redelivered
+	s.Broadcast("news", []byte("2"))
+	msg, _ = ch.last()
+	_, err := c.Exec("ack", WS, &DefaultRequest{
+		Vars:    map[string]string{"seq": strconv.FormatUint(msg.Seq, 10)},
+		Channel: ch,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, n = ch.last(); n != 4 {
+		t.Errorf("acknowledged message should not be redelivered, got %d", n)
+	}
+}
+
+func TestFilter(t *testing.T) {
+
+	s := NewSubscription()
+	filter, err := FilterExpr("user.id=42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = FilterExpr("user.id"); err == nil {
+		t.Error("wrong expression should return error")
+	}
+
+	ch, all := &testChannel{}, &testChannel{}
+	s.SubscribeCmd(ch, "events", nil, SubscribeOptions{Filter: filter})
+	s.SubscribeCmd(all, "events", nil)
+
+	s.Broadcast("events", []byte(`{"user":{"id":7},"event":"a"}`))
+	s.Broadcast("events", []byte(`{"user":{"id":42},"event":"b"}`))
+	s.Broadcast("events", []byte(`[{"user":{"id":42},"event":"c"},{"user":{"id":7},"event":"d"}]`))
+
+	ch.Lock()
+	defer ch.Unlock()
+	if len(ch.messages) != 2 {
+		t.Fatalf("wrong number of filtered messages: %d", len(ch.messages))
+	}
+	if want := `[{"event":"c","user":{"id":42}}]`; string(ch.messages[1].Data) != want {
+		t.Errorf("wrong filtered array: %s", ch.messages[1].Data)
+	}
+	if _, n := all.last(); n != 3 {
+		t.Errorf("wrong number of not filtered messages: %d", n)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+
+	s := NewSubscription()
+	interval, debounce, pattern := &testChannel{}, &testChannel{}, &testChannel{}
+	s.SubscribeCmd(interval, "news", nil, SubscribeOptions{MinInterval: 50 * time.Millisecond})
+	s.SubscribeCmd(debounce, "news", nil, SubscribeOptions{Debounce: 30 * time.Millisecond})
+	s.SubscribeCmd(pattern, "news*", nil, SubscribeOptions{MinInterval: 50 * time.Millisecond})
+
+	for _, data := range []string{"1", "2", "3"} {
+		s.Broadcast("news", []byte(data))
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// First push is sent immediately with min interval
+	if msg, n := interval.last(); n != 1 || string(msg.Data) != "1" {
+		t.Errorf("wrong first push %d: %s", n, msg.Data)
+	}
+	if _, n := debounce.last(); n != 0 {
+		t.Errorf("debounced push should be deferred, got %d", n)
+	}
+
+	// Coalesced pushes contain the latest data
+	time.Sleep(100 * time.Millisecond)
+	for _, ch := range []*testChannel{interval, debounce, pattern} {
+		if msg, _ := ch.last(); string(msg.Data) != "3" {
+			t.Errorf("wrong coalesced push: %s", msg.Data)
+		}
+	}
+	for _, ch := range []*testChannel{interval, pattern} {
+		if _, n := ch.last(); n != 2 {
+			t.Errorf("wrong number of throttled pushes: %d", n)
+		}
+	}
+	if _, n := debounce.last(); n != 1 {
+		t.Errorf("wrong number of debounced pushes: %d", n)
+	}
+}
+
+func TestNotify(t *testing.T) {
+
+	s := NewSubscription()
+	var mut sync.Mutex
+	executed := 0
+	ch := &testChannel{}
+	s.SubscribeCmd(ch, "news", func(ctx context.Context, con ConnectionChannel,
+		command string) ([]byte, error) {
+
+		mut.Lock()
+		defer mut.Unlock()
+		executed++
+		return []byte("news"), nil
+	})
+
+	stop := s.StartDispatcher(20 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		s.Notify("news")
+	}
+	time.Sleep(50 * time.Millisecond)
+	stop()
+
+	mut.Lock()
+	defer mut.Unlock()
+	if executed != 1 {
+		t.Errorf("notifications should be batched into one push, got %d", executed)
+	}
+}
+
+func TestStats(t *testing.T) {
+
+	c := New()
+	s := NewSubscription()
+	s.AddStatsCommand(c, WS)
+
+	ch1, ch2 := &testChannel{}, &testChannel{}
+	s.SubscribeCmd(ch1, "news", nil)
+	s.SubscribeCmd(ch2, "news", nil)
+	s.SubscribeCmd(ch2, "weather", nil)
+	s.Broadcast("news", []byte("1"))
+
+	res, err := c.Exec("substats", WS, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats SubscriptionStats
+	if err = json.Unmarshal(res, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Connections != 2 || stats.Subscriptions["news"] != 2 ||
+		stats.Pushes != 2 || stats.SendErrors != 0 {
+		t.Errorf("wrong stats: %s", res)
+	}
+}
+
+func TestGzipChannel(t *testing.T) {
+
+	s := NewSubscription()
+	ch := &rawChannel{}
+	gz := NewGzipChannel(ch, 100)
+	s.SubscribeCmd(gz, "news", nil)
+
+	small, large := "small", strings.Repeat("large ", 100)
+	s.Broadcast("news", []byte(small))
+	s.Broadcast("news", []byte(large))
+
+	if len(ch.messages) != 2 {
+		t.Fatalf("wrong number of messages: %d", len(ch.messages))
+	}
+	if len(ch.messages[1]) >= len(large) {
+		t.Errorf("large message should be compressed, got %d", len(ch.messages[1]))
+	}
+	for i, want := range []string{small, large} {
+		data, err := Decompress(ch.messages[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg SubscriptionMessage
+		if err = json.Unmarshal(data, &msg); err != nil || string(msg.Data) != want {
+			t.Errorf("wrong message %d: %v", i, err)
+		}
+	}
+}
+
+// rawChannel is a connection channel which stores sent data.
+type rawChannel struct {
+	messages [][]byte
+}
+
+func (ch *rawChannel) Send(data []byte) error {
+	ch.messages = append(ch.messages, data)
+	return nil
+}
+
+func TestPatch(t *testing.T) {
+
+	from := `{"a":1,"b":{"c":"x","d":[1,2,3]},"e/f":true,"g":[1]}`
+	to := `{"a":1,"b":{"c":"y","d":[1,2,4]},"e/f":false,"g":[1,2],"h":null}`
+	patch, err := JSONPatch([]byte(from), []byte(to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ApplyJSONPatch([]byte(from), patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want any
+	json.Unmarshal(res, &got)
+	json.Unmarshal([]byte(to), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong patched document: %s, patch %s", res, patch)
+	}
+
+	// Patch mode subscription
+	s := NewSubscription()
+	ch := &testChannel{}
+	s.SubscribeCmd(ch, "dashboard", nil, SubscribeOptions{Patch: true})
+	doc := func(value int) []byte {
+		return []byte(fmt.Sprintf(`{"title":%q,"value":%d}`,
+			strings.Repeat("dashboard", 10), value))
+	}
+	s.Broadcast("dashboard", doc(1))
+	s.Broadcast("dashboard", doc(2))
+
+	ch.Lock()
+	defer ch.Unlock()
+	if len(ch.messages) != 2 || ch.messages[0].Patch || !ch.messages[1].Patch {
+		t.Fatalf("wrong patch mode messages: %v", ch.messages)
+	}
+	res, err = ApplyJSONPatch(ch.messages[0].Data, ch.messages[1].Data)
+	if err != nil || string(res) != string(doc(2)) {
+		t.Errorf("wrong patched data: %s, %v", res, err)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+
+	c := New()
+	s := NewSubscription()
+	e := NewEncryption()
+	e.AddCommands(c, WS)
+	s.SetEncryption(e)
+
+	// Negotiate key
+	pub, priv, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := &rawChannel{}
+	res, err := c.Exec("encrypt", WS, &DefaultRequest{
+		Vars:    map[string]string{"key": base64.RawURLEncoding.EncodeToString(pub[:])},
+		Channel: ch,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKey, _ := base64.RawURLEncoding.DecodeString(string(res))
+	key := SharedKey((*[32]byte)(serverKey), priv)
+
+	// Encrypted push
+	s.SubscribeCmd(ch, "secret", nil)
+	s.Broadcast("secret", []byte("data"))
+	data, err := Open(key, ch.messages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg SubscriptionMessage
+	if err = json.Unmarshal(data, &msg); err != nil || string(msg.Data) != "data" {
+		t.Errorf("wrong decrypted message: %s, %v", data, err)
+	}
+
+	// Encrypted request data
+	sealed, _ := Seal(key, []byte("request"))
+	if data, err = e.Open(ch, sealed); err != nil || string(data) != "request" {
+		t.Errorf("wrong decrypted request: %s, %v", data, err)
+	}
+	if _, err = e.Open(ch, append(sealed, 0)); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("wrong data should not be decrypted, got %v", err)
+	}
+
+	// Key is removed with connection
+	s.UnsubscribeAll(ch)
+	if _, err = e.Seal(ch, data); !errors.Is(err, ErrNoKey) {
+		t.Errorf("key should be removed, got %v", err)
+	}
+}
+
+func TestParallelism(t *testing.T) {
+
+	s := NewSubscription()
+	s.SetParallelism(2, 20*time.Millisecond)
+
+	var mut sync.Mutex
+	running, maxRunning := 0, 0
+	handler := WrapSubscriptionHandler(func(command string) ([]byte, error) {
+		mut.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mut.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mut.Lock()
+		running--
+		mut.Unlock()
+		return []byte("ok"), nil
+	})
+	for i := 0; i < 6; i++ {
+		s.SubscribeCmd(&testChannel{}, "news", handler)
+	}
+	slow := &slowChannel{delay: 100 * time.Millisecond}
+	s.SubscribeCmd(slow, "news", handler)
+
+	err := s.ExecCmd("news")
+	if !errors.Is(err, ErrSendTimeout) {
+		t.Errorf("slow connection send should time out, got %v", err)
+	}
+	if maxRunning != 2 {
+		t.Errorf("wrong number of concurrent executions: %d", maxRunning)
+	}
+}
+
+// slowChannel is a connection channel with slow send.
+type slowChannel struct {
+	delay time.Duration
+}
+
+func (ch *slowChannel) Send(data []byte) error {
+	time.Sleep(ch.delay)
+	return nil
+}
+
+func TestHandlerSubscribe(t *testing.T) {
+
+	s := NewSubscription()
+	ch := &testChannel{}
+
+	// Handler changes subscriptions while command is executed
+	s.SubscribeCmd(ch, "news", func(ctx context.Context, con ConnectionChannel,
+		command string) ([]byte, error) {
+
+		s.SubscribeCmd(ch, "weather", nil)
+		s.UnsubscribeCmd(ch, "news")
+		return []byte("news"), nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.ExecCmd("news")
+		s.ExecConCmd(ch, "news")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler changing subscriptions deadlocks")
+	}
+	if n := s.CommandsCount(ch); n != 1 {
+		t.Errorf("wrong commands count: %d", n)
+	}
+}
+
+func TestSubscriptionChurn(t *testing.T) {
+
+	s := NewSubscription()
+	stable := &testChannel{}
+	s.SubscribeCmd(stable, "news", nil)
+
+	// Subscribe and unsubscribe connections while broadcasting
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ch := &testChannel{}
+				s.SubscribeCmd(ch, "news", nil)
+				s.UnsubscribeAll(ch)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		s.Broadcast("news", []byte("data"))
+	}
+	wg.Wait()
+
+	if _, n := stable.last(); n != 100 {
+		t.Errorf("wrong number of pushes: %d", n)
+	}
+	if n := s.ConnectionsCount("news"); n != 1 {
+		t.Errorf("wrong connections count: %d", n)
+	}
+}
+
+// blockingChannel is a closable connection channel which send blocks until
+// release is closed.
+type blockingChannel struct {
+	testChannel
+	started chan struct{}
+	release chan struct{}
+	closed  bool
+}
+
+func (ch *blockingChannel) Send(data []byte) error {
+	select {
+	case ch.started <- struct{}{}:
+	default:
+	}
+	<-ch.release
+	return ch.testChannel.Send(data)
+}
+
+func (ch *blockingChannel) Close() error {
+	ch.Lock()
+	ch.closed = true
+	ch.Unlock()
+	return nil
+}
+
+func TestDrain(t *testing.T) {
+
+	s := NewSubscription()
+	ch := &blockingChannel{started: make(chan struct{}),
+		release: make(chan struct{})}
+	s.SubscribeCmd(ch, "news", nil)
+
+	// In-flight send
+	go s.Broadcast("news", []byte(`"first"`))
+	<-ch.started
+
+	drained := make(chan error)
+	go func() { drained <- s.Drain(ch, time.Second) }()
+
+	// New pushes are not delivered to draining connection
+	time.Sleep(10 * time.Millisecond)
+	if err := s.Broadcast("news", []byte(`"second"`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-drained:
+		t.Fatal("drain should wait for in-flight send")
+	default:
+	}
+
+	close(ch.release)
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+
+	ch.Lock()
+	messages, closed := ch.messages, ch.closed
+	ch.Unlock()
+	if len(messages) != 2 || string(messages[0].Data) != `"first"` ||
+		messages[1].Command != DrainCommand || !closed {
+		t.Errorf("wrong drained connection state: %v, closed %v", messages, closed)
+	}
+	if n := s.ConnectionsCount("news"); n != 0 {
+		t.Errorf("drained connection should be unsubscribed, got %d", n)
+	}
+
+	// Drain timeout
+	ch = &blockingChannel{started: make(chan struct{}), release: make(chan struct{})}
+	s.SubscribeCmd(ch, "news", nil)
+	go s.Broadcast("news", []byte(`"third"`))
+	<-ch.started
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(ch.release)
+	}()
+	if err := s.Drain(ch, 10*time.Millisecond); !errors.Is(err, ErrSendTimeout) {
+		t.Errorf("send timeout error expected, got %v", err)
+	}
+}
+
+func TestSessionHandoff(t *testing.T) {
+
+	key := []byte("cluster key")
+	node1, node2 := NewSubscription(), NewSubscription()
+	node1.SetResume(time.Minute, 10)
+	node2.SetResume(time.Minute, 10)
+
+	// Suspended session on node1
+	ch1 := &testChannel{}
+	node1.Resume(ch1, "session")
+	node1.SubscribeCmd(ch1, "news", nil)
+	node1.Disconnect(ch1)
+	node1.Broadcast("news", []byte(`"1"`))
+
+	// Hand off session to node2
+	if _, err := node1.ExportSession("unknown"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("session not found error expected, got %v", err)
+	}
+	state, err := node1.ExportSession("session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(state)
+	var imported SessionState
+	json.Unmarshal(data, &imported)
+	if err = node2.ImportSession(imported, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := node1.ConnectionsCount("news"); n != 0 {
+		t.Errorf("exported session should be removed, got %d", n)
+	}
+
+	// Resume with session tokens
+	c := New()
+	node2.AddCommands(c, WS)
+	tokens1 := NewSessionTokens("node1", key)
+	tokens2 := NewSessionTokens("node2", key)
+	node2.SetSessionTokens(tokens2)
+
+	tok1, _ := tokens1.Issue("session", time.Minute)
+	tok2, _ := tokens2.Issue("session", time.Minute)
+	expired, _ := tokens2.Issue("session", -time.Second)
+	forged, _ := NewSessionTokens("node2", []byte("wrong")).Issue("session", time.Minute)
+
+	ch2 := &testChannel{}
+	for _, test := range []struct {
+		token string
+		err   error
+	}{
+		{tok1, ErrWrongNode},
+		{expired, ErrSessionToken},
+		{forged, ErrSessionToken},
+		{"session", ErrSessionToken},
+		{tok2, nil},
+	} {
+		_, err := c.Exec("resume", WS, &DefaultRequest{Channel: ch2,
+			Vars: map[string]string{"session": test.token}})
+		if !errors.Is(err, test.err) {
+			t.Errorf("wrong resume error: %v, want %v", err, test.err)
+		}
+	}
+
+	node2.Broadcast("news", []byte(`"2"`))
+	ch2.Lock()
+	defer ch2.Unlock()
+	if len(ch2.messages) != 2 || string(ch2.messages[0].Data) != `"1"` ||
+		string(ch2.messages[1].Data) != `"2"` {
+		t.Errorf("wrong messages after handoff: %v", ch2.messages)
+	}
+}
+
+func TestSessionTokenCommands(t *testing.T) {
+
+	c := New()
+	c.Add("news", "", WS, "", "", "", "",
+		func(cmd *CommandData, processIn ProcessIn, data any) ([]byte, error) {
+			return []byte(`"news"`), nil
+		},
+	)
+	s := NewSubscription()
+	s.AddCommands(c, WS)
+	s.SetResume(time.Minute, 10)
+	tokens := NewSessionTokens("node1", []byte("key"))
+	s.SetSessionTokens(tokens)
+
+	// Subscribe returns token of connection session
+	ch1 := &testChannel{}
+	var sessions []string
+	var token string
+	for range 2 {
+		res, err := c.Exec("subscribe", WS, &DefaultRequest{Channel: ch1,
+			Vars: map[string]string{"command": "news"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tok, err := tokens.Validate(string(res))
+		if err != nil {
+			t.Fatalf("wrong subscribe token %s: %v", res, err)
+		}
+		sessions = append(sessions, tok.Session)
+		token = string(res)
+	}
+	if sessions[0] == "" || sessions[0] != sessions[1] {
+		t.Errorf("wrong connection sessions: %v", sessions)
+	}
+
+	// Resume with token returns refreshed token and replays messages
+	s.Disconnect(ch1)
+	s.Broadcast("news", []byte(`"1"`))
+	ch2 := &testChannel{}
+	res, err := c.Exec("resume", WS, &DefaultRequest{Channel: ch2,
+		Vars: map[string]string{"session": token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok, err := tokens.Validate(string(res)); err != nil ||
+		tok.Session != sessions[0] {
+		t.Errorf("wrong resume token %s: %v", res, err)
+	}
+	s.Broadcast("news", []byte(`"2"`))
+	ch2.Lock()
+	defer ch2.Unlock()
+	if len(ch2.messages) != 2 || string(ch2.messages[0].Data) != `"1"` ||
+		string(ch2.messages[1].Data) != `"2"` {
+		t.Errorf("wrong messages after resume: %v", ch2.messages)
+	}
+}
+
+func TestBackplane(t *testing.T) {
+
+	b := NewMemoryBackplane()
+	node1, node2 := NewSubscription(), NewSubscription()
+	if err := node1.SetBackplane(b, "node1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := node2.SetBackplane(b, "node2"); err != nil {
+		t.Fatal(err)
+	}
+
+	ch1, ch2 := &testChannel{}, &testChannel{}
+	node1.SubscribeCmd(ch1, "news", func(ctx context.Context, con ConnectionChannel,
+		command string) ([]byte, error) {
+
+		return []byte(`"node1"`), nil
+	})
+	node2.SubscribeCmd(ch2, "news", func(ctx context.Context, con ConnectionChannel,
+		command string) ([]byte, error) {
+
+		return []byte(`"node2"`), nil
+	})
+
+	// Broadcast reaches subscribers of all nodes once
+	if err := node1.Broadcast("news", []byte(`"hello"`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range []*testChannel{ch1, ch2} {
+		if msg, n := ch.last(); n != 1 || string(msg.Data) != `"hello"` {
+			t.Errorf("wrong broadcast message: %s, %d", msg.Data, n)
+		}
+	}
+
+	// ExecCmd executes handlers on all nodes
+	if err := node2.ExecCmd("news"); err != nil {
+		t.Fatal(err)
+	}
+	for i, ch := range []*testChannel{ch1, ch2} {
+		want := fmt.Sprintf(`"node%d"`, i+1)
+		if msg, n := ch.last(); n != 2 || string(msg.Data) != want {
+			t.Errorf("wrong exec message: %s, %d", msg.Data, n)
+		}
+	}
+}
+
+func TestSubscriptionHandlerContext(t *testing.T) {
+
+	s := NewSubscription()
+	s.SetHandlerTimeout(20 * time.Millisecond)
+
+	ch := &testChannel{}
+	var (
+		mut      sync.Mutex
+		got      ConnectionChannel
+		deadline bool
+	)
+	s.SubscribeCmd(ch, "report", func(ctx context.Context, con ConnectionChannel,
+		command string) ([]byte, error) {
+
+		mut.Lock()
+		got = con
+		_, deadline = ctx.Deadline()
+		mut.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return []byte("done"), nil
+		}
+	})
+
+	// Handler is canceled by handler timeout
+	start := time.Now()
+	if err := s.ExecCmd("report"); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("handler should be canceled by timeout")
+	}
+	mut.Lock()
+	if got != ch || !deadline {
+		t.Errorf("handler got wrong connection or no deadline")
+	}
+	mut.Unlock()
+	if msg, n := ch.last(); n != 1 || msg.Err != context.DeadlineExceeded.Error() {
+		t.Errorf("deadline error expected, got %v", msg)
+	}
+
+	// Handler is canceled by caller context
+	s.SetHandlerTimeout(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.ExecConCmdContext(ctx, ch, "report")
+	if msg, n := ch.last(); n != 2 || msg.Err != context.Canceled.Error() {
+		t.Errorf("canceled error expected, got %v", msg)
+	}
+}
+
+func TestSubscriptionErrorPolicy(t *testing.T) {
+
+	s := NewSubscription()
+	var (
+		mut   sync.Mutex
+		calls int
+	)
+	failing := func(fails int) SubscriptionHandler {
+		return func(ctx context.Context, con ConnectionChannel,
+			command string) ([]byte, error) {
+
+			mut.Lock()
+			defer mut.Unlock()
+			calls++
+			if calls <= fails {
+				return nil, fmt.Errorf("failed %d", calls)
+			}
+			return []byte("ok"), nil
+		}
+	}
+
+	// Send error by default
+	ch := &testChannel{}
+	s.SubscribeCmd(ch, "news", failing(1))
+	s.ExecCmd("news")
+	if msg, n := ch.last(); n != 1 || msg.Err != "failed 1" {
+		t.Errorf("error should be sent, got %v", msg)
+	}
+	s.UnsubscribeAll(ch)
+
+	// Suppress errors
+	calls = 0
+	s.SetErrorPolicy(&ErrorPolicy{Action: ErrorSuppress})
+	ch = &testChannel{}
+	s.SubscribeCmd(ch, "news", failing(1))
+	s.ExecCmd("news")
+	if _, n := ch.last(); n != 0 {
+		t.Errorf("error should be suppressed")
+	}
+	s.ExecCmd("news")
+	if msg, n := ch.last(); n != 1 || string(msg.Data) != "ok" {
+		t.Errorf("data should be sent, got %v", msg)
+	}
+	s.UnsubscribeAll(ch)
+
+	// Retry with backoff
+	calls = 0
+	ch = &testChannel{}
+	s.SubscribeCmd(ch, "news", failing(2), SubscribeOptions{ErrorPolicy: &ErrorPolicy{
+		Action: ErrorRetry, Retries: 2, Backoff: time.Millisecond}})
+	s.ExecCmd("news")
+	if msg, n := ch.last(); n != 1 || string(msg.Data) != "ok" || calls != 3 {
+		t.Errorf("retried data should be sent, got %v after %d calls", msg, calls)
+	}
+	s.UnsubscribeAll(ch)
+
+	// Unsubscribe after max failures
+	calls = 0
+	ch = &testChannel{}
+	s.SubscribeCmd(ch, "news", failing(10), SubscribeOptions{ErrorPolicy: &ErrorPolicy{
+		MaxFailures: 2}})
+	for i := 0; i < 3; i++ {
+		s.ExecCmd("news")
+	}
+	if _, n := ch.last(); n != 2 {
+		t.Errorf("connection should be unsubscribed after 2 failures, got %d", n)
+	}
+	if calls != 2 {
+		t.Errorf("handler should be called 2 times, got %d", calls)
+	}
+
+	// Unsubscribe pattern subscription after max failures
+	calls = 0
+	ch = &testChannel{}
+	s.SubscribeCmd(ch, "news.*", failing(10), SubscribeOptions{ErrorPolicy: &ErrorPolicy{
+		MaxFailures: 1}})
+	for i := 0; i < 3; i++ {
+		s.ExecCmd("news.sport")
+	}
+	if _, n := ch.last(); n != 1 || calls != 1 {
+		t.Errorf("pattern subscription should be unsubscribed after failure, "+
+			"got %d pushes", n)
+	}
+}
+
+func TestSubscribeTyped(t *testing.T) {
+
+	type quote struct {
+		Symbol string    `json:"symbol"`
+		Price  float64   `json:"price"`
+		Tags   []string  `json:"tags,omitempty"`
+		Time   time.Time `json:"time"`
+	}
+
+	s := NewSubscription()
+	ch := &testChannel{}
+	err := SubscribeTyped(s, ch, "quote", func(ctx context.Context,
+		con ConnectionChannel, command string) (quote, error) {
+
+		return quote{Symbol: "ABC", Price: 1.5}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ExecCmd("quote")
+	var q quote
+	if msg, _ := ch.last(); json.Unmarshal(msg.Data, &q) != nil || q.Symbol != "ABC" {
+		t.Errorf("wrong typed payload: %s", msg.Data)
+	}
+
+	// Broadcast typed payload
+	if err = BroadcastTyped(s, "quote", quote{Symbol: "XYZ"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := ch.last(); json.Unmarshal(msg.Data, &q) != nil || q.Symbol != "XYZ" {
+		t.Errorf("wrong broadcast payload: %s", msg.Data)
+	}
+	if err = BroadcastTyped(s, "quote", "text"); !errors.Is(err, ErrPayloadType) {
+		t.Errorf("payload type error expected, got %v", err)
+	}
+
+	// Payload schema
+	schema, ok := s.PayloadSchema("quote")
+	want := `{"properties":{"price":{"type":"number"},"symbol":{"type":"string"},` +
+		`"tags":{"items":{"type":"string"},"type":"array"},` +
+		`"time":{"format":"date-time","type":"string"}},` +
+		`"required":["symbol","price","time"],"type":"object"}`
+	if !ok || schema != want {
+		t.Errorf("wrong payload schema: %s", schema)
+	}
+
+	// Custom serializer
+	s.SetSerializer(RawSerializer{})
+	s.SubscribeCmd(ch, "raw", nil)
+	if err = BroadcastTyped(s, "raw", "text"); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := ch.last(); string(msg.Data) != "text" {
+		t.Errorf("wrong raw payload: %s", msg.Data)
+	}
+}
+
+func TestWildcardSubscription(t *testing.T) {
+
+	s := NewSubscription()
+	all, cpu := &testChannel{}, &testChannel{}
+	s.SubscribeCmd(all, "metrics.*", func(ctx context.Context,
+		con ConnectionChannel, command string) ([]byte, error) {
+
+		return []byte(command), nil
+	})
+	s.SubscribeCmd(cpu, "metrics.cpu", nil)
+	s.SubscribeCmd(cpu, "metrics.c*", nil)
+
+	// Exec matching command
+	if err := s.ExecCmd("metrics.mem"); err != nil {
+		t.Fatal(err)
+	}
+	msg, n := all.last()
+	if n != 1 || msg.Command != "metrics.mem" || msg.Pattern != "metrics.*" ||
+		string(msg.Data) != "metrics.mem" {
+		t.Errorf("wrong pattern message: %v", msg)
+	}
+
+	// Broadcast reaches exact and pattern subscribers once
+	s.Broadcast("metrics.cpu", []byte("42"))
+	if msg, n = all.last(); n != 2 || msg.Command != "metrics.cpu" {
+		t.Errorf("wrong broadcast message: %v", msg)
+	}
+	if msg, n = cpu.last(); n != 1 || msg.Pattern != "" {
+		t.Errorf("exact subscriber should get one message: %d %v", n, msg)
+	}
+	s.Broadcast("weather", []byte("sunny"))
+	if _, n = all.last(); n != 2 {
+		t.Error("not matching command should not be sent")
+	}
+
+	// Exec connection pattern subscription
+	if err := s.ExecConCmd(all, "metrics.disk"); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ = all.last(); msg.Command != "metrics.disk" {
+		t.Errorf("wrong connection message: %v", msg)
+	}
+
+	// Unsubscribe pattern
+	s.UnsubscribeCmd(all, "metrics.*")
+	s.ExecCmd("metrics.mem")
+	if _, n = all.last(); n != 3 {
+		t.Error("unsubscribed pattern should not be sent")
+	}
+
+	if !IsPattern("metrics.*") || IsPattern("metrics") || IsPattern("a[") {
+		t.Error("wrong IsPattern result")
+	}
+}
+
+func TestSubscriptionOrdering(t *testing.T) {
+
+	s := NewSubscription()
+	ch := &testChannel{}
+	s.SubscribeCmd(ch, "news", nil)
+	s.SubscribeCmd(ch, "weather", nil)
+
+	// Sequence numbers are off by default
+	s.Broadcast("news", []byte("1"))
+	if msg, _ := ch.last(); msg.CmdSeq != 0 {
+		t.Errorf("command sequence should be off, got %d", msg.CmdSeq)
+	}
+
+	// Per command sequence numbers
+	s.SetOrdering(OrderingSeq)
+	s.Broadcast("news", []byte("2"))
+	s.Broadcast("weather", []byte("sunny"))
+	s.Broadcast("news", []byte("3"))
+	if msg, _ := ch.last(); msg.Command != "news" || msg.CmdSeq != 2 {
+		t.Errorf("wrong news sequence: %v", msg)
+	}
+
+	// Strict order of concurrent pushes
+	s.SetOrdering(OrderingStrict)
+	ch = &testChannel{}
+	s.SubscribeCmd(ch, "news", nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Broadcast("news", []byte(strconv.Itoa(i)))
+		}()
+	}
+	wg.Wait()
+	ch.Lock()
+	for i, msg := range ch.messages {
+		if msg.CmdSeq != uint64(i+1) {
+			t.Errorf("message %d delivered with sequence %d", i, msg.CmdSeq)
+		}
+	}
+	ch.Unlock()
+
+	// Connection sequences are dropped with connection
+	s.UnsubscribeAll(ch)
+	s.SubscribeCmd(ch, "news", nil)
+	s.Broadcast("news", []byte("new"))
+	if msg, _ := ch.last(); msg.CmdSeq != 1 {
+		t.Errorf("sequence should restart, got %d", msg.CmdSeq)
+	}
+}
+
+func TestSubscriptionCredits(t *testing.T) {
+
+	s := NewSubscription()
+	s.SetAck(time.Minute, 0)
+	s.SetCredits(2, 2)
+
+	ch := &testChannel{}
+	s.SubscribeCmd(ch, "news", nil)
+	for i := 1; i <= 4; i++ {
+		if err := s.Broadcast("news", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Two messages are sent, two are paused
+	msg, n := ch.last()
+	if n != 2 || string(msg.Data) != "2" {
+		t.Fatalf("2 messages should be sent, got %d", n)
+	}
+	if err := s.Broadcast("news", []byte("5")); !errors.Is(err, ErrNoCredits) {
+		t.Errorf("no credits error expected, got %v", err)
+	}
+
+	// Ack resumes paused messages
+	s.Ack(ch, msg.Seq)
+	if msg, n = ch.last(); n != 3 || string(msg.Data) != "3" {
+		t.Errorf("paused message should be sent on ack, got %d %s", n, msg.Data)
+	}
+	ch.Lock()
+	first := ch.messages[0].Seq
+	ch.Unlock()
+	s.Ack(ch, first)
+	s.Ack(ch, msg.Seq)
+	if msg, n = ch.last(); n != 4 || string(msg.Data) != "4" {
+		t.Errorf("paused message should be sent on ack, got %d %s", n, msg.Data)
+	}
+	s.Broadcast("news", []byte("6"))
+	if msg, n = ch.last(); n != 5 || string(msg.Data) != "6" {
+		t.Errorf("message should be sent with credits, got %d %s", n, msg.Data)
+	}
+}
+
+func TestRooms(t *testing.T) {
+
+	c := New()
+	s := NewSubscription()
+	rooms := NewRooms(s)
+	rooms.AddCommands(c, WS)
+
+	alice, bob := &testChannel{}, &testChannel{}
+	exec := func(ch ConnectionChannel, user, name string,
+		vars map[string]string) ([]byte, error) {
+
+		req := &DefaultRequest{Vars: vars, Channel: ch}
+		if user != "" {
+			req.User = user
+		}
+		return c.Exec(name, WS, req)
+	}
+	event := func(ch *testChannel) (e RoomEvent) {
+		msg, _ := ch.last()
+		json.Unmarshal(msg.Data, &e)
+		return
+	}
+
+	if _, err := exec(alice, "", "join", map[string]string{"room": "lobby"}); err != ErrNoUser {
+		t.Errorf("no user error expected, got %v", err)
+	}
+	exec(alice, "alice", "join", map[string]string{"room": "lobby"})
+	exec(bob, "bob", "join", map[string]string{"room": "lobby"})
+	if e := event(alice); e.Event != RoomJoin || e.User != "bob" ||
+		strings.Join(e.Members, ",") != "alice,bob" {
+		t.Errorf("wrong join event: %+v", e)
+	}
+
+	// Say message
+	exec(bob, "bob", "say", map[string]string{"room": "lobby", "text": "hi"})
+	if e := event(alice); e.Event != RoomMessage || e.User != "bob" || e.Text != "hi" {
+		t.Errorf("wrong message event: %+v", e)
+	}
+	res, _ := exec(alice, "alice", "members", map[string]string{"room": "lobby"})
+	if string(res) != `["alice","bob"]` {
+		t.Errorf("wrong members: %s", res)
+	}
+
+	// Leave on disconnect
+	rooms.LeaveAll(bob)
+	if e := event(alice); e.Event != RoomLeave || e.User != "bob" ||
+		strings.Join(e.Members, ",") != "alice" {
+		t.Errorf("wrong leave event: %+v", e)
+	}
+	if _, n := bob.last(); n != 2 {
+		t.Errorf("left connection should not receive events, got %d", n)
+	}
+}
+
+func TestSubscriptionHealth(t *testing.T) {
+
+	s := NewSubscription()
+	s.SetAck(time.Minute, 0)
+	s.SetCredits(1, 5)
+
+	// Paused messages are connection queue
+	ch := &testChannel{}
+	s.SubscribeCmd(ch, "news", nil)
+	for i := 1; i <= 3; i++ {
+		s.Broadcast("news", []byte(strconv.Itoa(i)))
+	}
+	if b := s.Backlog(); b.ConnQueue != 2 || b.Queued != 2 {
+		t.Fatalf("wrong credits backlog: %+v", b)
+	}
+	if h := s.Health(); h.Degraded {
+		t.Fatalf("health without thresholds is degraded: %+v", h)
+	}
+	s.SetBacklogThresholds(BacklogThresholds{ConnQueue: 1})
+	if h := s.Health(); !h.Degraded || len(h.Reasons) != 1 {
+		t.Fatalf("health should be degraded: %+v", h)
+	}
+
+	// Transport queues
+	s.AddBacklogQueue(func() (int, int) { return 3, 4 })
+	if b := s.Backlog(); b.ConnQueue != 3 || b.Queued != 6 {
+		t.Fatalf("wrong transport queues backlog: %+v", b)
+	}
+
+	// Blocked sends and pushes waiting for push worker
+	s = NewSubscription()
+	s.SetParallelism(1, 0)
+	s.SetBacklogThresholds(BacklogThresholds{Workers: 1})
+	started, release := make(chan struct{}), make(chan struct{})
+	for range 3 {
+		s.SubscribeCmd(&blockingChannel{started: started, release: release},
+			"alerts", nil)
+	}
+	done := make(chan error)
+	go func() { done <- s.Broadcast("alerts", []byte(`"alert"`)) }()
+	<-started
+	var b Backlog
+	for range 100 {
+		if b = s.Backlog(); b.Workers == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if b.Workers != 2 || b.Sends != 1 || b.ConnSends != 1 {
+		t.Fatalf("wrong blocked backlog: %+v", b)
+	}
+	if h := s.Health(); !h.Degraded {
+		t.Fatalf("health should be degraded: %+v", h)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if b = s.Backlog(); b != (Backlog{}) {
+		t.Fatalf("backlog should be empty: %+v", b)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Subscription throttling module of Command processing golang package.
+
+package command
+
+import (
+	"sync"
+	"time"
+)
+
+// throttle keeps subscriber pushes throttling state.
+type throttle struct {
+	last    time.Time              // Last push time
+	pending func() ([]byte, error) // Latest deferred push data producer
+	timer   *time.Timer            // Deferred push timer
+	sync.Mutex
+}
+
+// push pushes data produced by produce function to connection. If the
+// subscriber has MinInterval or Debounce options the push may be deferred
+// and coalesced with next pushes, then the latest produce function is called
+// once when deferred push is flushed.
+func (s *Subscription) push(con ConnectionChannel, command string,
+	sub *subscriber, produce func() ([]byte, error)) error {
+
+	if sub.MinInterval <= 0 && sub.Debounce <= 0 {
+		data, err := produce()
+		return s.send(con, command, sub, data, err)
+	}
+
+	t := &sub.throttle
+	t.Lock()
+	t.pending = produce
+
+	// Debounce: restart timer on each push
+	if sub.Debounce > 0 {
+		delay := sub.Debounce
+		if wait := time.Until(t.last.Add(sub.MinInterval)); wait > delay {
+			delay = wait
+		}
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+		t.timer = time.AfterFunc(delay, func() { s.flush(con, command, sub) })
+		t.Unlock()
+		return nil
+	}
+
+	// Min interval: push now or at the end of interval
+	if t.timer != nil {
+		t.Unlock()
+		return nil
+	}
+	if wait := time.Until(t.last.Add(sub.MinInterval)); wait > 0 {
+		t.timer = time.AfterFunc(wait, func() { s.flush(con, command, sub) })
+		t.Unlock()
+		return nil
+	}
+	t.last, t.pending = time.Now(), nil
+	t.Unlock()
+
+	data, err := produce()
+	return s.send(con, command, sub, data, err)
+}
+
+// flush sends deferred push if connection is still subscribed to command.
+func (s *Subscription) flush(con ConnectionChannel, command string,
+	sub *subscriber) {
+
+	t := &sub.throttle
+	t.Lock()
+	produce := t.pending
+	t.last, t.pending, t.timer = time.Now(), nil, nil
+	t.Unlock()
+
+	s.RLock()
+	subscribed := s.m[sub.key(command)][con] == sub
+	s.RUnlock()
+
+	if produce == nil || !subscribed {
+		return
+	}
+	data, err := produce()
+	s.send(con, command, sub, data, err)
+}
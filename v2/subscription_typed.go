@@ -0,0 +1,187 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Typed subscription payloads module of Command processing golang package.
+
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ErrPayloadType is an error returned when typed subscription payload type
+// differs from payload type registered for the command.
+var ErrPayloadType = fmt.Errorf("wrong subscription payload type")
+
+// payloadType is a registered payload type of subscription command.
+type payloadType struct {
+	typ    reflect.Type
+	schema string
+}
+
+// SetSerializer sets serializer of typed subscription payloads, see
+// SubscribeTyped and BroadcastTyped. The JSONSerializer is used if
+// serializer is not set.
+func (s *Subscription) SetSerializer(serializer Serializer) {
+	s.Lock()
+	defer s.Unlock()
+	s.serializer = serializer
+}
+
+// PayloadSchema returns json schema of typed payload registered for command
+// by SubscribeTyped or BroadcastTyped.
+func (s *Subscription) PayloadSchema(command string) (schema string, ok bool) {
+	s.RLock()
+	defer s.RUnlock()
+	p, ok := s.payloads[command]
+	return p.schema, ok
+}
+
+// SubscribeTyped subscribes connection to command with typed handler h. The
+// handler result is marshaled by subscription serializer, see SetSerializer,
+// and the payload type with its json schema is registered for the command,
+// see PayloadSchema. It returns ErrPayloadType if other payload type is
+// registered for the command.
+func SubscribeTyped[T any](s *Subscription, con ConnectionChannel,
+	command string, h func(ctx context.Context, con ConnectionChannel,
+		command string) (T, error), opts ...SubscribeOptions) error {
+
+	if err := registerPayload[T](s, command); err != nil {
+		return err
+	}
+	s.SubscribeCmd(con, command, func(ctx context.Context,
+		con ConnectionChannel, command string) ([]byte, error) {
+
+		v, err := h(ctx, con, command)
+		if err != nil {
+			return nil, err
+		}
+		return s.marshal(v)
+	}, opts...)
+	return nil
+}
+
+// BroadcastTyped marshals v by subscription serializer and broadcasts it to
+// connections subscribed to command, see Broadcast. It registers payload
+// type of the command like SubscribeTyped.
+func BroadcastTyped[T any](s *Subscription, command string, v T) error {
+	if err := registerPayload[T](s, command); err != nil {
+		return err
+	}
+	data, err := s.marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Broadcast(command, data)
+}
+
+// registerPayload registers payload type T of command.
+func registerPayload[T any](s *Subscription, command string) error {
+	typ := reflect.TypeFor[T]()
+
+	s.Lock()
+	defer s.Unlock()
+	if p, ok := s.payloads[command]; ok {
+		if p.typ != typ {
+			return fmt.Errorf("command '%s' payload %s, registered %s: %w",
+				command, typ, p.typ, ErrPayloadType)
+		}
+		return nil
+	}
+	schema, err := json.Marshal(jsonSchema(typ, nil))
+	if err != nil {
+		return err
+	}
+	if s.payloads == nil {
+		s.payloads = make(map[string]payloadType)
+	}
+	s.payloads[command] = payloadType{typ, string(schema)}
+	return nil
+}
+
+// marshal marshals typed payload by subscription serializer.
+func (s *Subscription) marshal(v any) ([]byte, error) {
+	s.RLock()
+	serializer := s.serializer
+	s.RUnlock()
+	if serializer == nil {
+		serializer = JSONSerializer{}
+	}
+	return serializer.Marshal(v)
+}
+
+// jsonSchema returns json schema of type t. The seen map prevents infinite
+// recursion of recursive types.
+func jsonSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeFor[json.RawMessage]():
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": jsonSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]any{"type": "object",
+			"additionalProperties": jsonSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]any{"type": "object"}
+		}
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		properties := make(map[string]any)
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" && opts == "" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = jsonSchema(f.Type, seen)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]any{}
+}
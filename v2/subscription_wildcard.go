@@ -0,0 +1,125 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Wildcard subscriptions module of Command processing golang package.
+
+package command
+
+import (
+	"path"
+	"strings"
+)
+
+// IsPattern returns true if command is a valid subscription pattern, like
+// "metrics.*", see path.Match for syntax.
+func IsPattern(command string) bool {
+	if !strings.ContainsAny(command, "*?[") {
+		return false
+	}
+	_, err := path.Match(command, "")
+	return err == nil
+}
+
+// publishPatterns replaces list of subscribed patterns if any of changed
+// commands is a pattern. It should be called under the Subscription lock.
+func (s *Subscription) publishPatterns(commands ...string) {
+	changed := false
+	for _, command := range commands {
+		if IsPattern(command) {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return
+	}
+
+	var patterns []string
+	for command := range s.m {
+		if IsPattern(command) {
+			patterns = append(patterns, command)
+		}
+	}
+	s.patterns.Store(&patterns)
+}
+
+// matchSubscribers returns command subscribers subs with subscribers of
+// patterns matching command. Connection subscribed both to command and
+// matching pattern is returned once. The snapshot slices are not changed.
+func matchSubscribers(snap subscribersSnapshot, patterns []string,
+	command string, subs []connSubscriber) []connSubscriber {
+
+	copied := false
+	for _, pattern := range patterns {
+		if pattern == command {
+			continue
+		}
+		if ok, _ := path.Match(pattern, command); !ok {
+			continue
+		}
+		for _, sub := range snap[pattern] {
+			if hasSubscriber(subs, sub.con) {
+				continue
+			}
+			if !copied {
+				subs = append(make([]connSubscriber, 0, len(subs)+1), subs...)
+				copied = true
+			}
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// hasSubscriber returns true if subs contains subscriber of connection con.
+func hasSubscriber(subs []connSubscriber, con ConnectionChannel) bool {
+	for _, sub := range subs {
+		if sub.con == con {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns subscription key of subscriber pushes of command: pattern of
+// pattern subscription or the command.
+func (sub *subscriber) key(command string) string {
+	if sub.pattern != "" {
+		return sub.pattern
+	}
+	return command
+}
+
+// matchCon returns subscriber of connection con to pattern matching command.
+// It should be called under the Subscription lock.
+func (s *Subscription) matchCon(con ConnectionChannel, command string) (
+	*subscriber, bool) {
+
+	patterns := s.patterns.Load()
+	if patterns == nil {
+		return nil, false
+	}
+	for _, pattern := range *patterns {
+		if ok, _ := path.Match(pattern, command); !ok {
+			continue
+		}
+		if sub, ok := s.m[pattern][con]; ok {
+			return sub, true
+		}
+	}
+	return nil, false
+}
+
+// sendPattern sends command data or error matched by subscription pattern
+// to connection.
+func (s *Subscription) sendPattern(con ConnectionChannel, command,
+	pattern string, data []byte, err error) error {
+
+	msg := SubscriptionMessage{Command: command, Pattern: pattern, Data: data}
+	if err != nil {
+		msg.Err = err.Error()
+	}
+	_, err = s.sendMessage(con, msg)
+	return err
+}
@@ -0,0 +1,238 @@
+// Copyright 2024 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package webhook is an inbound webhooks adapter of the Command processing
+// package registry for no-code integrations.
+//
+// The Bridge maps webhook payloads, like form services submissions or mail
+// hooks, to commands by configured field mapping:
+//
+//	b := webhook.New(c, command.HTTP)
+//	b.Handle("contact", webhook.Mapping{
+//		Command: "ticket/create",
+//		Vars:    map[string]string{"email": "sender.email", "subject": "subject"},
+//		Data:    "body-plain",
+//	})
+//	http.Handle("/hooks/", b)
+//
+// Request to "/hooks/contact" executes the "ticket/create" command with
+// command variables taken from payload fields. JSON payload fields are
+// addressed by dot separated path, like "sender.email", form payload fields
+// by name.
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+// TokenHeader is a request header with webhook token.
+const TokenHeader = "X-Webhook-Token"
+
+// MaxBodySize is a maximum size of webhook payload. Larger payloads are
+// rejected with 413 status.
+const MaxBodySize = 1 << 20
+
+// ErrField is an error returned when required payload field is absent.
+var ErrField = fmt.Errorf("payload field not found")
+
+// Mapping maps webhook payload to command.
+type Mapping struct {
+	Command  string            // Command name
+	Vars     map[string]string // Command variables by payload field paths
+	Defaults map[string]string // Command variables default values
+	Data     string            // Payload field path of command data, whole payload if empty
+
+	// Token is a webhook secret which should be sent in TokenHeader header
+	// or "token" query parameter, it is not checked if empty.
+	Token string
+}
+
+// Bridge executes commands of webhook payloads.
+type Bridge struct {
+	c         *command.Commands
+	processIn command.ProcessIn
+	hooks     map[string]Mapping
+	sync.RWMutex
+}
+
+// New creates webhooks bridge of commands registry. Commands are executed
+// with processIn input processing type.
+func New(c *command.Commands, processIn command.ProcessIn) *Bridge {
+	return &Bridge{c: c, processIn: processIn, hooks: make(map[string]Mapping)}
+}
+
+// Handle sets mapping of hook name, the last segment of request path.
+func (b *Bridge) Handle(hook string, m Mapping) {
+	b.Lock()
+	defer b.Unlock()
+	b.hooks[hook] = m
+}
+
+// ServeHTTP executes mapped command of webhook payload and writes command
+// answer.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.RLock()
+	m, ok := b.hooks[path.Base(r.URL.Path)]
+	b.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if m.Token != "" {
+		token := r.Header.Get(TokenHeader)
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(m.Token)) != 1 {
+			http.Error(w, "wrong webhook token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.As(err, new(*http.MaxBytesError)) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	vars, data, err := m.Map(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := b.c.Get(m.Command); !ok {
+		http.Error(w, "command '"+m.Command+"' not found", http.StatusNotFound)
+		return
+	}
+	res, err := b.c.Exec(m.Command, b.processIn, &command.DefaultRequest{
+		Vars: vars, Data: data, Header: r.Header, Ctx: r.Context(),
+		RemoteAddr: r.RemoteAddr})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write(res)
+}
+
+// Map returns command variables and data of payload with content type.
+// JSON and form payloads are supported, other payloads are only passed as
+// command data.
+func (m Mapping) Map(contentType string, payload []byte) (
+	vars map[string]string, data []byte, err error) {
+
+	field, err := fields(contentType, payload)
+	if err != nil {
+		return
+	}
+
+	vars = make(map[string]string, len(m.Vars))
+	for name, value := range m.Defaults {
+		vars[name] = value
+	}
+	for name, p := range m.Vars {
+		value, ok := field(p)
+		if !ok {
+			if _, ok := vars[name]; ok {
+				continue
+			}
+			err = fmt.Errorf("%w: %s", ErrField, p)
+			return
+		}
+		vars[name] = value
+	}
+
+	data = payload
+	if m.Data != "" {
+		value, ok := field(m.Data)
+		if !ok {
+			err = fmt.Errorf("%w: %s", ErrField, m.Data)
+			return
+		}
+		data = []byte(value)
+	}
+	return
+}
+
+// fields returns payload fields getter.
+func fields(contentType string, payload []byte) (
+	func(p string) (string, bool), error) {
+
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v any
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return nil, err
+		}
+		return func(p string) (string, bool) { return jsonField(v, p) }, nil
+
+	case mediaType == "application/x-www-form-urlencoded",
+		mediaType == "multipart/form-data":
+		r := &http.Request{Method: http.MethodPost, Header: http.Header{
+			"Content-Type": {contentType}}, Body: io.NopCloser(
+			strings.NewReader(string(payload)))}
+		if params["boundary"] != "" {
+			if err := r.ParseMultipartForm(MaxBodySize); err != nil {
+				return nil, err
+			}
+			defer r.MultipartForm.RemoveAll()
+		} else if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		return func(p string) (string, bool) {
+			values, ok := r.PostForm[p]
+			if !ok || len(values) == 0 {
+				return "", false
+			}
+			return values[0], true
+		}, nil
+	}
+	return func(string) (string, bool) { return "", false }, nil
+}
+
+// jsonField returns json value field by dot separated path. Array elements
+// are addressed by index, objects and arrays are returned in json format.
+func jsonField(v any, p string) (string, bool) {
+	for _, key := range strings.Split(p, ".") {
+		switch x := v.(type) {
+		case map[string]any:
+			if v = x[key]; v == nil {
+				return "", false
+			}
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(x) {
+				return "", false
+			}
+			v = x[i]
+		default:
+			return "", false
+		}
+	}
+
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case nil:
+		return "", false
+	}
+	data, err := json.Marshal(v)
+	return string(data), err == nil
+}
@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kirill-scherba/command/v2"
+)
+
+func TestBridge(t *testing.T) {
+
+	c := command.New()
+	c.Add("ticket", "", command.All, "{email}/{subject}/{priority}", "", "", "",
+		func(cmd *command.CommandData, processIn command.ProcessIn, data any) (
+			[]byte, error) {
+			vars, _ := c.Vars(data)
+			body, _ := c.Data(data)
+			return []byte(vars["email"] + "|" + vars["subject"] + "|" +
+				vars["priority"] + "|" + string(body)), nil
+		},
+	)
+
+	b := New(c, command.All)
+	b.Handle("contact", Mapping{
+		Command:  "ticket",
+		Vars:     map[string]string{"email": "sender.email", "subject": "subject", "priority": "priority"},
+		Defaults: map[string]string{"priority": "low"},
+		Data:     "lines.1",
+		Token:    "secret",
+	})
+	b.Handle("form", Mapping{
+		Command: "ticket",
+		Vars:    map[string]string{"email": "email", "subject": "subject"},
+	})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	post := func(path, contentType, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+path,
+			strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set(TokenHeader, "secret")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, _ := io.ReadAll(res.Body)
+		return res.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	// JSON payload with default value
+	status, answer := post("/hooks/contact", "application/json",
+		`{"sender":{"email":"kate@example.com"},"subject":"Help","lines":["a","b"]}`)
+	if status != http.StatusOK || answer != "kate@example.com|Help|low|b" {
+		t.Fatalf("wrong json answer: %d %q", status, answer)
+	}
+
+	// Form payload, whole payload is command data
+	form := url.Values{"email": {"john@example.com"}, "subject": {"Hi"}}.Encode()
+	status, answer = post("/hooks/form", "application/x-www-form-urlencoded", form)
+	if status != http.StatusOK || answer != "john@example.com|Hi||"+form {
+		t.Fatalf("wrong form answer: %d %q", status, answer)
+	}
+
+	// Absent field
+	status, _ = post("/hooks/form", "application/json", `{"email":"x"}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("wrong absent field status: %d", status)
+	}
+	if _, _, err := (Mapping{Vars: map[string]string{"a": "b"}}).Map(
+		"application/json", []byte(`{}`)); !errors.Is(err, ErrField) {
+		t.Fatalf("wrong absent field error: %v", err)
+	}
+
+	// Oversized payload
+	status, _ = post("/hooks/form", "application/x-www-form-urlencoded",
+		"email="+strings.Repeat("x", MaxBodySize))
+	if status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("wrong oversized payload status: %d", status)
+	}
+
+	// Wrong token and unknown hook
+	res, err := http.Post(srv.URL+"/hooks/contact?token=wrong",
+		"application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong token status: %d", res.StatusCode)
+	}
+	if status, _ = post("/hooks/unknown", "application/json", `{}`); status != http.StatusNotFound {
+		t.Fatalf("wrong unknown hook status: %d", status)
+	}
+}